@@ -0,0 +1,81 @@
+package loggingproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// addressOverrides pins backend hosts to fixed dial addresses. Keys are
+// lower-cased "host" or "host:port"; values are "ip:port", or a bare IP that
+// keeps the port being dialed.
+type addressOverrides map[string]string
+
+// newAddressOverrides validates overrides and layers them over base, so a
+// route's entries win over the server-wide ones.
+func newAddressOverrides(base addressOverrides, overrides map[string]string) (addressOverrides, error) {
+	if len(overrides) == 0 {
+		return base, nil
+	}
+	merged := make(addressOverrides, len(base)+len(overrides))
+	for host, address := range base {
+		merged[host] = address
+	}
+	for host, address := range overrides {
+		host = strings.ToLower(strings.TrimSpace(host))
+		address = strings.TrimSpace(address)
+		if host == "" || address == "" {
+			return nil, fmt.Errorf("invalid address override %q -> %q", host, address)
+		}
+		if _, _, err := net.SplitHostPort(address); err != nil && strings.Contains(address, ":") && net.ParseIP(address) == nil {
+			return nil, fmt.Errorf("invalid address override for %s: %w", host, err)
+		}
+		merged[host] = address
+	}
+	return merged, nil
+}
+
+// address returns the address to dial for addr, which is "host:port".
+func (o addressOverrides) address(addr string) string {
+	if len(o) == 0 {
+		return addr
+	}
+	if override, ok := o[strings.ToLower(addr)]; ok {
+		return withDialPort(override, addr)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if override, ok := o[strings.ToLower(host)]; ok {
+		return withDialPort(override, addr)
+	}
+	return addr
+}
+
+// withDialPort adds the port of addr to an override without one.
+func withDialPort(override, addr string) string {
+	if _, _, err := net.SplitHostPort(override); err == nil {
+		return override
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	return net.JoinHostPort(override, port)
+}
+
+// withAddressOverrides returns a copy of client with its own transport that
+// dials according to overrides. The copy keeps the redirect policy, proxy
+// and TLS settings of client.
+func withAddressOverrides(client *http.Client, overrides addressOverrides, lifetime time.Duration, guard *destinationGuard) (*http.Client, error) {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("address overrides require an *http.Transport, got %T", client.Transport)
+	}
+	transport = transport.Clone()
+	setDialer(transport, lifetime, overrides, guard)
+
+	clientCopy := *client
+	clientCopy.Transport = transport
+	return &clientCopy, nil
+}
@@ -0,0 +1,108 @@
+package loggingproxy
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAddressOverridePinsHostToBackend(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "host="+r.Host)
+	}))
+	defer backend.Close()
+	backendAddr := strings.TrimPrefix(backend.URL, "http://")
+	_, port, _ := strings.Cut(backendAddr, ":")
+
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:     HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		AddressOverride: map[string]string{"backend.invalid": backendAddr},
+	})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	if err := proxyServer.AddRoute("/api/", "http://Backend.invalid:1/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	// A route override without a port keeps the destination's port
+	err = proxyServer.AddRouteWithOptions("/other/", "http://other.invalid:"+port+"/", &NoOpLogger{}, RouteOptions{
+		AddressOverride: map[string]string{"other.invalid": "127.0.0.1"},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for path, expected := range map[string]string{
+		"/api/items":   "host=Backend.invalid:1",
+		"/other/items": "host=other.invalid:" + port,
+	} {
+		resp, err := http.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != expected {
+			t.Errorf("%s: expected %q from the pinned backend, got %d %q", path, expected, resp.StatusCode, body)
+		}
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 1 || testLogger.requests[0].metadata.DestinationURL != "http://Backend.invalid:1/items" {
+		t.Errorf("Expected the logged URL to keep the host name, got %+v", testLogger.requests)
+	}
+
+	if _, err := NewProxyServerWithOptions(ProxyServerOptions{AddressOverride: map[string]string{"example.com": "127.0.0.1:80:80"}}); err == nil {
+		t.Error("Expected an invalid override address to be rejected")
+	}
+}
+
+func TestAddressOverrideKeepsTLSServerName(t *testing.T) {
+	serverNames := make(chan string, 1)
+	backend := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serverNames <- r.TLS.ServerName
+		io.WriteString(w, "secure")
+	}))
+	defer backend.Close()
+
+	// The test certificate is valid for example.com
+	caFile := filepath.Join(t.TempDir(), "backend-ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal("Failed to write CA file:", err)
+	}
+
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/secure/", "https://example.com/", &NoOpLogger{}, RouteOptions{
+		ClientTLS:       &ClientTLSConfig{CAFile: caFile},
+		AddressOverride: map[string]string{"example.com:443": strings.TrimPrefix(backend.URL, "https://")},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/secure/")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "secure" {
+		t.Fatalf("Expected the pinned TLS backend to answer, got %d %q", resp.StatusCode, body)
+	}
+	if serverName := <-serverNames; serverName != "example.com" {
+		t.Errorf("Expected SNI example.com, got %q", serverName)
+	}
+}
@@ -0,0 +1,24 @@
+package loggingproxy
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// RequireBearerToken returns a handler that serves only requests sending
+// "Authorization: Bearer <token>", for admin endpoints that share a listener
+// with proxied traffic. Other requests get 401 Unauthorized. An empty token
+// rejects every request.
+func RequireBearerToken(token string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		scheme, credentials, _ := strings.Cut(request.Header.Get("Authorization"), " ")
+		if token == "" || !strings.EqualFold(scheme, "Bearer") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimSpace(credentials)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="logging-proxy admin"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, request)
+	})
+}
@@ -0,0 +1,39 @@
+package loggingproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireBearerToken(t *testing.T) {
+	handler := RequireBearerToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for authorization, expected := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Basic secret":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusNoContent,
+		"bearer secret": http.StatusNoContent,
+	} {
+		request := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != expected {
+			t.Errorf("Authorization %q: expected %d, got %d", authorization, expected, recorder.Code)
+		}
+	}
+
+	// Without a configured token nothing is served
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/admin/metrics", nil)
+	request.Header.Set("Authorization", "Bearer ")
+	RequireBearerToken("", http.NotFoundHandler()).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected an empty token to reject requests, got %d", recorder.Code)
+	}
+}
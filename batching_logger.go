@@ -0,0 +1,253 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultBatchMaxCount is the number of transcripts in a full batch when
+// BatchingLoggerOptions.MaxCount is not positive.
+const DefaultBatchMaxCount = 100
+
+// DefaultBatchMaxBytes is the size of a full batch when
+// BatchingLoggerOptions.MaxBytes is not positive.
+const DefaultBatchMaxBytes = 8 << 20
+
+// DefaultBatchFlushInterval is how often a partial batch is flushed when
+// BatchingLoggerOptions.FlushInterval is not positive.
+const DefaultBatchFlushInterval = 5 * time.Second
+
+// DefaultBatchMaxStreamSize caps every buffered transcript when
+// BatchingLoggerOptions.MaxStreamSize is not positive.
+const DefaultBatchMaxStreamSize = 1 << 20
+
+// BatchedTranscript is a logged request or response buffered by
+// BatchingLogger.
+type BatchedTranscript struct {
+	StreamType string
+	Timestamp  time.Time
+	Metadata   RequestMetadata
+	// Stream is the logged stream: the request or status line, headers and
+	// the (decompressed) body, cut off after MaxStreamSize bytes.
+	Stream []byte
+	// Size is the size of the whole stream and Truncated is set if Stream
+	// was cut off.
+	Size      int64
+	Truncated bool
+}
+
+// BatchLogger is implemented by loggers that can write several transcripts at
+// once, such as remote sinks that upload a batch in a single call.
+type BatchLogger interface {
+	LogBatch(batch []BatchedTranscript)
+}
+
+// BatchingLoggerOptions configures a BatchingLogger.
+type BatchingLoggerOptions struct {
+	// Logger receives the batches. If it implements BatchLogger, every flush
+	// is one LogBatch call; otherwise the transcripts are replayed one by one.
+	Logger Logger
+	// Forward receives every stream as well, as it arrives, typically a
+	// FileLogger. Nil only batches.
+	Forward Logger
+	// MaxCount and MaxBytes flush the batch as soon as it holds this many
+	// transcripts or bytes.
+	MaxCount int
+	MaxBytes int
+	// FlushInterval flushes a partial batch this long after the previous flush.
+	FlushInterval time.Duration
+	// MaxStreamSize caps every buffered transcript, in bytes. It is never
+	// larger than MaxBytes.
+	MaxStreamSize int
+}
+
+// BatchingLogger buffers completed transcripts in memory and hands them to
+// the wrapped logger in batches, when a batch is full or on an interval, so
+// remote sinks see one call per batch instead of one per stream. Streams are
+// read once, so every transcript is buffered, up to MaxStreamSize bytes; a
+// transcript replayed to a logger without LogBatch ends with an
+// "X-Logged-Stream: truncated" line if it was cut off.
+// Batches are flushed in order, one at a time; Close flushes the rest.
+type BatchingLogger struct {
+	logger        Logger
+	forward       Logger
+	maxCount      int
+	maxBytes      int
+	maxStreamSize int
+
+	mu      sync.Mutex
+	batch   []BatchedTranscript
+	size    int
+	closed  bool
+	flushMu sync.Mutex
+	dropped atomic.Uint64
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBatchingLogger creates a BatchingLogger and starts its flush timer.
+func NewBatchingLogger(options BatchingLoggerOptions) (*BatchingLogger, error) {
+	if options.Logger == nil {
+		return nil, errors.New("batching logger requires a logger")
+	}
+	if options.MaxCount <= 0 {
+		options.MaxCount = DefaultBatchMaxCount
+	}
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = DefaultBatchMaxBytes
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = DefaultBatchFlushInterval
+	}
+	if options.MaxStreamSize <= 0 {
+		options.MaxStreamSize = DefaultBatchMaxStreamSize
+	}
+	options.MaxStreamSize = min(options.MaxStreamSize, options.MaxBytes)
+
+	l := &BatchingLogger{
+		logger:        options.Logger,
+		forward:       options.Forward,
+		maxCount:      options.MaxCount,
+		maxBytes:      options.MaxBytes,
+		maxStreamSize: options.MaxStreamSize,
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	go l.run(options.FlushInterval)
+	return l, nil
+}
+
+// LogRequest buffers the request stream for the next batch
+func (l *BatchingLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	l.add("request", metadata, timestamp, rawRequestStream)
+}
+
+// LogResponse buffers the response stream for the next batch
+func (l *BatchingLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	l.add("response", metadata, timestamp, rawResponseStream)
+}
+
+// LogConnect forwards CONNECT events right away to the wrapped loggers that
+// support them. They carry no stream worth batching.
+func (l *BatchingLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(l.forward, metadata, timestamp)
+	forwardConnect(l.logger, metadata, timestamp)
+}
+
+// Flush hands the buffered transcripts to the wrapped logger now.
+func (l *BatchingLogger) Flush() {
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+	l.mu.Lock()
+	batch := l.take()
+	l.mu.Unlock()
+	l.write(batch)
+}
+
+// Dropped returns the number of transcripts dropped because the logger was
+// already closed.
+func (l *BatchingLogger) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+// Close stops the flush timer, flushes the buffered transcripts and closes
+// the wrapped loggers that implement io.Closer.
+func (l *BatchingLogger) Close() error {
+	l.mu.Lock()
+	alreadyClosed := l.closed
+	l.closed = true
+	l.mu.Unlock()
+	if !alreadyClosed {
+		close(l.stop)
+	}
+	<-l.done
+	l.Flush()
+
+	return errors.Join(closeWrapped(l.forward), closeWrapped(l.logger))
+}
+
+func (l *BatchingLogger) add(streamType string, metadata RequestMetadata, timestamp time.Time, stream io.ReadCloser) {
+	captured := captureStream(stream, l.maxStreamSize, forwardTo(l.forward, streamType, metadata, timestamp))
+	transcript := BatchedTranscript{
+		StreamType: streamType,
+		Timestamp:  timestamp,
+		Metadata:   metadata,
+		Stream:     captured.Bytes(),
+		Size:       captured.size,
+		Truncated:  captured.size > int64(captured.Len()),
+	}
+
+	// flushMu keeps batches in order: a full batch is written before the
+	// next one can fill up and overtake it.
+	l.flushMu.Lock()
+	defer l.flushMu.Unlock()
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		l.dropped.Add(1)
+		return
+	}
+	var full []BatchedTranscript
+	if l.size+len(transcript.Stream) > l.maxBytes {
+		full = l.take()
+	}
+	l.batch = append(l.batch, transcript)
+	l.size += len(transcript.Stream)
+	if full == nil && (len(l.batch) >= l.maxCount || l.size >= l.maxBytes) {
+		full = l.take()
+	}
+	l.mu.Unlock()
+	l.write(full)
+}
+
+// take removes and returns the buffered batch. The caller holds l.mu.
+func (l *BatchingLogger) take() []BatchedTranscript {
+	batch := l.batch
+	l.batch = nil
+	l.size = 0
+	return batch
+}
+
+func (l *BatchingLogger) write(batch []BatchedTranscript) {
+	if len(batch) == 0 {
+		return
+	}
+	if batchLogger, ok := l.logger.(BatchLogger); ok {
+		batchLogger.LogBatch(batch)
+		return
+	}
+	for _, transcript := range batch {
+		var stream io.ReadCloser = io.NopCloser(bytes.NewReader(transcript.Stream))
+		if transcript.Truncated {
+			// Mark where the stream was cut off
+			note := fmt.Sprintf("\r\nX-Logged-Stream: truncated; size=%d; logged=%d\r\n", transcript.Size, len(transcript.Stream))
+			stream = io.NopCloser(io.MultiReader(bytes.NewReader(transcript.Stream), strings.NewReader(note)))
+		}
+		if transcript.StreamType == "request" {
+			l.logger.LogRequest(transcript.Metadata, transcript.Timestamp, stream)
+		} else {
+			l.logger.LogResponse(transcript.Metadata, transcript.Timestamp, stream)
+		}
+	}
+}
+
+func (l *BatchingLogger) run(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			l.Flush()
+		case <-l.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package loggingproxy
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubBatchLogger records every batch it receives.
+type stubBatchLogger struct {
+	NoOpLogger
+	mu      sync.Mutex
+	batches [][]BatchedTranscript
+}
+
+func (l *stubBatchLogger) LogBatch(batch []BatchedTranscript) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.batches = append(l.batches, batch)
+}
+
+func (l *stubBatchLogger) Batches() [][]BatchedTranscript {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([][]BatchedTranscript(nil), l.batches...)
+}
+
+func logBatchRequests(l Logger, count int) {
+	for i := 0; i < count; i++ {
+		metadata := RequestMetadata{ID: fmt.Sprintf("req-%d", i)}
+		stream := fmt.Sprintf("GET /%d HTTP/1.1\r\n\r\n", i)
+		l.LogRequest(metadata, time.Now(), io.NopCloser(strings.NewReader(stream)))
+	}
+}
+
+func TestBatchingLoggerFlushesFullBatch(t *testing.T) {
+	sink := &stubBatchLogger{}
+	batchingLogger, err := NewBatchingLogger(BatchingLoggerOptions{Logger: sink, MaxCount: 5, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create batching logger: %v", err)
+	}
+	defer batchingLogger.Close()
+
+	logBatchRequests(batchingLogger, 5)
+
+	batches := sink.Batches()
+	if len(batches) != 1 || len(batches[0]) != 5 {
+		t.Fatalf("expected one batch of 5 transcripts, got %d batches", len(batches))
+	}
+	for i, transcript := range batches[0] {
+		if transcript.StreamType != "request" || transcript.Metadata.ID != fmt.Sprintf("req-%d", i) {
+			t.Errorf("unexpected transcript %d: %s %s", i, transcript.StreamType, transcript.Metadata.ID)
+		}
+		if want := fmt.Sprintf("GET /%d HTTP/1.1\r\n\r\n", i); string(transcript.Stream) != want {
+			t.Errorf("expected stream %q, got %q", want, transcript.Stream)
+		}
+	}
+}
+
+func TestBatchingLoggerFlushesPartialBatchOnInterval(t *testing.T) {
+	sink := &stubBatchLogger{}
+	batchingLogger, err := NewBatchingLogger(BatchingLoggerOptions{Logger: sink, MaxCount: 10, FlushInterval: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("failed to create batching logger: %v", err)
+	}
+	defer batchingLogger.Close()
+
+	logBatchRequests(batchingLogger, 3)
+	if batches := sink.Batches(); len(batches) != 0 {
+		t.Fatalf("expected no flush below the batch size, got %d batches", len(batches))
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(sink.Batches()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	batches := sink.Batches()
+	if len(batches) != 1 || len(batches[0]) != 3 {
+		t.Fatalf("expected one timed batch of 3 transcripts, got %v", batches)
+	}
+}
+
+func TestBatchingLoggerReplaysToPlainLoggerAndFlushesOnClose(t *testing.T) {
+	testLogger := &TestLogger{}
+	batchingLogger, err := NewBatchingLogger(BatchingLoggerOptions{Logger: testLogger, MaxBytes: 64, MaxStreamSize: 16, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create batching logger: %v", err)
+	}
+
+	batchingLogger.LogResponse(RequestMetadata{ID: "big"}, time.Now(), io.NopCloser(strings.NewReader("HTTP/1.1 200 OK\r\n\r\nthis body is cut off")))
+	if len(testLogger.responses) != 0 {
+		t.Fatalf("expected the response to be buffered, got %d logged", len(testLogger.responses))
+	}
+	if err := batchingLogger.Close(); err != nil {
+		t.Fatalf("failed to close batching logger: %v", err)
+	}
+
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("expected the buffered response to be flushed on close, got %d", len(testLogger.responses))
+	}
+	if got := testLogger.responses[0].content; got != "HTTP/1.1 200 OK\r\r\nX-Logged-Stream: truncated; size=39; logged=16\r\n" {
+		t.Errorf("expected the stream cut off after 16 bytes and marked, got %q", got)
+	}
+
+	logBatchRequests(batchingLogger, 1)
+	if batchingLogger.Dropped() != 1 || len(testLogger.requests) != 0 {
+		t.Errorf("expected a transcript logged after close to be dropped, got %d dropped", batchingLogger.Dropped())
+	}
+}
@@ -0,0 +1,154 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// BodyCaptureMode selects how much of the request and response bodies of a
+// route reach its logger. The backend and the client always get the complete
+// bodies. The zero value is BodyCaptureFull.
+type BodyCaptureMode struct {
+	kind  string
+	limit int64
+}
+
+var (
+	// BodyCaptureFull logs complete bodies.
+	BodyCaptureFull = BodyCaptureMode{}
+	// BodyCaptureNone logs nothing for the route; its logger receives no
+	// streams at all.
+	BodyCaptureNone = BodyCaptureMode{kind: "none"}
+	// BodyCaptureHeadersOnly logs the request line or status line and the
+	// headers. Once the body ended, an "X-Logged-Body: omitted; size=N" line
+	// after them gives the size of the body as sent.
+	BodyCaptureHeadersOnly = BodyCaptureMode{kind: "headers"}
+)
+
+// BodyCaptureTruncated logs at most limit bytes of each (decompressed) body.
+// Longer bodies are followed by an "X-Logged-Body: truncated; size=N;
+// logged=limit" line once they ended.
+func BodyCaptureTruncated(limit int64) BodyCaptureMode {
+	return BodyCaptureMode{kind: "truncated", limit: max(limit, 0)}
+}
+
+// ParseBodyCaptureMode parses "full", "headers", "none" or "truncated:N". An
+// empty string is full.
+func ParseBodyCaptureMode(mode string) (BodyCaptureMode, error) {
+	switch mode {
+	case "", "full":
+		return BodyCaptureFull, nil
+	case "headers":
+		return BodyCaptureHeadersOnly, nil
+	case "none":
+		return BodyCaptureNone, nil
+	}
+	if limit, ok := strings.CutPrefix(mode, "truncated:"); ok {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err == nil && n >= 0 {
+			return BodyCaptureTruncated(n), nil
+		}
+	}
+	return BodyCaptureFull, fmt.Errorf("invalid body capture mode %q (want full, headers, none or truncated:N)", mode)
+}
+
+// String returns the mode in the form ParseBodyCaptureMode accepts.
+func (m BodyCaptureMode) String() string {
+	switch m.kind {
+	case "":
+		return "full"
+	case "truncated":
+		return fmt.Sprintf("truncated:%d", m.limit)
+	}
+	return m.kind
+}
+
+// logsBody reports whether any of the body is logged, so that it is worth
+// decompressing.
+func (m BodyCaptureMode) logsBody() bool {
+	return m.kind == "" || m.kind == "truncated"
+}
+
+// captureBody returns what is logged of body. What is logged of the body is
+// passed on as it arrives. The rest of a truncated or omitted body is read to
+// its end, and a note with its size follows as the last line of the log. A
+// body that fails is still logged as failed, so the log is marked incomplete.
+func (m BodyCaptureMode) captureBody(body io.Reader) io.Reader {
+	switch m.kind {
+	case "headers", "none":
+		return &cappedBody{body: body, omitted: true}
+	case "truncated":
+		return &cappedBody{body: body, limit: m.limit}
+	}
+	return body
+}
+
+// omitBody is what is logged of a body that was not passed to the log at
+// all: the X-Logged-Body omitted note, once end ends, with the size counted
+// in sent. A failure read from end still marks the log incomplete.
+func omitBody(end io.Reader, sent *atomic.Int64) io.Reader {
+	return &cappedBody{body: end, omitted: true, sent: sent}
+}
+
+// cappedBody passes on the first limit bytes of body and then reads the rest
+// of it, ending with an X-Logged-Body note if anything was left out.
+type cappedBody struct {
+	body    io.Reader
+	limit   int64
+	omitted bool
+	size    int64
+	// sent, if set, is the size of the body, counted where it was sent
+	// instead of read from body
+	sent *atomic.Int64
+	// tail is what is logged after the first limit bytes, set once they were
+	// read
+	tail io.Reader
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	if b.tail == nil && b.size < b.limit {
+		if remaining := b.limit - b.size; int64(len(p)) > remaining {
+			p = p[:remaining]
+		}
+		n, err := b.body.Read(p)
+		b.size += int64(n)
+		if err != nil {
+			b.tail = failedAfter(nil, err)
+		}
+		if n > 0 || b.tail == nil {
+			return n, nil
+		}
+	}
+	if b.tail == nil {
+		rest, err := io.Copy(io.Discard, b.body)
+		b.size += rest
+		if b.sent != nil {
+			b.size = b.sent.Load()
+		}
+		var note string
+		if b.omitted {
+			note = fmt.Sprintf("X-Logged-Body: omitted; size=%d\r\n", b.size)
+		} else if rest > 0 {
+			note = fmt.Sprintf("X-Logged-Body: truncated; size=%d; logged=%d\r\n", b.size, b.limit)
+			if b.limit > 0 {
+				// End the logged part of the body's last line
+				note = "\r\n" + note
+			}
+		}
+		b.tail = failedAfter([]byte(note), err)
+	}
+	return b.tail.Read(p)
+}
+
+// failedAfter returns a reader of logged that ends with err, if any. An
+// io.EOF error is the end of logged.
+func failedAfter(logged []byte, err error) io.Reader {
+	if err != nil && err != io.EOF {
+		return io.MultiReader(bytes.NewReader(logged), errorReader{err: err})
+	}
+	return bytes.NewReader(logged)
+}
@@ -0,0 +1,187 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyCaptureModes(t *testing.T) {
+	requestBody := strings.Repeat("q", 100)
+	responseBody := strings.Repeat("r", 200)
+	received := make(chan string, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- string(body)
+		io.WriteString(w, responseBody)
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		mode BodyCaptureMode
+		// loggedRequest and loggedResponse are the expected logged bodies,
+		// and note the expected X-Logged-Body lines after them
+		loggedRequest  string
+		loggedResponse string
+		requestNote    string
+		responseNote   string
+		noLogs         bool
+	}{
+		{mode: BodyCaptureFull, loggedRequest: requestBody, loggedResponse: responseBody},
+		{mode: BodyCaptureTruncated(150), loggedRequest: requestBody, loggedResponse: responseBody[:150],
+			responseNote: "X-Logged-Body: truncated; size=200; logged=150"},
+		{mode: BodyCaptureTruncated(10), loggedRequest: requestBody[:10], loggedResponse: responseBody[:10],
+			requestNote: "X-Logged-Body: truncated; size=100; logged=10", responseNote: "X-Logged-Body: truncated; size=200; logged=10"},
+		{mode: BodyCaptureHeadersOnly, requestNote: "X-Logged-Body: omitted; size=100", responseNote: "X-Logged-Body: omitted; size=200"},
+		{mode: BodyCaptureNone, noLogs: true},
+	}
+	for _, test := range tests {
+		t.Run(test.mode.String(), func(t *testing.T) {
+			testLogger := &TestLogger{}
+			proxyServer := NewProxyServer("")
+			if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{BodyCapture: test.mode}); err != nil {
+				t.Fatal("Failed to add route:", err)
+			}
+			testServer := httptest.NewServer(proxyServer)
+			defer testServer.Close()
+
+			resp, err := http.Post(testServer.URL+"/api/items", "text/plain", strings.NewReader(requestBody))
+			if err != nil {
+				t.Fatal("Request failed:", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != responseBody {
+				t.Errorf("Expected the client to get the full response, got %d bytes", len(body))
+			}
+			if got := <-received; got != requestBody {
+				t.Errorf("Expected the backend to get the full request, got %d bytes", len(got))
+			}
+
+			// Give async logging a moment to complete
+			time.Sleep(100 * time.Millisecond)
+			if test.noLogs {
+				if len(testLogger.requests) != 0 || len(testLogger.responses) != 0 {
+					t.Errorf("Expected nothing to be logged, got %d requests and %d responses", len(testLogger.requests), len(testLogger.responses))
+				}
+				return
+			}
+			if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+				t.Fatalf("Expected a request and a response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+			}
+			for _, logged := range []struct{ content, body, note string }{
+				{testLogger.requests[0].content, test.loggedRequest, test.requestNote},
+				{testLogger.responses[0].content, test.loggedResponse, test.responseNote},
+			} {
+				head, loggedBody, _ := strings.Cut(logged.content, "\r\n\r\n")
+				expected := logged.body
+				if logged.note != "" {
+					if expected != "" {
+						expected += "\r\n"
+					}
+					expected += logged.note + "\r\n"
+				}
+				if loggedBody != expected {
+					t.Errorf("Expected the logged body %.40q, got %.40q", expected, loggedBody)
+				}
+				if strings.Contains(head, "X-Logged-Body") {
+					t.Errorf("Expected no X-Logged-Body note in the headers:\n%s", head)
+				}
+			}
+		})
+	}
+}
+
+func TestBodyCaptureModesApplyToFallbacks(t *testing.T) {
+	// Close the backend straight away so its address refuses connections
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+
+	fallbackBody := `{"error":"offline"}`
+	for _, test := range []struct {
+		mode   BodyCaptureMode
+		logged string
+	}{
+		{BodyCaptureFull, fallbackBody},
+		{BodyCaptureTruncated(5), fallbackBody[:5] + "\r\nX-Logged-Body: truncated; size=19; logged=5\r\n"},
+		{BodyCaptureHeadersOnly, "X-Logged-Body: omitted; size=19\r\n"},
+	} {
+		t.Run(test.mode.String(), func(t *testing.T) {
+			testLogger := &TestLogger{}
+			proxyServer := NewProxyServer("")
+			options := RouteOptions{BodyCapture: test.mode, Fallback: &FallbackResponse{Body: []byte(fallbackBody)}}
+			if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, options); err != nil {
+				t.Fatal("Failed to add route:", err)
+			}
+			testServer := httptest.NewServer(proxyServer)
+			defer testServer.Close()
+
+			status, body := getStatusAndBody(t, testServer.URL+"/api/models")
+			if status != http.StatusServiceUnavailable || body != fallbackBody {
+				t.Fatalf("Expected the client to get the full fallback, got %d %q", status, body)
+			}
+
+			// Give async logging a moment to complete
+			time.Sleep(100 * time.Millisecond)
+			if len(testLogger.responses) != 1 {
+				t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+			}
+			if _, loggedBody, _ := strings.Cut(testLogger.responses[0].content, "\r\n\r\n"); loggedBody != test.logged {
+				t.Errorf("Expected the logged fallback body %q, got %q", test.logged, loggedBody)
+			}
+		})
+	}
+}
+
+func TestBodyCaptureLogsBeforeTheBodyEnds(t *testing.T) {
+	for _, mode := range []BodyCaptureMode{BodyCaptureHeadersOnly, BodyCaptureTruncated(5)} {
+		reader, writer := io.Pipe()
+		captured := mode.captureBody(reader)
+		go writer.Write([]byte("0123456789"))
+
+		// What is logged of the body is available while the body continues
+		logged := make([]byte, 5)
+		if mode == BodyCaptureTruncated(5) {
+			if _, err := io.ReadFull(captured, logged); err != nil || string(logged) != "01234" {
+				t.Fatalf("Expected the logged prefix before the body ended, got %q, %v", logged, err)
+			}
+		}
+
+		done := make(chan string)
+		go func() {
+			rest, _ := io.ReadAll(captured)
+			done <- string(rest)
+		}()
+		select {
+		case rest := <-done:
+			t.Fatalf("Expected the note to wait for the end of the body, got %q", rest)
+		case <-time.After(50 * time.Millisecond):
+		}
+		writer.Write([]byte("abc"))
+		writer.Close()
+		rest := <-done
+		if mode == BodyCaptureHeadersOnly && rest != "X-Logged-Body: omitted; size=13\r\n" {
+			t.Errorf("Expected an omitted note, got %q", rest)
+		}
+		if mode == BodyCaptureTruncated(5) && rest != "\r\nX-Logged-Body: truncated; size=13; logged=5\r\n" {
+			t.Errorf("Expected a truncated note, got %q", rest)
+		}
+	}
+}
+
+func TestParseBodyCaptureMode(t *testing.T) {
+	for _, mode := range []BodyCaptureMode{BodyCaptureFull, BodyCaptureNone, BodyCaptureHeadersOnly, BodyCaptureTruncated(4096)} {
+		parsed, err := ParseBodyCaptureMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("Expected %s to round-trip, got %v, %v", mode, parsed, err)
+		}
+	}
+	for _, invalid := range []string{"partial", "truncated", "truncated:-1", "truncated:x"} {
+		if _, err := ParseBodyCaptureMode(invalid); err == nil {
+			t.Errorf("Expected %q to be rejected", invalid)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultBodyFilterTimeout bounds a filter command when BodyFilter.Timeout is
+// not positive.
+const DefaultBodyFilterTimeout = 5 * time.Second
+
+// DefaultBodyFilterMaxBody is the largest body, and filter output, when
+// BodyFilter.MaxBody is not positive.
+const DefaultBodyFilterMaxBody = 1 << 20
+
+// maxFilterStderr caps the stderr output quoted in filter errors.
+const maxFilterStderr = 512
+
+// BodyFilter transforms request or response bodies with an external command,
+// like a CGI filter: the body is written to its stdin and its stdout is used
+// as the new body. The command runs without a shell. Streaming bodies, which
+// are chunked uploads of unknown length and event streams, are passed through
+// unfiltered; compressed bodies are decompressed for the command and sent on
+// uncompressed.
+type BodyFilter struct {
+	// Command is the program and its arguments.
+	Command []string
+	// Timeout kills the command if it has not finished in time.
+	Timeout time.Duration
+	// MaxBody is the largest body the command is given and the largest
+	// output it may produce. Larger bodies fail the request.
+	MaxBody int64
+}
+
+// withDefaults validates the filter and fills in its defaults.
+func (f *BodyFilter) withDefaults() (*BodyFilter, error) {
+	if f == nil {
+		return nil, nil
+	}
+	if len(f.Command) == 0 || f.Command[0] == "" {
+		return nil, errors.New("body filter requires a command")
+	}
+	filter := *f
+	if filter.Timeout <= 0 {
+		filter.Timeout = DefaultBodyFilterTimeout
+	}
+	if filter.MaxBody <= 0 {
+		filter.MaxBody = DefaultBodyFilterMaxBody
+	}
+	return &filter, nil
+}
+
+// run pipes body through the command and returns its output.
+func (f *BodyFilter) run(ctx context.Context, body []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, f.Command[0], f.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	stdout := &limitedBuffer{limit: f.MaxBody}
+	stderr := &limitedBuffer{limit: maxFilterStderr}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out after %s", f.Command[0], f.Timeout)
+	}
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%s: %w: %s", f.Command[0], err, message)
+		}
+		return nil, fmt.Errorf("%s: %w", f.Command[0], err)
+	}
+	if stdout.exceeded {
+		return nil, fmt.Errorf("%s produced more than %d bytes", f.Command[0], f.MaxBody)
+	}
+	return stdout.Bytes(), nil
+}
+
+// filterRequestBody runs the route's request filter on a request body of
+// known length. It returns a zero status for requests that may be forwarded.
+func (r *proxyRoute) filterRequestBody(request *http.Request) (int, string) {
+	if r.requestFilter == nil || request.Body == nil || request.Body == http.NoBody || request.ContentLength <= 0 {
+		return 0, ""
+	}
+	if request.ContentLength > r.requestFilter.MaxBody {
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("request body of %d bytes is too large to filter (limit %d)", request.ContentLength, r.requestFilter.MaxBody)
+	}
+	body, err := readFilterBody(request.Body, request.Header.Get("Content-Encoding"), r.requestFilter.MaxBody)
+	request.Body.Close()
+	if err != nil {
+		return http.StatusBadRequest, fmt.Sprintf("failed to read request body: %v", err)
+	}
+	filtered, err := r.requestFilter.run(request.Context(), body)
+	if err != nil {
+		return http.StatusBadGateway, fmt.Sprintf("request filter failed: %v", err)
+	}
+
+	request.Body = io.NopCloser(bytes.NewReader(filtered))
+	request.ContentLength = int64(len(filtered))
+	request.Header.Set("Content-Length", strconv.Itoa(len(filtered)))
+	request.Header.Del("Content-Encoding")
+	return 0, ""
+}
+
+// filterResponseBody runs the route's response filter and replaces the body
+// of response with its output.
+func (r *proxyRoute) filterResponseBody(ctx context.Context, method string, response *http.Response) error {
+	if r.responseFilter == nil || method == http.MethodHead || response.ContentLength == 0 {
+		return nil
+	}
+	if response.StatusCode < http.StatusOK || response.StatusCode == http.StatusNoContent || response.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if isStreamingContentType(response.Header.Get("Content-Type")) {
+		return nil
+	}
+	if response.ContentLength > r.responseFilter.MaxBody {
+		return fmt.Errorf("response body of %d bytes is too large to filter (limit %d)", response.ContentLength, r.responseFilter.MaxBody)
+	}
+	body, err := readFilterBody(response.Body, response.Header.Get("Content-Encoding"), r.responseFilter.MaxBody)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	filtered, err := r.responseFilter.run(ctx, body)
+	if err != nil {
+		return fmt.Errorf("response filter failed: %w", err)
+	}
+
+	response.Body = io.NopCloser(bytes.NewReader(filtered))
+	response.ContentLength = int64(len(filtered))
+	response.TransferEncoding = nil
+	response.Header.Set("Content-Length", strconv.Itoa(len(filtered)))
+	response.Header.Del("Content-Encoding")
+	return nil
+}
+
+// readFilterBody reads and decompresses a body of at most maxBody bytes.
+func readFilterBody(body io.Reader, contentEncoding string, maxBody int64) ([]byte, error) {
+	if contentEncoding != "" {
+		decompressed, err := decompressReader(body, contentEncoding)
+		if err != nil {
+			return nil, err
+		}
+		defer decompressed.Close()
+		body = decompressed
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBody {
+		return nil, fmt.Errorf("body is larger than %d bytes", maxBody)
+	}
+	return data, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a runaway command cannot exhaust memory.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit    int64
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - int64(b.Len()); int64(len(p)) > room {
+		b.exceeded = true
+		b.Buffer.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,103 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyFiltersTransformRequestAndResponse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "backend saw: "+string(body))
+	}))
+	defer backend.Close()
+
+	upper := &BodyFilter{Command: []string{"tr", "a-z", "A-Z"}}
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/request/", backend.URL+"/", testLogger, RouteOptions{RequestFilter: upper}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	if err := proxyServer.AddRouteWithOptions("/response/", backend.URL+"/", testLogger, RouteOptions{ResponseFilter: upper}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for path, want := range map[string]string{
+		"/request/":  "backend saw: HELLO",
+		"/response/": "BACKEND SAW: HELLO",
+	} {
+		resp, err := http.Post(testServer.URL+path, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != want {
+			t.Errorf("%s: expected %q, got %d: %q", path, want, resp.StatusCode, body)
+		}
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	logged := ""
+	for _, entry := range append(testLogger.requests, testLogger.responses...) {
+		logged += entry.content + "\n"
+	}
+	for _, want := range []string{"\r\n\r\nHELLO", "Content-Length: 5\r\n", "\r\n\r\nBACKEND SAW: HELLO"} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("Expected the logs to contain the filtered %q, got:\n%s", want, logged)
+		}
+	}
+}
+
+func TestBodyFilterFailureIsBadGateway(t *testing.T) {
+	backendCalled := false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendCalled = true
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	slow := &BodyFilter{Command: []string{"sleep", "5"}, Timeout: 100 * time.Millisecond}
+	if err := proxyServer.AddRouteWithOptions("/slow/", backend.URL+"/", &NoOpLogger{}, RouteOptions{RequestFilter: slow}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	failing := &BodyFilter{Command: []string{"sh", "-c", "echo broken >&2; exit 3"}}
+	if err := proxyServer.AddRouteWithOptions("/failing/", backend.URL+"/", &NoOpLogger{}, RouteOptions{RequestFilter: failing}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	if err := proxyServer.AddRouteWithOptions("/empty/", backend.URL+"/", &NoOpLogger{}, RouteOptions{RequestFilter: &BodyFilter{}}); err == nil {
+		t.Error("Expected a filter without a command to be rejected")
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for path, want := range map[string]string{
+		"/slow/":    "timed out after 100ms",
+		"/failing/": "exit status 3: broken",
+	} {
+		start := time.Now()
+		resp, err := http.Post(testServer.URL+path, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadGateway || !strings.Contains(string(body), want) {
+			t.Errorf("%s: expected 502 mentioning %q, got %d: %s", path, want, resp.StatusCode, body)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: expected the filter to be stopped by its timeout, took %s", path, elapsed)
+		}
+	}
+	if backendCalled {
+		t.Error("Expected the backend not to be contacted when the request filter fails")
+	}
+}
@@ -0,0 +1,171 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DefaultBodyTagsMaxBody is the largest request body read for
+// RouteOptions.BodyTags when BodyTagsMaxBody is not positive.
+const DefaultBodyTagsMaxBody = 64 << 10
+
+// bodyTags extracts RequestMetadata.Tags from JSON request bodies.
+type bodyTags struct {
+	paths   map[string]jsonPath
+	maxBody int64
+}
+
+// newBodyTags parses the paths of RouteOptions.BodyTags, keyed by tag name.
+// It returns nil if there are none.
+func newBodyTags(tags map[string]string, maxBody int64) (*bodyTags, error) {
+	if len(tags) == 0 {
+		return nil, nil
+	}
+	if maxBody <= 0 {
+		maxBody = DefaultBodyTagsMaxBody
+	}
+	t := &bodyTags{paths: map[string]jsonPath{}, maxBody: maxBody}
+	for name, path := range tags {
+		if name == "" {
+			return nil, fmt.Errorf("body tag for %q has no name", path)
+		}
+		parsed, err := parseJSONPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("body tag %s: %w", name, err)
+		}
+		t.paths[name] = parsed
+	}
+	return t, nil
+}
+
+// extract buffers an uncompressed JSON request body of at most maxBody bytes
+// and returns the tags found in it. The body is replaced, so the request can
+// still be forwarded. Other bodies are left unread and get no tags.
+func (t *bodyTags) extract(request *http.Request) map[string]string {
+	if t == nil || request.Body == nil || request.Body == http.NoBody || request.ContentLength == 0 || request.ContentLength > t.maxBody {
+		return nil
+	}
+	if !isJSONContentType(request.Header.Get("Content-Type")) || request.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	// Read one byte past the limit to catch chunked bodies that exceed it
+	body, err := io.ReadAll(io.LimitReader(request.Body, t.maxBody+1))
+	request.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(body), request.Body), Closer: request.Body}
+	if err != nil || int64(len(body)) > t.maxBody {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var document any
+	if decoder.Decode(&document) != nil {
+		return nil
+	}
+
+	var tags map[string]string
+	for name, path := range t.paths {
+		value, ok := path.lookup(document)
+		if !ok {
+			continue
+		}
+		if tags == nil {
+			tags = map[string]string{}
+		}
+		tags[name] = jsonTagValue(value)
+	}
+	return tags
+}
+
+// jsonTagValue renders a JSON value as a tag: strings as they are, anything
+// else as JSON.
+func jsonTagValue(value any) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// jsonPath is a path into a JSON document, such as $.user.id or
+// $.messages[0].role.
+type jsonPath []jsonPathStep
+
+// jsonPathStep selects an object member by key, or an array element if index
+// is not negative.
+type jsonPathStep struct {
+	key   string
+	index int
+}
+
+// parseJSONPath parses a path of .key, [index] and ['key'] steps. The leading
+// $ is optional, and so is the dot before the first key.
+func parseJSONPath(path string) (jsonPath, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	if rest != "" && rest[0] != '.' && rest[0] != '[' {
+		rest = "." + rest
+	}
+	var parsed jsonPath
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			key := rest[1:end]
+			if key == "" {
+				return nil, fmt.Errorf("invalid JSON path %q: empty key", path)
+			}
+			parsed = append(parsed, jsonPathStep{key: key, index: -1})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %q: missing ]", path)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				parsed = append(parsed, jsonPathStep{key: inner[1 : len(inner)-1], index: -1})
+			} else if index, err := strconv.Atoi(inner); err == nil && index >= 0 {
+				parsed = append(parsed, jsonPathStep{index: index})
+			} else {
+				return nil, fmt.Errorf("invalid JSON path %q: bad step [%s]", path, inner)
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("invalid JSON path %q: expected . or [ before %q", path, rest)
+		}
+	}
+	if len(parsed) == 0 {
+		return nil, fmt.Errorf("invalid JSON path %q: no steps", path)
+	}
+	return parsed, nil
+}
+
+// lookup returns the value at the path in a decoded JSON document.
+func (p jsonPath) lookup(document any) (any, bool) {
+	value := document
+	for _, step := range p {
+		if step.index >= 0 {
+			array, ok := value.([]any)
+			if !ok || step.index >= len(array) {
+				return nil, false
+			}
+			value = array[step.index]
+			continue
+		}
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[step.key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,77 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyTagsRecordFieldsOfJSONRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(w, r.Body)
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/v1/", backend.URL+"/", testLogger, RouteOptions{
+		BodyTags: map[string]string{
+			"model":      "$.model",
+			"first_role": "$.messages[0].role",
+			"user_id":    "metadata['user.id']",
+			"stream":     "$.stream",
+		},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	requestBody := `{"model":"gpt-test","stream":false,"messages":[{"role":"system"}],"metadata":{"user.id":42}}`
+	for _, contentType := range []string{"application/json", "text/plain"} {
+		resp, err := http.Post(testServer.URL+"/v1/chat", contentType, strings.NewReader(requestBody))
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != requestBody {
+			t.Errorf("Expected the body to be forwarded unchanged, got %q", body)
+		}
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 2 {
+		t.Fatalf("Expected 2 request logs, got %d", len(testLogger.requests))
+	}
+	var tagged []map[string]string
+	for _, entry := range testLogger.requests {
+		if !strings.HasSuffix(entry.content, requestBody) {
+			t.Errorf("Expected the whole body in the log, got %q", entry.content)
+		}
+		if entry.metadata.Tags != nil {
+			tagged = append(tagged, entry.metadata.Tags)
+		}
+	}
+	if len(tagged) != 1 {
+		t.Fatalf("Expected only the JSON request to be tagged, got %v", tagged)
+	}
+	expected := map[string]string{"model": "gpt-test", "first_role": "system", "user_id": "42", "stream": "false"}
+	for name, value := range expected {
+		if tagged[0][name] != value {
+			t.Errorf("Expected tag %s=%q, got %q", name, value, tagged[0][name])
+		}
+	}
+
+	for _, path := range []string{"$", "$.model[x]", "$..model", "$.a[0"} {
+		err := NewProxyServer("").AddRouteWithOptions("/x/", backend.URL, &NoOpLogger{}, RouteOptions{BodyTags: map[string]string{"tag": path}})
+		if err == nil {
+			t.Errorf("Expected path %q to be rejected", path)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package loggingproxy
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// originalURLKey holds the request URL before its path was lowercased for
+// case-insensitive route matching.
+type originalURLKey struct{}
+
+// serveCaseInsensitive dispatches r on its lowercased path if that matches a
+// registered route or handler, and on the path as sent otherwise, so 404s
+// and the mux's redirects keep the client's casing.
+func (s *ProxyServer) serveCaseInsensitive(w http.ResponseWriter, r *http.Request) {
+	lowered := *r.URL
+	lowered.Path = lowerASCII(r.URL.Path)
+	lowered.RawPath = lowerASCII(r.URL.RawPath)
+	if lowered.Path == r.URL.Path && lowered.RawPath == r.URL.RawPath {
+		s.mux.ServeHTTP(w, r)
+		return
+	}
+
+	matchRequest := r.WithContext(context.WithValue(r.Context(), originalURLKey{}, r.URL))
+	matchRequest.URL = &lowered
+	if _, pattern := s.mux.Handler(matchRequest); !s.hasPattern(pattern) {
+		s.mux.ServeHTTP(w, r)
+		return
+	}
+	s.mux.ServeHTTP(w, matchRequest)
+}
+
+// restoreOriginalURL undoes the lowercasing of serveCaseInsensitive and
+// returns the {path...} wildcard of the route in the client's casing.
+func restoreOriginalURL(request *http.Request) string {
+	path := request.PathValue("path")
+	original, ok := request.Context().Value(originalURLKey{}).(*url.URL)
+	if !ok {
+		return path
+	}
+	request.URL = original
+	// Only ASCII letters are lowercased, so the lengths still match
+	if len(path) <= len(original.Path) {
+		if suffix := original.Path[len(original.Path)-len(path):]; strings.EqualFold(suffix, path) {
+			return suffix
+		}
+	}
+	return path
+}
+
+func (s *ProxyServer) hasPattern(pattern string) bool {
+	s.patternsMu.RLock()
+	defer s.patternsMu.RUnlock()
+	_, ok := s.patterns[pattern]
+	return ok
+}
+
+func (s *ProxyServer) addPattern(pattern string) {
+	s.patternsMu.Lock()
+	defer s.patternsMu.Unlock()
+	if s.patterns == nil {
+		s.patterns = map[string]struct{}{}
+	}
+	s.patterns[pattern] = struct{}{}
+}
+
+// lowerPatternPath lowercases the path of a mux pattern, leaving its method
+// and host alone.
+func lowerPatternPath(pattern string) string {
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		return pattern[:i] + lowerASCII(pattern[i:])
+	}
+	return pattern
+}
+
+// lowerASCII lowercases ASCII letters only, unlike strings.ToLower, so the
+// length of the path never changes.
+func lowerASCII(s string) string {
+	return strings.Map(func(r rune) rune {
+		if 'A' <= r && r <= 'Z' {
+			return r + ('a' - 'A')
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,54 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCaseInsensitiveRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	for _, caseInsensitive := range []bool{false, true} {
+		testLogger := &TestLogger{}
+		proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{CaseInsensitiveRoutes: caseInsensitive})
+		if err != nil {
+			t.Fatalf("Failed to create proxy server: %v", err)
+		}
+		if err := proxyServer.AddRoute("/api/", backend.URL+"/v1/", testLogger); err != nil {
+			t.Fatal("Failed to add route:", err)
+		}
+		if err := proxyServer.AddRouteWithOptions("/Files/", backend.URL+"/files/", testLogger, RouteOptions{PreservePath: true}); err != nil {
+			t.Fatal("Failed to add route:", err)
+		}
+		testServer := httptest.NewServer(proxyServer)
+
+		status, body := getStatusAndBody(t, testServer.URL+"/API/Test")
+		if !caseInsensitive {
+			if status != http.StatusNotFound {
+				t.Errorf("Expected /API/Test to 404 without case-insensitive routes, got %d", status)
+			}
+			testServer.Close()
+			continue
+		}
+		if status != http.StatusOK || body != "/v1/Test" {
+			t.Errorf("Expected /API/Test to be forwarded as /v1/Test, got %d: %s", status, body)
+		}
+		// Give async logging a moment to complete
+		time.Sleep(100 * time.Millisecond)
+		if len(testLogger.requests) != 1 || testLogger.requests[0].metadata.SourceURL != testServer.URL+"/API/Test" {
+			t.Errorf("Expected the request to be logged with its original path, got %+v", testLogger.requests)
+		}
+
+		// Patterns with upper case letters match too, and PreservePath keeps the client's path
+		if status, body := getStatusAndBody(t, testServer.URL+"/files/Report.PDF"); status != http.StatusOK || body != "/files/files/Report.PDF" {
+			t.Errorf("Expected /files/Report.PDF to match /Files/, got %d: %s", status, body)
+		}
+		testServer.Close()
+	}
+}
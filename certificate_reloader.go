@@ -0,0 +1,151 @@
+package loggingproxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCertificateCheckInterval is how often CertificateReloader.Watch
+// checks the files by default.
+const DefaultCertificateCheckInterval = 10 * time.Second
+
+// CertificateReloader serves a certificate and key from disk through
+// tls.Config.GetCertificate and picks up renewed files, for example from
+// cert-manager or an ACME client, without a restart. Watch checks the
+// modification time and size of both files on a ticker and reloads them when
+// they change; Reload forces a reload, for example from a SIGHUP handler.
+// Handshakes only load the current certificate and never touch the disk.
+//
+// Only new handshakes see a reloaded certificate, so established connections
+// are unaffected. If the renewed files cannot be loaded, for example because
+// the certificate was replaced before its key, the previous certificate keeps
+// being served.
+type CertificateReloader struct {
+	// LogLevel filters the reloader's messages, such as a failed reload.
+	LogLevel LogLevel
+
+	certFile    string
+	keyFile     string
+	certificate atomic.Pointer[tls.Certificate]
+
+	// mu serializes reloads
+	mu        sync.Mutex
+	certStamp fileStamp
+	keyStamp  fileStamp
+}
+
+// fileStamp identifies a version of a file on disk.
+type fileStamp struct {
+	modTime int64
+	size    int64
+}
+
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}, nil
+}
+
+// NewCertificateReloader loads the certificate and key, returning an error if
+// they cannot be used.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// Reload reads the certificate and key from disk. On error the previously
+// loaded certificate is kept.
+func (r *CertificateReloader) Reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloadLocked()
+}
+
+func (r *CertificateReloader) reloadLocked() error {
+	certStamp, err := statFile(r.certFile)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate: %w", err)
+	}
+	keyStamp, err := statFile(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to read certificate key: %w", err)
+	}
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	r.certificate.Store(&certificate)
+	r.certStamp = certStamp
+	r.keyStamp = keyStamp
+	return nil
+}
+
+// Watch checks the files every interval, or DefaultCertificateCheckInterval
+// if it is not positive, and reloads them when either changed, until the
+// returned function is called. Set LogLevel before calling it.
+func (r *CertificateReloader) Watch(interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = DefaultCertificateCheckInterval
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				r.reloadChanged()
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			wg.Wait()
+		})
+	}
+}
+
+// reloadChanged reloads the files if either changed since they were loaded.
+func (r *CertificateReloader) reloadChanged() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	certStamp, certErr := statFile(r.certFile)
+	keyStamp, keyErr := statFile(r.keyFile)
+	if certErr != nil || keyErr != nil || (certStamp == r.certStamp && keyStamp == r.keyStamp) {
+		return
+	}
+	if err := r.reloadLocked(); err != nil {
+		levelLogger{level: r.LogLevel}.Errorf("Failed to reload TLS certificate, keeping the previous one: %v", err)
+		// Retry once the files change again rather than on every check
+		r.certStamp = certStamp
+		r.keyStamp = keyStamp
+	}
+}
+
+// GetCertificate returns the current certificate. It is meant for
+// tls.Config.GetCertificate.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return r.certificate.Load(), nil
+}
+
+// TLSConfig returns a server TLS configuration that serves the reloaded
+// certificate.
+func (r *CertificateReloader) TLSConfig() *tls.Config {
+	return &tls.Config{GetCertificate: r.GetCertificate}
+}
@@ -0,0 +1,139 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeServerCertificate writes a self-signed certificate for commonName and
+// its key, dated so that the files differ from any earlier version.
+func writeServerCertificate(t *testing.T, certFile, keyFile, commonName string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	for _, path := range []string{certFile, keyFile} {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("failed to date %s: %v", path, err)
+		}
+	}
+}
+
+func TestCertificateReloaderServesRenewedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server-key.pem")
+	now := time.Now()
+	writeServerCertificate(t, certFile, keyFile, "original", now.Add(-time.Minute))
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("Failed to load certificate:", err)
+	}
+	stop := reloader.Watch(10 * time.Millisecond)
+	defer stop()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})}
+	go server.Serve(tls.NewListener(listener, reloader.TLSConfig()))
+	defer server.Close()
+
+	dial := func() (*tls.Conn, string) {
+		t.Helper()
+		conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			t.Fatal("Failed to connect:", err)
+		}
+		return conn, conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+	}
+	get := func(conn *tls.Conn) {
+		t.Helper()
+		if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: proxy\r\n\r\n"); err != nil {
+			t.Fatal("Failed to write request:", err)
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal("Failed to read response:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "ok" {
+			t.Fatalf("Expected ok, got %q", body)
+		}
+	}
+
+	existing, name := dial()
+	defer existing.Close()
+	if name != "original" {
+		t.Fatalf("Expected the original certificate, got %q", name)
+	}
+	get(existing)
+
+	// A renewed certificate is picked up by the next check
+	writeServerCertificate(t, certFile, keyFile, "renewed", now)
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		renewed, renewedName := dial()
+		renewed.Close()
+		if name = renewedName; name == "renewed" {
+			break
+		}
+	}
+	if name != "renewed" {
+		t.Errorf("Expected new connections to use the renewed certificate, got %q", name)
+	}
+	// The connection established before the renewal keeps working
+	get(existing)
+	if name := existing.ConnectionState().PeerCertificates[0].Subject.CommonName; name != "original" {
+		t.Errorf("Expected the existing connection to keep its certificate, got %q", name)
+	}
+
+	// A half-written renewal keeps the previous certificate in use
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatal("Failed to write key:", err)
+	}
+	if err := reloader.Reload(); err == nil {
+		t.Error("Expected reloading an invalid key to fail")
+	}
+	broken, name := dial()
+	broken.Close()
+	if name != "renewed" {
+		t.Errorf("Expected the previous certificate after a failed reload, got %q", name)
+	}
+}
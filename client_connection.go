@@ -0,0 +1,18 @@
+package loggingproxy
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// recordClientConnection records how the client reached the proxy: the HTTP
+// version of its request and, over TLS, the negotiated TLS version and ALPN
+// protocol. An empty ALPN means the client did not offer any protocol the
+// listener accepts.
+func recordClientConnection(metadata *RequestMetadata, request *http.Request) {
+	metadata.ClientProto = request.Proto
+	if request.TLS != nil {
+		metadata.ClientTLSVersion = tls.VersionName(request.TLS.Version)
+		metadata.ClientALPN = request.TLS.NegotiatedProtocol
+	}
+}
@@ -0,0 +1,58 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientConnectionIsRecorded(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	tlsServer := httptest.NewUnstartedServer(proxyServer)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	defer tlsServer.Close()
+	plainServer := httptest.NewServer(proxyServer)
+	defer plainServer.Close()
+
+	for _, url := range []string{tlsServer.URL + "/api/secure", plainServer.URL + "/api/plain"} {
+		resp, err := tlsServer.Client().Get(url)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 2 {
+		t.Fatalf("Expected 2 logged requests, got %d", len(testLogger.requests))
+	}
+	for _, entry := range testLogger.requests {
+		metadata := entry.metadata
+		switch metadata.SourceURL {
+		case tlsServer.URL + "/api/secure":
+			if metadata.ClientProto != "HTTP/2.0" || metadata.ClientALPN != "h2" || metadata.ClientTLSVersion != "TLS 1.3" {
+				t.Errorf("Expected HTTP/2 over TLS 1.3 with ALPN h2, got %q %q %q", metadata.ClientProto, metadata.ClientTLSVersion, metadata.ClientALPN)
+			}
+		case plainServer.URL + "/api/plain":
+			if metadata.ClientProto != "HTTP/1.1" || metadata.ClientALPN != "" || metadata.ClientTLSVersion != "" {
+				t.Errorf("Expected plain HTTP/1.1, got %q %q %q", metadata.ClientProto, metadata.ClientTLSVersion, metadata.ClientALPN)
+			}
+		default:
+			t.Errorf("Unexpected request %s", metadata.SourceURL)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package loggingproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// ClientTLSConfig configures TLS towards upstream backends, including the client
+// certificate presented for mutual TLS.
+type ClientTLSConfig struct {
+	// CertFile and KeyFile are PEM files with the client certificate and its
+	// private key. Both must be set to present a client certificate.
+	CertFile string
+	KeyFile  string
+
+	// CAFile is a PEM bundle used to verify the backend certificate instead of
+	// the system roots.
+	CAFile string
+}
+
+func (config ClientTLSConfig) enabled() bool {
+	return config.CertFile != "" || config.KeyFile != "" || config.CAFile != ""
+}
+
+func (config ClientTLSConfig) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		if config.CertFile == "" || config.KeyFile == "" {
+			return nil, fmt.Errorf("client TLS requires both a certificate and a key file")
+		}
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if config.CAFile != "" {
+		caPEM, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	return tlsConfig, nil
+}
+
+// withClientTLS returns a copy of client whose transport uses the given TLS
+// settings. The copy keeps the redirect policy and proxy settings of client.
+func withClientTLS(client *http.Client, config ClientTLSConfig) (*http.Client, error) {
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("client TLS requires an *http.Transport, got %T", client.Transport)
+	}
+	transport = transport.Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	clientCopy := *client
+	clientCopy.Transport = transport
+	return &clientCopy, nil
+}
@@ -0,0 +1,166 @@
+package loggingproxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newMTLSBackend starts a TLS backend that requires a client certificate signed
+// by a freshly generated CA. It returns the backend and ClientTLSConfig files
+// for a valid client certificate and the backend's own certificate.
+func newMTLSBackend(t *testing.T) (*httptest.Server, ClientTLSConfig) {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("failed to parse CA certificate: %v", err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate client key: %v", err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "logging-proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, caCert, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("failed to create client certificate: %v", err)
+	}
+	clientKeyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatalf("failed to marshal client key: %v", err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(caCert)
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello " + r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	backend.TLS = &tls.Config{
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		ClientCAs:  clientCAs,
+	}
+	backend.StartTLS()
+	t.Cleanup(backend.Close)
+
+	config := ClientTLSConfig{
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client-key.pem"),
+		CAFile:   filepath.Join(dir, "backend-ca.pem"),
+	}
+	writePEM(t, config.CertFile, "CERTIFICATE", clientDER)
+	writePEM(t, config.KeyFile, "EC PRIVATE KEY", clientKeyDER)
+	writePEM(t, config.CAFile, "CERTIFICATE", backend.Certificate().Raw)
+	return backend, config
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write %s: %v", path, err)
+	}
+}
+
+func getStatusAndBody(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestRouteClientTLSPresentsClientCertificate(t *testing.T) {
+	backend, clientTLS := newMTLSBackend(t)
+	withoutCertificate := ClientTLSConfig{CAFile: clientTLS.CAFile}
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/mtls/", backend.URL+"/", &NoOpLogger{}, RouteOptions{ClientTLS: &clientTLS}); err != nil {
+		t.Fatalf("failed to add mTLS route: %v", err)
+	}
+	if err := proxyServer.AddRouteWithOptions("/plain/", backend.URL+"/", &NoOpLogger{}, RouteOptions{ClientTLS: &withoutCertificate}); err != nil {
+		t.Fatalf("failed to add plain route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	status, body := getStatusAndBody(t, testServer.URL+"/mtls/")
+	if status != http.StatusOK || body != "hello logging-proxy" {
+		t.Fatalf("expected mTLS route to succeed, got %d %q", status, body)
+	}
+
+	status, _ = getStatusAndBody(t, testServer.URL+"/plain/")
+	if status != http.StatusBadGateway {
+		t.Fatalf("expected route without client certificate to fail with 502, got %d", status)
+	}
+}
+
+func TestServerClientTLSAppliesToAllRoutes(t *testing.T) {
+	backend, clientTLS := newMTLSBackend(t)
+
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy: HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		ClientTLS:   clientTLS,
+	})
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	status, body := getStatusAndBody(t, testServer.URL+"/api/")
+	if status != http.StatusOK || body != "hello logging-proxy" {
+		t.Fatalf("expected request with server-wide client certificate to succeed, got %d %q", status, body)
+	}
+}
+
+func TestClientTLSRequiresCertificateAndKey(t *testing.T) {
+	_, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientTLS: ClientTLSConfig{CertFile: "client.pem"},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a client certificate without a key")
+	}
+}
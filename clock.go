@@ -0,0 +1,23 @@
+package loggingproxy
+
+import "time"
+
+// Clock supplies the current time for timestamps and durations. Tests can
+// inject a fake clock to get deterministic values.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// clockOrReal returns clock, or the wall clock if clock is nil.
+func clockOrReal(clock Clock) Clock {
+	if clock == nil {
+		return realClock{}
+	}
+	return clock
+}
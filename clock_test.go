@@ -0,0 +1,111 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock returns now and then advances it by step on every call.
+type fakeClock struct {
+	mu   sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now
+	c.now = c.now.Add(c.step)
+	return now
+}
+
+func TestFileLoggerUsesClockForDurationAndFilenames(t *testing.T) {
+	logDir := t.TempDir()
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir: logDir,
+		Clock:  &fakeClock{now: started.Add(1500 * time.Millisecond)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+
+	metadata := RequestMetadata{ID: "0123456789abcdef", RequestStartedAt: started}
+	fileLogger.LogRequest(metadata, started, io.NopCloser(strings.NewReader("GET / HTTP/1.1\r\n\r\n")))
+
+	if _, err := os.Stat(filepath.Join(logDir, "2026-01-02_03-04-05.000_01234567_request.bin")); err != nil {
+		t.Fatalf("Expected log file named after the clock timestamp: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(logDir, "2026-01-02_03-04-05.000_01234567_request_metadata.json"))
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	var logMetadata fileLogMetadata
+	if err := json.Unmarshal(raw, &logMetadata); err != nil {
+		t.Fatalf("Failed to parse metadata: %v", err)
+	}
+	if logMetadata.DurationMS != 1500 {
+		t.Errorf("Expected duration_ms 1500, got %d", logMetadata.DurationMS)
+	}
+	if !logMetadata.CompletedAt.Equal(started.Add(1500 * time.Millisecond)) {
+		t.Errorf("Expected completed_at from the clock, got %v", logMetadata.CompletedAt)
+	}
+}
+
+func TestProxyServerUsesClockForTimestamps(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	started := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy: HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		Clock:       &fakeClock{now: started, step: 250 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/api/test")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 request and 1 response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	if timestamp := testLogger.requests[0].timestamp; !timestamp.Equal(started) {
+		t.Errorf("Expected request timestamp %v, got %v", started, timestamp)
+	}
+	metadata := testLogger.responses[0].metadata
+	if !metadata.RequestStartedAt.Equal(started) {
+		t.Errorf("Expected request_started_at %v, got %v", started, metadata.RequestStartedAt)
+	}
+	if metadata.UpstreamHeaderDurationMS != 250 {
+		t.Errorf("Expected upstream_header_duration_ms 250, got %d", metadata.UpstreamHeaderDurationMS)
+	}
+	if timestamp := testLogger.responses[0].timestamp; !timestamp.Equal(started.Add(250 * time.Millisecond)) {
+		t.Errorf("Expected response timestamp %v, got %v", started.Add(250*time.Millisecond), timestamp)
+	}
+}
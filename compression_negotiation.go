@@ -0,0 +1,24 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+)
+
+// negotiatedAcceptEncoding is sent to the backend on behalf of clients that do
+// not send Accept-Encoding. It only lists encodings the proxy can decode again
+// for the client.
+const negotiatedAcceptEncoding = "gzip, br"
+
+// decodeResponseForClient wraps body in a decompressor for the response's
+// Content-Encoding, for a client that never asked for compression. Bodiless
+// responses are returned as they are. ok is false if the encoding cannot be
+// decoded; body is then returned with the bytes already consumed put back, so
+// the response can still be forwarded as it was received.
+func decodeResponseForClient(response *http.Response, body io.Reader) (decoded io.Reader, ok bool) {
+	if response.Body == http.NoBody {
+		return body, true
+	}
+	decoded, _, err := decompressBody(body, response.Header.Get("Content-Encoding"))
+	return decoded, err == nil
+}
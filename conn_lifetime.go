@@ -0,0 +1,185 @@
+package loggingproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// setDialer installs the dialer of transport. Hosts in overrides are dialed
+// at their override address instead of being resolved. Otherwise guard checks
+// the addresses dialed for the hosts a request marked. Connections older
+// than lifetime are no longer reused, so backends behind changing IPs are
+// re-resolved and re-dialed periodically: an expired connection refuses the
+// next request before sending any of it, which makes the transport send the
+// request again on a new connection. An HTTP/2 connection carries several
+// requests at once and cannot refuse one without failing the others, so with
+// a lifetime the transport only speaks HTTP/1.1. Call it again after cloning
+// the transport. Without overrides or guard and with a non-positive lifetime
+// the transport's dialer is left as it is.
+func setDialer(transport *http.Transport, lifetime time.Duration, overrides addressOverrides, guard *destinationGuard) {
+	if lifetime <= 0 && len(overrides) == 0 && guard == nil {
+		return
+	}
+	if lifetime > 0 {
+		// A non-nil empty map disables HTTP/2
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		// The same dialer settings as http.DefaultTransport
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+		// An override is the operator's choice of address, so it is not guarded
+		dialAddr := overrides.address(addr)
+		if dialAddr == addr {
+			dialer.Control = guard.control(ctx, addr)
+		}
+		conn, err := dialer.DialContext(ctx, network, dialAddr)
+		if err != nil || lifetime <= 0 {
+			return conn, err
+		}
+		return &lifetimeConn{Conn: conn, expires: time.Now().Add(lifetime)}, nil
+	}
+}
+
+// errConnExpired refuses a request on a connection older than its lifetime.
+// Nothing was written, so the transport retries the request on a new
+// connection.
+var errConnExpired = errors.New("upstream connection exceeded its lifetime")
+
+// lifetimeConn refuses to start another request once it is older than its
+// lifetime. The transport then closes it and dials again. An expired
+// connection that is idle in the pool is closed by the transport's idle
+// timeout if no request uses it first.
+type lifetimeConn struct {
+	net.Conn
+	expires time.Time
+	// used is set once a request was written, so that a connection is never
+	// refused before its first request
+	used atomic.Bool
+	// writing is set while a request is written and cleared once its
+	// response starts, so that only the first write of a request checks the
+	// lifetime
+	writing atomic.Bool
+}
+
+func (c *lifetimeConn) Write(p []byte) (int, error) {
+	if !c.writing.Load() && c.used.Load() && time.Now().After(c.expires) {
+		return 0, errConnExpired
+	}
+	c.writing.Store(true)
+	c.used.Store(true)
+	return c.Conn.Write(p)
+}
+
+func (c *lifetimeConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.writing.Store(false)
+	}
+	return n, err
+}
+
+// maxRewindBytes bounds what a rewindableBody keeps for sending again. The
+// transport writes the headers of a streamed body before reading it, so a
+// refused request read at most the byte it probes the body with.
+const maxRewindBytes = 4 << 10
+
+// rewindableBody is a request body that can be handed out again while what
+// was read of it is still kept. The transport only retries a request with a
+// body on a new connection if Request.GetBody returns it again, and it closes
+// the body of the failed attempt first, so closing is deferred until the
+// round trip returned.
+type rewindableBody struct {
+	body io.ReadCloser
+
+	mu       sync.Mutex
+	read     []byte
+	offset   int
+	overflow bool
+	closed   bool
+	done     bool
+}
+
+// withRewindableBody returns request with a body and GetBody that let a
+// request refused by an expired connection be sent again. Once a response
+// arrives GetBody is removed again, so the client handles redirects as it
+// would without it. Call release when the round trip returned.
+func withRewindableBody(request *http.Request) (*http.Request, func()) {
+	if request.Body == nil || request.Body == http.NoBody {
+		return request, func() {}
+	}
+	rewindable := &rewindableBody{body: request.Body}
+	var rewound *http.Request
+	rewound = request.WithContext(httptrace.WithClientTrace(request.Context(), &httptrace.ClientTrace{
+		GotFirstResponseByte: func() {
+			rewound.GetBody = nil
+		},
+	}))
+	rewound.Body = rewindable
+	rewound.GetBody = rewindable.rewind
+	return rewound, rewindable.release
+}
+
+func (b *rewindableBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if b.offset < len(b.read) {
+		n := copy(p, b.read[b.offset:])
+		b.offset += n
+		b.mu.Unlock()
+		return n, nil
+	}
+	b.mu.Unlock()
+
+	n, err := b.body.Read(p)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.overflow && len(b.read)+n <= maxRewindBytes {
+		b.read = append(b.read, p[:n]...)
+		b.offset = len(b.read)
+	} else {
+		b.overflow = true
+		b.read = nil
+	}
+	return n, err
+}
+
+// rewind hands the body out again from its start.
+func (b *rewindableBody) rewind() (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.overflow {
+		return nil, errors.New("request body was already sent")
+	}
+	b.offset = 0
+	b.closed = false
+	return b, nil
+}
+
+func (b *rewindableBody) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.done {
+		b.closed = true
+		return nil
+	}
+	return b.body.Close()
+}
+
+// release closes the body if the last attempt already closed it. Otherwise
+// the transport is still sending it and closes it when done.
+func (b *rewindableBody) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.done = true
+	if b.closed {
+		b.body.Close()
+	}
+}
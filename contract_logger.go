@@ -0,0 +1,294 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultContractIgnoredHeaders vary between otherwise identical requests.
+var defaultContractIgnoredHeaders = []string{
+	"Content-Length",
+	"Date",
+	"User-Agent",
+	"Accept-Encoding",
+	"X-Request-Id",
+}
+
+// DefaultContractMaxRequestSize is the default ContractLogger.MaxRequestSize.
+const DefaultContractMaxRequestSize = 1 << 20
+
+// ContractMismatch describes one way a live request deviated from its golden.
+type ContractMismatch struct {
+	RequestID string    `json:"request_id"`
+	Timestamp time.Time `json:"timestamp"`
+	Path      string    `json:"path"`
+	// Kind is "missing_golden", "method", "header" or "body".
+	Kind     string `json:"kind"`
+	Header   string `json:"header,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// ContractLogger compares each outgoing request against a golden transcript
+// for the same destination path and records mismatches. Goldens use the same
+// format as the request .bin files written by FileLogger, so a recorded session
+// can be replayed as a contract. Headers listed in the golden must match;
+// extra live headers are ignored. JSON bodies are compared semantically.
+type ContractLogger struct {
+	// Logger optionally receives every stream after comparison.
+	Logger Logger
+
+	// ReportPath receives one JSON line per mismatch. Empty keeps mismatches
+	// in memory only.
+	ReportPath string
+
+	// IgnoredHeaders are never compared. Nil uses a default list of headers
+	// that vary per request, such as Date and User-Agent.
+	IgnoredHeaders []string
+
+	// MaxRequestSize caps how much of each logged request is read for
+	// comparison. The body of a longer request is not compared and is
+	// recorded as a body mismatch. Zero means DefaultContractMaxRequestSize.
+	MaxRequestSize int64
+
+	// LogLevel filters the logger's own messages, such as a failed report
+	// write.
+	LogLevel LogLevel
+
+	goldens map[string]contractTranscript
+
+	mu         sync.Mutex
+	mismatches []ContractMismatch
+}
+
+type contractTranscript struct {
+	method string
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// NewContractLogger creates a contract logger from golden transcripts keyed by
+// destination path. Logger may be nil.
+func NewContractLogger(goldens map[string][]byte, reportPath string, logger Logger) (*ContractLogger, error) {
+	parsed := make(map[string]contractTranscript, len(goldens))
+	for path, raw := range goldens {
+		_, transcript, err := parseContractTranscript(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid golden for %s: %w", path, err)
+		}
+		parsed[path] = transcript
+	}
+	return &ContractLogger{
+		Logger:     logger,
+		ReportPath: reportPath,
+		goldens:    parsed,
+	}, nil
+}
+
+// LoadContractGoldens reads the request .bin files in dir and keys them by
+// the path of their request line.
+func LoadContractGoldens(dir string) (map[string][]byte, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*_request.bin"))
+	if err != nil {
+		return nil, err
+	}
+	goldens := make(map[string][]byte, len(files))
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read golden: %w", err)
+		}
+		path, _, err := parseContractTranscript(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid golden %s: %w", file, err)
+		}
+		goldens[path] = raw
+	}
+	return goldens, nil
+}
+
+// LogRequest compares the request against its golden and forwards the stream
+func (l *ContractLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	maxSize := l.MaxRequestSize
+	if maxSize <= 0 {
+		maxSize = DefaultContractMaxRequestSize
+	}
+	raw, err := io.ReadAll(io.LimitReader(rawRequestStream, maxSize+1))
+	if err == nil {
+		checked, oversized := raw, int64(len(raw)) > maxSize
+		if oversized {
+			checked = raw[:maxSize]
+		}
+		l.check(metadata, timestamp, checked, oversized)
+	}
+
+	// The rest of a longer request follows what was read
+	stream := &readCloser{Reader: io.MultiReader(bytes.NewReader(raw), rawRequestStream), Closer: rawRequestStream}
+	if l.Logger == nil {
+		discardStream(stream)
+		return
+	}
+	l.Logger.LogRequest(metadata, timestamp, stream)
+}
+
+// LogResponse forwards the response stream to the wrapped logger, if any
+func (l *ContractLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	if l.Logger == nil {
+		discardStream(rawResponseStream)
+		return
+	}
+	l.Logger.LogResponse(metadata, timestamp, rawResponseStream)
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *ContractLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(l.Logger, metadata, timestamp)
+}
+
+// Close closes the wrapped logger if it implements io.Closer.
+func (l *ContractLogger) Close() error {
+	return closeWrapped(l.Logger)
+}
+
+// Mismatches returns all mismatches recorded so far.
+func (l *ContractLogger) Mismatches() []ContractMismatch {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ContractMismatch(nil), l.mismatches...)
+}
+
+// check compares raw against its golden. When oversized, raw is only the
+// start of the request and its body is not compared.
+func (l *ContractLogger) check(metadata RequestMetadata, timestamp time.Time, raw []byte, oversized bool) {
+	path, live, err := parseContractTranscript(raw)
+	if err != nil {
+		return
+	}
+	newMismatch := func(kind, header, expected, actual string) ContractMismatch {
+		return ContractMismatch{
+			RequestID: metadata.ID,
+			Timestamp: timestamp,
+			Path:      path,
+			Kind:      kind,
+			Header:    header,
+			Expected:  expected,
+			Actual:    actual,
+		}
+	}
+
+	golden, ok := l.goldens[path]
+	if !ok {
+		l.record(newMismatch("missing_golden", "", "", ""))
+		return
+	}
+
+	var mismatches []ContractMismatch
+	if golden.method != live.method {
+		mismatches = append(mismatches, newMismatch("method", "", golden.method, live.method))
+	}
+	for name, values := range golden.header {
+		if l.ignoresHeader(name) {
+			continue
+		}
+		expected := strings.Join(values, ", ")
+		actual := strings.Join(live.header.Values(name), ", ")
+		if expected != actual {
+			mismatches = append(mismatches, newMismatch("header", name, expected, actual))
+		}
+	}
+	if oversized {
+		actual := fmt.Sprintf("request larger than %d bytes, body not compared", len(raw))
+		mismatches = append(mismatches, newMismatch("body", "", string(golden.body), actual))
+	} else if !contractBodiesEqual(golden.body, live.body) {
+		mismatches = append(mismatches, newMismatch("body", "", string(golden.body), string(live.body)))
+	}
+
+	for _, mismatch := range mismatches {
+		l.record(mismatch)
+	}
+}
+
+func (l *ContractLogger) ignoresHeader(name string) bool {
+	ignored := l.IgnoredHeaders
+	if ignored == nil {
+		ignored = defaultContractIgnoredHeaders
+	}
+	for _, ignoredName := range ignored {
+		if strings.EqualFold(name, ignoredName) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *ContractLogger) record(mismatch ContractMismatch) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mismatches = append(l.mismatches, mismatch)
+
+	if l.ReportPath == "" {
+		return
+	}
+	line, err := json.Marshal(mismatch)
+	if err != nil {
+		return
+	}
+	report, err := os.OpenFile(l.ReportPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		levelLogger{level: l.LogLevel}.Errorf("Failed to open contract report %s: %v", l.ReportPath, err)
+		return
+	}
+	defer report.Close()
+	report.Write(append(line, '\n'))
+}
+
+// parseContractTranscript splits a logged request into its destination path,
+// method, headers and body.
+func parseContractTranscript(raw []byte) (string, contractTranscript, error) {
+	reader := bufio.NewReader(bytes.NewReader(raw))
+	head, header, err := readStreamHead(reader)
+	if err != nil {
+		return "", contractTranscript{}, fmt.Errorf("failed to parse request head: %w", err)
+	}
+	requestLine, _, _ := strings.Cut(string(head), "\n")
+	fields := strings.Fields(requestLine)
+	if len(fields) < 2 {
+		return "", contractTranscript{}, fmt.Errorf("invalid request line %q", strings.TrimSpace(requestLine))
+	}
+	requestURL, err := url.Parse(fields[1])
+	if err != nil {
+		return "", contractTranscript{}, fmt.Errorf("invalid request URL: %w", err)
+	}
+	// The body is the rest of raw after the head
+	body := raw[len(head):]
+
+	// Host is not part of the logged headers but may be in hand-written goldens
+	header.Del("Host")
+	return requestURL.Path, contractTranscript{
+		method: fields[0],
+		header: header,
+		body:   body,
+	}, nil
+}
+
+func contractBodiesEqual(expected, actual []byte) bool {
+	if bytes.Equal(expected, actual) {
+		return true
+	}
+	var expectedJSON, actualJSON any
+	if json.Unmarshal(expected, &expectedJSON) != nil || json.Unmarshal(actual, &actualJSON) != nil {
+		return false
+	}
+	return reflect.DeepEqual(expectedJSON, actualJSON)
+}
@@ -0,0 +1,144 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContractLoggerFlagsDeviatingRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	reportPath := filepath.Join(t.TempDir(), "contract-report.jsonl")
+	goldens := map[string][]byte{
+		"/v1/chat": []byte("POST http://backend.test/v1/chat HTTP/1.1\r\n" +
+			"Content-Type: application/json\r\n" +
+			"X-Api-Version: 2\r\n" +
+			"User-Agent: recorded-client\r\n" +
+			"\r\n" +
+			`{"model": "small", "stream": false}`),
+	}
+	contractLogger, err := NewContractLogger(goldens, reportPath, nil)
+	if err != nil {
+		t.Fatalf("failed to create contract logger: %v", err)
+	}
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/v1/", contractLogger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	post := func(body string, apiVersion string) {
+		request, _ := http.NewRequest(http.MethodPost, testServer.URL+"/api/chat", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("X-Api-Version", apiVersion)
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		// Give async logging a moment to complete
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// Same JSON with different key order and a different User-Agent matches
+	post(`{"stream":false,"model":"small"}`, "2")
+	if mismatches := contractLogger.Mismatches(); len(mismatches) != 0 {
+		t.Fatalf("expected matching request to pass, got %+v", mismatches)
+	}
+	if _, err := os.Stat(reportPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no report file for a matching request, got %v", err)
+	}
+
+	post(`{"stream":true,"model":"small"}`, "3")
+	mismatches := contractLogger.Mismatches()
+	if len(mismatches) != 2 {
+		t.Fatalf("expected a header and a body mismatch, got %+v", mismatches)
+	}
+
+	report, err := os.Open(reportPath)
+	if err != nil {
+		t.Fatalf("expected report file: %v", err)
+	}
+	defer report.Close()
+	kinds := map[string]ContractMismatch{}
+	scanner := bufio.NewScanner(report)
+	for scanner.Scan() {
+		var mismatch ContractMismatch
+		if err := json.Unmarshal(scanner.Bytes(), &mismatch); err != nil {
+			t.Fatalf("invalid report line %q: %v", scanner.Text(), err)
+		}
+		kinds[mismatch.Kind] = mismatch
+	}
+	if header := kinds["header"]; header.Header != "X-Api-Version" || header.Expected != "2" || header.Actual != "3" {
+		t.Errorf("unexpected header mismatch: %+v", header)
+	}
+	if body := kinds["body"]; body.Path != "/v1/chat" || !strings.Contains(body.Actual, `"stream":true`) {
+		t.Errorf("unexpected body mismatch: %+v", body)
+	}
+}
+
+func TestContractLoggerFlagsRequestsWithoutGolden(t *testing.T) {
+	contractLogger, err := NewContractLogger(nil, "", nil)
+	if err != nil {
+		t.Fatalf("failed to create contract logger: %v", err)
+	}
+
+	raw := "GET http://backend.test/unknown HTTP/1.1\r\n\r\n"
+	contractLogger.LogRequest(RequestMetadata{ID: "unknown"}, time.Now(), io.NopCloser(strings.NewReader(raw)))
+
+	mismatches := contractLogger.Mismatches()
+	if len(mismatches) != 1 || mismatches[0].Kind != "missing_golden" || mismatches[0].Path != "/unknown" {
+		t.Fatalf("expected a missing_golden mismatch for /unknown, got %+v", mismatches)
+	}
+}
+
+// connectTestLogger records the IDs of the CONNECT events it receives.
+type connectTestLogger struct {
+	TestLogger
+	connects []string
+}
+
+func (l *connectTestLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	l.connects = append(l.connects, metadata.ID)
+}
+
+func TestContractLoggerBoundsComparedRequestsAndForwardsConnects(t *testing.T) {
+	golden := "POST http://backend.test/v1/upload HTTP/1.1\r\n\r\nsmall"
+	wrapped := &connectTestLogger{}
+	contractLogger, err := NewContractLogger(map[string][]byte{"/v1/upload": []byte(golden)}, "", wrapped)
+	if err != nil {
+		t.Fatal("Failed to create contract logger:", err)
+	}
+	contractLogger.MaxRequestSize = 64
+
+	live := "POST http://backend.test/v1/upload HTTP/1.1\r\n\r\n" + strings.Repeat("x", 100)
+	contractLogger.LogRequest(RequestMetadata{ID: "upload"}, time.Now(), io.NopCloser(strings.NewReader(live)))
+	mismatches := contractLogger.Mismatches()
+	if len(mismatches) != 1 || mismatches[0].Kind != "body" || !strings.Contains(mismatches[0].Actual, "not compared") {
+		t.Errorf("Expected the oversized body to be reported as not compared, got %+v", mismatches)
+	}
+	if len(wrapped.requests) != 1 || wrapped.requests[0].content != live {
+		t.Errorf("Expected the whole request to be forwarded, got %d requests", len(wrapped.requests))
+	}
+
+	contractLogger.LogConnect(RequestMetadata{ID: "tunnel"}, time.Now())
+	if len(wrapped.connects) != 1 || wrapped.connects[0] != "tunnel" {
+		t.Errorf("Expected the CONNECT event to be forwarded, got %v", wrapped.connects)
+	}
+}
@@ -0,0 +1,107 @@
+package loggingproxy
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig makes the proxy answer CORS preflight requests itself and add
+// CORS headers to proxied responses, for browser clients calling backends that
+// don't handle CORS.
+type CORSConfig struct {
+	// AllowedOrigins lists origins allowed to call the route. "*" allows any.
+	AllowedOrigins []string
+
+	// AllowedMethods and AllowedHeaders are returned from preflight requests.
+	// When empty, the method and headers the browser asks for are allowed.
+	AllowedMethods []string
+	AllowedHeaders []string
+
+	// ExposedHeaders lists response headers readable by browser scripts.
+	ExposedHeaders []string
+
+	// AllowCredentials allows cookies and authorization headers. With "*" in
+	// AllowedOrigins the request origin is echoed, as browsers require.
+	AllowCredentials bool
+
+	// MaxAge is how long browsers may cache a preflight result. Zero omits it.
+	MaxAge time.Duration
+}
+
+// isPreflight reports whether request is a CORS preflight request.
+func isPreflight(request *http.Request) bool {
+	return request.Method == http.MethodOptions &&
+		request.Header.Get("Origin") != "" &&
+		request.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// allowOrigin returns the Access-Control-Allow-Origin value for origin, or an
+// empty string if the origin is not allowed.
+func (c *CORSConfig) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	if slices.Contains(c.AllowedOrigins, "*") {
+		if c.AllowCredentials {
+			return origin
+		}
+		return "*"
+	}
+	if slices.Contains(c.AllowedOrigins, origin) {
+		return origin
+	}
+	return ""
+}
+
+// setResponseHeaders adds CORS headers for a response to a request from origin.
+func (c *CORSConfig) setResponseHeaders(header http.Header, origin string) bool {
+	if c == nil {
+		return false
+	}
+	allowedOrigin := c.allowOrigin(origin)
+	if allowedOrigin == "" {
+		return false
+	}
+	header.Set("Access-Control-Allow-Origin", allowedOrigin)
+	if allowedOrigin != "*" {
+		header.Add("Vary", "Origin")
+	}
+	if c.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if len(c.ExposedHeaders) > 0 {
+		header.Set("Access-Control-Expose-Headers", strings.Join(c.ExposedHeaders, ", "))
+	}
+	return true
+}
+
+// writePreflight answers a preflight request without contacting the backend.
+func (c *CORSConfig) writePreflight(w http.ResponseWriter, request *http.Request) {
+	header := w.Header()
+	if c.setResponseHeaders(header, request.Header.Get("Origin")) {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+
+		allowedMethods := strings.Join(c.AllowedMethods, ", ")
+		if allowedMethods == "" {
+			allowedMethods = request.Header.Get("Access-Control-Request-Method")
+		}
+		header.Set("Access-Control-Allow-Methods", allowedMethods)
+
+		allowedHeaders := strings.Join(c.AllowedHeaders, ", ")
+		if allowedHeaders == "" {
+			allowedHeaders = request.Header.Get("Access-Control-Request-Headers")
+		}
+		if allowedHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", allowedHeaders)
+		}
+
+		if c.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,126 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newCORSTestProxy(t *testing.T, options RouteOptions) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var backendHits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHits.Add(1)
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(backend.Close)
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", &NoOpLogger{}, options); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	t.Cleanup(testServer.Close)
+	return testServer, &backendHits
+}
+
+func TestCORSPreflightIsAnsweredByProxy(t *testing.T) {
+	testServer, backendHits := newCORSTestProxy(t, RouteOptions{CORS: &CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+		AllowedMethods: []string{"GET", "POST"},
+		AllowedHeaders: []string{"Authorization", "Content-Type"},
+		MaxAge:         10 * time.Minute,
+	}})
+
+	request, _ := http.NewRequest(http.MethodOptions, testServer.URL+"/api/chat", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	request.Header.Set("Access-Control-Request-Method", "POST")
+	request.Header.Set("Access-Control-Request-Headers", "content-type")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("preflight request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected 204 for preflight, got %d", resp.StatusCode)
+	}
+	expectedHeaders := map[string]string{
+		"Access-Control-Allow-Origin":  "https://app.example.com",
+		"Access-Control-Allow-Methods": "GET, POST",
+		"Access-Control-Allow-Headers": "Authorization, Content-Type",
+		"Access-Control-Max-Age":       "600",
+	}
+	for name, expected := range expectedHeaders {
+		if actual := resp.Header.Get(name); actual != expected {
+			t.Errorf("expected %s %q, got %q", name, expected, actual)
+		}
+	}
+	if backendHits.Load() != 0 {
+		t.Errorf("expected preflight not to reach the backend, got %d hits", backendHits.Load())
+	}
+}
+
+func TestCORSHeadersAreAddedToProxiedResponses(t *testing.T) {
+	testServer, backendHits := newCORSTestProxy(t, RouteOptions{CORS: &CORSConfig{
+		AllowedOrigins: []string{"*"},
+		ExposedHeaders: []string{"X-Request-Id"},
+	}})
+
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/models", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" || backendHits.Load() != 1 {
+		t.Fatalf("expected request to be proxied, got %d %q", resp.StatusCode, string(body))
+	}
+	if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("expected Access-Control-Allow-Origin *, got %q", origin)
+	}
+	if exposed := resp.Header.Get("Access-Control-Expose-Headers"); exposed != "X-Request-Id" {
+		t.Errorf("expected Access-Control-Expose-Headers X-Request-Id, got %q", exposed)
+	}
+}
+
+func TestCORSRejectsUnlistedOrigins(t *testing.T) {
+	testServer, _ := newCORSTestProxy(t, RouteOptions{CORS: &CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com"},
+	}})
+
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/models", nil)
+	request.Header.Set("Origin", "https://evil.example.com")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if origin := resp.Header.Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("expected no Access-Control-Allow-Origin for an unlisted origin, got %q", origin)
+	}
+}
+
+func TestOptionsRequestsAreProxiedWithoutCORS(t *testing.T) {
+	testServer, backendHits := newCORSTestProxy(t, RouteOptions{})
+
+	request, _ := http.NewRequest(http.MethodOptions, testServer.URL+"/api/chat", nil)
+	request.Header.Set("Origin", "https://app.example.com")
+	request.Header.Set("Access-Control-Request-Method", "POST")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if backendHits.Load() != 1 {
+		t.Fatalf("expected OPTIONS to reach the backend when CORS is not configured, got %d hits", backendHits.Load())
+	}
+}
@@ -0,0 +1,106 @@
+package loggingproxy
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redactedHeaderValue replaces injected credentials in logged transcripts.
+const redactedHeaderValue = "[redacted]"
+
+// CredentialProvider returns the Authorization header value a route sends
+// upstream, see RouteOptions.Authorization. It is called for every request,
+// so providers that are slow or rate limited, such as OAuth client
+// credentials token endpoints, should be wrapped with CachedCredential.
+type CredentialProvider func() (string, error)
+
+// StaticCredential always returns value.
+func StaticCredential(value string) CredentialProvider {
+	return func() (string, error) {
+		return value, nil
+	}
+}
+
+// EnvCredential returns the value of the environment variable name.
+func EnvCredential(name string) CredentialProvider {
+	return func() (string, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok || strings.TrimSpace(value) == "" {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return strings.TrimSpace(value), nil
+	}
+}
+
+// FileCredential returns the contents of the file at path, without
+// surrounding whitespace. The file is read on every call, so a rotated
+// secret is picked up without a restart.
+func FileCredential(path string) CredentialProvider {
+	return func() (string, error) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		value := strings.TrimSpace(string(data))
+		if value == "" {
+			return "", fmt.Errorf("%s is empty", path)
+		}
+		return value, nil
+	}
+}
+
+// BearerCredential turns a token into a "Bearer <token>" header value.
+func BearerCredential(token CredentialProvider) CredentialProvider {
+	return func() (string, error) {
+		value, err := token()
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + value, nil
+	}
+}
+
+// BasicCredential turns a username and password into a "Basic" header value.
+func BasicCredential(username string, password CredentialProvider) CredentialProvider {
+	return func() (string, error) {
+		value, err := password()
+		if err != nil {
+			return "", err
+		}
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+value)), nil
+	}
+}
+
+// CachedCredential calls provider at most once per ttl and returns the cached
+// value in between. Concurrent callers wait for a single refresh. If a
+// refresh fails, the error is returned and the next call tries again. A
+// non-positive ttl caches the first value forever.
+func CachedCredential(provider CredentialProvider, ttl time.Duration) CredentialProvider {
+	var (
+		mu        sync.Mutex
+		value     string
+		fetchedAt time.Time
+		fetched   bool
+	)
+	return func() (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if fetched && (ttl <= 0 || time.Since(fetchedAt) < ttl) {
+			return value, nil
+		}
+		refreshed, err := provider()
+		if err != nil {
+			return "", err
+		}
+		if refreshed == "" {
+			return "", errors.New("credential provider returned an empty value")
+		}
+		value, fetchedAt, fetched = refreshed, time.Now(), true
+		return value, nil
+	}
+}
@@ -0,0 +1,108 @@
+package loggingproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRouteInjectsRotatingAuthorization(t *testing.T) {
+	seen := make(chan string, 3)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- r.Header.Get("Authorization")
+	}))
+	defer backend.Close()
+
+	var rotation atomic.Int32
+	token := func() (string, error) {
+		return fmt.Sprintf("token-%d", rotation.Load()), nil
+	}
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{
+		Authorization: BearerCredential(token),
+	}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for i, want := range []string{"Bearer token-0", "Bearer token-1"} {
+		rotation.Store(int32(i))
+		request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/", nil)
+		request.Header.Set("Authorization", "Bearer client-token")
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if got := <-seen; got != want {
+			t.Errorf("Expected backend to receive %q, got %q", want, got)
+		}
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	for _, logged := range testLogger.requests {
+		if strings.Contains(logged.content, "token-") || !strings.Contains(logged.content, "Authorization: [redacted]\r\n") {
+			t.Errorf("Expected the injected credential to be redacted, got:\n%s", logged.content)
+		}
+	}
+}
+
+func TestRouteRejectsRequestWhenCredentialFails(t *testing.T) {
+	backendCalled := false
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendCalled = true
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", &NoOpLogger{}, RouteOptions{
+		Authorization: func() (string, error) { return "", errors.New("vault is sealed") },
+	}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	status, body := getStatusAndBody(t, testServer.URL+"/api/")
+	if status != http.StatusBadGateway || !strings.Contains(body, "vault is sealed") {
+		t.Errorf("Expected 502 with the provider error, got %d: %s", status, body)
+	}
+	if backendCalled {
+		t.Error("Expected the backend not to be contacted")
+	}
+}
+
+func TestCachedCredentialRefreshesAfterTTL(t *testing.T) {
+	var calls atomic.Int32
+	cached := CachedCredential(func() (string, error) {
+		return fmt.Sprintf("token-%d", calls.Add(1)), nil
+	}, 50*time.Millisecond)
+
+	first, _ := cached()
+	second, _ := cached()
+	if first != "token-1" || second != "token-1" {
+		t.Fatalf("Expected the value to be cached, got %q and %q", first, second)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if third, _ := cached(); third != "token-2" {
+		t.Errorf("Expected a refresh after the TTL, got %q", third)
+	}
+}
+
+func TestBasicCredential(t *testing.T) {
+	value, err := BasicCredential("user", StaticCredential("secret"))()
+	if err != nil || value != "Basic dXNlcjpzZWNyZXQ=" {
+		t.Errorf("Unexpected basic credential %q: %v", value, err)
+	}
+}
@@ -0,0 +1,159 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// rawContextSize is how many compressed bytes before a decompression failure
+// are logged with it.
+const rawContextSize = 64
+
+// decompressForLogging decompresses a logged body. If the encoding cannot be
+// decoded, it returns the error together with a reader over the body as-is,
+// including the bytes the decompressor already consumed while trying. If the
+// body turns out to be corrupt once decompression has started, the log goes
+// on with an X-Decompression-Error note holding the error and the offsets of
+// the failure, followed by the raw compressed bytes from shortly before it to
+// the end of the body, base64-encoded.
+func decompressForLogging(body io.Reader, encoding string) (io.ReadCloser, error) {
+	decompressed, raw, err := decompressBody(body, encoding)
+	if err != nil {
+		return decompressed, err
+	}
+	return &decompressionFallbackReader{decompressed: decompressed, raw: raw}, nil
+}
+
+// decompressBody decompresses body like decompressForLogging, but a failure
+// midway is returned by Read as it is.
+func decompressBody(body io.Reader, encoding string) (io.ReadCloser, *rawReader, error) {
+	raw := &rawReader{source: bufio.NewReader(body), recording: true}
+	decompressed, err := decompressReader(raw, encoding)
+	raw.recording = false
+	if err != nil {
+		return io.NopCloser(io.MultiReader(bytes.NewReader(raw.recorded.Bytes()), raw.source)), raw, err
+	}
+	return decompressed, raw, nil
+}
+
+// rawReader feeds a decompressor from a compressed body. It is an
+// io.ByteReader, so gzip reads from it without buffering ahead and offset is
+// exactly the number of compressed bytes it consumed.
+type rawReader struct {
+	source    *bufio.Reader
+	recording bool
+	recorded  bytes.Buffer
+	offset    int64
+	// recent holds at least the last rawContextSize bytes read
+	recent []byte
+	// err is the error of the body itself, as opposed to a decoding error
+	err error
+}
+
+func (r *rawReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	if r.recording {
+		r.recorded.Write(p[:n])
+	}
+	r.remember(p[:n]...)
+	r.sourceError(err)
+	return n, err
+}
+
+func (r *rawReader) ReadByte() (byte, error) {
+	b, err := r.source.ReadByte()
+	if err != nil {
+		r.sourceError(err)
+		return b, err
+	}
+	if r.recording {
+		r.recorded.WriteByte(b)
+	}
+	r.remember(b)
+	return b, nil
+}
+
+func (r *rawReader) remember(p ...byte) {
+	r.offset += int64(len(p))
+	r.recent = append(r.recent, p...)
+	if len(r.recent) > 2*rawContextSize {
+		r.recent = append(r.recent[:0], r.recent[len(r.recent)-rawContextSize:]...)
+	}
+}
+
+func (r *rawReader) sourceError(err error) {
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+}
+
+// context returns a copy of the last compressed bytes read.
+func (r *rawReader) context() []byte {
+	return bytes.Clone(r.recent[max(len(r.recent)-rawContextSize, 0):])
+}
+
+// decompressionFallbackReader switches from the decompressed body to a note
+// and the raw compressed bytes when decompression fails midway.
+type decompressionFallbackReader struct {
+	decompressed io.ReadCloser
+	raw          *rawReader
+	written      int64
+	fallback     io.Reader
+}
+
+func (r *decompressionFallbackReader) Read(p []byte) (int, error) {
+	if r.fallback != nil {
+		return r.fallback.Read(p)
+	}
+	n, err := r.decompressed.Read(p)
+	r.written += int64(n)
+	// Errors of the body itself are not decoding errors and are passed on
+	if err == nil || errors.Is(err, io.EOF) || r.raw.err != nil {
+		return n, err
+	}
+	context := r.raw.context()
+	note := fmt.Sprintf("\r\n\r\nX-Decompression-Error: %v; offset=%d; decompressed=%d\r\nX-Raw-Body: base64; offset=%d\r\n\r\n",
+		err, r.raw.offset, r.written, r.raw.offset-int64(len(context)))
+	r.fallback = io.MultiReader(strings.NewReader(note), &base64LineReader{source: io.MultiReader(bytes.NewReader(context), r.raw)})
+	if n > 0 {
+		return n, nil
+	}
+	return r.fallback.Read(p)
+}
+
+func (r *decompressionFallbackReader) Close() error {
+	return r.decompressed.Close()
+}
+
+// base64LineReader encodes its source as base64 in lines of 76 characters.
+type base64LineReader struct {
+	source  io.Reader
+	pending []byte
+	err     error
+}
+
+func (r *base64LineReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		var chunk [57]byte
+		n, err := io.ReadFull(r.source, chunk[:])
+		if n > 0 {
+			r.pending = base64.StdEncoding.AppendEncode(r.pending, chunk[:n])
+			r.pending = append(r.pending, "\r\n"...)
+		}
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		r.err = err
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
@@ -0,0 +1,81 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTruncatedGzipLogsRawBytesAndOffset(t *testing.T) {
+	// Random bytes do not compress, so half the stream is a long way in
+	original := make([]byte, 4096)
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range original {
+		original[i] = byte(rng.Uint32())
+	}
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write(original)
+	gzipWriter.Close()
+	truncated := compressed.Bytes()[:compressed.Len()/2]
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(truncated)
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/truncated", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	clientBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(clientBody, truncated) {
+		t.Fatalf("Expected the client to receive the %d bytes sent, got %d", len(truncated), len(clientBody))
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	_, body, _ := strings.Cut(testLogger.responses[0].content, "\r\n\r\n")
+	decompressed, rest, found := strings.Cut(body, "\r\n\r\nX-Decompression-Error: ")
+	if !found {
+		t.Fatalf("Expected a decompression error note in the logged body, got %q", body)
+	}
+	if len(decompressed) == 0 || !bytes.HasPrefix(original, []byte(decompressed)) {
+		t.Errorf("Expected the body decompressed before the failure to be logged, got %d bytes", len(decompressed))
+	}
+
+	note, encoded, _ := strings.Cut(rest, "\r\n\r\n")
+	expectedNote := fmt.Sprintf("unexpected EOF; offset=%d; decompressed=%d\r\nX-Raw-Body: base64; offset=%d",
+		len(truncated), len(decompressed), len(truncated)-rawContextSize)
+	if note != expectedNote {
+		t.Errorf("Expected note %q, got %q", expectedNote, note)
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded, "\r\n", ""))
+	if err != nil || !bytes.Equal(raw, truncated[len(truncated)-rawContextSize:]) {
+		t.Errorf("Expected the last %d compressed bytes in base64, got %q (%v)", rawContextSize, encoded, err)
+	}
+}
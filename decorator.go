@@ -0,0 +1,39 @@
+package loggingproxy
+
+import (
+	"io"
+	"time"
+)
+
+// The decorators in this package wrap another Logger and pass every stream,
+// CONNECT event and Close on to it. These helpers do the passing on.
+
+// forwardTo returns a function that logs a stream to logger as the request
+// or response of metadata, or nil if there is no logger to forward to.
+func forwardTo(logger Logger, streamType string, metadata RequestMetadata, timestamp time.Time) func(io.ReadCloser) {
+	if logger == nil {
+		return nil
+	}
+	return func(stream io.ReadCloser) {
+		if streamType == "request" {
+			logger.LogRequest(metadata, timestamp, stream)
+		} else {
+			logger.LogResponse(metadata, timestamp, stream)
+		}
+	}
+}
+
+// forwardConnect passes a CONNECT event on to logger if it supports them.
+func forwardConnect(logger Logger, metadata RequestMetadata, timestamp time.Time) {
+	if connectLogger, ok := logger.(ConnectLogger); ok {
+		connectLogger.LogConnect(metadata, timestamp)
+	}
+}
+
+// closeWrapped closes logger if it implements io.Closer.
+func closeWrapped(logger Logger) error {
+	if closer, ok := logger.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
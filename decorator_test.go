@@ -0,0 +1,43 @@
+package loggingproxy
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// closingLogger records whether it was closed.
+type closingLogger struct {
+	NoOpLogger
+	closed bool
+}
+
+func (l *closingLogger) Close() error {
+	l.closed = true
+	return errors.New("closed")
+}
+
+func TestDecoratorHelpers(t *testing.T) {
+	if forwardTo(nil, "request", RequestMetadata{}, time.Now()) != nil {
+		t.Error("Expected nothing to forward to without a logger")
+	}
+
+	testLogger := &TestLogger{}
+	forwardTo(testLogger, "request", RequestMetadata{ID: "one"}, time.Now())(io.NopCloser(strings.NewReader("request")))
+	forwardTo(testLogger, "response", RequestMetadata{ID: "one"}, time.Now())(io.NopCloser(strings.NewReader("response")))
+	if len(testLogger.requests) != 1 || testLogger.requests[0].content != "request" || len(testLogger.responses) != 1 || testLogger.responses[0].content != "response" {
+		t.Errorf("Expected each stream forwarded as its type, got %d requests and %d responses", len(testLogger.requests), len(testLogger.responses))
+	}
+
+	// Loggers without LogConnect or Close are skipped
+	forwardConnect(testLogger, RequestMetadata{}, time.Now())
+	if err := closeWrapped(testLogger); err != nil {
+		t.Errorf("Expected no error closing a logger without Close, got %v", err)
+	}
+	closer := &closingLogger{}
+	if err := closeWrapped(closer); !closer.closed || err == nil {
+		t.Errorf("Expected the wrapped logger to be closed and its error returned, got closed=%v, %v", closer.closed, err)
+	}
+}
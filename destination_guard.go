@@ -0,0 +1,178 @@
+package loggingproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// DestinationGuard rejects reverse proxy requests whose destination resolves
+// to a loopback, private (RFC 1918 and RFC 4193), link-local or unspecified
+// address, to keep user-influenced destinations from reaching internal
+// services. The destination is resolved before the request is forwarded, and
+// the addresses actually dialed, including those of redirect targets, are
+// checked again, so a host whose DNS answer changes in between is caught too.
+// An upstream proxy resolves destinations itself, so with one only the check
+// before forwarding applies.
+type DestinationGuard struct {
+	// Allow lists destinations that may be reached anyway, as exact hosts,
+	// "*.example.com" suffixes, IP literals or CIDR ranges, for example
+	// "localhost" or "127.0.0.0/8". IP entries are matched against every
+	// resolved address.
+	Allow []string
+
+	// Resolver looks up destination hosts. Nil uses net.DefaultResolver.
+	Resolver *net.Resolver
+}
+
+type destinationGuard struct {
+	allow    *mitmExcludeMatcher
+	resolver *net.Resolver
+}
+
+func newDestinationGuard(config *DestinationGuard) (*destinationGuard, error) {
+	if config == nil {
+		return nil, nil
+	}
+	allow, err := newMITMHostMatcher(config.Allow, "destination guard allow entry")
+	if err != nil {
+		return nil, err
+	}
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &destinationGuard{allow: allow, resolver: resolver}, nil
+}
+
+// check returns why host must not be proxied to, or "" if it may be. Hosts
+// that fail to resolve are let through; forwarding to them fails anyway.
+func (g *destinationGuard) check(ctx context.Context, host string) string {
+	if g == nil || g.allow.Match(host) {
+		return ""
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		addrs, err := g.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return ""
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if isInternalIP(ip) && !g.allow.Match(ip.String()) {
+			if ip.String() == host {
+				return fmt.Sprintf("destination %s is an internal address", host)
+			}
+			return fmt.Sprintf("destination %s resolves to internal address %s", host, ip)
+		}
+	}
+	return ""
+}
+
+// blockedDestinationError is returned by the client when a redirect or a
+// connection leads to an address the guard rejects.
+type blockedDestinationError struct {
+	reason string
+}
+
+func (e *blockedDestinationError) Error() string {
+	return e.reason
+}
+
+type guardedHostsKey struct{}
+
+// guardedHosts are the hosts a request is forwarded to, including redirect
+// targets. Only connections to them are checked while dialing, so that an
+// upstream proxy on an internal address can still be used.
+type guardedHosts struct {
+	mu    sync.Mutex
+	hosts map[string]bool
+}
+
+func guardedHostsFrom(ctx context.Context) *guardedHosts {
+	hosts, _ := ctx.Value(guardedHostsKey{}).(*guardedHosts)
+	return hosts
+}
+
+func (h *guardedHosts) add(host string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.hosts[strings.ToLower(host)] = true
+}
+
+func (h *guardedHosts) contains(host string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.hosts[strings.ToLower(host)]
+}
+
+// guard returns ctx marked so that connections to host are checked by the
+// dialer.
+func (g *destinationGuard) guard(ctx context.Context, host string) context.Context {
+	if g == nil {
+		return ctx
+	}
+	hosts := guardedHostsFrom(ctx)
+	if hosts == nil {
+		hosts = &guardedHosts{hosts: map[string]bool{}}
+		ctx = context.WithValue(ctx, guardedHostsKey{}, hosts)
+	}
+	hosts.add(host)
+	return ctx
+}
+
+// checkRedirect rejects a redirect to an internal address and checks the
+// connections to its host.
+func (g *destinationGuard) checkRedirect(request *http.Request) error {
+	if g == nil {
+		return nil
+	}
+	host := request.URL.Hostname()
+	if reason := g.check(request.Context(), host); reason != "" {
+		return &blockedDestinationError{reason: "redirect " + reason}
+	}
+	guardedHostsFrom(request.Context()).add(host)
+	return nil
+}
+
+// control returns a net.Dialer Control function that refuses connections to
+// internal addresses for addr, or nil if addr is not a guarded host of ctx.
+func (g *destinationGuard) control(ctx context.Context, addr string) func(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(addr)
+	if g == nil || err != nil || !guardedHostsFrom(ctx).contains(host) || g.allow.Match(host) {
+		return nil
+	}
+	return func(_, address string, _ syscall.RawConn) error {
+		dialed, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip := net.ParseIP(dialed)
+		if ip != nil && isInternalIP(ip) && !g.allow.Match(ip.String()) {
+			return &blockedDestinationError{reason: fmt.Sprintf("destination %s connects to internal address %s", host, ip)}
+		}
+		return nil
+	}
+}
+
+func isInternalIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified()
+}
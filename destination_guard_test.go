@@ -0,0 +1,167 @@
+package loggingproxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newGuardedProxy(t *testing.T, guard *DestinationGuard, destination string, logger Logger) *httptest.Server {
+	t.Helper()
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{DestinationGuard: guard})
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", destination, logger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func TestDestinationGuardBlocksPrivateTarget(t *testing.T) {
+	testLogger := &TestLogger{}
+	testServer := newGuardedProxy(t, &DestinationGuard{}, "http://10.1.2.3/", testLogger)
+
+	status, body := getStatusAndBody(t, testServer.URL+"/api/admin")
+	if status != http.StatusForbidden || !strings.Contains(body, "internal address") {
+		t.Fatalf("expected 403 for a private destination, got %d %q", status, body)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 1 || !testLogger.requests[0].metadata.Blocked {
+		t.Fatalf("expected the blocked request to be logged, got %d logs", len(testLogger.requests))
+	}
+}
+
+func TestDestinationGuardAllowsLoopbackOnlyViaAllowList(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+	localhostURL := strings.Replace(backend.URL, "127.0.0.1", "localhost", 1) + "/"
+
+	testCases := []struct {
+		name        string
+		allow       []string
+		destination string
+		status      int
+	}{
+		{"loopback IP", nil, backend.URL + "/", http.StatusForbidden},
+		{"host resolving to loopback", nil, localhostURL, http.StatusForbidden},
+		{"allowed IP", []string{"127.0.0.1"}, backend.URL + "/", http.StatusOK},
+		{"allowed CIDR", []string{"127.0.0.0/8", "::1"}, localhostURL, http.StatusOK},
+		{"allowed host", []string{"localhost"}, localhostURL, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testServer := newGuardedProxy(t, &DestinationGuard{Allow: tc.allow}, tc.destination, &NoOpLogger{})
+			if status, body := getStatusAndBody(t, testServer.URL+"/api/"); status != tc.status {
+				t.Errorf("expected %d, got %d %q", tc.status, status, body)
+			}
+		})
+	}
+}
+
+func TestDestinationGuardCheck(t *testing.T) {
+	guard, err := newDestinationGuard(&DestinationGuard{})
+	if err != nil {
+		t.Fatalf("failed to create guard: %v", err)
+	}
+	for host, blocked := range map[string]bool{
+		"93.184.216.34":   false,
+		"2606:2800::1":    false,
+		"10.0.0.1":        true,
+		"172.16.5.4":      true,
+		"192.168.1.1":     true,
+		"127.0.0.1":       true,
+		"169.254.169.254": true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fd00::1":         true,
+		"fe80::1":         true,
+	} {
+		if reason := guard.check(context.Background(), host); (reason != "") != blocked {
+			t.Errorf("check(%q) = %q, want blocked=%v", host, reason, blocked)
+		}
+	}
+
+	if _, err := newDestinationGuard(&DestinationGuard{Allow: []string{"10.0.0.0/99"}}); err == nil {
+		t.Error("expected an error for an invalid allow-list CIDR")
+	}
+}
+
+func TestDestinationGuardChecksDialedAddresses(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+	localhostURL := strings.Replace(backend.URL, "127.0.0.1", "localhost", 1) + "/"
+
+	guard, err := newDestinationGuard(&DestinationGuard{})
+	if err != nil {
+		t.Fatalf("failed to create guard: %v", err)
+	}
+	transport := newDirectTransport()
+	setDialer(transport, 0, nil, guard)
+	client := &http.Client{Transport: transport}
+
+	// The dialer catches what the check before forwarding missed, such as a
+	// DNS answer that changed in between
+	request, _ := http.NewRequestWithContext(guard.guard(context.Background(), "localhost"), http.MethodGet, localhostURL, nil)
+	var blocked *blockedDestinationError
+	if _, err := client.Do(request); !errors.As(err, &blocked) {
+		t.Fatalf("expected the connection to loopback to be refused, got %v", err)
+	}
+
+	// Hosts the request did not mark, such as an upstream proxy, are dialed
+	request, _ = http.NewRequest(http.MethodGet, localhostURL, nil)
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("expected an unguarded host to be dialed, got %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestDestinationGuardBlocksRedirectToInternalAddress(t *testing.T) {
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("metadata"))
+	}))
+	defer internal.Close()
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL+"/latest/meta-data", http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		DestinationGuard: &DestinationGuard{Allow: []string{"localhost"}},
+		MaxRedirects:     3,
+	})
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	destination := strings.Replace(redirector.URL, "127.0.0.1", "localhost", 1) + "/"
+	if err := proxyServer.AddRoute("/api/", destination, testLogger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	status, body := getStatusAndBody(t, testServer.URL+"/api/")
+	if status != http.StatusForbidden || !strings.Contains(body, "internal address") {
+		t.Fatalf("expected 403 for a redirect to an internal address, got %d %q", status, body)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 1 || !testLogger.responses[0].metadata.Blocked {
+		t.Fatalf("expected the blocked redirect to be logged, got %d responses", len(testLogger.responses))
+	}
+}
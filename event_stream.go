@@ -0,0 +1,28 @@
+package loggingproxy
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// eventStreamSubscription classifies a Server-Sent Events request for
+// RequestMetadata.EventStream. EventSource clients send Last-Event-ID when
+// they reconnect after losing a stream, so the backend can resume after that
+// event; the header is forwarded like any other. Requests that do not accept
+// text/event-stream and send no Last-Event-ID are not event streams.
+func eventStreamSubscription(request *http.Request) (kind string, lastEventID string) {
+	lastEventID = request.Header.Get("Last-Event-ID")
+	if lastEventID != "" {
+		return EventStreamReconnect, lastEventID
+	}
+	for _, accept := range request.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err == nil && mediaType == "text/event-stream" {
+				return EventStreamSubscribe, ""
+			}
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,79 @@
+package loggingproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStreamReconnectIsForwardedAndFlagged(t *testing.T) {
+	lastEventIDs := make(chan string, 10)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventIDs <- r.Header.Get("Last-Event-ID")
+		w.Header().Set("Content-Type", "text/event-stream")
+		// Keep streaming for longer than the request timeout
+		for i := range 5 {
+			fmt.Fprintf(w, "id: %d\ndata: event %d\n\n", i, i)
+			w.(http.Flusher).Flush()
+			time.Sleep(50 * time.Millisecond)
+		}
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:    HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		RequestTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	if err := proxyServer.AddRoute("/events/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	subscribe := func(lastEventID string) {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/events/feed", nil)
+		request.Header.Set("Accept", "text/event-stream")
+		if lastEventID != "" {
+			request.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || !strings.Contains(string(body), "data: event 4") {
+			t.Errorf("Expected the whole stream despite the request timeout, got %q (%v)", body, err)
+		}
+		if got := <-lastEventIDs; got != lastEventID {
+			t.Errorf("Expected Last-Event-ID %q upstream, got %q", lastEventID, got)
+		}
+	}
+	subscribe("")
+	subscribe("3")
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 2 {
+		t.Fatalf("Expected two logged requests, got %d", len(testLogger.requests))
+	}
+	kinds := map[string]string{}
+	for _, entry := range testLogger.requests {
+		kinds[entry.metadata.EventStream] = entry.metadata.LastEventID
+	}
+	if lastEventID, ok := kinds[EventStreamSubscribe]; !ok || lastEventID != "" {
+		t.Errorf("Expected a fresh subscription to be flagged, got %v", kinds)
+	}
+	if lastEventID, ok := kinds[EventStreamReconnect]; !ok || lastEventID != "3" {
+		t.Errorf("Expected the reconnection to be flagged with its Last-Event-ID, got %v", kinds)
+	}
+}
@@ -0,0 +1,104 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// FallbackResponse is served instead of a 502/504 error when a route's backend
+// cannot be reached, for example a static degraded reply from an LLM gateway.
+type FallbackResponse struct {
+	// StatusCode defaults to 503 Service Unavailable.
+	StatusCode int
+	Header     http.Header
+	// Body is the response body. BodyFile, if set, is read once when the route
+	// is added and replaces Body.
+	Body     []byte
+	BodyFile string
+}
+
+// load validates the fallback and reads its body file.
+func (f FallbackResponse) load() (*FallbackResponse, error) {
+	if f.StatusCode == 0 {
+		f.StatusCode = http.StatusServiceUnavailable
+	}
+	if f.StatusCode < 100 || f.StatusCode > 999 {
+		return nil, fmt.Errorf("invalid fallback status %d", f.StatusCode)
+	}
+	if f.BodyFile != "" {
+		body, err := os.ReadFile(f.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read fallback body: %w", err)
+		}
+		f.Body = body
+	}
+	f.Header = f.Header.Clone()
+	return &f, nil
+}
+
+// serveFallback answers a request whose upstream request failed with the
+// route's fallback and logs it as a response with RequestMetadata.Fallback set.
+func (s *ProxyServer) serveFallback(w http.ResponseWriter, route *proxyRoute, origin string, metadata RequestMetadata, logger Logger, requestLogDone <-chan struct{}, upstreamErr error) {
+	fallback := route.fallback
+	responseTime := s.clock.Now()
+	metadata.Fallback = true
+	metadata.FallbackReason = upstreamErr.Error()
+	metadata.ResponseStatus = fmt.Sprintf("%d %s", fallback.StatusCode, http.StatusText(fallback.StatusCode))
+	metadata.ResponseStatusCode = fallback.StatusCode
+
+	for key, values := range fallback.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(fallback.Body)))
+	s.setProxyHeaders(w, route, origin, metadata)
+	w.WriteHeader(fallback.StatusCode)
+	w.Write(fallback.Body)
+
+	s.logWorkers.Go(func() {
+		s.waitForRequestLog(requestLogDone)
+		var transcript bytes.Buffer
+		fmt.Fprintf(&transcript, "HTTP/1.1 %s\r\n", metadata.ResponseStatus)
+		fmt.Fprintf(&transcript, "X-Proxy-Fallback: %s\r\n", metadata.FallbackReason)
+		for name, values := range fallback.Header {
+			if !s.logHeaders.allows(name) {
+				continue
+			}
+			for _, value := range values {
+				fmt.Fprintf(&transcript, "%s: %s\r\n", name, value)
+			}
+		}
+		transcript.WriteString("\r\n")
+		logger.LogResponse(metadata, responseTime, &readCloser{
+			Reader: io.MultiReader(&transcript, route.bodyCapture.captureBody(bytes.NewReader(fallback.Body))),
+			Closer: io.NopCloser(nil),
+		})
+	})
+}
+
+// heldReadCloser keeps what is read through it until it is released, for a
+// response log that is only started once the fallback is ruled out.
+type heldReadCloser struct {
+	io.ReadCloser
+	held     bytes.Buffer
+	released bool
+}
+
+func (h *heldReadCloser) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	if !h.released {
+		h.held.Write(p[:n])
+	}
+	return n, err
+}
+
+// release stops holding and returns what was held.
+func (h *heldReadCloser) release() io.Reader {
+	h.released = true
+	return &h.held
+}
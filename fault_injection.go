@@ -0,0 +1,130 @@
+package loggingproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// DefaultFaultStatus is the status of injected errors when
+// FaultInjection.ErrorStatus is zero.
+const DefaultFaultStatus = 503
+
+var errInjectedFault = errors.New("injected fault")
+
+// FaultInjection adds synthetic latency, errors and connection resets to a
+// route, to test how clients cope with a slow or failing backend. Injected
+// faults are listed in RequestMetadata.InjectedFaults.
+type FaultInjection struct {
+	// Latency is added before every request is forwarded, plus a random
+	// extra of up to LatencyJitter.
+	Latency       time.Duration
+	LatencyJitter time.Duration
+	// ErrorRate is the probability, from 0 to 1, that a request is answered
+	// with ErrorStatus by the proxy instead of being forwarded. Zero
+	// ErrorStatus uses DefaultFaultStatus.
+	ErrorRate   float64
+	ErrorStatus int
+	// ResetRate is the probability, from 0 to 1, that the client connection
+	// is dropped once the backend has answered. The backend's response is
+	// still read and logged.
+	ResetRate float64
+	// Seed makes the random decisions repeatable. Zero uses a random seed.
+	Seed uint64
+}
+
+// routeFaults decides the faults of each request of a route.
+type routeFaults struct {
+	options FaultInjection
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// injectedFaults are the faults decided for one request.
+type injectedFaults struct {
+	latency time.Duration
+	status  int
+	reset   bool
+}
+
+// newRouteFaults returns nil if options is nil.
+func newRouteFaults(options *FaultInjection) (*routeFaults, error) {
+	if options == nil {
+		return nil, nil
+	}
+	if options.Latency < 0 || options.LatencyJitter < 0 {
+		return nil, errors.New("fault injection latency must not be negative")
+	}
+	for _, rate := range []float64{options.ErrorRate, options.ResetRate} {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("fault injection rate %v is not between 0 and 1", rate)
+		}
+	}
+	faults := &routeFaults{options: *options}
+	if faults.options.ErrorStatus == 0 {
+		faults.options.ErrorStatus = DefaultFaultStatus
+	}
+	if faults.options.ErrorStatus < 400 || faults.options.ErrorStatus > 599 {
+		return nil, fmt.Errorf("fault injection status %d is not an error status", faults.options.ErrorStatus)
+	}
+	seed := options.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	faults.rng = rand.New(rand.NewPCG(seed, seed))
+	return faults, nil
+}
+
+// decide rolls the faults of the next request. Only the faults that are
+// configured consume random numbers, so a seeded sequence of decisions does
+// not change when an unrelated fault is added.
+func (f *routeFaults) decide() injectedFaults {
+	if f == nil {
+		return injectedFaults{}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	faults := injectedFaults{latency: f.options.Latency}
+	if f.options.LatencyJitter > 0 {
+		faults.latency += time.Duration(f.rng.Int64N(int64(f.options.LatencyJitter) + 1))
+	}
+	if f.options.ErrorRate > 0 && f.rng.Float64() < f.options.ErrorRate {
+		faults.status = f.options.ErrorStatus
+	} else if f.options.ResetRate > 0 && f.rng.Float64() < f.options.ResetRate {
+		faults.reset = true
+	}
+	return faults
+}
+
+// names describes the faults for RequestMetadata.InjectedFaults.
+func (f injectedFaults) names() []string {
+	var names []string
+	if f.latency > 0 {
+		names = append(names, fmt.Sprintf("latency=%s", f.latency))
+	}
+	if f.status != 0 {
+		names = append(names, fmt.Sprintf("error=%d", f.status))
+	}
+	if f.reset {
+		names = append(names, "reset")
+	}
+	return names
+}
+
+// delay waits for the injected latency, or until ctx is done.
+func (f injectedFaults) delay(ctx context.Context) {
+	if f.latency <= 0 {
+		return
+	}
+	timer := time.NewTimer(f.latency)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
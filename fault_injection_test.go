@@ -0,0 +1,154 @@
+package loggingproxy
+
+import (
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFaultInjectionAddsLatency(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{
+		FaultInjection: &FaultInjection{Latency: 200 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	start := time.Now()
+	resp, err := http.Get(testServer.URL + "/api/slow")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	elapsed := time.Since(start)
+	if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Errorf("Expected the backend response, got %d: %q", resp.StatusCode, body)
+	}
+	if elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Expected the response to be delayed by about 200ms, took %v", elapsed)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected one logged response, got %d", len(testLogger.responses))
+	}
+	faults := testLogger.responses[0].metadata.InjectedFaults
+	if len(faults) != 1 || faults[0] != "latency=200ms" {
+		t.Errorf("Expected the latency to be flagged in the metadata, got %v", faults)
+	}
+}
+
+func TestFaultInjectionErrorsAreSeeded(t *testing.T) {
+	var forwarded atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	const seed, rate, requests = 42, 0.3, 50
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{
+		FaultInjection: &FaultInjection{ErrorRate: rate, ErrorStatus: http.StatusInternalServerError, Seed: seed},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	// The proxy rolls one number per request from the seeded generator
+	rng := rand.New(rand.NewPCG(seed, seed))
+	injected := 0
+	for i := range requests {
+		expectError := rng.Float64() < rate
+		resp, err := http.Post(testServer.URL+"/api/items", "text/plain", strings.NewReader("body"))
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if expectError {
+			injected++
+			if resp.StatusCode != http.StatusInternalServerError {
+				t.Errorf("Request %d: expected an injected 500, got %d", i, resp.StatusCode)
+			}
+		} else if resp.StatusCode != http.StatusOK {
+			t.Errorf("Request %d: expected the backend response, got %d", i, resp.StatusCode)
+		}
+	}
+	if injected == 0 || injected == requests {
+		t.Fatalf("Expected the seed to inject some errors, got %d of %d", injected, requests)
+	}
+	if got := int(forwarded.Load()); got != requests-injected {
+		t.Errorf("Expected %d forwarded requests, got %d", requests-injected, got)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	flagged := 0
+	for _, entry := range testLogger.responses {
+		if len(entry.metadata.InjectedFaults) == 1 && entry.metadata.InjectedFaults[0] == "error=500" {
+			flagged++
+			if !strings.Contains(entry.content, "X-Proxy-Error: injected fault") {
+				t.Errorf("Expected the synthetic response to be marked, got %q", entry.content)
+			}
+		}
+	}
+	if flagged != injected {
+		t.Errorf("Expected %d flagged responses, got %d", injected, flagged)
+	}
+}
+
+func TestFaultInjectionResetsClientConnection(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "answered")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{
+		FaultInjection: &FaultInjection{ResetRate: 1},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	if resp, err := http.Get(testServer.URL + "/api/items"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("Expected the connection to be dropped, got %d", resp.StatusCode)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 1 || !strings.HasSuffix(testLogger.responses[0].content, "answered") {
+		t.Fatalf("Expected the backend response to be logged, got %d responses", len(testLogger.responses))
+	}
+	if faults := testLogger.responses[0].metadata.InjectedFaults; len(faults) != 1 || faults[0] != "reset" {
+		t.Errorf("Expected the reset to be flagged, got %v", faults)
+	}
+
+	if _, err := newRouteFaults(&FaultInjection{ErrorRate: 1.5}); err == nil {
+		t.Error("Expected a rate over 1 to be rejected")
+	}
+}
@@ -1,12 +1,17 @@
 package loggingproxy
 
 import (
+	"bufio"
+	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"sync"
+	"text/template"
 	"time"
 )
 
@@ -14,19 +19,238 @@ import (
 type FileLogger struct {
 	LogDir  string
 	Console bool
+
+	bufferSize       int
+	flushInterval    time.Duration
+	filenameTemplate *template.Template
+	clock            Clock
+	index            bool
+	captureCap       *logCap
+	encryption       cipher.AEAD
+	encryptPatterns  map[string]struct{}
+	ops              levelLogger
+	indexMu          sync.Mutex
+	openFilesMu      sync.Mutex
+	openFiles        map[*bufferedLogFile]struct{}
+	stopFlushing     chan struct{}
+	closeOnce        sync.Once
+}
+
+// FileLoggerOptions configures a FileLogger.
+type FileLoggerOptions struct {
+	LogDir  string
+	Console bool
+
+	// BufferSize enables buffered writes of .bin files. Streams are copied in
+	// many small chunks (especially SSE), so buffering trades durability for
+	// fewer write syscalls. Zero writes every chunk directly to the file.
+	BufferSize int
+
+	// FlushInterval periodically flushes and fsyncs buffered files that are
+	// still being written, bounding how much data a crash can lose. Zero only
+	// flushes when the buffer fills, the stream completes, or on Close.
+	FlushInterval time.Duration
+
+	// FilenameTemplate is a text/template for log file names, without the
+	// extension, executed with LogFilenameData. "/" creates subdirectories,
+	// e.g. "{{.Pattern}}/{{.Timestamp}}_{{.Method}}_{{.ShortID}}_{{.StreamType}}".
+	// Empty uses DefaultFilenameTemplate.
+	FilenameTemplate string
+
+	// Clock provides stream completion times. Nil uses the wall clock.
+	Clock Clock
+
+	// Index appends a FileLogIndexEntry to FileLogIndexName in LogDir for every
+	// logged stream, so captures can be searched without reading every
+	// metadata file.
+	Index bool
+
+	// LogLevel filters the logger's own console lines and error messages.
+	// Console request lines are info, so LogLevelError silences them.
+	LogLevel LogLevel
+
+	// MaxCaptures caps the number of captures (the request and response of
+	// one request ID) and MaxBytes their total .bin size. Zero is no limit.
+	// Only captures logged since the FileLogger was created are counted.
+	MaxCaptures int
+	MaxBytes    int64
+	// CapPolicy is what happens at the cap: LogCapStop (the default) logs no
+	// new captures, LogCapEvict deletes the oldest ones. Evicted captures keep
+	// their index lines.
+	CapPolicy LogCapPolicy
+
+	// EncryptionKey encrypts .bin files with AES-GCM. It must be 16, 24 or 32
+	// bytes long. Encrypted files are read back with DecryptLog; metadata
+	// files and the index stay plaintext. Nil writes plaintext files.
+	EncryptionKey []byte
+	// EncryptPatterns limits encryption to streams of these route patterns.
+	// Empty encrypts every stream.
+	EncryptPatterns []string
+}
+
+// FileLogIndexName is the name of the FileLogger index file in LogDir.
+const FileLogIndexName = "index.jsonl"
+
+// FileLogIndexEntry is one line of the FileLogger index, written when a stream
+// has been logged.
+type FileLogIndexEntry struct {
+	ID         string    `json:"id"`
+	StreamType string    `json:"stream_type"`
+	Timestamp  time.Time `json:"timestamp"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	TargetURL  string    `json:"target_url"`
+	Status     int       `json:"status,omitempty"`
+	Filename   string    `json:"filename"`
+	Completed  bool      `json:"completed"`
+	Subject    string    `json:"subject,omitempty"`
+	// Evicted marks the line appended when the cap evicted the capture ID.
+	// The capture's earlier lines are kept, but its files are gone.
+	Evicted bool `json:"evicted,omitempty"`
 }
 
 // NewFileLogger creates a new file-based logger
 func NewFileLogger(logDir string, console bool) (*FileLogger, error) {
+	return NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir:  logDir,
+		Console: console,
+	})
+}
+
+// NewFileLoggerWithOptions creates a new file-based logger with optional write buffering
+func NewFileLoggerWithOptions(options FileLoggerOptions) (*FileLogger, error) {
 	// Ensure log directory exists
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	if err := os.MkdirAll(options.LogDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create log directory: %w", err)
 	}
 
-	return &FileLogger{
-		LogDir:  logDir,
-		Console: console,
-	}, nil
+	filenameTemplate, err := parseFilenameTemplate(options.FilenameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	capPolicy, err := ParseLogCapPolicy(string(options.CapPolicy))
+	if err != nil {
+		return nil, err
+	}
+
+	var encryption cipher.AEAD
+	if options.EncryptionKey != nil {
+		if encryption, err = newLogCipher(options.EncryptionKey); err != nil {
+			return nil, err
+		}
+	}
+	var encryptPatterns map[string]struct{}
+	if len(options.EncryptPatterns) > 0 {
+		encryptPatterns = make(map[string]struct{}, len(options.EncryptPatterns))
+		for _, pattern := range options.EncryptPatterns {
+			encryptPatterns[pattern] = struct{}{}
+		}
+	}
+
+	f := &FileLogger{
+		LogDir:           options.LogDir,
+		Console:          options.Console,
+		bufferSize:       options.BufferSize,
+		flushInterval:    options.FlushInterval,
+		filenameTemplate: filenameTemplate,
+		clock:            clockOrReal(options.Clock),
+		index:            options.Index,
+		ops:              levelLogger{level: options.LogLevel},
+		captureCap:       newLogCap(options.MaxCaptures, options.MaxBytes, capPolicy, levelLogger{level: options.LogLevel}),
+		encryption:       encryption,
+		encryptPatterns:  encryptPatterns,
+		openFiles:        map[*bufferedLogFile]struct{}{},
+		stopFlushing:     make(chan struct{}),
+	}
+	if f.captureCap != nil && f.index {
+		f.captureCap.evicted = f.appendEvicted
+	}
+	if f.bufferSize > 0 && f.flushInterval > 0 {
+		go f.flushLoop()
+	}
+	return f, nil
+}
+
+// Close flushes and fsyncs all buffered files that are still being written.
+// Streams still in progress keep logging; their remaining data is flushed when
+// they complete.
+func (f *FileLogger) Close() error {
+	f.closeOnce.Do(func() {
+		if f.stopFlushing != nil {
+			close(f.stopFlushing)
+		}
+	})
+	return f.flushOpenFiles()
+}
+
+func (f *FileLogger) flushLoop() {
+	ticker := time.NewTicker(f.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.flushOpenFiles(); err != nil {
+				f.ops.Errorf("Failed to flush log files: %v", err)
+			}
+		case <-f.stopFlushing:
+			return
+		}
+	}
+}
+
+func (f *FileLogger) flushOpenFiles() error {
+	f.openFilesMu.Lock()
+	files := make([]*bufferedLogFile, 0, len(f.openFiles))
+	for file := range f.openFiles {
+		files = append(files, file)
+	}
+	f.openFilesMu.Unlock()
+
+	var errs []error
+	for _, file := range files {
+		errs = append(errs, file.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+// bufferedLogFile is a .bin file written through a bufio.Writer that can be
+// flushed concurrently by the periodic flusher and Close. It deliberately does
+// not implement io.ReaderFrom, so io.Copy cannot bypass the buffer.
+type bufferedLogFile struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func (b *bufferedLogFile) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.writer.Write(p)
+}
+
+// Flush writes buffered data to the file and fsyncs it.
+func (b *bufferedLogFile) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.writer.Flush(); err != nil {
+		return err
+	}
+	return b.file.Sync()
+}
+
+func (f *FileLogger) trackOpenFile(file *bufferedLogFile) {
+	f.openFilesMu.Lock()
+	defer f.openFilesMu.Unlock()
+	if f.openFiles == nil {
+		f.openFiles = map[*bufferedLogFile]struct{}{}
+	}
+	f.openFiles[file] = struct{}{}
+}
+
+func (f *FileLogger) untrackOpenFile(file *bufferedLogFile) {
+	f.openFilesMu.Lock()
+	defer f.openFilesMu.Unlock()
+	delete(f.openFiles, file)
 }
 
 // LogRequest logs a request with its metadata and raw HTTP stream to a file
@@ -44,7 +268,7 @@ func (f *FileLogger) LogConnect(metadata RequestMetadata, _ time.Time) {
 	if !f.Console {
 		return
 	}
-	log.Printf("[connect] %s: %s", shortMetadataID(metadata), formatConsoleRequest(metadata))
+	f.ops.Infof("[connect] %s: %s", shortMetadataID(metadata), formatConsoleRequest(metadata))
 }
 
 type fileLogMetadata struct {
@@ -56,6 +280,7 @@ type fileLogMetadata struct {
 	DurationMS   int64           `json:"duration_ms,omitempty"`
 	BytesWritten int64           `json:"bytes_written"`
 	Completed    bool            `json:"completed"`
+	Encrypted    bool            `json:"encrypted,omitempty"`
 	Error        string          `json:"error,omitempty"`
 	Filename     string          `json:"filename"`
 }
@@ -64,12 +289,27 @@ type fileLogMetadata struct {
 func (f *FileLogger) logRawStream(metadata RequestMetadata, timestamp time.Time, rawStream io.ReadCloser, streamType string) {
 	defer rawStream.Close()
 
-	timestampStr := timestamp.Format("2006-01-02_15-04-05.000")
 	metadataID := shortMetadataID(metadata)
-	filename := fmt.Sprintf("%s_%s_%s.bin", timestampStr, metadataID, streamType)
-	filePath := filepath.Join(f.LogDir, filename)
-	metadataFilename := fmt.Sprintf("%s_%s_%s_metadata.json", timestampStr, metadataID, streamType)
-	metadataPath := filepath.Join(f.LogDir, metadataFilename)
+	baseName := f.logBaseName(metadata, timestamp, streamType)
+	logDir := filepath.Dir(filepath.Join(f.LogDir, filepath.FromSlash(baseName)))
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		f.ops.Errorf("Failed to create log directory %s: %v", logDir, err)
+		return
+	}
+
+	// Create the log file
+	// Templates need not be unique, so never truncate an earlier capture.
+	logFile, baseName, createErr := createLogFile(f.LogDir, baseName)
+	filename := baseName + ".bin"
+	filePath := filepath.Join(f.LogDir, filepath.FromSlash(filename))
+	metadataPath := filepath.Join(f.LogDir, filepath.FromSlash(baseName+"_metadata.json"))
+	if !f.captureCap.admit(metadata.ID, filePath, metadataPath) {
+		if logFile != nil {
+			logFile.Close()
+			os.Remove(filePath)
+		}
+		return
+	}
 
 	logMetadata := fileLogMetadata{
 		StreamType: streamType,
@@ -77,50 +317,176 @@ func (f *FileLogger) logRawStream(metadata RequestMetadata, timestamp time.Time,
 		Timestamp:  timestamp,
 		StartedAt:  timestamp,
 		Filename:   filename,
+		Encrypted:  f.encrypts(metadata),
 	}
 
 	// Write an initial metadata record before consuming the stream. If a stream hangs,
 	// the metadata file still exists and shows completed=false.
 	f.writeMetadata(metadataPath, logMetadata)
 
-	// Create the log file
-	// The initial metadata was already written so incomplete streams are visible.
-	logFile, err := os.Create(filePath)
-	if err != nil {
-		logMetadata.Error = fmt.Sprintf("failed to create log file: %v", err)
+	if createErr != nil {
+		logMetadata.Error = fmt.Sprintf("failed to create log file: %v", createErr)
 		f.writeMetadata(metadataPath, logMetadata)
-		log.Printf("[error] Failed to create log file %s: %v\n", filePath, err)
+		f.ops.Errorf("Failed to create log file %s: %v", filePath, createErr)
 		return
 	}
 	defer logFile.Close()
 
+	var logWriter io.Writer = logFile
+	var buffered *bufferedLogFile
+	if f.bufferSize > 0 {
+		buffered = &bufferedLogFile{file: logFile, writer: bufio.NewWriterSize(logFile, f.bufferSize)}
+		f.trackOpenFile(buffered)
+		logWriter = buffered
+	}
+
 	// Write raw HTTP stream (headers + body already combined)
-	bytesWritten, err := io.Copy(logFile, rawStream)
-	completedAt := time.Now()
+	var bytesWritten int64
+	var err error
+	if logMetadata.Encrypted {
+		var encryptor *logEncryptor
+		encryptor, err = newLogEncryptor(logWriter, f.encryption)
+		if err == nil {
+			bytesWritten, err = io.Copy(encryptor, rawStream)
+			// Seal the final record even if the stream failed, so the
+			// partial log can still be decrypted
+			if closeErr := encryptor.Close(); closeErr != nil && err == nil {
+				err = fmt.Errorf("failed to encrypt log file: %w", closeErr)
+			}
+		}
+	} else {
+		bytesWritten, err = io.Copy(logWriter, rawStream)
+	}
+	if buffered != nil {
+		// Flush before recording completion so completed=true implies the data is on disk.
+		f.untrackOpenFile(buffered)
+		if flushErr := buffered.Flush(); flushErr != nil && err == nil {
+			err = fmt.Errorf("failed to flush log file: %w", flushErr)
+		}
+	}
+	// Encryption makes the file larger than the stream, and the cap counts
+	// what is on disk
+	fileSize := bytesWritten
+	if info, statErr := logFile.Stat(); statErr == nil {
+		fileSize = info.Size()
+	}
+	f.captureCap.written(metadata.ID, fileSize)
+	completedAt := clockOrReal(f.clock).Now()
 	logMetadata.CompletedAt = &completedAt
 	logMetadata.DurationMS = completedAt.Sub(timestamp).Milliseconds()
 	logMetadata.BytesWritten = bytesWritten
 	logMetadata.Completed = err == nil
 	if err != nil {
 		logMetadata.Error = err.Error()
-		log.Printf("[error] Failed to write raw HTTP stream: %v\n", err)
+		f.ops.Errorf("Failed to write raw HTTP stream: %v", err)
 	}
 
 	// Create and save metadata
 	// Rewrite it with completion status, byte count, and duration.
 	f.writeMetadata(metadataPath, logMetadata)
+	if f.index {
+		f.appendIndex(logMetadata)
+	}
 
 	if f.Console {
-		log.Printf("[%s] %s: %s", streamType, metadataID, formatConsoleRequest(metadata))
-		log.Printf("[%s] %s: %d bytes saved to %s", streamType, metadataID, bytesWritten, filename)
+		f.ops.Infof("[%s] %s: %s", streamType, metadataID, formatConsoleRequest(metadata))
+		f.ops.Infof("[%s] %s: %d bytes saved to %s", streamType, metadataID, bytesWritten, filename)
 	}
 }
 
-func shortMetadataID(metadata RequestMetadata) string {
-	if len(metadata.ID) <= 8 {
-		return metadata.ID
+// encrypts reports whether the .bin file of a stream is encrypted.
+func (f *FileLogger) encrypts(metadata RequestMetadata) bool {
+	if f.encryption == nil {
+		return false
 	}
-	return metadata.ID[:8]
+	if f.encryptPatterns == nil {
+		return true
+	}
+	_, ok := f.encryptPatterns[metadata.Pattern]
+	return ok
+}
+
+// appendIndex adds a line for a logged stream to the index file. Lines are
+// written with a single append under a lock, so they are never interleaved.
+func (f *FileLogger) appendIndex(logMetadata fileLogMetadata) {
+	line, err := json.Marshal(FileLogIndexEntry{
+		ID:         logMetadata.Metadata.ID,
+		StreamType: logMetadata.StreamType,
+		Timestamp:  logMetadata.Timestamp,
+		Method:     logMetadata.Metadata.Method,
+		URL:        logMetadata.Metadata.SourceURL,
+		TargetURL:  logMetadata.Metadata.DestinationURL,
+		Status:     logMetadata.Metadata.ResponseStatusCode,
+		Filename:   logMetadata.Filename,
+		Completed:  logMetadata.Completed,
+		Subject:    logMetadata.Metadata.Subject,
+	})
+	if err != nil {
+		return
+	}
+	f.writeIndexLine(line)
+}
+
+// appendEvicted adds a line to the index marking the capture id as evicted.
+func (f *FileLogger) appendEvicted(id string) {
+	line, err := json.Marshal(FileLogIndexEntry{ID: id, Timestamp: clockOrReal(f.clock).Now(), Evicted: true})
+	if err != nil {
+		return
+	}
+	f.writeIndexLine(line)
+}
+
+func (f *FileLogger) writeIndexLine(line []byte) {
+	f.indexMu.Lock()
+	defer f.indexMu.Unlock()
+	indexPath := filepath.Join(f.LogDir, FileLogIndexName)
+	index, err := os.OpenFile(indexPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		f.ops.Errorf("Failed to open log index %s: %v", indexPath, err)
+		return
+	}
+	defer index.Close()
+	if _, err := index.Write(append(line, '\n')); err != nil {
+		f.ops.Errorf("Failed to write log index %s: %v", indexPath, err)
+	}
+}
+
+// logBaseName returns the log path for a stream relative to LogDir, without
+// extension. Template errors fall back to the default naming scheme.
+func (f *FileLogger) logBaseName(metadata RequestMetadata, timestamp time.Time, streamType string) string {
+	data := LogFilenameData{
+		RequestMetadata: metadata,
+		Time:            timestamp,
+		Timestamp:       timestamp.Format("2006-01-02_15-04-05.000"),
+		ShortID:         shortMetadataID(metadata),
+		StreamType:      streamType,
+	}
+	if f.filenameTemplate != nil {
+		baseName, err := renderFilename(f.filenameTemplate, data)
+		if err == nil {
+			return baseName
+		}
+		f.ops.Errorf("Failed to render log filename: %v", err)
+	}
+	return fmt.Sprintf("%s_%s_%s", data.Timestamp, data.ShortID, data.StreamType)
+}
+
+// createLogFile creates baseName.bin under logDir, adding a -2, -3, ... suffix
+// if the name is taken, and returns the file with the base name it used.
+func createLogFile(logDir, baseName string) (*os.File, string, error) {
+	candidate := baseName
+	for n := 2; ; n++ {
+		filePath := filepath.Join(logDir, filepath.FromSlash(candidate+".bin"))
+		logFile, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, fs.ErrExist) {
+			return logFile, candidate, err
+		}
+		candidate = fmt.Sprintf("%s-%d", baseName, n)
+	}
+}
+
+func shortMetadataID(metadata RequestMetadata) string {
+	return idPrefix(metadata.ID, 8)
 }
 
 func formatConsoleRequest(metadata RequestMetadata) string {
@@ -134,7 +500,7 @@ func (f *FileLogger) writeMetadata(metadataPath string, logMetadata fileLogMetad
 	// Replace metadata atomically so readers never observe partial JSON.
 	tmpFile, err := os.CreateTemp(filepath.Dir(metadataPath), "."+filepath.Base(metadataPath)+".*.tmp")
 	if err != nil {
-		log.Printf("[error] Failed to create metadata file %s: %v\n", metadataPath, err)
+		f.ops.Errorf("Failed to create metadata file %s: %v", metadataPath, err)
 		return
 	}
 	tmpPath := tmpFile.Name()
@@ -142,18 +508,18 @@ func (f *FileLogger) writeMetadata(metadataPath string, logMetadata fileLogMetad
 	encoder := json.NewEncoder(tmpFile)
 	encoder.SetIndent("", "  ")
 	if err := encoder.Encode(logMetadata); err != nil {
-		log.Printf("[error] Failed to write metadata file %s: %v\n", metadataPath, err)
+		f.ops.Errorf("Failed to write metadata file %s: %v", metadataPath, err)
 		tmpFile.Close()
 		os.Remove(tmpPath)
 		return
 	}
 	if err := tmpFile.Close(); err != nil {
-		log.Printf("[error] Failed to close metadata file %s: %v\n", metadataPath, err)
+		f.ops.Errorf("Failed to close metadata file %s: %v", metadataPath, err)
 		os.Remove(tmpPath)
 		return
 	}
 	if err := os.Rename(tmpPath, metadataPath); err != nil {
-		log.Printf("[error] Failed to replace metadata file %s: %v\n", metadataPath, err)
+		f.ops.Errorf("Failed to replace metadata file %s: %v", metadataPath, err)
 		os.Remove(tmpPath)
 	}
 }
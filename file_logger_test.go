@@ -1,10 +1,15 @@
 package loggingproxy
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -148,3 +153,497 @@ func TestFileLogging(t *testing.T) {
 		}
 	}
 }
+
+// chunkedReader returns data in small chunks, like a streamed SSE body.
+type chunkedReader struct {
+	data      []byte
+	chunkSize int
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := min(min(len(p), r.chunkSize), len(r.data))
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func readSingleBinFile(t *testing.T, logDir string) []byte {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(logDir, "*.bin"))
+	if err != nil {
+		t.Fatalf("failed to glob log files: %v", err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 .bin file, got %d", len(matches))
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatalf("failed to read log file: %v", err)
+	}
+	return data
+}
+
+func TestFileLoggerBufferedModeFlushesOnClose(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir:     logDir,
+		BufferSize: 64 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("failed to create file logger: %v", err)
+	}
+
+	streamReader, streamWriter := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fileLogger.LogResponse(RequestMetadata{ID: "buffered-close"}, time.Now(), streamReader)
+	}()
+
+	payload := "HTTP/1.1 200 OK\r\n\r\npartial streaming body"
+	if _, err := io.WriteString(streamWriter, payload); err != nil {
+		t.Fatalf("failed to write stream: %v", err)
+	}
+	// An empty write only returns once the logger reads again, which means it
+	// has finished copying the payload into its buffer.
+	streamWriter.Write(nil)
+
+	// The stream is still open, so the data sits in the buffer.
+	if data := readSingleBinFile(t, logDir); len(data) != 0 {
+		t.Fatalf("expected buffered data not to be written yet, got %q", string(data))
+	}
+
+	if err := fileLogger.Close(); err != nil {
+		t.Fatalf("failed to close file logger: %v", err)
+	}
+	if data := readSingleBinFile(t, logDir); string(data) != payload {
+		t.Fatalf("expected Close to flush %q, got %q", payload, string(data))
+	}
+
+	io.WriteString(streamWriter, " and the rest")
+	streamWriter.Close()
+	<-done
+	if data := readSingleBinFile(t, logDir); string(data) != payload+" and the rest" {
+		t.Fatalf("expected completed stream to be fully flushed, got %q", string(data))
+	}
+}
+
+func TestFileLoggerBufferedModeFlushesPeriodically(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir:        logDir,
+		BufferSize:    64 * 1024,
+		FlushInterval: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create file logger: %v", err)
+	}
+	defer fileLogger.Close()
+
+	streamReader, streamWriter := io.Pipe()
+	defer streamWriter.Close()
+	go fileLogger.LogRequest(RequestMetadata{ID: "buffered-interval"}, time.Now(), streamReader)
+
+	payload := "POST http://example.com/ HTTP/1.1\r\n\r\nupload in progress"
+	io.WriteString(streamWriter, payload)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if data := readSingleBinFile(t, logDir); string(data) == payload {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected periodic flush to write in-progress stream data")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func benchmarkFileLogger(b *testing.B, options FileLoggerOptions) {
+	options.LogDir = b.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(options)
+	if err != nil {
+		b.Fatalf("failed to create file logger: %v", err)
+	}
+	defer fileLogger.Close()
+
+	body := []byte(strings.Repeat("data: {\"chunk\": 1}\n\n", 2000))
+	b.SetBytes(int64(len(body)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		metadata := RequestMetadata{ID: fmt.Sprintf("bench-%d", i)}
+		fileLogger.LogResponse(metadata, time.Now(), io.NopCloser(&chunkedReader{data: body, chunkSize: 20}))
+	}
+}
+
+func BenchmarkFileLoggerUnbuffered(b *testing.B) {
+	benchmarkFileLogger(b, FileLoggerOptions{})
+}
+
+func BenchmarkFileLoggerBuffered(b *testing.B) {
+	benchmarkFileLogger(b, FileLoggerOptions{BufferSize: 64 * 1024})
+}
+
+func TestFileLoggerFilenameTemplate(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir:           logDir,
+		FilenameTemplate: "{{.Pattern}}/{{.Method}}_{{.ResponseStatusCode}}_{{.StreamType}}",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", fileLogger); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Post(testServer.URL+"/api/items", "text/plain", strings.NewReader("new item"))
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	// The response status is only known once the response stream is logged
+	for _, name := range []string{
+		"api/POST_0_request.bin",
+		"api/POST_0_request_metadata.json",
+		"api/POST_201_response.bin",
+		"api/POST_201_response_metadata.json",
+	} {
+		if _, err := os.Stat(filepath.Join(logDir, filepath.FromSlash(name))); err != nil {
+			t.Errorf("Expected log file %s: %v", name, err)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(logDir, "api", "POST_201_response.bin"))
+	if err != nil {
+		t.Fatalf("Failed to read response log: %v", err)
+	}
+	if !strings.Contains(string(content), "created") {
+		t.Errorf("Expected response body in templated log file, got:\n%s", content)
+	}
+}
+
+func TestFileLoggerFilenameTemplateCollision(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir:           logDir,
+		FilenameTemplate: "{{.Method}}_{{.StreamType}}",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+	defer fileLogger.Close()
+
+	// Two identical requests render the same name; neither may overwrite the other
+	for i, id := range []string{"first", "second"} {
+		metadata := RequestMetadata{ID: id, Method: "GET"}
+		fileLogger.LogRequest(metadata, time.Now(), io.NopCloser(strings.NewReader(fmt.Sprintf("GET /%d HTTP/1.1\r\n\r\n", i))))
+	}
+
+	for i, name := range []string{"GET_request", "GET_request-2"} {
+		content, err := os.ReadFile(filepath.Join(logDir, name+".bin"))
+		if err != nil {
+			t.Fatalf("Expected capture %s: %v", name, err)
+		}
+		if want := fmt.Sprintf("GET /%d ", i); !strings.HasPrefix(string(content), want) {
+			t.Errorf("Expected %s to hold request %d, got %q", name, i, content)
+		}
+		if _, err := os.Stat(filepath.Join(logDir, name+"_metadata.json")); err != nil {
+			t.Errorf("Expected metadata for %s: %v", name, err)
+		}
+	}
+}
+
+func TestFileLoggerIndex(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: logDir, Index: true})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	var expected []FileLogIndexEntry
+	for i, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		timestamp := start.Add(time.Duration(i) * time.Second)
+		metadata := RequestMetadata{
+			ID:             fmt.Sprintf("00000000-0000-0000-0000-00000000000%d", i),
+			Method:         method,
+			SourceURL:      fmt.Sprintf("http://localhost:5601/api/items/%d", i),
+			DestinationURL: fmt.Sprintf("http://backend/items/%d", i),
+		}
+		fileLogger.LogRequest(metadata, timestamp, io.NopCloser(strings.NewReader(method+" /items HTTP/1.1\r\n\r\n")))
+		metadata.ResponseStatusCode = http.StatusOK + i
+		fileLogger.LogResponse(metadata, timestamp, io.NopCloser(strings.NewReader("HTTP/1.1 200 OK\r\n\r\nok")))
+
+		for _, streamType := range []string{"request", "response"} {
+			entry := FileLogIndexEntry{
+				ID:         metadata.ID,
+				StreamType: streamType,
+				Timestamp:  timestamp,
+				Method:     method,
+				URL:        metadata.SourceURL,
+				TargetURL:  metadata.DestinationURL,
+				Filename:   fileLogger.logBaseName(metadata, timestamp, streamType) + ".bin",
+				Completed:  true,
+			}
+			if streamType == "response" {
+				entry.Status = metadata.ResponseStatusCode
+			}
+			expected = append(expected, entry)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(logDir, FileLogIndexName))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d index lines, got %d:\n%s", len(expected), len(lines), content)
+	}
+	for i, line := range lines {
+		var entry FileLogIndexEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to parse index line %d: %v", i, err)
+		}
+		if entry != expected[i] {
+			t.Errorf("Index line %d:\n got %+v\nwant %+v", i, entry, expected[i])
+		}
+		if _, err := os.Stat(filepath.Join(logDir, entry.Filename)); err != nil {
+			t.Errorf("Index line %d points to a missing file: %v", i, err)
+		}
+	}
+}
+
+func TestFileLoggerPurgeBySubject(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: logDir, Index: true})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	files := map[string][]string{}
+	for i, subject := range []string{"alice", "bob", "alice", ""} {
+		timestamp := start.Add(time.Duration(i) * time.Second)
+		metadata := RequestMetadata{
+			ID:        fmt.Sprintf("00000000-0000-0000-0000-00000000000%d", i),
+			Method:    http.MethodPost,
+			SourceURL: "http://localhost:5601/api/items",
+			Subject:   subject,
+		}
+		fileLogger.LogRequest(metadata, timestamp, io.NopCloser(strings.NewReader("POST /items HTTP/1.1\r\n\r\n")))
+		fileLogger.LogResponse(metadata, timestamp, io.NopCloser(strings.NewReader("HTTP/1.1 200 OK\r\n\r\nok")))
+		for _, streamType := range []string{"request", "response"} {
+			baseName := fileLogger.logBaseName(metadata, timestamp, streamType)
+			files[subject] = append(files[subject], baseName+".bin", baseName+"_metadata.json")
+		}
+	}
+
+	purged, err := fileLogger.Purge("alice")
+	if err != nil {
+		t.Fatalf("Purge failed: %v", err)
+	}
+	if purged != 4 {
+		t.Errorf("Expected 4 purged streams, got %d", purged)
+	}
+
+	for subject, names := range files {
+		for _, name := range names {
+			_, err := os.Stat(filepath.Join(logDir, name))
+			if subject == "alice" && !os.IsNotExist(err) {
+				t.Errorf("Expected %s to be purged, stat returned %v", name, err)
+			}
+			if subject != "alice" && err != nil {
+				t.Errorf("Expected %s of subject %q to be kept: %v", name, subject, err)
+			}
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(logDir, FileLogIndexName))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 index lines after purge, got %d:\n%s", len(lines), content)
+	}
+	for i, line := range lines {
+		var entry FileLogIndexEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to parse index line %d: %v", i, err)
+		}
+		if entry.Subject == "alice" {
+			t.Errorf("Index line %d was not purged: %s", i, line)
+		}
+	}
+
+	purged, err = fileLogger.Purge("alice")
+	if err != nil || purged != 0 {
+		t.Errorf("Expected a second purge to delete nothing, got %d, %v", purged, err)
+	}
+	if _, err := fileLogger.Purge(""); err == nil {
+		t.Error("Expected purging an empty subject to fail")
+	}
+}
+
+// logCaptures logs count request/response pairs with consecutive IDs and
+// returns the .bin names of each capture.
+func logCaptures(fileLogger *FileLogger, count int) [][]string {
+	start := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	var captures [][]string
+	for i := 0; i < count; i++ {
+		timestamp := start.Add(time.Duration(i) * time.Second)
+		metadata := RequestMetadata{ID: fmt.Sprintf("capture-%d", i), Method: http.MethodGet, SourceURL: "http://localhost:5601/api/items"}
+		fileLogger.LogRequest(metadata, timestamp, io.NopCloser(strings.NewReader("GET /items HTTP/1.1\r\n\r\n")))
+		fileLogger.LogResponse(metadata, timestamp, io.NopCloser(strings.NewReader("HTTP/1.1 200 OK\r\n\r\nok")))
+		captures = append(captures, []string{
+			fileLogger.logBaseName(metadata, timestamp, "request") + ".bin",
+			fileLogger.logBaseName(metadata, timestamp, "response") + ".bin",
+		})
+	}
+	return captures
+}
+
+func assertCapturesExist(t *testing.T, logDir string, captures [][]string, expected []bool) {
+	t.Helper()
+	for i, names := range captures {
+		for _, name := range names {
+			_, err := os.Stat(filepath.Join(logDir, name))
+			if expected[i] && err != nil {
+				t.Errorf("Expected capture %d file %s to exist: %v", i, name, err)
+			}
+			if !expected[i] && !os.IsNotExist(err) {
+				t.Errorf("Expected capture %d file %s not to exist, stat returned %v", i, name, err)
+			}
+		}
+	}
+}
+
+func TestFileLoggerCapStopsNewCaptures(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: logDir, MaxCaptures: 2})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+
+	captures := logCaptures(fileLogger, 4)
+	assertCapturesExist(t, logDir, captures, []bool{true, true, false, false})
+}
+
+func TestFileLoggerCapEvictsOldestCaptures(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: logDir, MaxCaptures: 2, CapPolicy: LogCapEvict})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+
+	captures := logCaptures(fileLogger, 4)
+	assertCapturesExist(t, logDir, captures, []bool{false, false, true, true})
+	entries, _ := os.ReadDir(logDir)
+	if len(entries) != 8 {
+		t.Errorf("Expected the .bin and metadata files of 2 captures, got %d files", len(entries))
+	}
+}
+
+func TestFileLoggerCapLimitsTotalBytes(t *testing.T) {
+	// Every capture writes 23 + 21 = 44 bytes
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: logDir, MaxBytes: 100, CapPolicy: LogCapEvict})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+	captures := logCaptures(fileLogger, 4)
+	assertCapturesExist(t, logDir, captures, []bool{false, false, true, true})
+
+	logDir = t.TempDir()
+	fileLogger, err = NewFileLoggerWithOptions(FileLoggerOptions{LogDir: logDir, MaxBytes: 88})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+	captures = logCaptures(fileLogger, 4)
+	assertCapturesExist(t, logDir, captures, []bool{true, true, false, false})
+
+	if _, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: t.TempDir(), CapPolicy: "drop"}); err == nil {
+		t.Error("Expected an invalid cap policy to be rejected")
+	}
+}
+
+func TestFileLoggerCapCountsEncryptedFilesAndIndexesEvictions(t *testing.T) {
+	// Encrypted, every capture takes more than half of the 100 bytes on disk,
+	// although its 44 plaintext bytes would fit twice
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir:        logDir,
+		MaxBytes:      100,
+		CapPolicy:     LogCapEvict,
+		Index:         true,
+		EncryptionKey: bytes.Repeat([]byte{1}, 32),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+	captures := logCaptures(fileLogger, 4)
+	assertCapturesExist(t, logDir, captures, []bool{false, false, false, true})
+
+	content, err := os.ReadFile(filepath.Join(logDir, FileLogIndexName))
+	if err != nil {
+		t.Fatalf("Failed to read index: %v", err)
+	}
+	var evicted []string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var entry FileLogIndexEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Failed to parse index line %q: %v", line, err)
+		}
+		if entry.Evicted {
+			evicted = append(evicted, entry.ID)
+		}
+	}
+	if strings.Join(evicted, ",") != "capture-0,capture-1,capture-2" {
+		t.Errorf("Expected the evictions to be marked in the index, got %v", evicted)
+	}
+}
+
+func TestRenderFilenameSanitizesOutput(t *testing.T) {
+	tmpl, err := parseFilenameTemplate("{{.Pattern}}/../{{.Method}} {{.DestinationURL}}")
+	if err != nil {
+		t.Fatalf("Failed to parse template: %v", err)
+	}
+
+	name, err := renderFilename(tmpl, LogFilenameData{RequestMetadata: RequestMetadata{
+		Pattern:        "/v1/chat/",
+		Method:         "GET",
+		DestinationURL: "http://host:80/x?y=<z>",
+	}})
+	if err != nil {
+		t.Fatalf("Failed to render filename: %v", err)
+	}
+	if name != "v1/chat/GET_http/host_80/x_y_z" {
+		t.Fatalf("Unexpected sanitized filename %q", name)
+	}
+}
+
+func TestFileLoggerRejectsInvalidFilenameTemplate(t *testing.T) {
+	if _, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: t.TempDir(), FilenameTemplate: "{{.Method"}); err == nil {
+		t.Fatal("Expected an error for an invalid filename template")
+	}
+}
@@ -0,0 +1,228 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// FilterFunc decides whether a request and its response are logged. It is
+// called when the response log arrives, with the response's metadata, so
+// metadata.ResponseStatusCode is set and a filter such as
+// metadata.ResponseStatusCode >= 500 works. request is parsed from the head
+// of the logged request stream: its URL is the destination URL and its
+// headers are the logged headers. The body is not read and is always empty;
+// metadata.SourceURL holds the URL the client used.
+type FilterFunc func(metadata RequestMetadata, request *http.Request) bool
+
+// DefaultFilterMaxRequestBody is the default FilterLogger.MaxRequestBody.
+const DefaultFilterMaxRequestBody = 1 << 20
+
+// filterDecisionWait bounds how long a response log waits for its request
+// log, which may have been dropped.
+const filterDecisionWait = 5 * time.Second
+
+// filterDecisionTTL is how long a request log is held for a response log
+// that never arrives.
+const filterDecisionTTL = 10 * time.Minute
+
+// FilterLogger forwards the requests accepted by Filter, and their responses,
+// to the wrapped logger and discards everything else. A request log is held
+// until its response log arrives, and the decision is made then, so a
+// request and its response are always logged together and the filter can
+// use the response status. Held request bodies longer than MaxRequestBody are
+// logged truncated, with an "X-Logged-Body: truncated" line after them. A
+// response whose request log does not arrive within a few seconds is decided
+// without the request's headers and logged alone, and the request is
+// discarded if it arrives later. Requests that never get a response log, such
+// as CONNECT tunnels or requests refused before they were sent, are not
+// logged.
+type FilterLogger struct {
+	Logger Logger
+	Filter FilterFunc
+	// MaxRequestBody caps how much of each request body is held until its
+	// response arrives. Zero means DefaultFilterMaxRequestBody.
+	MaxRequestBody int64
+
+	mu      sync.Mutex
+	pending map[string]*filterPending
+	swept   time.Time
+}
+
+// filterPending pairs a request log with its response log.
+type filterPending struct {
+	// arrived is closed once the request log was held or the response log
+	// gave up waiting for it, in which case request is nil
+	arrived   chan struct{}
+	request   *filteredRequest
+	arrivedAt time.Time
+}
+
+// filteredRequest is a request log held until its response log arrives.
+type filteredRequest struct {
+	metadata   RequestMetadata
+	timestamp  time.Time
+	request    *http.Request
+	transcript []byte
+	// err is the error the request stream failed with, if any
+	err error
+}
+
+// NewFilterLogger wraps logger so that only requests accepted by filter are
+// logged.
+func NewFilterLogger(logger Logger, filter FilterFunc) *FilterLogger {
+	return &FilterLogger{Logger: logger, Filter: filter}
+}
+
+// LogRequest holds the request until its response log arrives
+func (l *FilterLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	defer rawRequestStream.Close()
+	reader := bufio.NewReader(rawRequestStream)
+	head, _, err := readStreamHead(reader)
+	held := &filteredRequest{metadata: metadata, timestamp: timestamp, transcript: head}
+
+	request, parseErr := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if parseErr != nil {
+		request = filterRequest(metadata)
+	}
+	request.Body = http.NoBody
+	held.request = request
+
+	if err == nil {
+		var body []byte
+		maxBody := l.MaxRequestBody
+		if maxBody <= 0 {
+			maxBody = DefaultFilterMaxRequestBody
+		}
+		body, err = io.ReadAll(BodyCaptureTruncated(maxBody).captureBody(reader))
+		held.transcript = append(held.transcript, body...)
+	}
+	held.err = err
+	l.hold(metadata.ID, held)
+}
+
+// LogResponse decides whether the request is logged and forwards the held
+// request and the response stream if it is
+func (l *FilterLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	held := l.await(metadata.ID)
+	request := filterRequest(metadata)
+	if held != nil {
+		request = held.request
+	}
+	if !l.Filter(metadata, request) {
+		discardStream(rawResponseStream)
+		return
+	}
+	if held != nil {
+		l.Logger.LogRequest(held.metadata, held.timestamp, &readCloser{
+			Reader: failedAfter(held.transcript, held.err),
+			Closer: io.NopCloser(nil),
+		})
+	}
+	l.Logger.LogResponse(metadata, timestamp, rawResponseStream)
+}
+
+// LogConnect forwards CONNECT events accepted by Filter if the wrapped logger
+// supports them. The request has the CONNECT method and no headers.
+func (l *FilterLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	connectLogger, ok := l.Logger.(ConnectLogger)
+	if !ok || !l.Filter(metadata, filterRequest(metadata)) {
+		return
+	}
+	connectLogger.LogConnect(metadata, timestamp)
+}
+
+// Close closes the wrapped logger if it implements io.Closer.
+func (l *FilterLogger) Close() error {
+	return closeWrapped(l.Logger)
+}
+
+// filterRequest is the request passed to Filter when there is no logged
+// request head to parse.
+func filterRequest(metadata RequestMetadata) *http.Request {
+	request := &http.Request{Method: metadata.Method, Header: http.Header{}, Body: http.NoBody}
+	request.URL, _ = url.Parse(metadata.DestinationURL)
+	if request.URL == nil {
+		request.URL = &url.URL{}
+	}
+	request.Host = request.URL.Host
+	return request
+}
+
+// hold keeps request for the response log of id, unless it already gave up
+// waiting for it.
+func (l *FilterLogger) hold(id string, request *filteredRequest) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pending := l.pendingLocked(id)
+	l.sweepLocked()
+	select {
+	case <-pending.arrived:
+		// The response was already logged without it
+		delete(l.pending, id)
+		return
+	default:
+	}
+	pending.request = request
+	pending.arrivedAt = time.Now()
+	close(pending.arrived)
+}
+
+// await returns the request held for id, or nil if there is none within
+// filterDecisionWait.
+func (l *FilterLogger) await(id string) *filteredRequest {
+	l.mu.Lock()
+	pending := l.pendingLocked(id)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(filterDecisionWait)
+	defer timer.Stop()
+	select {
+	case <-pending.arrived:
+	case <-timer.C:
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-pending.arrived:
+		delete(l.pending, id)
+		return pending.request
+	default:
+	}
+	// Leave the entry so that a late request log is discarded
+	pending.arrivedAt = time.Now()
+	close(pending.arrived)
+	return nil
+}
+
+func (l *FilterLogger) pendingLocked(id string) *filterPending {
+	if l.pending == nil {
+		l.pending = map[string]*filterPending{}
+	}
+	pending, ok := l.pending[id]
+	if !ok {
+		pending = &filterPending{arrived: make(chan struct{})}
+		l.pending[id] = pending
+	}
+	return pending
+}
+
+// sweepLocked drops requests whose response never arrived, and the marks of
+// responses whose request never arrived, at most once per minute.
+func (l *FilterLogger) sweepLocked() {
+	now := time.Now()
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+	for id, pending := range l.pending {
+		if !pending.arrivedAt.IsZero() && now.Sub(pending.arrivedAt) > filterDecisionTTL {
+			delete(l.pending, id)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilterLoggerLogsOnlyMatchingRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "response "+r.URL.Path)
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	filterLogger := NewFilterLogger(testLogger, func(metadata RequestMetadata, request *http.Request) bool {
+		return request.Header.Get("X-Debug") == "1"
+	})
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", filterLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for _, path := range []string{"plain", "debug", "other"} {
+		request, _ := http.NewRequest(http.MethodPost, testServer.URL+"/api/"+path, strings.NewReader("body "+path))
+		if path == "debug" {
+			request.Header.Set("X-Debug", "1")
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "response /"+path {
+			t.Errorf("Expected every request to be proxied, got %q", body)
+		}
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected only the debug request to be logged, got %d requests and %d responses", len(testLogger.requests), len(testLogger.responses))
+	}
+	request, response := testLogger.requests[0], testLogger.responses[0]
+	if !strings.Contains(request.content, "X-Debug: 1") || !strings.HasSuffix(request.content, "body debug") {
+		t.Errorf("Expected the whole debug request in the log, got %q", request.content)
+	}
+	if response.metadata.ID != request.metadata.ID || !strings.HasSuffix(response.content, "response /debug") {
+		t.Errorf("Expected the response of the debug request, got %q for %s", response.content, response.metadata.ID)
+	}
+
+	// A response log that starts first waits for its request
+	pairLogger := &TestLogger{}
+	filterLogger = NewFilterLogger(pairLogger, func(metadata RequestMetadata, request *http.Request) bool {
+		return strings.HasSuffix(request.URL.Path, "/chat")
+	})
+	responseDone := make(chan struct{})
+	go func() {
+		defer close(responseDone)
+		filterLogger.LogResponse(RequestMetadata{ID: "chat"}, time.Now(), io.NopCloser(strings.NewReader("HTTP/1.1 200 OK\r\n\r\nok")))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	filterLogger.LogRequest(RequestMetadata{ID: "chat"}, time.Now(), io.NopCloser(strings.NewReader("POST http://backend/v1/chat HTTP/1.1\r\n\r\n")))
+	<-responseDone
+	if len(pairLogger.requests) != 1 || len(pairLogger.responses) != 1 {
+		t.Errorf("Expected the request and its earlier response to be logged, got %d and %d", len(pairLogger.requests), len(pairLogger.responses))
+	}
+	if len(filterLogger.pending) != 0 {
+		t.Errorf("Expected held requests to be forgotten once both halves are logged, got %d", len(filterLogger.pending))
+	}
+}
+
+func TestFilterLoggerDecidesOnTheResponseStatus(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		io.WriteString(w, "response "+r.URL.Path)
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	filterLogger := NewFilterLogger(testLogger, func(metadata RequestMetadata, request *http.Request) bool {
+		return metadata.ResponseStatusCode >= 500
+	})
+	filterLogger.MaxRequestBody = 8
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", filterLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for _, path := range []string{"ok", "fail"} {
+		resp, err := http.Post(testServer.URL+"/api/"+path, "text/plain", strings.NewReader("request body "+path))
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		// Log sequentially, the TestLogger is not safe for concurrent use
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected only the failed request to be logged, got %d requests and %d responses", len(testLogger.requests), len(testLogger.responses))
+	}
+	request, response := testLogger.requests[0], testLogger.responses[0]
+	if !strings.HasSuffix(request.content, "\r\n\r\nrequest \r\nX-Logged-Body: truncated; size=17; logged=8\r\n") {
+		t.Errorf("Expected the held request body to be truncated, got %q", request.content)
+	}
+	if response.metadata.ResponseStatusCode != http.StatusInternalServerError || !strings.HasSuffix(response.content, "response /fail") {
+		t.Errorf("Expected the failed response, got %q", response.content)
+	}
+}
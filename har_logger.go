@@ -0,0 +1,512 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HARLogger collects completed request/response pairs, matched by request ID,
+// and writes them to Path as an HTTP Archive (HAR 1.2) file that browser
+// devtools and other analysis tools can open. Text bodies are stored as-is and
+// binary bodies are base64 encoded. The file is rewritten every FlushEvery
+// completed entries and on Close. Once it holds MaxEntries entries it is
+// renamed with a timestamp and a new archive is started, so neither memory
+// nor the cost of rewriting grows without bound.
+type HARLogger struct {
+	// Logger optionally receives every stream as well.
+	Logger Logger
+
+	Path string
+
+	// FlushEvery rewrites the file after this many new entries. Zero only
+	// writes the file on Close and when it is rotated.
+	FlushEvery int
+
+	// MaxEntries is the number of entries after which the file is rotated.
+	// Zero uses DefaultHARMaxEntries.
+	MaxEntries int
+
+	// MaxBodySize is the largest request or response body kept in an entry,
+	// in bytes. Longer bodies are truncated and marked in a comment. Zero uses
+	// DefaultHARMaxBodySize.
+	MaxBodySize int
+
+	// PendingTimeout drops exchanges still missing their request or response
+	// after this long, such as those whose log was abandoned. Zero uses
+	// DefaultHARPendingTimeout.
+	PendingTimeout time.Duration
+
+	// Clock timestamps response completion. Nil uses the wall clock.
+	Clock Clock
+
+	// LogLevel filters the logger's own messages, such as write failures.
+	LogLevel LogLevel
+
+	mu        sync.Mutex
+	pending   map[string]*harPending
+	nextSweep time.Time
+	entries   []HAREntry
+	unflushed int
+}
+
+// Defaults for HARLogger.
+const (
+	DefaultHARMaxEntries     = 1000
+	DefaultHARMaxBodySize    = 1 << 20
+	DefaultHARPendingTimeout = 5 * time.Minute
+)
+
+// harMaxHeadSize is captured in addition to MaxBodySize for the start line
+// and headers of a stream.
+const harMaxHeadSize = 64 << 10
+
+// NewHARLogger creates a HAR logger writing to path. Logger may be nil.
+func NewHARLogger(path string, flushEvery int, logger Logger) *HARLogger {
+	return &HARLogger{
+		Logger:     logger,
+		Path:       path,
+		FlushEvery: flushEvery,
+	}
+}
+
+// HAR is the root of an HTTP Archive file.
+type HAR struct {
+	Log HARLog `json:"log"`
+}
+
+// HARLog holds the entries of an HTTP Archive.
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	Entries []HAREntry `json:"entries"`
+}
+
+// HARCreator identifies the application that wrote the archive.
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is a single request/response exchange.
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+}
+
+// HARRequest describes the request sent upstream.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse describes the response received from upstream.
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARNameValue `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARNameValue is a header, cookie or query parameter.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARPostData is a logged request body.
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// Encoding is "base64" for binary bodies. It is not part of HAR 1.2 for
+	// postData but is understood by common HAR tools.
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARContent is a logged response body.
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// HARTimings splits the entry time into phases, in milliseconds. Phases the
+// proxy cannot observe are -1.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+type harPending struct {
+	request  *HARRequest
+	response *HARResponse
+	// metadata of the response, which includes upstream timings
+	metadata    RequestMetadata
+	completedAt time.Time
+	// created is when the first half was logged
+	created time.Time
+}
+
+// LogRequest records the request half of an entry and forwards the stream
+func (l *HARLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	raw := l.capture(rawRequestStream, forwardTo(l.Logger, "request", metadata, timestamp))
+	request, err := parseHARRequest(raw, metadata, l.maxBodySize())
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pending := l.pendingEntry(metadata.ID)
+	pending.request = request
+	l.complete(metadata.ID, pending)
+}
+
+// LogResponse records the response half of an entry and forwards the stream
+func (l *HARLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	raw := l.capture(rawResponseStream, forwardTo(l.Logger, "response", metadata, timestamp))
+	completedAt := clockOrReal(l.Clock).Now()
+	response, err := parseHARResponse(raw, l.maxBodySize())
+	if err != nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	pending := l.pendingEntry(metadata.ID)
+	pending.response = response
+	pending.metadata = metadata
+	pending.completedAt = completedAt
+	l.complete(metadata.ID, pending)
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *HARLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(l.Logger, metadata, timestamp)
+}
+
+// Flush writes all completed entries to Path.
+func (l *HARLogger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.flushLocked()
+}
+
+// Close writes the HAR file and closes the wrapped logger if it implements
+// io.Closer. Exchanges that are still missing their request or response are
+// not written.
+func (l *HARLogger) Close() error {
+	err := l.Flush()
+	if closeErr := closeWrapped(l.Logger); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// capture passes stream to forward, if set, and returns
+// the start of the stream, up to the head and MaxBodySize bytes of body. The
+// stream is always read to the end.
+func (l *HARLogger) capture(stream io.ReadCloser, forward func(io.ReadCloser)) *cappedBuffer {
+	return captureStream(stream, harMaxHeadSize+l.maxBodySize(), forward)
+}
+
+func (l *HARLogger) maxBodySize() int {
+	if l.MaxBodySize > 0 {
+		return l.MaxBodySize
+	}
+	return DefaultHARMaxBodySize
+}
+
+func (l *HARLogger) pendingEntry(id string) *harPending {
+	if l.pending == nil {
+		l.pending = map[string]*harPending{}
+	}
+	pending, ok := l.pending[id]
+	if !ok {
+		now := clockOrReal(l.Clock).Now()
+		l.expirePending(now)
+		pending = &harPending{created: now}
+		l.pending[id] = pending
+	}
+	return pending
+}
+
+// expirePending drops the exchanges that waited longer than PendingTimeout
+// for their other half. It only scans the pending exchanges once per half
+// timeout.
+func (l *HARLogger) expirePending(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	timeout := l.PendingTimeout
+	if timeout <= 0 {
+		timeout = DefaultHARPendingTimeout
+	}
+	l.nextSweep = now.Add(timeout / 2)
+	for id, pending := range l.pending {
+		if now.Sub(pending.created) > timeout {
+			delete(l.pending, id)
+		}
+	}
+}
+
+func (l *HARLogger) complete(id string, pending *harPending) {
+	if pending.request == nil || pending.response == nil {
+		return
+	}
+	delete(l.pending, id)
+	l.entries = append(l.entries, newHAREntry(pending))
+	l.unflushed++
+
+	maxEntries := l.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultHARMaxEntries
+	}
+	if len(l.entries) >= maxEntries {
+		if err := l.rotateLocked(); err != nil {
+			levelLogger{level: l.LogLevel}.Errorf("Failed to rotate HAR file %s: %v", l.Path, err)
+		}
+		return
+	}
+	if l.FlushEvery > 0 && l.unflushed >= l.FlushEvery {
+		if err := l.flushLocked(); err != nil {
+			levelLogger{level: l.LogLevel}.Errorf("Failed to flush HAR file %s: %v", l.Path, err)
+		}
+	}
+}
+
+// rotateLocked writes the full archive, renames it with a timestamp, such as
+// traffic-20260102T030405.000.har, and starts a new one. The entries are
+// dropped even if writing fails, to keep memory bounded.
+func (l *HARLogger) rotateLocked() error {
+	defer func() {
+		l.entries = nil
+		l.unflushed = 0
+	}()
+	if err := l.flushLocked(); err != nil {
+		return err
+	}
+	extension := filepath.Ext(l.Path)
+	stamp := clockOrReal(l.Clock).Now().UTC().Format("20060102T150405.000")
+	rotated := strings.TrimSuffix(l.Path, extension) + "-" + stamp + extension
+	if err := os.Rename(l.Path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate HAR file: %w", err)
+	}
+	return nil
+}
+
+func (l *HARLogger) flushLocked() error {
+	entries := l.entries
+	if entries == nil {
+		entries = []HAREntry{}
+	}
+	data, err := json.MarshalIndent(HAR{Log: HARLog{
+		Version: "1.2",
+		Creator: HARCreator{Name: "logging-proxy", Version: "1.0"},
+		Entries: entries,
+	}}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so readers never see a partial archive
+	tempPath := l.Path + ".tmp"
+	if dir := filepath.Dir(l.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create HAR directory: %w", err)
+		}
+	}
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write HAR file: %w", err)
+	}
+	if err := os.Rename(tempPath, l.Path); err != nil {
+		return fmt.Errorf("failed to write HAR file: %w", err)
+	}
+	l.unflushed = 0
+	return nil
+}
+
+func newHAREntry(pending *harPending) HAREntry {
+	metadata := pending.metadata
+	total := durationMS(pending.completedAt.Sub(metadata.RequestStartedAt))
+	timings := HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1, Wait: total}
+	if metadata.UpstreamResponseAt != nil {
+		timings.Wait = durationMS(metadata.UpstreamResponseAt.Sub(metadata.RequestStartedAt))
+		timings.Receive = durationMS(pending.completedAt.Sub(*metadata.UpstreamResponseAt))
+	}
+
+	entry := HAREntry{
+		StartedDateTime: metadata.RequestStartedAt,
+		Time:            timings.Send + timings.Wait + timings.Receive,
+		Request:         *pending.request,
+		Response:        *pending.response,
+		Timings:         timings,
+		Comment:         metadata.ID,
+	}
+	if metadata.FinalURL != "" {
+		entry.Request.URL = metadata.FinalURL
+	}
+	return entry
+}
+
+func durationMS(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// harBody returns the part of body that is kept in an entry, the size of the
+// whole body, of which omitted bytes were not captured, and a comment if it
+// was truncated.
+func harBody(body []byte, omitted int64, maxBody int) ([]byte, int, string) {
+	size := len(body) + int(omitted)
+	if size <= maxBody {
+		return body, size, ""
+	}
+	body = body[:min(len(body), maxBody)]
+	return body, size, fmt.Sprintf("body truncated to %d of %d bytes", len(body), size)
+}
+
+func parseHARRequest(raw *cappedBuffer, metadata RequestMetadata, maxBody int) (*HARRequest, error) {
+	transcript, err := ParseTranscript(bytes.NewReader(raw.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	if transcript.IsResponse {
+		return nil, fmt.Errorf("expected a request, got %q", transcript.StartLine)
+	}
+
+	body, bodySize, comment := harBody(transcript.Body, raw.size-int64(raw.Len()), maxBody)
+	request := &HARRequest{
+		Method:      transcript.Method,
+		URL:         transcript.URL,
+		HTTPVersion: transcript.Proto,
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(transcript.Header),
+		QueryString: []HARNameValue{},
+		HeadersSize: -1,
+		BodySize:    bodySize,
+	}
+	if !strings.Contains(request.URL, "://") && metadata.DestinationURL != "" {
+		request.URL = metadata.DestinationURL
+	}
+	if requestURL, err := url.Parse(request.URL); err == nil {
+		request.QueryString = harQueryString(requestURL.Query())
+	}
+	if bodySize > 0 {
+		text, encoding := harBodyText(body)
+		request.PostData = &HARPostData{
+			MimeType: transcript.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		}
+	}
+	return request, nil
+}
+
+func parseHARResponse(raw *cappedBuffer, maxBody int) (*HARResponse, error) {
+	transcript, err := ParseTranscript(bytes.NewReader(raw.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+	if !transcript.IsResponse {
+		return nil, fmt.Errorf("expected a response, got %q", transcript.StartLine)
+	}
+
+	_, statusText, _ := strings.Cut(transcript.Status, " ")
+	body, bodySize, comment := harBody(transcript.Body, raw.size-int64(raw.Len()), maxBody)
+	text, encoding := harBodyText(body)
+	return &HARResponse{
+		Status:      transcript.StatusCode,
+		StatusText:  statusText,
+		HTTPVersion: transcript.Proto,
+		Cookies:     []HARNameValue{},
+		Headers:     harHeaders(transcript.Header),
+		Content: HARContent{
+			Size:     bodySize,
+			MimeType: transcript.Header.Get("Content-Type"),
+			Text:     text,
+			Encoding: encoding,
+			Comment:  comment,
+		},
+		RedirectURL: transcript.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    bodySize,
+	}, nil
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	headers := []HARNameValue{}
+	for _, name := range names {
+		for _, value := range header[name] {
+			headers = append(headers, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+func harQueryString(query url.Values) []HARNameValue {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	params := []HARNameValue{}
+	for _, name := range names {
+		for _, value := range query[name] {
+			params = append(params, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return params
+}
+
+// harBodyText returns body as text, or base64 encoded with encoding "base64"
+// if it is not valid UTF-8 text.
+func harBodyText(body []byte) (string, string) {
+	if utf8.Valid(body) && !bytes.ContainsRune(body, 0) {
+		return string(body), ""
+	}
+	return base64.StdEncoding.EncodeToString(body), "base64"
+}
@@ -0,0 +1,173 @@
+package loggingproxy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHARLoggerWritesProxiedExchanges(t *testing.T) {
+	binaryBody := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/image" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(binaryBody)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"ok":true}`)
+	}))
+	defer backend.Close()
+
+	harPath := filepath.Join(t.TempDir(), "traffic.har")
+	testLogger := &TestLogger{}
+	harLogger := NewHARLogger(harPath, 0, testLogger)
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", harLogger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Post(testServer.URL+"/api/chat?model=test", "application/json", strings.NewReader(`{"prompt":"hi"}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	status, _ := getStatusAndBody(t, testServer.URL+"/api/image")
+	if status != http.StatusOK {
+		t.Fatalf("expected image request to succeed, got %d", status)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if err := harLogger.Close(); err != nil {
+		t.Fatalf("failed to close HAR logger: %v", err)
+	}
+
+	data, err := os.ReadFile(harPath)
+	if err != nil {
+		t.Fatalf("failed to read HAR file: %v", err)
+	}
+	var har HAR
+	if err := json.Unmarshal(data, &har); err != nil {
+		t.Fatalf("HAR file is not valid JSON: %v", err)
+	}
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 2 {
+		t.Fatalf("expected a HAR 1.2 log with 2 entries, got version %q with %d entries", har.Log.Version, len(har.Log.Entries))
+	}
+
+	entries := map[string]HAREntry{}
+	for _, entry := range har.Log.Entries {
+		entries[entry.Request.Method] = entry
+	}
+	post := entries["POST"]
+	if post.Request.URL != backend.URL+"/chat?model=test" {
+		t.Errorf("expected the upstream URL, got %q", post.Request.URL)
+	}
+	if len(post.Request.QueryString) != 1 || post.Request.QueryString[0] != (HARNameValue{Name: "model", Value: "test"}) {
+		t.Errorf("expected the query string to be parsed, got %+v", post.Request.QueryString)
+	}
+	if post.Request.PostData == nil || post.Request.PostData.Text != `{"prompt":"hi"}` || post.Request.PostData.Encoding != "" {
+		t.Errorf("expected a text request body, got %+v", post.Request.PostData)
+	}
+	if post.Response.Status != http.StatusOK || post.Response.Content.Text != `{"ok":true}` || post.Response.Content.MimeType != "application/json" {
+		t.Errorf("expected a text JSON response, got %+v", post.Response)
+	}
+	if post.Timings.Wait < 0 || post.Timings.Receive < 0 || post.StartedDateTime.IsZero() {
+		t.Errorf("expected entry timings, got %+v at %v", post.Timings, post.StartedDateTime)
+	}
+
+	image := entries["GET"].Response.Content
+	if image.Encoding != "base64" || image.Text != base64.StdEncoding.EncodeToString(binaryBody) || image.Size != len(binaryBody) {
+		t.Errorf("expected a base64 encoded binary body, got %+v", image)
+	}
+
+	if len(testLogger.requests) != 2 || len(testLogger.responses) != 2 {
+		t.Errorf("expected the wrapped logger to receive both exchanges, got %d requests and %d responses",
+			len(testLogger.requests), len(testLogger.responses))
+	}
+}
+
+func TestHARLoggerFlushesEveryNEntries(t *testing.T) {
+	harPath := filepath.Join(t.TempDir(), "traffic.har")
+	harLogger := NewHARLogger(harPath, 2, nil)
+
+	logExchange := func(id string) {
+		metadata := RequestMetadata{ID: id, DestinationURL: "http://backend/" + id, RequestStartedAt: time.Now()}
+		harLogger.LogRequest(metadata, time.Now(), io.NopCloser(strings.NewReader("GET http://backend/"+id+" HTTP/1.1\r\n\r\n")))
+		harLogger.LogResponse(metadata, time.Now(), io.NopCloser(strings.NewReader("HTTP/1.1 204 No Content\r\n\r\n")))
+	}
+
+	logExchange("first")
+	if _, err := os.Stat(harPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no HAR file before FlushEvery entries, got %v", err)
+	}
+	logExchange("second")
+	data, err := os.ReadFile(harPath)
+	if err != nil {
+		t.Fatalf("expected HAR file after FlushEvery entries: %v", err)
+	}
+	var har HAR
+	if err := json.Unmarshal(data, &har); err != nil || len(har.Log.Entries) != 2 {
+		t.Fatalf("expected 2 flushed entries, got %d (%v)", len(har.Log.Entries), err)
+	}
+}
+
+func TestHARLoggerBoundsEntriesBodiesAndPendingExchanges(t *testing.T) {
+	dir := t.TempDir()
+	harPath := filepath.Join(dir, "traffic.har")
+	clock := &fakeClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	harLogger := NewHARLogger(harPath, 0, nil)
+	harLogger.MaxEntries = 2
+	harLogger.MaxBodySize = 8
+	harLogger.PendingTimeout = time.Minute
+	harLogger.Clock = clock
+
+	logExchange := func(id, body string) {
+		metadata := RequestMetadata{ID: id, DestinationURL: "http://backend/" + id, RequestStartedAt: clock.now}
+		harLogger.LogRequest(metadata, clock.now, io.NopCloser(strings.NewReader("POST http://backend/"+id+" HTTP/1.1\r\n\r\n"+body)))
+		harLogger.LogResponse(metadata, clock.now, io.NopCloser(strings.NewReader("HTTP/1.1 200 OK\r\n\r\n"+body)))
+	}
+
+	// The second entry fills the archive, which is then rotated
+	logExchange("first", "short")
+	logExchange("second", strings.Repeat("x", 100))
+	rotated := filepath.Join(dir, "traffic-20260102T030405.000.har")
+	data, err := os.ReadFile(rotated)
+	if err != nil {
+		t.Fatalf("expected a rotated HAR file: %v", err)
+	}
+	var har HAR
+	if err := json.Unmarshal(data, &har); err != nil || len(har.Log.Entries) != 2 {
+		t.Fatalf("expected 2 entries in the rotated file, got %d (%v)", len(har.Log.Entries), err)
+	}
+	response := har.Log.Entries[1].Response
+	if response.Content.Text != "xxxxxxxx" || response.Content.Size != 100 || response.BodySize != 100 ||
+		response.Content.Comment != "body truncated to 8 of 100 bytes" {
+		t.Errorf("expected the response body truncated to 8 bytes, got %+v", response.Content)
+	}
+	if postData := har.Log.Entries[1].Request.PostData; postData == nil || postData.Text != "xxxxxxxx" || postData.Comment == "" {
+		t.Errorf("expected the request body truncated to 8 bytes, got %+v", postData)
+	}
+	if len(harLogger.entries) != 0 {
+		t.Errorf("expected rotation to start a new archive, got %d entries", len(harLogger.entries))
+	}
+
+	// A request whose response never arrives is eventually forgotten
+	harLogger.LogRequest(RequestMetadata{ID: "lost"}, clock.now, io.NopCloser(strings.NewReader("GET http://backend/lost HTTP/1.1\r\n\r\n")))
+	clock.now = clock.now.Add(2 * time.Minute)
+	logExchange("third", "ok")
+	if _, ok := harLogger.pending["lost"]; ok || len(harLogger.pending) != 0 {
+		t.Errorf("expected the abandoned exchange to expire, got %d pending", len(harLogger.pending))
+	}
+}
@@ -0,0 +1,47 @@
+package loggingproxy
+
+import (
+	"net/http"
+	"net/textproto"
+)
+
+// conventionalHeaderNames are the headers whose usual spelling differs from
+// textproto.CanonicalMIMEHeaderKey, which capitalizes only the first letter
+// of each word. TE is left out: it is a hop-by-hop header that the HTTP/2
+// transport only recognizes as "Te".
+var conventionalHeaderNames = map[string]string{
+	"Content-Id":             "Content-ID",
+	"Content-Md5":            "Content-MD5",
+	"Dnt":                    "DNT",
+	"Etag":                   "ETag",
+	"Expect-Ct":              "Expect-CT",
+	"Www-Authenticate":       "WWW-Authenticate",
+	"X-Dns-Prefetch-Control": "X-DNS-Prefetch-Control",
+	"X-Ua-Compatible":        "X-UA-Compatible",
+	"X-Xss-Protection":       "X-XSS-Protection",
+}
+
+// canonicalHeaderName returns name in canonical form, using the usual
+// spelling of the headers in conventionalHeaderNames. Names that are not
+// valid header field names are returned unchanged.
+func canonicalHeaderName(name string) string {
+	name = textproto.CanonicalMIMEHeaderKey(name)
+	if conventional, ok := conventionalHeaderNames[name]; ok {
+		return conventional
+	}
+	return name
+}
+
+// canonicalizeHeader renames the headers in header to their canonical names
+// for RouteOptions.CanonicalHeaders. header.Get does not find the renamed
+// conventional spellings, so this is done once nothing reads them anymore.
+func canonicalizeHeader(header http.Header) {
+	for name, values := range header {
+		canonical := canonicalHeaderName(name)
+		if canonical == name {
+			continue
+		}
+		delete(header, name)
+		header[canonical] = append(header[canonical], values...)
+	}
+}
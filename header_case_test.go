@@ -0,0 +1,102 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rawExchange sends request over a new connection to addr and returns the
+// raw response.
+func rawExchange(t *testing.T, addr, request string) string {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal("Failed to connect:", err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, request); err != nil {
+		t.Fatal("Failed to write request:", err)
+	}
+	response, _ := io.ReadAll(conn)
+	return string(response)
+}
+
+func TestCanonicalHeadersRenamesForwardedAndLoggedHeaders(t *testing.T) {
+	// A raw backend, so that header names are seen and sent exactly as written
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	defer listener.Close()
+	forwarded := make(chan string, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			var head strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				head.WriteString(line)
+				if err != nil || line == "\r\n" {
+					break
+				}
+			}
+			forwarded <- head.String()
+			io.WriteString(conn, "HTTP/1.1 401 Unauthorized\r\ncontent-type: text/plain\r\nwww-authenticate: Bearer\r\netag: \"v1\"\r\ncontent-length: 2\r\nconnection: close\r\n\r\nno")
+			conn.Close()
+		}
+	}()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	backendURL := fmt.Sprintf("http://%s/", listener.Addr())
+	if err := proxyServer.AddRouteWithOptions("/api/", backendURL, testLogger, RouteOptions{CanonicalHeaders: true}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	if err := proxyServer.AddRoute("/plain/", backendURL, &NoOpLogger{}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+	proxyAddr := testServer.Listener.Addr().String()
+
+	response := rawExchange(t, proxyAddr, "GET /api/x HTTP/1.1\r\nHost: proxy\r\ndnt: 1\r\nConnection: close\r\n\r\n")
+	for _, line := range []string{"Content-Type: text/plain", "WWW-Authenticate: Bearer", `ETag: "v1"`} {
+		if !strings.Contains(response, "\r\n"+line+"\r\n") {
+			t.Errorf("Expected the client to receive %q, got:\n%s", line, response)
+		}
+	}
+	if head := <-forwarded; !strings.Contains(head, "\r\nDNT: 1\r\n") {
+		t.Errorf("Expected DNT to be forwarded as DNT, got:\n%s", head)
+	}
+
+	// Without the option the names keep Go's canonical form
+	response = rawExchange(t, proxyAddr, "GET /plain/x HTTP/1.1\r\nHost: proxy\r\nConnection: close\r\n\r\n")
+	<-forwarded
+	if !strings.Contains(response, "\r\nContent-Type: text/plain\r\n") || !strings.Contains(response, "\r\nWww-Authenticate: Bearer\r\n") {
+		t.Errorf("Expected canonical names without conventional spellings, got:\n%s", response)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 request and 1 response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	if !strings.Contains(testLogger.requests[0].content, "\r\nDNT: 1\r\n") {
+		t.Errorf("Expected DNT in the request log, got:\n%s", testLogger.requests[0].content)
+	}
+	for _, line := range []string{"Content-Type: text/plain", "WWW-Authenticate: Bearer", `ETag: "v1"`} {
+		if !strings.Contains(testLogger.responses[0].content, "\r\n"+line+"\r\n") {
+			t.Errorf("Expected %q in the response log, got:\n%s", line, testLogger.responses[0].content)
+		}
+	}
+}
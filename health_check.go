@@ -0,0 +1,100 @@
+package loggingproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultHealthCheckInterval and DefaultHealthCheckTimeout are used when the
+// fields of a HealthCheck are not positive.
+const (
+	DefaultHealthCheckInterval = 10 * time.Second
+	DefaultHealthCheckTimeout  = 2 * time.Second
+)
+
+// HealthCheck actively probes the backends of a route. A backend whose probe
+// fails, or answers with a status outside 200-399, is taken out of rotation
+// until a probe succeeds again.
+type HealthCheck struct {
+	// Path is requested with GET from every backend. It is resolved against
+	// the backend destination like a link, so "/healthz" is below the host
+	// and "healthz" below the destination path.
+	Path string
+	// Interval is the time between probes of a backend.
+	Interval time.Duration
+	// Timeout bounds a single probe.
+	Timeout time.Duration
+}
+
+// startHealthChecks probes every backend of the balancer, first right away
+// and then every interval, until the returned function is called.
+func (b *loadBalancer) startHealthChecks(check HealthCheck, client *http.Client, pattern string, ops levelLogger) (stop func(), err error) {
+	path, err := url.Parse(check.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid health check path %q: %w", check.Path, err)
+	}
+	if check.Interval <= 0 {
+		check.Interval = DefaultHealthCheckInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = DefaultHealthCheckTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for _, backend := range b.backends {
+		probeURL := backend.destinationURL.ResolveReference(path).String()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(check.Interval)
+			defer ticker.Stop()
+			for {
+				err := probeBackend(ctx, client, probeURL, check.Timeout)
+				if ctx.Err() != nil {
+					return
+				}
+				if b.probed(backend, err == nil) {
+					if err != nil {
+						ops.Infof("[health] %s: backend %s is down: %v", pattern, backend.destination, err)
+					} else {
+						ops.Infof("[health] %s: backend %s is up again", pattern, backend.destination)
+					}
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}, nil
+}
+
+func probeBackend(ctx context.Context, client *http.Client, probeURL string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL, nil)
+	if err != nil {
+		return err
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode > 399 {
+		return fmt.Errorf("health check returned %s", response.Status)
+	}
+	return nil
+}
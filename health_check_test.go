@@ -0,0 +1,96 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckTakesDownBackendsOutOfRotation(t *testing.T) {
+	var firstUp, secondUp atomic.Bool
+	firstUp.Store(true)
+	secondUp.Store(true)
+	newBackend := func(name string, up *atomic.Bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/healthz" && !up.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			io.WriteString(w, name)
+		}))
+	}
+	first := newBackend("first", &firstUp)
+	defer first.Close()
+	second := newBackend("second", &secondUp)
+	defer second.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	defer proxyServer.Close()
+	err := proxyServer.AddRouteWithOptions("/api/", "", testLogger, RouteOptions{
+		Backends:    []Backend{{Destination: first.URL + "/v1/"}, {Destination: second.URL + "/v1/"}},
+		HealthCheck: &HealthCheck{Path: "/healthz", Interval: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	distribution := func() map[string]int {
+		t.Helper()
+		counts := map[string]int{}
+		for i := 0; i < 20; i++ {
+			resp, err := http.Get(testServer.URL + "/api/items")
+			if err != nil {
+				t.Fatal("Request failed:", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				counts[string(body)]++
+			} else {
+				counts[resp.Status]++
+			}
+		}
+		return counts
+	}
+	// Probes run every 10ms, so wait long enough for a few of them
+	settle := func() { time.Sleep(100 * time.Millisecond) }
+
+	firstUp.Store(false)
+	settle()
+	if counts := distribution(); counts["second"] != 20 {
+		t.Errorf("Expected all traffic on the healthy backend, got %v", counts)
+	}
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if metadata := testLogger.requests[0].metadata; !metadata.BackendFailover || metadata.DestinationTemplate != second.URL+"/v1/" {
+		t.Errorf("Expected a failover to the second backend, got failover=%v to %q", metadata.BackendFailover, metadata.DestinationTemplate)
+	}
+
+	secondUp.Store(false)
+	settle()
+	if counts := distribution(); counts["503 Service Unavailable"] != 20 {
+		t.Errorf("Expected 503 while every backend is down, got %v", counts)
+	}
+
+	firstUp.Store(true)
+	secondUp.Store(true)
+	settle()
+	if counts := distribution(); counts["first"] != 10 || counts["second"] != 10 {
+		t.Errorf("Expected traffic to rebalance after recovery, got %v", counts)
+	}
+}
+
+func TestHealthCheckRequiresBackends(t *testing.T) {
+	proxyServer := NewProxyServer("")
+	defer proxyServer.Close()
+	err := proxyServer.AddRouteWithOptions("/api/", "http://127.0.0.1:1/", &NoOpLogger{}, RouteOptions{HealthCheck: &HealthCheck{Path: "/healthz"}})
+	if err == nil {
+		t.Error("Expected a health check without backends to be rejected")
+	}
+}
@@ -13,12 +13,13 @@ import (
 	"net"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elazarl/goproxy"
-	"github.com/google/uuid"
 	golangproxy "golang.org/x/net/proxy"
 )
 
@@ -38,6 +39,19 @@ type HTTPProxyOptions struct {
 	ClientProxy               HTTPClientProxyConfig
 	Auth                      HTTPProxyAuthConfig
 	Verbose                   bool
+	// LogHeaderAllowList restricts the headers written to logged transcripts
+	// to the listed names. Empty logs every header.
+	LogHeaderAllowList []string
+	// MaxLoggedHeaderValue and MaxLoggedHeaderBytes bound logged header
+	// blocks, see ProxyServerOptions.
+	MaxLoggedHeaderValue int
+	MaxLoggedHeaderBytes int
+	// IDGenerator generates RequestMetadata.ID. Nil uses UUIDs.
+	IDGenerator IDGenerator
+	// MaxConnLifetime closes upstream connections once they are this old
+	// instead of reusing them. Zero reuses connections until they are idle
+	// for too long.
+	MaxConnLifetime time.Duration
 }
 
 type HTTPProxyServer struct {
@@ -49,6 +63,12 @@ type HTTPProxyServer struct {
 	mitmInclude               *mitmExcludeMatcher
 	mitmExclude               *mitmExcludeMatcher
 	loggingExcludeURLPrefixes *urlPrefixMatcher
+	logHeaders                logHeaderFilter
+	logHeaderLimits           loggedHeaderLimits
+	idGenerator               IDGenerator
+	// rewindBodies lets the transport send a request again when an expired
+	// upstream connection refuses it
+	rewindBodies bool
 }
 
 type httpProxyAuthenticator struct {
@@ -61,11 +81,20 @@ type httpProxyRequestState struct {
 	requestTime time.Time
 }
 
+// teeReadCloser copies a body to a log pipe as it is read. Every chunk is
+// written to the pipe before Read returns it, and the pipe is unbuffered, so
+// the logger has received a chunk before it is sent upstream. Streaming
+// uploads are logged while they are in progress, not after the round trip.
 type teeReadCloser struct {
 	source          io.ReadCloser
 	writer          *io.PipeWriter
 	once            sync.Once
 	loggingDisabled bool
+	// sourceError, if set, fails the log stream with the wrapped error when
+	// reading the source fails, so the log records it as incomplete.
+	sourceError func(error) error
+	// logged, if set, counts the bytes written to the log.
+	logged *atomic.Int64
 }
 
 type contextDialerFunc func(context.Context, string, string) (net.Conn, error)
@@ -93,17 +122,13 @@ func NewHTTPProxyServer(options HTTPProxyOptions) (*HTTPProxyServer, error) {
 	if err != nil {
 		return nil, err
 	}
-	// Keep the upstream transport from adding implicit Accept-Encoding: gzip and
-	// auto-decompressing responses. If the client explicitly asks for compressed
-	// data, those compressed bytes should be proxied through unchanged; logging
-	// can decompress its tee'd copy separately.
-	transport.DisableCompression = true
 	if options.UpstreamTLSConfig != nil {
 		transport.TLSClientConfig = options.UpstreamTLSConfig.Clone()
 	}
 	if transport.TLSClientConfig == nil {
 		transport.TLSClientConfig = &tls.Config{}
 	}
+	setDialer(transport, options.MaxConnLifetime, nil, nil)
 
 	mitmInclude, err := newMITMIncludeMatcher(options.MITMIncludeHosts)
 	if err != nil {
@@ -124,6 +149,11 @@ func NewHTTPProxyServer(options HTTPProxyOptions) (*HTTPProxyServer, error) {
 	proxy.ConnectDialWithReq = nil
 	if transport.Proxy != nil {
 		proxy.ConnectDialWithReq = newConnectDialWithHTTPClientProxy(proxy, transport, transport.Proxy)
+	} else if options.MaxConnLifetime > 0 {
+		// Tunnels are dialed without the lifetime of pooled connections
+		proxy.ConnectDialWithReq = func(request *http.Request, network, addr string) (net.Conn, error) {
+			return dialDirect(transport, request, network, addr)
+		}
 	}
 	// Preserve client Accept-Encoding so compressed request/response streams are
 	// proxied through unchanged. The logging path only sees a tee'd copy.
@@ -145,6 +175,10 @@ func NewHTTPProxyServer(options HTTPProxyOptions) (*HTTPProxyServer, error) {
 		mitmInclude:               mitmInclude,
 		mitmExclude:               mitmExclude,
 		loggingExcludeURLPrefixes: loggingExcludeURLPrefixes,
+		logHeaders:                newLogHeaderFilter(options.LogHeaderAllowList),
+		logHeaderLimits:           loggedHeaderLimits{maxValue: options.MaxLoggedHeaderValue, maxBlock: options.MaxLoggedHeaderBytes},
+		idGenerator:               options.IDGenerator,
+		rewindBodies:              options.MaxConnLifetime > 0,
 	}
 
 	if server.authenticator != nil {
@@ -216,7 +250,13 @@ func dialDirect(transport *http.Transport, request *http.Request, network, addr
 
 func dialDirectContext(transport *http.Transport, ctx context.Context, network, addr string) (net.Conn, error) {
 	if transport != nil && transport.DialContext != nil {
-		return transport.DialContext(ctx, network, addr)
+		conn, err := transport.DialContext(ctx, network, addr)
+		// A tunnel is never pooled, so it does not expire like an upstream
+		// HTTP connection
+		if lifetime, ok := conn.(*lifetimeConn); ok {
+			return lifetime.Conn, err
+		}
+		return conn, err
 	}
 	if transport != nil && transport.Dial != nil {
 		return transport.Dial(network, addr)
@@ -429,7 +469,7 @@ func (s *HTTPProxyServer) logHTTPProxyConnect(host string, ctx *goproxy.ProxyCtx
 	}
 
 	metadata := RequestMetadata{
-		ID:               uuid.New().String(),
+		ID:               newRequestID(s.idGenerator),
 		Pattern:          "HTTP_PROXY_CONNECT",
 		Method:           method,
 		SourceURL:        target,
@@ -443,6 +483,9 @@ func (s *HTTPProxyServer) handleRequest(request *http.Request, ctx *goproxy.Prox
 	if request == nil || request.URL == nil {
 		return request, nil
 	}
+	if s.rewindBodies {
+		ctx.RoundTripper = goproxy.RoundTripperFunc(rewindingRoundTrip)
+	}
 
 	requestTime := time.Now()
 	targetURL := cloneURL(request.URL)
@@ -460,23 +503,33 @@ func (s *HTTPProxyServer) handleRequest(request *http.Request, ctx *goproxy.Prox
 	}
 
 	metadata := RequestMetadata{
-		ID:                     uuid.New().String(),
+		ID:                     newRequestID(s.idGenerator),
 		Pattern:                pattern,
 		Method:                 request.Method,
 		SourceURL:              targetURL.String(),
 		DestinationURL:         targetURL.String(),
 		RequestContentEncoding: requestContentEncoding,
 	}
+	recordClientConnection(&metadata, request)
 	ctx.UserData = &httpProxyRequestState{metadata: metadata, requestTime: requestTime}
 
 	requestHeaders := request.Header.Clone()
+	requestTransferEncoding := slices.Clone(request.TransferEncoding)
 	request.Body = wrapBodyForLogging(request.Body, func(body io.ReadCloser) {
-		s.logHTTPProxyRequest(metadata, requestTime, request.Method, targetURL.String(), request.Proto, requestHeaders, requestContentEncoding, body)
+		s.logHTTPProxyRequest(metadata, requestTime, request.Method, targetURL.String(), request.Proto, requestHeaders, requestTransferEncoding, requestContentEncoding, body)
 	})
 
 	return request, nil
 }
 
+// rewindingRoundTrip sends request with a rewindable body, so that a request
+// refused by an expired upstream connection is sent again.
+func rewindingRoundTrip(request *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+	request, release := withRewindableBody(request)
+	defer release()
+	return ctx.Proxy.Tr.RoundTrip(request)
+}
+
 func (s *HTTPProxyServer) handleResponse(response *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
 	if response == nil {
 		return response
@@ -492,6 +545,7 @@ func (s *HTTPProxyServer) handleResponse(response *http.Response, ctx *goproxy.P
 	responseHeaders := response.Header.Clone()
 	responseContentEncoding := responseHeaders.Get("Content-Encoding")
 	upstreamProto := response.Proto
+	transferEncoding := slices.Clone(response.TransferEncoding)
 	metadata.ResponseContentEncoding = responseContentEncoding
 
 	// goproxy's MITM path serializes the upstream *http.Response with
@@ -507,37 +561,38 @@ func (s *HTTPProxyServer) handleResponse(response *http.Response, ctx *goproxy.P
 	}
 
 	response.Body = wrapBodyForLogging(response.Body, func(body io.ReadCloser) {
-		s.logHTTPProxyResponse(metadata, responseTime, upstreamProto, response.Status, responseHeaders, responseContentEncoding, body)
+		s.logHTTPProxyResponse(metadata, responseTime, upstreamProto, response.Status, responseHeaders, transferEncoding, responseContentEncoding, body)
 	})
 
 	return response
 }
 
-func (s *HTTPProxyServer) logHTTPProxyRequest(metadata RequestMetadata, timestamp time.Time, method, target, proto string, headers http.Header, contentEncoding string, body io.ReadCloser) {
+func (s *HTTPProxyServer) logHTTPProxyRequest(metadata RequestMetadata, timestamp time.Time, method, target, proto string, headers http.Header, transferEncoding []string, contentEncoding string, body io.ReadCloser) {
 	defer body.Close()
 
 	var headerBuf bytes.Buffer
 	fmt.Fprintf(&headerBuf, "%s %s %s\r\n", method, target, proto)
+	block := s.logHeaderLimits.block(&headerBuf)
 	for name, values := range headers {
-		if shouldSkipLoggedRequestHeader(name) {
+		if shouldSkipLoggedRequestHeader(name) || !s.logHeaders.allows(name) {
 			continue
 		}
 		for _, value := range values {
-			fmt.Fprintf(&headerBuf, "%s: %s\r\n", name, value)
+			block.write(name, value)
 		}
 	}
-	headerBuf.WriteString("\r\n")
-
+	block.close()
+	writeTransferEncodingMarker(&headerBuf, transferEncoding)
 	var bodyReader io.Reader = body
 	if contentEncoding != "" {
-		decompressed, err := decompressReader(body, contentEncoding)
+		decompressed, err := decompressForLogging(body, contentEncoding)
 		if err != nil {
 			fmt.Fprintf(&headerBuf, "X-Decompression-Error: %v\r\n", err)
-		} else {
-			defer decompressed.Close()
-			bodyReader = decompressed
 		}
+		defer decompressed.Close()
+		bodyReader = decompressed
 	}
+	headerBuf.WriteString("\r\n")
 
 	s.logger.LogRequest(metadata, timestamp, &readCloser{
 		Reader: io.MultiReader(&headerBuf, bodyReader),
@@ -545,31 +600,32 @@ func (s *HTTPProxyServer) logHTTPProxyRequest(metadata RequestMetadata, timestam
 	})
 }
 
-func (s *HTTPProxyServer) logHTTPProxyResponse(metadata RequestMetadata, timestamp time.Time, proto, status string, headers http.Header, contentEncoding string, body io.ReadCloser) {
+func (s *HTTPProxyServer) logHTTPProxyResponse(metadata RequestMetadata, timestamp time.Time, proto, status string, headers http.Header, transferEncoding []string, contentEncoding string, body io.ReadCloser) {
 	defer body.Close()
 
 	var headerBuf bytes.Buffer
-	fmt.Fprintf(&headerBuf, "%s %s\r\n", proto, status)
+	fmt.Fprintf(&headerBuf, "%s %s\r\n", proto, s.logHeaderLimits.truncate(status))
+	block := s.logHeaderLimits.block(&headerBuf)
 	for name, values := range headers {
-		if strings.EqualFold(name, "Content-Encoding") {
+		if strings.EqualFold(name, "Content-Encoding") || !s.logHeaders.allows(name) {
 			continue
 		}
 		for _, value := range values {
-			fmt.Fprintf(&headerBuf, "%s: %s\r\n", name, value)
+			block.write(name, value)
 		}
 	}
-	headerBuf.WriteString("\r\n")
-
+	block.close()
+	writeTransferEncodingMarker(&headerBuf, transferEncoding)
 	var bodyReader io.Reader = body
 	if contentEncoding != "" {
-		decompressed, err := decompressReader(body, contentEncoding)
+		decompressed, err := decompressForLogging(body, contentEncoding)
 		if err != nil {
 			fmt.Fprintf(&headerBuf, "X-Decompression-Error: %v\r\n", err)
-		} else {
-			defer decompressed.Close()
-			bodyReader = decompressed
 		}
+		defer decompressed.Close()
+		bodyReader = decompressed
 	}
+	headerBuf.WriteString("\r\n")
 
 	s.logger.LogResponse(metadata, timestamp, &readCloser{
 		Reader: io.MultiReader(&headerBuf, bodyReader),
@@ -594,7 +650,11 @@ func wrapBodyForLogging(body io.ReadCloser, logFunc func(io.ReadCloser)) io.Read
 func (t *teeReadCloser) Read(p []byte) (int, error) {
 	n, err := t.source.Read(p)
 	if n > 0 && !t.loggingDisabled {
-		if _, writeErr := t.writer.Write(p[:n]); writeErr != nil {
+		written, writeErr := t.writer.Write(p[:n])
+		if t.logged != nil {
+			t.logged.Add(int64(written))
+		}
+		if writeErr != nil {
 			// Logging is best-effort. If the log reader exits early (for example
 			// because decompression detected a truncated gzip stream after a client
 			// cancel), do not turn that side-channel failure into a proxied stream
@@ -603,6 +663,10 @@ func (t *teeReadCloser) Read(p []byte) (int, error) {
 			_ = t.writer.CloseWithError(writeErr)
 		}
 	}
+	if err != nil && err != io.EOF && t.sourceError != nil && !t.loggingDisabled {
+		t.loggingDisabled = true
+		_ = t.writer.CloseWithError(t.sourceError(err))
+	}
 	return n, err
 }
 
@@ -0,0 +1,358 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for IdempotencyConfig.
+const (
+	DefaultIdempotencyHeader     = "Idempotency-Key"
+	DefaultIdempotencyTTL        = 24 * time.Hour
+	DefaultIdempotencyMaxEntries = 1000
+	DefaultIdempotencyMaxBody    = 1 << 20
+)
+
+// IdempotencyConfig makes a route answer repeated requests with the same
+// idempotency key from a cache instead of forwarding them again, as expected
+// by payment-like APIs. Keys are scoped to the method, the request URI and
+// the caller, identified by its Authorization header and
+// ProxyServerOptions.SubjectHeader, so a key reused by another client is
+// never answered with someone else's response. A key repeated with a
+// different request body is answered with 422 Unprocessable Entity.
+// Responses with a 5xx status, and responses whose body exceeds MaxBody, are
+// not stored, so the client can retry them. Set-Cookie is never replayed.
+type IdempotencyConfig struct {
+	// Header names the request header with the key. Requests without it are
+	// forwarded as usual. It defaults to DefaultIdempotencyHeader.
+	Header string
+	// TTL is how long a response is served for its key. It defaults to
+	// DefaultIdempotencyTTL.
+	TTL time.Duration
+	// MaxEntries bounds the number of stored keys; the least recently used
+	// key is dropped first. It defaults to DefaultIdempotencyMaxEntries.
+	MaxEntries int
+	// MaxBody is the largest response body stored, in bytes. It defaults to
+	// DefaultIdempotencyMaxBody.
+	MaxBody int64
+}
+
+// idempotencyCache stores the responses of a route by idempotency key. A key
+// is reserved by the first request that uses it, so concurrent duplicates can
+// be told apart from completed ones. All methods accept a nil cache, which
+// never deduplicates.
+type idempotencyCache struct {
+	header     string
+	ttl        time.Duration
+	maxEntries int
+	maxBody    int64
+	clock      Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds *idempotentResponse, most recently used first
+	order *list.List
+}
+
+// idempotentResponse is the response stored for a key. It is not changed once
+// it is complete.
+type idempotentResponse struct {
+	key       string
+	requestID string
+	pending   bool
+	expires   time.Time
+	status    int
+	header    http.Header
+	body      []byte
+	// requestHash is the SHA-256 of the request body that used the key.
+	requestHash []byte
+}
+
+// newIdempotencyCache returns the cache for config, or nil if config is nil.
+func newIdempotencyCache(config *IdempotencyConfig, clock Clock) (*idempotencyCache, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.TTL < 0 || config.MaxEntries < 0 || config.MaxBody < 0 {
+		return nil, fmt.Errorf("idempotency TTL, MaxEntries and MaxBody must not be negative")
+	}
+	c := &idempotencyCache{
+		header:     http.CanonicalHeaderKey(strings.TrimSpace(config.Header)),
+		ttl:        config.TTL,
+		maxEntries: config.MaxEntries,
+		maxBody:    config.MaxBody,
+		clock:      clock,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+	if c.header == "" {
+		c.header = DefaultIdempotencyHeader
+	}
+	if c.ttl == 0 {
+		c.ttl = DefaultIdempotencyTTL
+	}
+	if c.maxEntries == 0 {
+		c.maxEntries = DefaultIdempotencyMaxEntries
+	}
+	if c.maxBody == 0 {
+		c.maxBody = DefaultIdempotencyMaxBody
+	}
+	return c, nil
+}
+
+// key returns the cache key of request from subject, or "" if it has no
+// idempotency key. It must be called before the route's credential replaces
+// the client's Authorization header.
+func (c *idempotencyCache) key(request *http.Request, subject string) string {
+	if c == nil {
+		return ""
+	}
+	key := strings.TrimSpace(request.Header.Get(c.header))
+	if key == "" {
+		return ""
+	}
+	// The credentials are hashed so that they are not kept in memory
+	caller := sha256.New()
+	for _, value := range request.Header.Values("Authorization") {
+		io.WriteString(caller, value+"\n")
+	}
+	io.WriteString(caller, subject)
+	return request.Method + " " + request.URL.RequestURI() + " " + hex.EncodeToString(caller.Sum(nil)) + " " + key
+}
+
+// begin looks up key for the request with requestID. It returns the stored
+// response of a completed earlier request, or inFlight if an earlier request
+// with the key is still running. Otherwise the key is reserved for requestID
+// until complete or release is called.
+func (c *idempotencyCache) begin(key, requestID string) (stored *idempotentResponse, inFlight bool) {
+	if c == nil || key == "" {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.clock.Now()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*idempotentResponse)
+		if entry.pending {
+			return nil, true
+		}
+		if now.Before(entry.expires) {
+			c.order.MoveToFront(element)
+			return entry, false
+		}
+		c.remove(element)
+	}
+	c.entries[key] = c.order.PushFront(&idempotentResponse{key: key, requestID: requestID, pending: true})
+	for c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+	return nil, false
+}
+
+// complete stores the response of the request that reserved key. A request
+// whose body was not read completely is not stored, since its hash is
+// unknown.
+func (c *idempotencyCache) complete(key, requestID string, status int, header http.Header, body *idempotentBody, request *idempotentRequestBody) {
+	if c == nil || key == "" {
+		return
+	}
+	requestHash := request.sum()
+	if status >= 500 || body.overflow || requestHash == nil {
+		c.release(key, requestID)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok || element.Value.(*idempotentResponse).requestID != requestID {
+		return
+	}
+	header.Del("Set-Cookie")
+	element.Value = &idempotentResponse{
+		key:         key,
+		requestID:   requestID,
+		expires:     c.clock.Now().Add(c.ttl),
+		status:      status,
+		header:      header,
+		body:        body.buf.Bytes(),
+		requestHash: requestHash,
+	}
+}
+
+// release drops the reservation of key by requestID if it was not completed,
+// so the next request with the key is forwarded.
+func (c *idempotencyCache) release(key, requestID string) {
+	if c == nil || key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		if entry := element.Value.(*idempotentResponse); entry.pending && entry.requestID == requestID {
+			c.remove(element)
+		}
+	}
+}
+
+func (c *idempotencyCache) remove(element *list.Element) {
+	delete(c.entries, element.Value.(*idempotentResponse).key)
+	c.order.Remove(element)
+}
+
+// newBody returns a recorder for a response body that may be stored.
+func (c *idempotencyCache) newBody() *idempotentBody {
+	return &idempotentBody{max: c.maxBody}
+}
+
+// idempotentBody records a response body as it is sent to the client. Writes
+// never fail; a body over max is marked as overflowing instead.
+type idempotentBody struct {
+	max      int64
+	buf      bytes.Buffer
+	overflow bool
+}
+
+func (b *idempotentBody) Write(p []byte) (int, error) {
+	if int64(b.buf.Len()+len(p)) > b.max {
+		b.overflow = true
+	}
+	if !b.overflow {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// idempotentRequestBody hashes a request body as it is forwarded, so that a
+// repeated key can be checked against the body it was first used with.
+type idempotentRequestBody struct {
+	io.ReadCloser
+	hash hash.Hash
+	eof  bool
+}
+
+// newIdempotentRequestBody hashes body. empty tells that the request has no
+// body, which the transport may never read.
+func newIdempotentRequestBody(body io.ReadCloser, empty bool) *idempotentRequestBody {
+	return &idempotentRequestBody{ReadCloser: body, hash: sha256.New(), eof: empty}
+}
+
+func (b *idempotentRequestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hash.Write(p[:n])
+	if err == io.EOF {
+		b.eof = true
+	}
+	return n, err
+}
+
+// sum returns the hash of the body, or nil if it was not read to the end.
+func (b *idempotentRequestBody) sum() []byte {
+	if !b.eof {
+		return nil
+	}
+	return b.hash.Sum(nil)
+}
+
+// matches reads the body of a repeated request and reports whether it is the
+// body the stored response was made for.
+func (stored *idempotentResponse) matches(body io.Reader) bool {
+	hash := sha256.New()
+	io.Copy(hash, body)
+	return bytes.Equal(hash.Sum(nil), stored.requestHash)
+}
+
+// encodeFor returns the stored response encoded for a repeated request with
+// method and acceptEncoding. A backend encoding the client does not accept is
+// decoded, and the route compresses the body for the client as it would a
+// forwarded response.
+func (stored *idempotentResponse) encodeFor(route *proxyRoute, method, acceptEncoding string) (http.Header, []byte) {
+	header := stored.header.Clone()
+	body := stored.body
+	if encoding := header.Get("Content-Encoding"); encoding != "" && !acceptsEncoding(acceptEncoding, encoding) {
+		if decoded, _, err := decompressBody(bytes.NewReader(body), encoding); err == nil {
+			plain, err := io.ReadAll(decoded)
+			decoded.Close()
+			if err == nil {
+				body = plain
+				header.Del("Content-Encoding")
+			}
+		}
+	}
+	response := &http.Response{StatusCode: stored.status, Header: header, ContentLength: int64(len(body))}
+	if route.compressResponsesMinSize > 0 && shouldCompressResponse(method, response, body, true, route.compressResponsesMinSize) {
+		if encoding := clientResponseEncoding(acceptEncoding); encoding != "" {
+			var compressed bytes.Buffer
+			compressor := newResponseCompressor(&compressed, encoding)
+			compressor.Write(body)
+			compressor.Close()
+			compressResponseHeader(header, encoding)
+			return header, compressed.Bytes()
+		}
+	}
+	if header.Get("Content-Length") != "" && method != http.MethodHead {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	return header, body
+}
+
+// serveDeduplicated answers a repeated request with the response stored for
+// its idempotency key and logs it with RequestMetadata.Deduplicated set.
+func (s *ProxyServer) serveDeduplicated(w http.ResponseWriter, route *proxyRoute, origin, method, acceptEncoding string, metadata RequestMetadata, logger Logger, requestLogDone <-chan struct{}, stored *idempotentResponse) {
+	responseTime := s.clock.Now()
+	metadata.ResponseStatus = fmt.Sprintf("%d %s", stored.status, http.StatusText(stored.status))
+	metadata.ResponseStatusCode = stored.status
+	metadata.ResponseContentEncoding = stored.header.Get("Content-Encoding")
+
+	header, body := stored.encodeFor(route, method, acceptEncoding)
+	for key, values := range header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	if route.canonicalHeaders {
+		canonicalizeHeader(w.Header())
+	}
+	s.setProxyHeaders(w, route, origin, metadata)
+	w.WriteHeader(stored.status)
+	w.Write(body)
+
+	s.logWorkers.Go(func() {
+		s.waitForRequestLog(requestLogDone)
+		var transcript bytes.Buffer
+		fmt.Fprintf(&transcript, "HTTP/1.1 %s\r\n", metadata.ResponseStatus)
+		fmt.Fprintf(&transcript, "X-Proxy-Deduplicated: %s\r\n", stored.requestID)
+		for name, values := range stored.header {
+			if strings.EqualFold(name, "Content-Encoding") || !s.logHeaders.allows(name) {
+				continue
+			}
+			for _, value := range values {
+				fmt.Fprintf(&transcript, "%s: %s\r\n", name, value)
+			}
+		}
+		var body io.Reader = bytes.NewReader(stored.body)
+		if metadata.ResponseContentEncoding != "" && route.bodyCapture.logsBody() {
+			decompressed, err := decompressForLogging(body, metadata.ResponseContentEncoding)
+			if err != nil {
+				fmt.Fprintf(&transcript, "X-Decompression-Error: %v\r\n", err)
+			}
+			defer decompressed.Close()
+			body = decompressed
+		}
+		body = route.bodyCapture.captureBody(body)
+		transcript.WriteString("\r\n")
+		logger.LogResponse(metadata, responseTime, &readCloser{
+			Reader: io.MultiReader(&transcript, body),
+			Closer: io.NopCloser(nil),
+		})
+	})
+}
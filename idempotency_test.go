@@ -0,0 +1,228 @@
+package loggingproxy
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyKeyDeduplicatesRequests(t *testing.T) {
+	var calls atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		call := calls.Add(1)
+		w.Header().Set("X-Charge", fmt.Sprint(call))
+		w.Header().Set("Set-Cookie", "session=first")
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "charge %d for %s", call, body)
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/pay/", backend.URL+"/", testLogger, RouteOptions{
+		Idempotency: &IdempotencyConfig{},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	var cookie string
+	chargeAs := func(authorization, key, body string) (int, string, string) {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodPost, testServer.URL+"/pay/charges", strings.NewReader(body))
+		if key != "" {
+			request.Header.Set("Idempotency-Key", key)
+		}
+		if authorization != "" {
+			request.Header.Set("Authorization", authorization)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		responseBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		cookie = resp.Header.Get("Set-Cookie")
+		return resp.StatusCode, resp.Header.Get("X-Charge"), string(responseBody)
+	}
+	charge := func(key, body string) (int, string, string) {
+		t.Helper()
+		return chargeAs("Bearer alice", key, body)
+	}
+
+	firstStatus, firstCharge, firstBody := charge("key-1", "10 EUR")
+	secondStatus, secondCharge, secondBody := charge("key-1", "10 EUR")
+	if calls.Load() != 1 {
+		t.Fatalf("Expected the backend to be called once, got %d calls", calls.Load())
+	}
+	if firstStatus != http.StatusCreated || secondStatus != firstStatus || secondCharge != firstCharge || secondBody != firstBody {
+		t.Errorf("Expected identical responses, got %d %s %q and %d %s %q",
+			firstStatus, firstCharge, firstBody, secondStatus, secondCharge, secondBody)
+	}
+	if cookie != "" {
+		t.Errorf("Expected Set-Cookie not to be replayed, got %q", cookie)
+	}
+
+	// The same key with another body is rejected, and from another caller it
+	// is a different key
+	if status, _, _ := charge("key-1", "99 EUR"); status != http.StatusUnprocessableEntity {
+		t.Errorf("Expected 422 for a reused key with another body, got %d", status)
+	}
+	if status, otherCharge, _ := chargeAs("Bearer mallory", "key-1", "10 EUR"); status != http.StatusCreated || otherCharge == firstCharge {
+		t.Errorf("Expected another caller's key to be forwarded, got %d with charge %s", status, otherCharge)
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("Expected the backend to be called twice, got %d calls", calls.Load())
+	}
+
+	// Other keys, and requests without a key, are forwarded
+	charge("key-2", "5 EUR")
+	charge("", "5 EUR")
+	if calls.Load() != 4 {
+		t.Errorf("Expected requests with other keys to be forwarded, got %d calls", calls.Load())
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 5 {
+		t.Fatalf("Expected 5 logged responses, got %d", len(testLogger.responses))
+	}
+	var deduplicated []capturedLog
+	for _, entry := range testLogger.responses {
+		if entry.metadata.Deduplicated {
+			deduplicated = append(deduplicated, entry)
+		}
+	}
+	if len(deduplicated) != 1 {
+		t.Fatalf("Expected one deduplicated response, got %d", len(deduplicated))
+	}
+	entry := deduplicated[0]
+	if entry.metadata.DeduplicatedFrom == "" || entry.metadata.DeduplicatedFrom == entry.metadata.ID {
+		t.Errorf("Expected the original request ID in the metadata, got %q", entry.metadata.DeduplicatedFrom)
+	}
+	if !strings.Contains(entry.content, "X-Proxy-Deduplicated: "+entry.metadata.DeduplicatedFrom) || !strings.HasSuffix(entry.content, firstBody) {
+		t.Errorf("Expected the stored response in the log, got %q", entry.content)
+	}
+}
+
+func TestIdempotencyCacheExpiresAndEvicts(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	cache, err := newIdempotencyCache(&IdempotencyConfig{TTL: time.Minute, MaxEntries: 2, MaxBody: 4}, clock)
+	if err != nil {
+		t.Fatal("Failed to create cache:", err)
+	}
+	store := func(key, requestID, body string) {
+		if stored, inFlight := cache.begin(key, requestID); stored != nil || inFlight {
+			t.Fatalf("Expected %s to be new", key)
+		}
+		recorded := cache.newBody()
+		recorded.Write([]byte(body))
+		cache.complete(key, requestID, http.StatusOK, http.Header{}, recorded, newIdempotentRequestBody(http.NoBody, true))
+	}
+
+	store("a", "1", "ok")
+	if _, inFlight := cache.begin("b", "2"); inFlight {
+		t.Fatal("Expected b to be new")
+	}
+	if _, inFlight := cache.begin("b", "3"); !inFlight {
+		t.Error("Expected a concurrent duplicate to be reported in flight")
+	}
+	cache.release("b", "2")
+	store("b", "4", "too large")
+	if _, inFlight := cache.begin("b", "5"); inFlight {
+		t.Error("Expected a body over MaxBody not to be stored")
+	}
+
+	// Storing a third key evicts the least recently used one
+	if stored, _ := cache.begin("a", "6"); stored == nil || stored.requestID != "1" {
+		t.Fatal("Expected a to be served from the cache")
+	}
+	store("c", "7", "ok")
+	if _, ok := cache.entries["b"]; ok {
+		t.Error("Expected b to be evicted")
+	}
+
+	clock.now = clock.now.Add(2 * time.Minute)
+	if stored, _ := cache.begin("a", "8"); stored != nil {
+		t.Error("Expected a to expire after its TTL")
+	}
+}
+
+func TestIdempotencyReplayIsEncodedForEachClient(t *testing.T) {
+	var calls atomic.Int32
+	plain := strings.Repeat(`{"charge":"ok"}`, 200)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/gzipped" {
+			// The backend compresses on its own
+			w.Header().Set("Content-Encoding", "gzip")
+			compressor := gzip.NewWriter(w)
+			io.WriteString(compressor, plain)
+			compressor.Close()
+			return
+		}
+		io.WriteString(w, plain)
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/pay/", backend.URL+"/", &NoOpLogger{}, RouteOptions{
+		Idempotency:       &IdempotencyConfig{},
+		CompressResponses: true,
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	// The transport is told not to decode, so the encoding the proxy chose is seen
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	charge := func(path, key, acceptEncoding string) (string, string) {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodPost, testServer.URL+"/pay/"+path, strings.NewReader("10 EUR"))
+		request.Header.Set("Idempotency-Key", key)
+		request.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := client.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		defer resp.Body.Close()
+		var body io.Reader = resp.Body
+		if resp.Header.Get("Content-Encoding") == "gzip" {
+			if body, err = gzip.NewReader(resp.Body); err != nil {
+				t.Fatal("Failed to decode gzip response:", err)
+			}
+		}
+		decoded, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal("Failed to read response:", err)
+		}
+		return resp.Header.Get("Content-Encoding"), string(decoded)
+	}
+
+	for _, path := range []string{"plain", "gzipped"} {
+		if encoding, body := charge(path, path, "gzip"); encoding != "gzip" || body != plain {
+			t.Errorf("%s: expected a gzip response for the first request, got %q", path, encoding)
+		}
+		if encoding, body := charge(path, path, "identity"); encoding != "" || body != plain {
+			t.Errorf("%s: expected the repeat without gzip to be decoded, got %q with %d bytes", path, encoding, len(body))
+		}
+		if encoding, body := charge(path, path, "br, gzip;q=0.5"); encoding != "br" && encoding != "gzip" || len(body) == 0 {
+			t.Errorf("%s: expected the repeat accepting compression to be compressed, got %q", path, encoding)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Errorf("Expected one backend call per key, got %d", calls.Load())
+	}
+}
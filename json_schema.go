@@ -0,0 +1,444 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxSchemaErrors caps the validation errors reported for one document.
+const maxSchemaErrors = 10
+
+// JSONSchema is a compiled JSON Schema used to validate request bodies. It
+// supports the validation keywords of JSON Schema draft 2020-12 that do not
+// need references: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, exclusiveMinimum, exclusiveMaximum, allOf, anyOf,
+// oneOf and not. Annotations such as title or description are ignored, and
+// schemas using $ref or any other keyword are rejected rather than silently
+// accepting what the keyword would have refused.
+type JSONSchema struct {
+	alwaysFalse bool
+
+	types    []string
+	enum     []any
+	constant *any
+
+	properties           map[string]*JSONSchema
+	required             []string
+	additionalProperties *JSONSchema
+
+	items    *JSONSchema
+	minItems *int
+	maxItems *int
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+
+	allOf []*JSONSchema
+	anyOf []*JSONSchema
+	oneOf []*JSONSchema
+	not   *JSONSchema
+}
+
+// ParseJSONSchema compiles a JSON Schema document.
+func ParseJSONSchema(data []byte) (*JSONSchema, error) {
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	schema, err := compileJSONSchema(document, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema: %w", err)
+	}
+	return schema, nil
+}
+
+func compileJSONSchema(document any, path string) (*JSONSchema, error) {
+	switch document := document.(type) {
+	case bool:
+		return &JSONSchema{alwaysFalse: !document}, nil
+	case map[string]any:
+		return compileJSONSchemaObject(document, path)
+	}
+	return nil, fmt.Errorf("%s: schema must be an object or a boolean", schemaPath(path))
+}
+
+// supportedSchemaKeywords are the keywords JSONSchema validates.
+var supportedSchemaKeywords = []string{
+	"type", "enum", "const", "properties", "required", "additionalProperties",
+	"items", "minItems", "maxItems", "minLength", "maxLength", "pattern",
+	"minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum",
+	"allOf", "anyOf", "oneOf", "not",
+}
+
+// schemaAnnotations are keywords that do not affect validation.
+var schemaAnnotations = []string{
+	"$schema", "$id", "$anchor", "$comment", "$defs", "definitions",
+	"title", "description", "default", "examples", "deprecated", "readOnly",
+	"writeOnly", "format", "contentEncoding", "contentMediaType",
+}
+
+func compileJSONSchemaObject(document map[string]any, path string) (*JSONSchema, error) {
+	var unsupported []string
+	for keyword := range document {
+		if !slices.Contains(supportedSchemaKeywords, keyword) && !slices.Contains(schemaAnnotations, keyword) {
+			unsupported = append(unsupported, keyword)
+		}
+	}
+	if len(unsupported) > 0 {
+		sort.Strings(unsupported)
+		verb := "is"
+		if len(unsupported) > 1 {
+			verb = "are"
+		}
+		return nil, fmt.Errorf("%s: %s %s not supported", schemaPath(path), strings.Join(unsupported, ", "), verb)
+	}
+
+	schema := &JSONSchema{}
+	var err error
+	switch types := document["type"].(type) {
+	case nil:
+	case string:
+		schema.types = []string{types}
+	case []any:
+		for _, t := range types {
+			name, ok := t.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/type: expected strings", schemaPath(path))
+			}
+			schema.types = append(schema.types, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s/type: expected a string or an array", schemaPath(path))
+	}
+	for _, t := range schema.types {
+		switch t {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return nil, fmt.Errorf("%s/type: unknown type %q", schemaPath(path), t)
+		}
+	}
+
+	if enum, ok := document["enum"]; ok {
+		values, ok := enum.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/enum: expected an array", schemaPath(path))
+		}
+		schema.enum = values
+	}
+	if constant, ok := document["const"]; ok {
+		schema.constant = &constant
+	}
+
+	if properties, ok := document["properties"]; ok {
+		properties, ok := properties.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/properties: expected an object", schemaPath(path))
+		}
+		schema.properties = map[string]*JSONSchema{}
+		for name, property := range properties {
+			if schema.properties[name], err = compileJSONSchema(property, path+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if required, ok := document["required"]; ok {
+		names, ok := required.([]any)
+		if !ok {
+			return nil, fmt.Errorf("%s/required: expected an array", schemaPath(path))
+		}
+		for _, name := range names {
+			name, ok := name.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s/required: expected strings", schemaPath(path))
+			}
+			schema.required = append(schema.required, name)
+		}
+	}
+	if additional, ok := document["additionalProperties"]; ok {
+		if schema.additionalProperties, err = compileJSONSchema(additional, path+"/additionalProperties"); err != nil {
+			return nil, err
+		}
+	}
+	if items, ok := document["items"]; ok {
+		if schema.items, err = compileJSONSchema(items, path+"/items"); err != nil {
+			return nil, err
+		}
+	}
+
+	for keyword, target := range map[string]**int{
+		"minItems":  &schema.minItems,
+		"maxItems":  &schema.maxItems,
+		"minLength": &schema.minLength,
+		"maxLength": &schema.maxLength,
+	} {
+		if value, ok := document[keyword]; ok {
+			number, ok := value.(float64)
+			if !ok || number < 0 || number != math.Trunc(number) {
+				return nil, fmt.Errorf("%s/%s: expected a non-negative integer", schemaPath(path), keyword)
+			}
+			limit := int(number)
+			*target = &limit
+		}
+	}
+	for keyword, target := range map[string]**float64{
+		"minimum":          &schema.minimum,
+		"maximum":          &schema.maximum,
+		"exclusiveMinimum": &schema.exclusiveMinimum,
+		"exclusiveMaximum": &schema.exclusiveMaximum,
+	} {
+		if value, ok := document[keyword]; ok {
+			number, ok := value.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s/%s: expected a number", schemaPath(path), keyword)
+			}
+			*target = &number
+		}
+	}
+	if pattern, ok := document["pattern"]; ok {
+		expression, ok := pattern.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s/pattern: expected a string", schemaPath(path))
+		}
+		if schema.pattern, err = regexp.Compile(expression); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", schemaPath(path), err)
+		}
+	}
+
+	for keyword, target := range map[string]*[]*JSONSchema{
+		"allOf": &schema.allOf,
+		"anyOf": &schema.anyOf,
+		"oneOf": &schema.oneOf,
+	} {
+		if value, ok := document[keyword]; ok {
+			subschemas, ok := value.([]any)
+			if !ok || len(subschemas) == 0 {
+				return nil, fmt.Errorf("%s/%s: expected a non-empty array", schemaPath(path), keyword)
+			}
+			for i, subschema := range subschemas {
+				compiled, err := compileJSONSchema(subschema, fmt.Sprintf("%s/%s/%d", path, keyword, i))
+				if err != nil {
+					return nil, err
+				}
+				*target = append(*target, compiled)
+			}
+		}
+	}
+	if not, ok := document["not"]; ok {
+		if schema.not, err = compileJSONSchema(not, path+"/not"); err != nil {
+			return nil, err
+		}
+	}
+	return schema, nil
+}
+
+func schemaPath(path string) string {
+	if path == "" {
+		return "schema"
+	}
+	return "schema" + path
+}
+
+// Validate checks a JSON document against the schema. It returns nil if the
+// document is valid, or an error listing up to ten violations, each prefixed
+// with the JSON pointer of the offending value.
+func (s *JSONSchema) Validate(data []byte) error {
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+	var violations []string
+	s.validate(document, "", &violations)
+	if len(violations) == 0 {
+		return nil
+	}
+	if len(violations) > maxSchemaErrors {
+		violations = append(violations[:maxSchemaErrors], fmt.Sprintf("and %d more", len(violations)-maxSchemaErrors))
+	}
+	return errors.New(strings.Join(violations, "; "))
+}
+
+// valid reports whether value matches the schema, for the combinators.
+func (s *JSONSchema) valid(value any) bool {
+	var violations []string
+	s.validate(value, "", &violations)
+	return len(violations) == 0
+}
+
+func (s *JSONSchema) validate(value any, pointer string, violations *[]string) {
+	fail := func(format string, args ...any) {
+		location := pointer
+		if location == "" {
+			location = "/"
+		}
+		*violations = append(*violations, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	if s.alwaysFalse {
+		fail("not allowed")
+		return
+	}
+	if len(s.types) > 0 && !matchesJSONType(value, s.types) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), jsonTypeName(value))
+		return
+	}
+	if s.enum != nil && !containsJSONValue(s.enum, value) {
+		fail("value is not one of the allowed values")
+	}
+	if s.constant != nil && !reflect.DeepEqual(*s.constant, value) {
+		fail("value does not match the expected constant")
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := value[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propertyPointer := pointer + "/" + escapeJSONPointer(name)
+			if property, ok := s.properties[name]; ok {
+				property.validate(value[name], propertyPointer, violations)
+			} else if s.additionalProperties != nil {
+				if s.additionalProperties.alwaysFalse {
+					fail("unexpected property %q", name)
+				} else {
+					s.additionalProperties.validate(value[name], propertyPointer, violations)
+				}
+			}
+		}
+	case []any:
+		if s.minItems != nil && len(value) < *s.minItems {
+			fail("expected at least %d items, got %d", *s.minItems, len(value))
+		}
+		if s.maxItems != nil && len(value) > *s.maxItems {
+			fail("expected at most %d items, got %d", *s.maxItems, len(value))
+		}
+		if s.items != nil {
+			for i, item := range value {
+				s.items.validate(item, pointer+"/"+strconv.Itoa(i), violations)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+		if s.minLength != nil && length < *s.minLength {
+			fail("expected at least %d characters, got %d", *s.minLength, length)
+		}
+		if s.maxLength != nil && length > *s.maxLength {
+			fail("expected at most %d characters, got %d", *s.maxLength, length)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			fail("does not match pattern %q", s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && value < *s.minimum {
+			fail("must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && value > *s.maximum {
+			fail("must be <= %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && value <= *s.exclusiveMinimum {
+			fail("must be > %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && value >= *s.exclusiveMaximum {
+			fail("must be < %v", *s.exclusiveMaximum)
+		}
+	}
+
+	for _, subschema := range s.allOf {
+		subschema.validate(value, pointer, violations)
+	}
+	if s.anyOf != nil {
+		matched := false
+		for _, subschema := range s.anyOf {
+			if subschema.valid(value) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			fail("does not match any of the anyOf schemas")
+		}
+	}
+	if s.oneOf != nil {
+		matches := 0
+		for _, subschema := range s.oneOf {
+			if subschema.valid(value) {
+				matches++
+			}
+		}
+		if matches != 1 {
+			fail("matches %d of the oneOf schemas, expected exactly 1", matches)
+		}
+	}
+	if s.not != nil && s.not.valid(value) {
+		fail("must not match the not schema")
+	}
+}
+
+func matchesJSONType(value any, types []string) bool {
+	name := jsonTypeName(value)
+	for _, t := range types {
+		if t == name || (t == "number" && name == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonTypeName returns the JSON Schema type of a decoded value. Whole numbers
+// are "integer".
+func jsonTypeName(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	}
+	return "unknown"
+}
+
+func containsJSONValue(values []any, value any) bool {
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func escapeJSONPointer(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
@@ -0,0 +1,167 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const chatSchema = `{
+	"type": "object",
+	"required": ["model", "messages"],
+	"properties": {
+		"model": {"type": "string", "minLength": 1},
+		"temperature": {"type": "number", "minimum": 0, "maximum": 2},
+		"messages": {
+			"type": "array",
+			"minItems": 1,
+			"items": {
+				"type": "object",
+				"required": ["role"],
+				"properties": {"role": {"enum": ["system", "user", "assistant"]}}
+			}
+		}
+	}
+}`
+
+func TestJSONSchemaValidate(t *testing.T) {
+	schema, err := ParseJSONSchema([]byte(chatSchema))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+
+	testCases := []struct {
+		document string
+		errors   []string
+	}{
+		{`{"model": "m", "messages": [{"role": "user"}]}`, nil},
+		{`{"model": "m", "messages": [{"role": "user"}], "temperature": 1.5}`, nil},
+		{`{"messages": [{"role": "user"}]}`, []string{`/: missing required property "model"`}},
+		{`{"model": "", "messages": []}`, []string{"/messages: expected at least 1 items, got 0", "/model: expected at least 1 characters, got 0"}},
+		{`{"model": "m", "messages": [{"role": "robot"}], "temperature": 3}`, []string{"/messages/0/role: value is not one of the allowed values", "/temperature: must be <= 2"}},
+		{`{"model": 42, "messages": [{}]}`, []string{"/messages/0: missing required property \"role\"", "/model: expected string, got integer"}},
+		{`[]`, []string{"/: expected object, got array"}},
+		{`{"model":`, []string{"invalid JSON"}},
+	}
+	for _, tc := range testCases {
+		err := schema.Validate([]byte(tc.document))
+		if tc.errors == nil {
+			if err != nil {
+				t.Errorf("Expected %s to be valid, got %v", tc.document, err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("Expected %s to be invalid", tc.document)
+			continue
+		}
+		for _, expected := range tc.errors {
+			if !strings.Contains(err.Error(), expected) {
+				t.Errorf("Expected the error for %s to contain %q, got %q", tc.document, expected, err)
+			}
+		}
+	}
+}
+
+func TestParseJSONSchemaRejectsUnsupportedSchemas(t *testing.T) {
+	for _, schema := range []string{
+		`{"$ref": "#/$defs/message"}`,
+		`{"type": "text"}`,
+		`{"properties": {"name": 1}}`,
+		`{"pattern": "("}`,
+		`not json`,
+		`{"type": "number", "multipleOf": 2}`,
+		`{"type": "array", "uniqueItems": true}`,
+		`{"properties": {"tags": {"prefixItems": [{"type": "string"}]}}}`,
+		`{"if": {"required": ["a"]}, "then": {"required": ["b"]}}`,
+		`{"patternProperties": {"^x-": {"type": "string"}}, "minProperties": 1, "dependentRequired": {"a": ["b"]}}`,
+	} {
+		if _, err := ParseJSONSchema([]byte(schema)); err == nil {
+			t.Errorf("Expected %s to be rejected", schema)
+		}
+	}
+}
+
+func TestParseJSONSchemaAllowsAnnotations(t *testing.T) {
+	schema := `{"$schema": "https://json-schema.org/draft/2020-12/schema", "title": "Message", "description": "A chat message",
+		"type": "object", "properties": {"sent": {"type": "string", "format": "date-time", "examples": ["2026-01-02T03:04:05Z"]}}}`
+	if _, err := ParseJSONSchema([]byte(schema)); err != nil {
+		t.Errorf("Expected annotations to be ignored, got %v", err)
+	}
+	_, err := ParseJSONSchema([]byte(`{"type": "object", "minProperties": 1, "patternProperties": {}}`))
+	if err == nil || !strings.Contains(err.Error(), "minProperties, patternProperties are not supported") {
+		t.Errorf("Expected the unsupported keywords to be named, got %v", err)
+	}
+}
+
+func TestRequestSchemaRejectsInvalidBodies(t *testing.T) {
+	var forwarded []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		forwarded = append(forwarded, string(body))
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	schema, err := ParseJSONSchema([]byte(chatSchema))
+	if err != nil {
+		t.Fatalf("Failed to parse schema: %v", err)
+	}
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	options := RouteOptions{RequestSchema: schema, RequestSchemaMaxBody: 256}
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, options); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	post := func(contentType, body string) (int, string) {
+		t.Helper()
+		resp, err := http.Post(testServer.URL+"/api/chat", contentType, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		responseBody, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(responseBody)
+	}
+
+	valid := `{"model": "m", "messages": [{"role": "user"}]}`
+	if status, body := post("application/json", valid); status != http.StatusOK || body != "ok" {
+		t.Fatalf("Expected a compliant request to be forwarded, got %d %q", status, body)
+	}
+	invalid := `{"messages": [{"role": "user"}]}`
+	status, body := post("application/json; charset=utf-8", invalid)
+	if status != http.StatusBadRequest || !strings.Contains(body, `missing required property "model"`) {
+		t.Fatalf("Expected 400 with the violation, got %d %q", status, body)
+	}
+	if status, _ := post("text/plain", invalid); status != http.StatusOK {
+		t.Errorf("Expected non-JSON bodies to be forwarded unvalidated, got %d", status)
+	}
+	if status, _ := post("application/json", `{"model": "`+strings.Repeat("m", 300)+`"}`); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a body over the validation limit, got %d", status)
+	}
+	if len(forwarded) != 2 || forwarded[0] != valid || forwarded[1] != invalid {
+		t.Errorf("Expected only the valid and the non-JSON request to reach the backend, got %q", forwarded)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 4 {
+		t.Fatalf("Expected 4 request logs, got %d", len(testLogger.requests))
+	}
+	rejected := testLogger.requests[1]
+	if !rejected.metadata.Blocked || !rejected.metadata.InvalidBody || rejected.metadata.ResponseStatusCode != http.StatusBadRequest {
+		t.Errorf("Expected the rejected request to be logged as an invalid body, got %+v", rejected.metadata)
+	}
+	if !strings.HasSuffix(rejected.content, "\r\n\r\n"+invalid) {
+		t.Errorf("Expected the rejected request to be logged with its body, got:\n%s", rejected.content)
+	}
+	if tooLarge := testLogger.requests[3].metadata; !tooLarge.Blocked || tooLarge.InvalidBody {
+		t.Errorf("Expected the oversized request to be blocked without invalid_body, got %+v", tooLarge)
+	}
+}
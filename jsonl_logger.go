@@ -0,0 +1,127 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// JSONLinesLoggerOptions configures a JSONLinesLogger.
+type JSONLinesLoggerOptions struct {
+	// Path is the file the lines are appended to.
+	Path string
+	// MaxStreamSize cuts off transcripts logged one by one after this many
+	// bytes. Zero means DefaultBatchMaxStreamSize. Batches are cut off by the
+	// BatchingLogger.
+	MaxStreamSize int
+	// MaxSize, MaxAge and MaxBackups rotate the file as for LokiLoggerOptions.
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	// Clock drives MaxAge and names rotated files. Nil uses the wall clock.
+	Clock Clock
+	// LogLevel filters the logger's own messages, such as write failures.
+	LogLevel LogLevel
+}
+
+// JSONLinesEntry is one line written by JSONLinesLogger.
+type JSONLinesEntry struct {
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Metadata  RequestMetadata `json:"metadata"`
+	// Stream is the logged stream, base64 encoded, cut off after
+	// MaxStreamSize bytes. Size is the size of the whole stream.
+	Stream    []byte `json:"stream"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// JSONLinesLogger writes every logged request and response, with its
+// metadata and stream, as one JSON line to a file. It implements BatchLogger,
+// so behind a BatchingLogger every batch is a single write.
+type JSONLinesLogger struct {
+	maxStreamSize int
+
+	mu   sync.Mutex
+	file *rotatingFile
+}
+
+// NewJSONLinesLogger opens the file and returns a JSONLinesLogger.
+func NewJSONLinesLogger(options JSONLinesLoggerOptions) (*JSONLinesLogger, error) {
+	if options.Path == "" {
+		return nil, errors.New("JSON lines logger needs a path")
+	}
+	if options.MaxStreamSize <= 0 {
+		options.MaxStreamSize = DefaultBatchMaxStreamSize
+	}
+	file := &rotatingFile{
+		path:       options.Path,
+		maxSize:    options.MaxSize,
+		maxAge:     options.MaxAge,
+		maxBackups: options.MaxBackups,
+		clock:      clockOrReal(options.Clock),
+		ops:        levelLogger{level: options.LogLevel},
+	}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return &JSONLinesLogger{maxStreamSize: options.MaxStreamSize, file: file}, nil
+}
+
+// LogRequest writes the request as one line
+func (l *JSONLinesLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	l.logStream("request", metadata, timestamp, rawRequestStream)
+}
+
+// LogResponse writes the response as one line
+func (l *JSONLinesLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	l.logStream("response", metadata, timestamp, rawResponseStream)
+}
+
+// LogBatch writes the lines of batch at once
+func (l *JSONLinesLogger) LogBatch(batch []BatchedTranscript) {
+	var lines []byte
+	for _, transcript := range batch {
+		line, err := json.Marshal(JSONLinesEntry{
+			Type:      transcript.StreamType,
+			Timestamp: transcript.Timestamp.UTC(),
+			Metadata:  transcript.Metadata,
+			Stream:    transcript.Stream,
+			Size:      transcript.Size,
+			Truncated: transcript.Truncated,
+		})
+		if err != nil {
+			continue
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if len(lines) == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.write(lines); err != nil {
+		l.file.ops.Errorf("Failed to write JSON lines log %s: %v", l.file.path, err)
+	}
+}
+
+// Close closes the file.
+func (l *JSONLinesLogger) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.close()
+}
+
+func (l *JSONLinesLogger) logStream(streamType string, metadata RequestMetadata, timestamp time.Time, stream io.ReadCloser) {
+	captured := captureStream(stream, l.maxStreamSize, nil)
+	l.LogBatch([]BatchedTranscript{{
+		StreamType: streamType,
+		Timestamp:  timestamp,
+		Metadata:   metadata,
+		Stream:     captured.Bytes(),
+		Size:       captured.size,
+		Truncated:  captured.size > int64(captured.Len()),
+	}})
+}
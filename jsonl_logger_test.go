@@ -0,0 +1,65 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLinesLoggerWritesBatches(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transcripts.ndjson")
+	sink, err := NewJSONLinesLogger(JSONLinesLoggerOptions{Path: path})
+	if err != nil {
+		t.Fatalf("failed to create JSON lines logger: %v", err)
+	}
+	forward := &TestLogger{}
+	batchingLogger, err := NewBatchingLogger(BatchingLoggerOptions{Logger: sink, Forward: forward, MaxStreamSize: 32, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("failed to create batching logger: %v", err)
+	}
+
+	request := "POST /upload HTTP/1.1\r\n\r\nhello"
+	response := "HTTP/1.1 200 OK\r\n\r\nthis body is cut off after a while"
+	batchingLogger.LogRequest(RequestMetadata{ID: "one"}, time.Now(), io.NopCloser(strings.NewReader(request)))
+	batchingLogger.LogResponse(RequestMetadata{ID: "one"}, time.Now(), io.NopCloser(strings.NewReader(response)))
+
+	// The forwarded logger gets every stream in full right away
+	if len(forward.requests) != 1 || forward.requests[0].content != request || len(forward.responses) != 1 || forward.responses[0].content != response {
+		t.Fatalf("expected the streams to be forwarded as they arrive, got %d requests and %d responses", len(forward.requests), len(forward.responses))
+	}
+	if info, _ := os.Stat(path); info.Size() != 0 {
+		t.Fatalf("expected the batch to be buffered, got %d bytes written", info.Size())
+	}
+	if err := batchingLogger.Close(); err != nil {
+		t.Fatalf("failed to close batching logger: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open JSON lines log: %v", err)
+	}
+	defer file.Close()
+	var entries []JSONLinesEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry JSONLinesEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("failed to parse line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 lines, got %d", len(entries))
+	}
+	if entries[0].Type != "request" || entries[0].Metadata.ID != "one" || string(entries[0].Stream) != request || entries[0].Truncated {
+		t.Errorf("unexpected request line: %+v", entries[0])
+	}
+	if entries[1].Type != "response" || string(entries[1].Stream) != response[:32] || !entries[1].Truncated || entries[1].Size != int64(len(response)) {
+		t.Errorf("expected the response cut off and marked truncated, got %+v", entries[1])
+	}
+}
@@ -0,0 +1,103 @@
+package loggingproxy
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// DefaultKafkaBufferSize is the number of messages KafkaLogger queues for the
+// producer when KafkaLoggerOptions.BufferSize is not positive.
+const DefaultKafkaBufferSize = 1024
+
+// DefaultKafkaMaxBodySize caps the logged stream in a message when
+// KafkaLoggerOptions.MaxBodySize is not positive.
+const DefaultKafkaMaxBodySize = 1 << 20
+
+// KafkaProducer publishes a single message to a Kafka topic. This module does
+// not depend on a Kafka client; implement it with a small adapter around the
+// client of your choice, such as franz-go or sarama.
+type KafkaProducer interface {
+	Produce(topic string, key, value []byte) error
+}
+
+// KafkaEnvelope is the JSON value of every message published by KafkaLogger.
+// The message key is the request ID, so a request and its response land in
+// the same partition.
+type KafkaEnvelope = StreamEnvelope
+
+// KafkaLoggerOptions configures a KafkaLogger.
+type KafkaLoggerOptions struct {
+	Producer KafkaProducer
+	Topic    string
+	// Logger receives every stream as well, typically a FileLogger. Nil only
+	// publishes to Kafka.
+	Logger Logger
+	// MaxBodySize caps the stream included in each message, in bytes.
+	MaxBodySize int
+	// BufferSize bounds the messages waiting for the producer. Messages that
+	// do not fit are dropped and counted.
+	BufferSize int
+}
+
+// KafkaLogger publishes each logged request and response as a KafkaEnvelope.
+// Messages are handed to the producer from a single background goroutine
+// through a bounded buffer, so a slow or unavailable broker never blocks the
+// proxy; messages that cannot be queued or produced are dropped and counted.
+type KafkaLogger struct {
+	logger    Logger
+	publisher *envelopePublisher
+}
+
+// NewKafkaLogger creates a KafkaLogger and starts its producer goroutine.
+// Close stops it after the queued messages have been produced.
+func NewKafkaLogger(options KafkaLoggerOptions) (*KafkaLogger, error) {
+	if options.Producer == nil {
+		return nil, errors.New("kafka logger requires a producer")
+	}
+	if options.Topic == "" {
+		return nil, errors.New("kafka logger requires a topic")
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = DefaultKafkaMaxBodySize
+	}
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultKafkaBufferSize
+	}
+
+	produce := func(key, value []byte) error {
+		return options.Producer.Produce(options.Topic, key, value)
+	}
+	return &KafkaLogger{
+		logger:    options.Logger,
+		publisher: newEnvelopePublisher(options.Logger, options.MaxBodySize, options.BufferSize, produce),
+	}, nil
+}
+
+// LogRequest forwards the request stream and publishes it
+func (l *KafkaLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	l.publisher.LogRequest(metadata, timestamp, rawRequestStream)
+}
+
+// LogResponse forwards the response stream and publishes it
+func (l *KafkaLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	l.publisher.LogResponse(metadata, timestamp, rawResponseStream)
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *KafkaLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	l.publisher.LogConnect(metadata, timestamp)
+}
+
+// Dropped returns the number of messages dropped because the buffer was full,
+// the producer failed or the logger was already closed.
+func (l *KafkaLogger) Dropped() uint64 {
+	return l.publisher.dropped.Load()
+}
+
+// Close waits for the queued messages to be produced and closes the wrapped
+// logger if it implements io.Closer. The producer is left to the caller.
+func (l *KafkaLogger) Close() error {
+	l.publisher.close()
+	return closeWrapped(l.logger)
+}
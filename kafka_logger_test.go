@@ -0,0 +1,152 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type producedMessage struct {
+	topic string
+	key   string
+	value []byte
+}
+
+// stubProducer records produced messages. While block is open, Produce waits
+// on it; err makes every Produce fail.
+type stubProducer struct {
+	mu       sync.Mutex
+	messages []producedMessage
+	block    chan struct{}
+	err      error
+}
+
+func (p *stubProducer) Produce(topic string, key, value []byte) error {
+	if p.block != nil {
+		<-p.block
+	}
+	if p.err != nil {
+		return p.err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, producedMessage{topic: topic, key: string(key), value: value})
+	return nil
+}
+
+func TestKafkaLoggerPublishesProxiedExchanges(t *testing.T) {
+	producer := &stubProducer{}
+	testLogger := &TestLogger{}
+	kafkaLogger, err := NewKafkaLogger(KafkaLoggerOptions{Producer: producer, Topic: "traffic", Logger: testLogger})
+	if err != nil {
+		t.Fatalf("failed to create kafka logger: %v", err)
+	}
+	proxySamplingRequests(t, kafkaLogger, 2)
+	if err := kafkaLogger.Close(); err != nil {
+		t.Fatalf("failed to close kafka logger: %v", err)
+	}
+
+	if len(producer.messages) != 4 {
+		t.Fatalf("expected 4 messages, got %d", len(producer.messages))
+	}
+	streams := map[string][]KafkaEnvelope{}
+	for _, message := range producer.messages {
+		var envelope KafkaEnvelope
+		if err := json.Unmarshal(message.value, &envelope); err != nil {
+			t.Fatalf("message value is not a JSON envelope: %v", err)
+		}
+		if message.topic != "traffic" || message.key != envelope.Metadata.ID || message.key == "" {
+			t.Errorf("expected topic traffic and the request ID as key, got %q and %q", message.topic, message.key)
+		}
+		streams[message.key] = append(streams[message.key], envelope)
+	}
+	if len(streams) != 2 {
+		t.Fatalf("expected messages for 2 requests, got %d", len(streams))
+	}
+	for id, envelopes := range streams {
+		if len(envelopes) != 2 {
+			t.Errorf("expected a request and a response for %s, got %d messages", id, len(envelopes))
+			continue
+		}
+		for _, envelope := range envelopes {
+			expectedBody := "request body"
+			if envelope.StreamType == "response" {
+				expectedBody = "response body"
+			}
+			if !strings.HasSuffix(envelope.Stream, "\r\n\r\n"+expectedBody) || envelope.Size != int64(len(envelope.Stream)) || envelope.Truncated {
+				t.Errorf("unexpected %s envelope: %+v", envelope.StreamType, envelope)
+			}
+		}
+	}
+
+	if len(testLogger.requests) != 2 || len(testLogger.responses) != 2 {
+		t.Errorf("expected the wrapped logger to receive both exchanges, got %d requests and %d responses",
+			len(testLogger.requests), len(testLogger.responses))
+	}
+}
+
+func TestKafkaLoggerCapsStream(t *testing.T) {
+	producer := &stubProducer{}
+	kafkaLogger, err := NewKafkaLogger(KafkaLoggerOptions{Producer: producer, Topic: "traffic", MaxBodySize: 16})
+	if err != nil {
+		t.Fatalf("failed to create kafka logger: %v", err)
+	}
+	stream := "HTTP/1.1 200 OK\r\n\r\n" + strings.Repeat("x", 100)
+	kafkaLogger.LogResponse(RequestMetadata{ID: "capped"}, time.Now(), io.NopCloser(strings.NewReader(stream)))
+	kafkaLogger.Close()
+
+	if len(producer.messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(producer.messages))
+	}
+	var envelope KafkaEnvelope
+	json.Unmarshal(producer.messages[0].value, &envelope)
+	if envelope.Stream != stream[:16] || envelope.Size != int64(len(stream)) || !envelope.Truncated {
+		t.Errorf("expected a truncated stream with the full size, got %+v", envelope)
+	}
+}
+
+func TestKafkaLoggerDropsWhenBrokerIsUnavailable(t *testing.T) {
+	// A producer stuck on an unreachable broker must not block logging
+	producer := &stubProducer{block: make(chan struct{})}
+	kafkaLogger, err := NewKafkaLogger(KafkaLoggerOptions{Producer: producer, Topic: "traffic", BufferSize: 1})
+	if err != nil {
+		t.Fatalf("failed to create kafka logger: %v", err)
+	}
+
+	logged := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			kafkaLogger.LogRequest(RequestMetadata{ID: "blocked"}, time.Now(), io.NopCloser(strings.NewReader("GET / HTTP/1.1\r\n\r\n")))
+		}
+		close(logged)
+	}()
+	select {
+	case <-logged:
+	case <-time.After(2 * time.Second):
+		t.Fatal("logging blocked on an unavailable broker")
+	}
+	// One message is held by the producer and at most one is queued
+	if dropped := kafkaLogger.Dropped(); dropped < 3 {
+		t.Errorf("expected at least 3 dropped messages, got %d", dropped)
+	}
+
+	producer.err = errors.New("broker unavailable")
+	close(producer.block)
+	kafkaLogger.Close()
+	if dropped := kafkaLogger.Dropped(); dropped != 5 {
+		t.Errorf("expected failed messages to be counted as dropped, got %d", dropped)
+	}
+}
+
+func TestNewKafkaLoggerRequiresProducerAndTopic(t *testing.T) {
+	if _, err := NewKafkaLogger(KafkaLoggerOptions{Topic: "traffic"}); err == nil {
+		t.Error("expected an error without a producer")
+	}
+	if _, err := NewKafkaLogger(KafkaLoggerOptions{Producer: &stubProducer{}}); err == nil {
+		t.Error("expected an error without a topic")
+	}
+}
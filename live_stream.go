@@ -0,0 +1,186 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultLiveStreamBufferSize is the per-subscriber event buffer used when
+// NewLiveStream is given a non-positive size.
+const DefaultLiveStreamBufferSize = 64
+
+// LiveEvent summarizes a completed request/response exchange.
+type LiveEvent struct {
+	ID         string `json:"id"`
+	Pattern    string `json:"pattern"`
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	TargetURL  string `json:"target_url"`
+	Status     int    `json:"status"`
+	DurationMS int64  `json:"duration_ms"`
+	// Bytes is the size of the logged response, including its headers.
+	Bytes int64 `json:"bytes"`
+}
+
+// LiveStream fans out LiveEvents to server-sent-events subscribers. Every
+// subscriber has a bounded buffer; events for a subscriber that falls behind
+// are dropped so publishing never blocks the proxy.
+type LiveStream struct {
+	bufferSize int
+	dropped    atomic.Uint64
+
+	mu          sync.Mutex
+	subscribers map[chan LiveEvent]struct{}
+}
+
+// NewLiveStream creates a live stream with the given per-subscriber buffer size.
+func NewLiveStream(bufferSize int) *LiveStream {
+	if bufferSize <= 0 {
+		bufferSize = DefaultLiveStreamBufferSize
+	}
+	return &LiveStream{
+		bufferSize:  bufferSize,
+		subscribers: map[chan LiveEvent]struct{}{},
+	}
+}
+
+// Publish sends event to every subscriber that has room for it.
+func (s *LiveStream) Publish(event LiveEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for subscriber := range s.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+}
+
+// Dropped returns the number of events dropped for slow subscribers.
+func (s *LiveStream) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *LiveStream) subscribe() chan LiveEvent {
+	subscriber := make(chan LiveEvent, s.bufferSize)
+	s.mu.Lock()
+	s.subscribers[subscriber] = struct{}{}
+	s.mu.Unlock()
+	return subscriber
+}
+
+func (s *LiveStream) unsubscribe(subscriber chan LiveEvent) {
+	s.mu.Lock()
+	delete(s.subscribers, subscriber)
+	s.mu.Unlock()
+}
+
+// ServeHTTP streams events as server-sent events until the client disconnects.
+func (s *LiveStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	subscriber := s.subscribe()
+	defer s.unsubscribe(subscriber)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	// Send a comment so the client knows it is subscribed
+	io.WriteString(w, ": connected\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-subscriber:
+			data, err := json.Marshal(event)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: response\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// LiveStreamLogger publishes a LiveEvent to Stream whenever a response has been
+// fully logged by the wrapped logger. A nil Logger discards the streams.
+type LiveStreamLogger struct {
+	Logger Logger
+	Stream *LiveStream
+	// Clock measures event durations. Nil uses the wall clock.
+	Clock Clock
+}
+
+// NewLiveStreamLogger wraps logger so completed exchanges are published to stream.
+func NewLiveStreamLogger(logger Logger, stream *LiveStream) *LiveStreamLogger {
+	return &LiveStreamLogger{
+		Logger: logger,
+		Stream: stream,
+	}
+}
+
+// LogRequest forwards the request stream unchanged
+func (l *LiveStreamLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	if l.Logger == nil {
+		discardStream(rawRequestStream)
+		return
+	}
+	l.Logger.LogRequest(metadata, timestamp, rawRequestStream)
+}
+
+// LogResponse forwards the response stream and publishes a summary once it is consumed
+func (l *LiveStreamLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	counted := &countingReadCloser{ReadCloser: rawResponseStream}
+	if l.Logger == nil {
+		discardStream(counted)
+	} else {
+		l.Logger.LogResponse(metadata, timestamp, counted)
+	}
+
+	l.Stream.Publish(LiveEvent{
+		ID:         metadata.ID,
+		Pattern:    metadata.Pattern,
+		Method:     metadata.Method,
+		URL:        metadata.SourceURL,
+		TargetURL:  metadata.DestinationURL,
+		Status:     metadata.ResponseStatusCode,
+		DurationMS: clockOrReal(l.Clock).Now().Sub(metadata.RequestStartedAt).Milliseconds(),
+		Bytes:      counted.n.Load(),
+	})
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *LiveStreamLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(l.Logger, metadata, timestamp)
+}
+
+// Close closes the wrapped logger if it implements io.Closer.
+func (l *LiveStreamLogger) Close() error {
+	return closeWrapped(l.Logger)
+}
+
+// countingReadCloser counts the bytes read through it.
+type countingReadCloser struct {
+	io.ReadCloser
+	n atomic.Int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,103 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLiveStreamEmitsCompletedExchanges(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "accepted")
+	}))
+	defer backend.Close()
+
+	stream := NewLiveStream(0)
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", NewLiveStreamLogger(&NoOpLogger{}, stream)); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	proxyServer.Handle("/admin/stream", stream)
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	sseResp, err := http.Get(testServer.URL + "/admin/stream")
+	if err != nil {
+		t.Fatalf("failed to connect to live stream: %v", err)
+	}
+	defer sseResp.Body.Close()
+	if contentType := sseResp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %q", contentType)
+	}
+
+	events := make(chan string, 1)
+	go func() {
+		scanner := bufio.NewScanner(sseResp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				events <- data
+				return
+			}
+		}
+	}()
+
+	// The subscription is registered before the connected comment is flushed,
+	// and the response headers arrive with it.
+	resp, err := http.Post(testServer.URL+"/api/jobs", "text/plain", strings.NewReader("job"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	select {
+	case data := <-events:
+		var event LiveEvent
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", data, err)
+		}
+		if event.Method != http.MethodPost || event.Status != http.StatusAccepted || event.Pattern != "/api/" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+		if !strings.HasSuffix(event.URL, "/api/jobs") || event.TargetURL != backend.URL+"/jobs" || event.ID == "" {
+			t.Errorf("unexpected event URLs or ID: %+v", event)
+		}
+		if event.Bytes <= int64(len("accepted")) {
+			t.Errorf("expected event to count the logged response, got %d bytes", event.Bytes)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for live stream event")
+	}
+}
+
+func TestLiveStreamDropsEventsForSlowSubscribers(t *testing.T) {
+	stream := NewLiveStream(1)
+	subscriber := stream.subscribe()
+	defer stream.unsubscribe(subscriber)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			stream.Publish(LiveEvent{ID: "event"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishing blocked on a slow subscriber")
+	}
+	if stream.Dropped() != 2 {
+		t.Fatalf("expected 2 dropped events, got %d", stream.Dropped())
+	}
+	if len(subscriber) != 1 {
+		t.Fatalf("expected 1 buffered event, got %d", len(subscriber))
+	}
+}
@@ -0,0 +1,144 @@
+package loggingproxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// DefaultBackendFailTimeout is how long a failed backend is skipped when
+// RouteOptions.BackendFailTimeout is not positive.
+const DefaultBackendFailTimeout = 10 * time.Second
+
+var errNoHealthyBackend = errors.New("no healthy backend")
+
+// Backend is one destination of a load balanced route.
+type Backend struct {
+	Destination string
+	// Weight is the share of requests the backend gets relative to the
+	// others of its route. Zero is 1.
+	Weight int
+}
+
+// loadBalancer picks a backend per request by smooth weighted round-robin, so
+// weights 1:3 send one request of every four to the first backend without
+// bursts. Health is checked passively: a backend whose request fails is
+// skipped for failTimeout, and if every backend failed all of them are tried.
+// With a health check, backends whose last probe failed are skipped until a
+// probe succeeds, and if none is up there is no backend to choose.
+type loadBalancer struct {
+	failTimeout time.Duration
+	clock       Clock
+
+	mu       sync.Mutex
+	backends []*balancedBackend
+}
+
+type balancedBackend struct {
+	destination    string
+	destinationURL url.URL
+	weight         int
+	// current is the running score of the smooth weighted round-robin.
+	current        int
+	unhealthyUntil time.Time
+	// down is set while the health check probes of the backend fail.
+	down bool
+}
+
+// newLoadBalancer returns nil if there are no backends. Health checks are
+// started separately with start.
+func newLoadBalancer(backends []Backend, failTimeout time.Duration, clock Clock) (*loadBalancer, error) {
+	if len(backends) == 0 {
+		return nil, nil
+	}
+	if failTimeout <= 0 {
+		failTimeout = DefaultBackendFailTimeout
+	}
+	balancer := &loadBalancer{failTimeout: failTimeout, clock: clock}
+	for _, backend := range backends {
+		if backend.Weight < 0 {
+			return nil, fmt.Errorf("backend %q has a negative weight", backend.Destination)
+		}
+		destinationURL, err := parseDestinationURL(backend.Destination)
+		if err != nil {
+			return nil, err
+		}
+		weight := backend.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		balancer.backends = append(balancer.backends, &balancedBackend{
+			destination:    backend.Destination,
+			destinationURL: *destinationURL,
+			weight:         weight,
+		})
+	}
+	return balancer, nil
+}
+
+// next returns the backend for the next request, and whether others were
+// skipped because they are unhealthy. It returns nil if every backend is down.
+func (b *loadBalancer) next() (*balancedBackend, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	up, healthy := 0, 0
+	for _, backend := range b.backends {
+		if !backend.down {
+			up++
+			if !now.Before(backend.unhealthyUntil) {
+				healthy++
+			}
+		}
+	}
+	if up == 0 {
+		return nil, true
+	}
+
+	var chosen *balancedBackend
+	total := 0
+	for _, backend := range b.backends {
+		if backend.down || (healthy > 0 && now.Before(backend.unhealthyUntil)) {
+			continue
+		}
+		backend.current += backend.weight
+		total += backend.weight
+		if chosen == nil || backend.current > chosen.current {
+			chosen = backend
+		}
+	}
+	chosen.current -= total
+	return chosen, healthy < len(b.backends)
+}
+
+// failed skips the backend with the configured destination for the fail
+// timeout. Other destinations, such as those of route matchers, are ignored.
+func (b *loadBalancer) failed(destination string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, backend := range b.backends {
+		if backend.destination == destination {
+			backend.unhealthyUntil = b.clock.Now().Add(b.failTimeout)
+			return
+		}
+	}
+}
+
+// probed records the result of a health check probe of backend. A successful
+// probe also ends a skip after a failed request.
+func (b *loadBalancer) probed(backend *balancedBackend, healthy bool) (changed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	changed = backend.down == healthy
+	backend.down = !healthy
+	if healthy {
+		backend.unhealthyUntil = time.Time{}
+	}
+	return changed
+}
@@ -0,0 +1,138 @@
+package loggingproxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBackendsAreWeightedRoundRobin(t *testing.T) {
+	counts := map[string]int{}
+	newBackend := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, name)
+		}))
+	}
+	light := newBackend("light")
+	defer light.Close()
+	heavy := newBackend("heavy")
+	defer heavy.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", "", testLogger, RouteOptions{Backends: []Backend{
+		{Destination: light.URL + "/"},
+		{Destination: heavy.URL + "/", Weight: 3},
+	}})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	const requests = 200
+	for i := 0; i < requests; i++ {
+		resp, err := http.Get(testServer.URL + "/api/items")
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		counts[string(body)]++
+	}
+	if counts["light"]+counts["heavy"] != requests || counts["light"] < 40 || counts["light"] > 60 {
+		t.Errorf("Expected about 50 light and 150 heavy responses, got %v", counts)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) == 0 {
+		t.Fatal("Expected logged requests")
+	}
+	metadata := testLogger.requests[0].metadata
+	if metadata.DestinationURL != light.URL+"/items" && metadata.DestinationURL != heavy.URL+"/items" {
+		t.Errorf("Expected the chosen backend as the destination URL, got %q", metadata.DestinationURL)
+	}
+
+	if err := proxyServer.AddRouteWithOptions("/both/", light.URL, testLogger, RouteOptions{Backends: []Backend{{Destination: heavy.URL}}}); err == nil {
+		t.Error("Expected a route with a destination and backends to be rejected")
+	}
+}
+
+func TestFailedBackendIsSkipped(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Failed to listen:", err)
+	}
+	deadURL := "http://" + listener.Addr().String() + "/"
+	listener.Close()
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "live")
+	}))
+	defer live.Close()
+
+	proxyServer := NewProxyServer("")
+	err = proxyServer.AddRouteWithOptions("/api/", "", &NoOpLogger{}, RouteOptions{Backends: []Backend{
+		{Destination: deadURL, Weight: 5},
+		{Destination: live.URL + "/"},
+	}})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	// The heavier dead backend is tried once, then skipped
+	failures := 0
+	for i := 0; i < 10; i++ {
+		resp, err := http.Get(testServer.URL + "/api/")
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Errorf("Expected only the first request to hit the dead backend, got %d failures", failures)
+	}
+}
+
+func TestFailedBackendRecoversAfterFailTimeout(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	balancer, err := newLoadBalancer([]Backend{
+		{Destination: "http://a/"},
+		{Destination: "http://b/"},
+	}, time.Minute, clock)
+	if err != nil {
+		t.Fatal("Failed to create load balancer:", err)
+	}
+	next := func() string {
+		backend, _ := balancer.next()
+		return backend.destination
+	}
+
+	balancer.failed("http://a/")
+	for i := 0; i < 4; i++ {
+		if destination := next(); destination != "http://b/" {
+			t.Fatalf("Expected the failed backend to be skipped, got %s", destination)
+		}
+	}
+
+	// Every backend failed: they are all tried again rather than none
+	balancer.failed("http://b/")
+	seen := map[string]bool{next(): true, next(): true}
+	if !seen["http://a/"] || !seen["http://b/"] {
+		t.Errorf("Expected both failed backends to be used, got %v", seen)
+	}
+
+	clock.now = clock.now.Add(time.Minute)
+	seen = map[string]bool{next(): true, next(): true}
+	if !seen["http://a/"] || !seen["http://b/"] {
+		t.Errorf("Expected both backends after the fail timeout, got %v", seen)
+	}
+}
@@ -0,0 +1,175 @@
+package loggingproxy
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// LogCapPolicy selects what a FileLogger does once FileLoggerOptions.MaxCaptures
+// or MaxBytes is reached.
+type LogCapPolicy string
+
+const (
+	// LogCapStop stops logging new captures. Streams of captures that were
+	// already started are still logged.
+	LogCapStop LogCapPolicy = "stop"
+	// LogCapEvict deletes the files of the oldest captures to make room.
+	LogCapEvict LogCapPolicy = "evict"
+)
+
+// ParseLogCapPolicy parses "stop" or "evict". An empty string is stop.
+func ParseLogCapPolicy(policy string) (LogCapPolicy, error) {
+	switch LogCapPolicy(policy) {
+	case "", LogCapStop:
+		return LogCapStop, nil
+	case LogCapEvict:
+		return LogCapEvict, nil
+	}
+	return LogCapStop, fmt.Errorf("invalid log cap policy %q (want stop or evict)", policy)
+}
+
+// logCap tracks the captures a FileLogger has written, oldest first, to
+// enforce a hard cap on their number and the total size of their .bin files
+// on disk. A capture is the request and response of one request ID.
+type logCap struct {
+	maxCaptures int
+	maxBytes    int64
+	evict       bool
+	ops         levelLogger
+	// evicted, if set, is called with the ID of every evicted capture once
+	// its files were deleted
+	evicted func(id string)
+
+	mu       sync.Mutex
+	order    []string
+	captures map[string]*logCapture
+	bytes    int64
+	refused  bool
+}
+
+type logCapture struct {
+	id    string
+	files []string
+	bytes int64
+}
+
+// newLogCap returns nil if neither limit is set.
+func newLogCap(maxCaptures int, maxBytes int64, policy LogCapPolicy, ops levelLogger) *logCap {
+	if maxCaptures <= 0 && maxBytes <= 0 {
+		return nil
+	}
+	return &logCap{
+		maxCaptures: maxCaptures,
+		maxBytes:    maxBytes,
+		evict:       policy == LogCapEvict,
+		ops:         ops,
+		captures:    map[string]*logCapture{},
+	}
+}
+
+// admit reports whether a stream of the capture id may be logged to files. A
+// new capture over the cap is refused, or makes room by evicting the oldest
+// captures. A nil logCap admits everything.
+func (c *logCap) admit(id string, files ...string) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	if capture, ok := c.captures[id]; ok {
+		capture.files = append(capture.files, files...)
+		c.mu.Unlock()
+		return true
+	}
+	if c.full(1) && !c.evict {
+		warn := !c.refused
+		c.refused = true
+		c.mu.Unlock()
+		if warn {
+			c.ops.Errorf("Log cap reached (%s), not logging new captures", c.describe())
+		}
+		return false
+	}
+
+	var evicted []*logCapture
+	for c.full(1) && len(c.order) > 0 {
+		evicted = append(evicted, c.removeOldest())
+	}
+	c.order = append(c.order, id)
+	c.captures[id] = &logCapture{id: id, files: files}
+	c.mu.Unlock()
+
+	c.remove(evicted)
+	return true
+}
+
+// written adds the size of a logged stream's file on disk to its capture. With
+// the evict policy, older captures are deleted until the total fits again.
+func (c *logCap) written(id string, bytes int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	capture, ok := c.captures[id]
+	if !ok {
+		c.mu.Unlock()
+		return
+	}
+	capture.bytes += bytes
+	c.bytes += bytes
+
+	var evicted []*logCapture
+	for c.evict && c.full(0) && len(c.order) > 1 && c.order[0] != id {
+		evicted = append(evicted, c.removeOldest())
+	}
+	c.mu.Unlock()
+
+	c.remove(evicted)
+}
+
+// full reports whether adding captures more would exceed a limit. The byte
+// limit counts as reached once the total is at or above it.
+func (c *logCap) full(captures int) bool {
+	if c.maxCaptures > 0 && len(c.order)+captures > c.maxCaptures {
+		return true
+	}
+	if c.maxBytes > 0 && (c.bytes > c.maxBytes || (captures > 0 && c.bytes >= c.maxBytes)) {
+		return true
+	}
+	return false
+}
+
+// removeOldest forgets the oldest capture and returns it. The caller holds
+// c.mu.
+func (c *logCap) removeOldest() *logCapture {
+	id := c.order[0]
+	c.order = c.order[1:]
+	capture := c.captures[id]
+	delete(c.captures, id)
+	c.bytes -= capture.bytes
+	return capture
+}
+
+// remove deletes the files of evicted captures.
+func (c *logCap) remove(evicted []*logCapture) {
+	for _, capture := range evicted {
+		for _, file := range capture.files {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				c.ops.Errorf("Failed to evict log file %s: %v", file, err)
+			}
+		}
+		if c.evicted != nil {
+			c.evicted(capture.id)
+		}
+	}
+}
+
+func (c *logCap) describe() string {
+	switch {
+	case c.maxCaptures > 0 && c.maxBytes > 0:
+		return fmt.Sprintf("%d captures or %d bytes", c.maxCaptures, c.maxBytes)
+	case c.maxCaptures > 0:
+		return fmt.Sprintf("%d captures", c.maxCaptures)
+	}
+	return fmt.Sprintf("%d bytes", c.maxBytes)
+}
@@ -0,0 +1,199 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// encryptedLogMagic starts every encrypted .bin file.
+const encryptedLogMagic = "LPENC1\n"
+
+// encryptedLogChunkSize is the plaintext size of one encrypted record.
+const encryptedLogChunkSize = 64 << 10
+
+// encryptedLogNoncePrefixSize is the random part of every record nonce. The
+// remaining 4 bytes count records, so a file never reuses a nonce.
+const encryptedLogNoncePrefixSize = 8
+
+// An encrypted log file is encryptedLogMagic, a random nonce prefix and a
+// sequence of records: a 4 byte big-endian length and an AES-GCM sealed chunk
+// of at most encryptedLogChunkSize bytes. The nonce of a record is the prefix
+// followed by the big-endian record number. The last record is sealed with
+// the additional data "final", so a cut-off file fails to decrypt instead of
+// looking like a shorter log.
+var (
+	encryptedLogFinal = []byte("final")
+	encryptedLogMore  = []byte("more")
+)
+
+func newLogCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// logEncryptor encrypts a stream written to a .bin file. Close writes the
+// final record and must be called even if the logged stream failed.
+type logEncryptor struct {
+	aead   cipher.AEAD
+	w      io.Writer
+	prefix []byte
+	record uint32
+	buf    []byte
+}
+
+func newLogEncryptor(w io.Writer, aead cipher.AEAD) (*logEncryptor, error) {
+	prefix := make([]byte, encryptedLogNoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	if _, err := io.WriteString(w, encryptedLogMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &logEncryptor{
+		aead:   aead,
+		w:      w,
+		prefix: prefix,
+		buf:    make([]byte, 0, encryptedLogChunkSize),
+	}, nil
+}
+
+func (e *logEncryptor) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buf) == encryptedLogChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the buffered data as the final record. It does not close the
+// underlying writer.
+func (e *logEncryptor) Close() error {
+	return e.seal(true)
+}
+
+func (e *logEncryptor) seal(final bool) error {
+	if e.record == math.MaxUint32 {
+		return errors.New("encrypted log is too large")
+	}
+	additionalData := encryptedLogMore
+	if final {
+		additionalData = encryptedLogFinal
+	}
+	sealed := e.aead.Seal(nil, e.nonce(), e.buf, additionalData)
+	e.record++
+	e.buf = e.buf[:0]
+
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(sealed)))
+	if _, err := e.w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+func (e *logEncryptor) nonce() []byte {
+	nonce := make([]byte, e.aead.NonceSize())
+	copy(nonce, e.prefix)
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], e.record)
+	return nonce
+}
+
+// DecryptLog returns a reader over the decrypted contents of a .bin file
+// written by a FileLogger with an EncryptionKey. Reads fail if the key is
+// wrong, the file was modified, or it is cut off before its final record.
+func DecryptLog(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newLogCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	reader := bufio.NewReader(r)
+	header := make([]byte, len(encryptedLogMagic)+encryptedLogNoncePrefixSize)
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(encryptedLogMagic)]) != encryptedLogMagic {
+		return nil, errors.New("not an encrypted log file")
+	}
+	return &logDecryptor{
+		aead:   aead,
+		r:      reader,
+		prefix: header[len(encryptedLogMagic):],
+	}, nil
+}
+
+type logDecryptor struct {
+	aead   cipher.AEAD
+	r      io.Reader
+	prefix []byte
+	record uint32
+	buf    []byte
+	final  bool
+	err    error
+}
+
+func (d *logDecryptor) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.final {
+			d.err = io.EOF
+			continue
+		}
+		d.err = d.open()
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+// open decrypts the next record into d.buf.
+func (d *logDecryptor) open() error {
+	var length [4]byte
+	if _, err := io.ReadFull(d.r, length[:]); err != nil {
+		return fmt.Errorf("encrypted log is truncated: %w", io.ErrUnexpectedEOF)
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > encryptedLogChunkSize+uint32(d.aead.Overhead()) {
+		return errors.New("encrypted log record is too large")
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("encrypted log is truncated: %w", io.ErrUnexpectedEOF)
+	}
+
+	nonce := make([]byte, d.aead.NonceSize())
+	copy(nonce, d.prefix)
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], d.record)
+	d.record++
+	if plain, err := d.aead.Open(nil, nonce, sealed, encryptedLogMore); err == nil {
+		d.buf = plain
+		return nil
+	}
+	plain, err := d.aead.Open(nil, nonce, sealed, encryptedLogFinal)
+	if err != nil {
+		return errors.New("failed to decrypt log: wrong key or corrupted file")
+	}
+	d.buf = plain
+	d.final = true
+	return nil
+}
@@ -0,0 +1,85 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileLoggerEncryptsLogs(t *testing.T) {
+	logDir := t.TempDir()
+	key := bytes.Repeat([]byte{0x42}, 32)
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir:           logDir,
+		FilenameTemplate: "{{.ID}}_{{.StreamType}}",
+		BufferSize:       4096,
+		EncryptionKey:    key,
+		EncryptPatterns:  []string{"/secret/"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+
+	// Large enough to span several encrypted records
+	body := strings.Repeat("password=hunter2&", 10000)
+	stream := "POST /login HTTP/1.1\r\nContent-Type: application/x-www-form-urlencoded\r\n\r\n" + body
+	timestamp := time.Now()
+	fileLogger.LogRequest(RequestMetadata{ID: "secret", Method: http.MethodPost, Pattern: "/secret/"}, timestamp, io.NopCloser(strings.NewReader(stream)))
+	fileLogger.LogRequest(RequestMetadata{ID: "public", Method: http.MethodPost, Pattern: "/public/"}, timestamp, io.NopCloser(strings.NewReader(stream)))
+
+	raw, err := os.ReadFile(filepath.Join(logDir, "secret_request.bin"))
+	if err != nil {
+		t.Fatalf("Failed to read encrypted log: %v", err)
+	}
+	if bytes.Contains(raw, []byte("hunter2")) || bytes.Contains(raw, []byte("POST /login")) {
+		t.Fatal("Expected the encrypted log not to contain plaintext")
+	}
+	decrypted, err := DecryptLog(bytes.NewReader(raw), key)
+	if err != nil {
+		t.Fatalf("Failed to open encrypted log: %v", err)
+	}
+	plain, err := io.ReadAll(decrypted)
+	if err != nil {
+		t.Fatalf("Failed to decrypt log: %v", err)
+	}
+	if string(plain) != stream {
+		t.Errorf("Expected the decrypted log to match the stream, got %d bytes instead of %d", len(plain), len(stream))
+	}
+	metadata, err := os.ReadFile(filepath.Join(logDir, "secret_request_metadata.json"))
+	if err != nil || !strings.Contains(string(metadata), `"encrypted": true`) {
+		t.Errorf("Expected the metadata to mark the log as encrypted, got %s: %v", metadata, err)
+	}
+
+	public, err := os.ReadFile(filepath.Join(logDir, "public_request.bin"))
+	if err != nil || string(public) != stream {
+		t.Errorf("Expected streams of other routes to stay plaintext: %v", err)
+	}
+
+	// A wrong key or a cut-off file fails instead of returning partial data
+	wrongKey := bytes.Repeat([]byte{0x24}, 32)
+	if decrypted, err := DecryptLog(bytes.NewReader(raw), wrongKey); err == nil {
+		if _, err := io.ReadAll(decrypted); err == nil {
+			t.Error("Expected decryption with the wrong key to fail")
+		}
+	}
+	if decrypted, err := DecryptLog(bytes.NewReader(raw[:len(raw)-100]), key); err == nil {
+		if _, err := io.ReadAll(decrypted); err == nil {
+			t.Error("Expected decryption of a truncated log to fail")
+		}
+	}
+	if _, err := DecryptLog(bytes.NewReader(public), key); err == nil {
+		t.Error("Expected a plaintext log to be rejected")
+	}
+}
+
+func TestFileLoggerRejectsInvalidEncryptionKey(t *testing.T) {
+	_, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: t.TempDir(), EncryptionKey: []byte("short")})
+	if err == nil {
+		t.Fatal("Expected an invalid key length to be rejected")
+	}
+}
@@ -0,0 +1,79 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultFilenameTemplate reproduces the FileLogger's original naming scheme.
+const DefaultFilenameTemplate = "{{.Timestamp}}_{{.ShortID}}_{{.StreamType}}"
+
+// LogFilenameData is the data available to a FileLogger filename template.
+// Metadata fields are promoted, so templates can use {{.Method}},
+// {{.Pattern}} or {{.ResponseStatusCode}} directly. The response status is
+// only known for response streams.
+type LogFilenameData struct {
+	RequestMetadata
+	// Time is the stream timestamp, for custom layouts like {{.Time.Format "2006/01/02"}}.
+	Time time.Time
+	// Timestamp is Time in the default "2006-01-02_15-04-05.000" layout.
+	Timestamp  string
+	ShortID    string
+	StreamType string
+}
+
+func parseFilenameTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultFilenameTemplate
+	}
+	tmpl, err := template.New("filename").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filename template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderFilename executes tmpl and returns a sanitized, slash-separated path
+// relative to the log directory, without extension. "/" in the output creates
+// subdirectories; every other unsafe character is replaced.
+func renderFilename(tmpl *template.Template, data LogFilenameData) (string, error) {
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return "", err
+	}
+
+	var segments []string
+	for _, segment := range strings.Split(rendered.String(), "/") {
+		segment = sanitizeFilenameSegment(segment)
+		if segment == "" || segment == "." || segment == ".." {
+			continue
+		}
+		segments = append(segments, segment)
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("filename template produced an empty name")
+	}
+	return path.Join(segments...), nil
+}
+
+func sanitizeFilenameSegment(segment string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '-' || r == '_' || r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, segment)
+	// Collapse the runs of underscores left by patterns like "/api/"
+	for strings.Contains(sanitized, "__") {
+		sanitized = strings.ReplaceAll(sanitized, "__", "_")
+	}
+	return strings.Trim(sanitized, "_")
+}
@@ -0,0 +1,96 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/textproto"
+	"strings"
+)
+
+// loggedTransferEncodingHeader records the Transfer-Encoding of the original
+// message. Logged bodies are always de-chunked, so the real header is not
+// logged, but this keeps streaming uploads distinguishable from fixed-length
+// ones.
+const loggedTransferEncodingHeader = "X-Original-Transfer-Encoding"
+
+// logHeaderFilter decides which headers are written to logged transcripts.
+// Forwarded traffic always keeps all headers. A nil filter logs every header.
+type logHeaderFilter map[string]struct{}
+
+// newLogHeaderFilter returns a filter that only logs the headers in allowList,
+// or nil if allowList is empty.
+func newLogHeaderFilter(allowList []string) logHeaderFilter {
+	if len(allowList) == 0 {
+		return nil
+	}
+	filter := make(logHeaderFilter, len(allowList))
+	for _, name := range allowList {
+		filter[textproto.CanonicalMIMEHeaderKey(name)] = struct{}{}
+	}
+	return filter
+}
+
+func (f logHeaderFilter) allows(name string) bool {
+	if f == nil {
+		return true
+	}
+	_, ok := f[textproto.CanonicalMIMEHeaderKey(name)]
+	return ok
+}
+
+// writeTransferEncodingMarker adds loggedTransferEncodingHeader to a logged
+// header block if the message used a transfer encoding.
+func writeTransferEncodingMarker(headerBuf *bytes.Buffer, transferEncoding []string) {
+	if len(transferEncoding) > 0 {
+		fmt.Fprintf(headerBuf, "%s: %s\r\n", loggedTransferEncodingHeader, strings.Join(transferEncoding, ", "))
+	}
+}
+
+// loggedHeaderLimits bound the header block of logged transcripts, so a peer
+// sending huge headers cannot make the logger buffer all of them. Forwarded
+// headers are not affected. Zero fields are unlimited.
+type loggedHeaderLimits struct {
+	// maxValue truncates longer header values and status texts.
+	maxValue int
+	// maxBlock is the size of the header block after which further headers
+	// are left out.
+	maxBlock int
+}
+
+// truncate cuts value to maxValue bytes and notes how much was cut.
+func (l loggedHeaderLimits) truncate(value string) string {
+	if l.maxValue <= 0 || len(value) <= l.maxValue {
+		return value
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", value[:l.maxValue], len(value)-l.maxValue)
+}
+
+// block starts writing headers to headerBuf, which already holds the start
+// line.
+func (l loggedHeaderLimits) block(headerBuf *bytes.Buffer) *loggedHeaderBlock {
+	return &loggedHeaderBlock{buf: headerBuf, limits: l}
+}
+
+// loggedHeaderBlock writes the headers of one logged transcript within its
+// limits.
+type loggedHeaderBlock struct {
+	buf     *bytes.Buffer
+	limits  loggedHeaderLimits
+	omitted int
+}
+
+func (b *loggedHeaderBlock) write(name, value string) {
+	value = b.limits.truncate(value)
+	if b.limits.maxBlock > 0 && b.buf.Len()+len(name)+len(value)+4 > b.limits.maxBlock {
+		b.omitted++
+		return
+	}
+	fmt.Fprintf(b.buf, "%s: %s\r\n", name, value)
+}
+
+// close notes the headers that were left out.
+func (b *loggedHeaderBlock) close() {
+	if b.omitted > 0 {
+		fmt.Fprintf(b.buf, "X-Logged-Headers: omitted %d; limit=%d\r\n", b.omitted, b.limits.maxBlock)
+	}
+}
@@ -0,0 +1,73 @@
+package loggingproxy
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// LogLevel selects which of the proxy's own operational messages, such as
+// console request lines and write failures, are printed through the standard
+// log package. It does not affect the logged traffic. The zero value is
+// LogLevelInfo.
+type LogLevel int
+
+const (
+	// LogLevelDebug adds per-request details such as forwarded requests.
+	LogLevelDebug LogLevel = -1
+	// LogLevelInfo prints per-request console lines and errors.
+	LogLevelInfo LogLevel = 0
+	// LogLevelError prints only errors.
+	LogLevelError LogLevel = 1
+)
+
+// ParseLogLevel parses "error", "info" or "debug". An empty string is info.
+func ParseLogLevel(level string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(level)) {
+	case "debug":
+		return LogLevelDebug, nil
+	case "", "info":
+		return LogLevelInfo, nil
+	case "error":
+		return LogLevelError, nil
+	}
+	return LogLevelInfo, fmt.Errorf("invalid log level %q (want error, info or debug)", level)
+}
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelError:
+		return "error"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// levelLogger prints operational messages at or above its level. The zero
+// value prints at LogLevelInfo.
+type levelLogger struct {
+	level LogLevel
+}
+
+// Errorf prints an error, tagged with [error].
+func (l levelLogger) Errorf(format string, args ...any) {
+	l.printf(LogLevelError, "[error] "+format, args...)
+}
+
+func (l levelLogger) Infof(format string, args ...any) {
+	l.printf(LogLevelInfo, format, args...)
+}
+
+func (l levelLogger) Debugf(format string, args ...any) {
+	l.printf(LogLevelDebug, format, args...)
+}
+
+func (l levelLogger) printf(level LogLevel, format string, args ...any) {
+	if level < l.level {
+		return
+	}
+	log.Printf(format, args...)
+}
@@ -0,0 +1,116 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// proxyWithLogLevel proxies one request with console logging at level and
+// returns the console output.
+func proxyWithLogLevel(t *testing.T, level LogLevel) string {
+	t.Helper()
+	var console bytes.Buffer
+	oldOutput := log.Writer()
+	oldFlags := log.Flags()
+	log.SetOutput(&console)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(oldOutput)
+		log.SetFlags(oldFlags)
+	}()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer backend.Close()
+
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: t.TempDir(), Console: true, LogLevel: level})
+	if err != nil {
+		t.Fatalf("failed to create file logger: %v", err)
+	}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{LogLevel: level})
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", fileLogger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	if status, _ := getStatusAndBody(t, testServer.URL+"/api/models"); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	return console.String()
+}
+
+func TestLogLevelFiltersConsoleLines(t *testing.T) {
+	if output := proxyWithLogLevel(t, LogLevelError); output != "" {
+		t.Errorf("expected per-request lines to be suppressed at error level, got:\n%s", output)
+	}
+
+	output := proxyWithLogLevel(t, LogLevelInfo)
+	if !strings.Contains(output, "[request] ") || !strings.Contains(output, "[response] ") {
+		t.Errorf("expected request and response lines at info level, got:\n%s", output)
+	}
+	if strings.Contains(output, "[forward] ") {
+		t.Errorf("expected no debug lines at info level, got:\n%s", output)
+	}
+
+	if output := proxyWithLogLevel(t, LogLevelDebug); !strings.Contains(output, "[forward] ") {
+		t.Errorf("expected forwarded requests at debug level, got:\n%s", output)
+	}
+}
+
+func TestLogLevelAppliesToDecorators(t *testing.T) {
+	var console bytes.Buffer
+	oldOutput := log.Writer()
+	oldFlags := log.Flags()
+	log.SetOutput(&console)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(oldOutput)
+		log.SetFlags(oldFlags)
+	}()
+
+	stream := func() io.ReadCloser { return io.NopCloser(strings.NewReader("GET / HTTP/1.1\r\n\r\n")) }
+	for _, level := range []LogLevel{LogLevelError, LogLevelInfo} {
+		throughputLogger := &ThroughputLogger{LogLevel: level}
+		throughputLogger.LogRequest(RequestMetadata{ID: "one"}, time.Now(), stream())
+	}
+	if lines := strings.Count(console.String(), "[throughput] "); lines != 1 {
+		t.Errorf("expected a throughput line only at info level, got:\n%s", console.String())
+	}
+
+	console.Reset()
+	contractLogger := &ContractLogger{ReportPath: t.TempDir() + "/missing/report.jsonl", LogLevel: LogLevelError}
+	contractLogger.LogRequest(RequestMetadata{ID: "one"}, time.Now(), stream())
+	if output := console.String(); !strings.HasPrefix(output, "[error] Failed to open contract report ") || strings.Count(output, "\n") != 1 {
+		t.Errorf("expected one tagged error line at error level, got:\n%s", output)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for input, expected := range map[string]LogLevel{
+		"":      LogLevelInfo,
+		"info":  LogLevelInfo,
+		"DEBUG": LogLevelDebug,
+		"error": LogLevelError,
+	} {
+		level, err := ParseLogLevel(input)
+		if err != nil || level != expected {
+			t.Errorf("ParseLogLevel(%q) = %v, %v; want %v", input, level, err, expected)
+		}
+	}
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
@@ -0,0 +1,111 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Purge deletes the .bin and metadata files of every logged stream whose
+// RequestMetadata.Subject is subject, and removes their lines from the index.
+// It returns the number of streams deleted. Streams that are still being
+// written when Purge runs are not reliably removed, so purge once traffic for
+// the subject has completed. Other outputs, such as HAR archives or reports,
+// are not touched.
+func (f *FileLogger) Purge(subject string) (int, error) {
+	if subject == "" {
+		return 0, errors.New("purge requires a subject")
+	}
+
+	purged := 0
+	var errs []error
+	walkErr := filepath.WalkDir(f.LogDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), "_metadata.json") {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		var logMetadata fileLogMetadata
+		if json.Unmarshal(data, &logMetadata) != nil || logMetadata.Metadata.Subject != subject {
+			return nil
+		}
+
+		binPath := filepath.Join(f.LogDir, filepath.FromSlash(logMetadata.Filename))
+		if err := os.Remove(binPath); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		purged++
+		return nil
+	})
+	if walkErr != nil {
+		errs = append(errs, walkErr)
+	}
+
+	if err := f.purgeIndex(subject); err != nil {
+		errs = append(errs, err)
+	}
+	return purged, errors.Join(errs...)
+}
+
+// purgeIndex rewrites the index without the lines for subject. The index is
+// replaced atomically under the same lock appendIndex uses.
+func (f *FileLogger) purgeIndex(subject string) error {
+	f.indexMu.Lock()
+	defer f.indexMu.Unlock()
+
+	indexPath := filepath.Join(f.LogDir, FileLogIndexName)
+	data, err := os.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		var entry FileLogIndexEntry
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Subject == subject {
+			continue
+		}
+		kept.Write(scanner.Bytes())
+		kept.WriteByte('\n')
+	}
+	if kept.Len() == len(data) {
+		return nil
+	}
+
+	tmpFile, err := os.CreateTemp(f.LogDir, "."+FileLogIndexName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+	if _, err := tmpFile.Write(kept.Bytes()); err != nil {
+		tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, indexPath)
+}
@@ -0,0 +1,61 @@
+package loggingproxy
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// errLogTimeout fails a log stream that a logger did not finish in time.
+var errLogTimeout = errors.New("logger did not finish before the log timeout")
+
+// logWatchdog abandons logging jobs that run longer than a deadline. A job
+// cannot be stopped from the outside, so an abandoned job keeps its goroutine,
+// but its stream is closed so the proxied stream no longer feeds it, and its
+// worker slot is released.
+type logWatchdog struct {
+	timeout  time.Duration
+	timedOut atomic.Uint64
+}
+
+func newLogWatchdog(timeout time.Duration) *logWatchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	return &logWatchdog{timeout: timeout}
+}
+
+// wrap returns job guarded by the watchdog. If job has not returned within the
+// timeout, stream is closed with errLogTimeout, the timeout is counted and the
+// returned function returns without waiting for job. A nil watchdog returns job
+// unchanged.
+func (w *logWatchdog) wrap(stream *io.PipeReader, job func()) func() {
+	if w == nil {
+		return job
+	}
+	return func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			job()
+		}()
+
+		timer := time.NewTimer(w.timeout)
+		defer timer.Stop()
+		select {
+		case <-done:
+		case <-timer.C:
+			w.timedOut.Add(1)
+			stream.CloseWithError(errLogTimeout)
+		}
+	}
+}
+
+// TimedOut returns the number of logging jobs abandoned by the watchdog.
+func (w *logWatchdog) TimedOut() uint64 {
+	if w == nil {
+		return 0
+	}
+	return w.timedOut.Load()
+}
@@ -0,0 +1,115 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// blockingLogger never reads its streams and only returns once released,
+// simulating a hung remote sink.
+type blockingLogger struct {
+	release chan struct{}
+}
+
+func (l *blockingLogger) LogRequest(_ RequestMetadata, _ time.Time, rawRequestStream io.ReadCloser) {
+	<-l.release
+	rawRequestStream.Close()
+}
+
+func (l *blockingLogger) LogResponse(_ RequestMetadata, _ time.Time, rawResponseStream io.ReadCloser) {
+	<-l.release
+	rawResponseStream.Close()
+}
+
+func TestLogWatchdogClosesStreamAfterTimeout(t *testing.T) {
+	watchdog := newLogWatchdog(20 * time.Millisecond)
+	reader, writer := io.Pipe()
+	release := make(chan struct{})
+	defer close(release)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchdog.wrap(reader, func() { <-release })()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the watchdog to abandon the job")
+	}
+	if _, err := writer.Write([]byte("data")); err != errLogTimeout {
+		t.Fatalf("expected writes to fail with errLogTimeout, got %v", err)
+	}
+	if watchdog.TimedOut() != 1 {
+		t.Fatalf("expected 1 timed out job, got %d", watchdog.TimedOut())
+	}
+}
+
+func TestLogWatchdogLeavesFastJobsAlone(t *testing.T) {
+	watchdog := newLogWatchdog(time.Second)
+	reader, _ := io.Pipe()
+	ran := false
+	watchdog.wrap(reader, func() { ran = true })()
+	if !ran || watchdog.TimedOut() != 0 {
+		t.Fatalf("expected the job to run without timing out, ran=%v timed out=%d", ran, watchdog.TimedOut())
+	}
+}
+
+func TestLogTimeoutAbandonsHungLogger(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	const logTimeout = 100 * time.Millisecond
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:       HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		MaxConcurrentLogs: 2,
+		LogTimeout:        logTimeout,
+	})
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	logger := &blockingLogger{release: make(chan struct{})}
+	defer close(logger.release)
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	// The second request only gets logging slots if the first request's hung
+	// logging goroutines were abandoned.
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		resp, err := http.Post(testServer.URL+"/api/upload", "text/plain", strings.NewReader("payload"))
+		if err != nil {
+			t.Fatalf("request %d failed: %v", i, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "ok" {
+			t.Fatalf("request %d: expected 200 ok, got %d %q", i, resp.StatusCode, body)
+		}
+		if elapsed := time.Since(start); elapsed > 5*logTimeout {
+			t.Fatalf("request %d took %v, expected the hung logger to be abandoned after %v", i, elapsed, logTimeout)
+		}
+
+		want := uint64(2 * (i + 1))
+		for deadline := time.Now().Add(time.Second); proxyServer.TimedOutLogs() < want && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := proxyServer.TimedOutLogs(); got != want {
+			t.Fatalf("after request %d: expected %d timed out logs, got %d", i, want, got)
+		}
+	}
+	if proxyServer.DroppedLogs() != 0 {
+		t.Fatalf("expected abandoned loggers to release their slots, got %d dropped logs", proxyServer.DroppedLogs())
+	}
+}
@@ -0,0 +1,66 @@
+package loggingproxy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// logWorkerPool bounds the number of concurrently running logging goroutines.
+// A logging job reads from a pipe that the proxied stream writes into, so a job
+// cannot sit in a queue without stalling the hot path. Instead a job is either
+// started immediately on a free slot or dropped.
+type logWorkerPool struct {
+	slots   chan struct{}
+	timeout time.Duration
+	dropped atomic.Uint64
+}
+
+func newLogWorkerPool(workers int, timeout time.Duration) *logWorkerPool {
+	if workers <= 0 {
+		return nil
+	}
+	return &logWorkerPool{
+		slots:   make(chan struct{}, workers),
+		timeout: timeout,
+	}
+}
+
+// Go runs job on a free worker slot. If no slot frees up within the pool's
+// timeout, the job is dropped and Go returns false. A nil pool is unbounded.
+func (p *logWorkerPool) Go(job func()) bool {
+	if p == nil {
+		go job()
+		return true
+	}
+
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		if p.timeout <= 0 {
+			p.dropped.Add(1)
+			return false
+		}
+		timer := time.NewTimer(p.timeout)
+		defer timer.Stop()
+		select {
+		case p.slots <- struct{}{}:
+		case <-timer.C:
+			p.dropped.Add(1)
+			return false
+		}
+	}
+
+	go func() {
+		defer func() { <-p.slots }()
+		job()
+	}()
+	return true
+}
+
+// Dropped returns the number of logging jobs dropped because the pool was full.
+func (p *logWorkerPool) Dropped() uint64 {
+	if p == nil {
+		return 0
+	}
+	return p.dropped.Load()
+}
@@ -0,0 +1,152 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowLogger drains each stream and then holds its logging goroutine for a while,
+// simulating a slow remote sink.
+type slowLogger struct {
+	delay     time.Duration
+	active    atomic.Int64
+	maxActive atomic.Int64
+	completed atomic.Int64
+}
+
+func (l *slowLogger) log(stream io.ReadCloser) {
+	defer stream.Close()
+	active := l.active.Add(1)
+	defer l.active.Add(-1)
+	for {
+		maxActive := l.maxActive.Load()
+		if active <= maxActive || l.maxActive.CompareAndSwap(maxActive, active) {
+			break
+		}
+	}
+	io.Copy(io.Discard, stream)
+	time.Sleep(l.delay)
+	l.completed.Add(1)
+}
+
+func (l *slowLogger) LogRequest(_ RequestMetadata, _ time.Time, rawRequestStream io.ReadCloser) {
+	l.log(rawRequestStream)
+}
+
+func (l *slowLogger) LogResponse(_ RequestMetadata, _ time.Time, rawResponseStream io.ReadCloser) {
+	l.log(rawResponseStream)
+}
+
+func TestLogWorkerPoolDropsWhenFull(t *testing.T) {
+	pool := newLogWorkerPool(1, 0)
+	release := make(chan struct{})
+	if !pool.Go(func() { <-release }) {
+		t.Fatal("expected first job to start")
+	}
+	if pool.Go(func() {}) {
+		t.Fatal("expected second job to be dropped while the pool is full")
+	}
+	if pool.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped job, got %d", pool.Dropped())
+	}
+	close(release)
+}
+
+func TestLogWorkerPoolWaitsForFreeSlot(t *testing.T) {
+	pool := newLogWorkerPool(1, time.Second)
+	release := make(chan struct{})
+	pool.Go(func() { <-release })
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+	}()
+
+	done := make(chan struct{})
+	if !pool.Go(func() { close(done) }) {
+		t.Fatal("expected job to wait for a free slot instead of being dropped")
+	}
+	<-done
+	if pool.Dropped() != 0 {
+		t.Fatalf("expected no dropped jobs, got %d", pool.Dropped())
+	}
+}
+
+func TestMaxConcurrentLogsBoundsLoggingGoroutines(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	const maxConcurrentLogs = 4
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:       HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		MaxConcurrentLogs: maxConcurrentLogs,
+	})
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	logger := &slowLogger{delay: 500 * time.Millisecond}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	baseline := runtime.NumGoroutine()
+
+	const requests = 100
+	client := &http.Client{Transport: &http.Transport{}}
+	var wg sync.WaitGroup
+	var failures atomic.Int64
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Post(testServer.URL+"/api/flood", "text/plain", strings.NewReader("payload"))
+			if err != nil {
+				failures.Add(1)
+				return
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				failures.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// All requests are done, but the slow logger still holds its goroutines.
+	// Unbounded logging would leave two sleeping goroutines per request here.
+	// Connection goroutines wind down asynchronously once idle connections are
+	// closed, so give them a moment while the logger is still sleeping.
+	client.CloseIdleConnections()
+	extraGoroutines := runtime.NumGoroutine() - baseline
+	for deadline := time.Now().Add(250 * time.Millisecond); extraGoroutines >= requests && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		extraGoroutines = runtime.NumGoroutine() - baseline
+	}
+
+	if failures.Load() != 0 {
+		t.Fatalf("expected all proxied requests to succeed, got %d failures", failures.Load())
+	}
+	if maxActive := logger.maxActive.Load(); maxActive > maxConcurrentLogs {
+		t.Fatalf("expected at most %d concurrent logging goroutines, got %d", maxConcurrentLogs, maxActive)
+	}
+	if proxyServer.DroppedLogs() == 0 {
+		t.Fatal("expected some logs to be dropped under load")
+	}
+	if extraGoroutines >= requests {
+		t.Fatalf("expected goroutine count to stay bounded, got %d above baseline for %d requests", extraGoroutines, requests)
+	}
+}
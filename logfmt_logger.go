@@ -0,0 +1,196 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// LogfmtLoggerOptions configures a LogfmtLogger.
+type LogfmtLoggerOptions struct {
+	// Writer receives one line per logged stream. Nil writes to os.Stdout.
+	Writer io.Writer
+	// Logger receives every stream as well, typically a FileLogger. Nil only
+	// writes the lines.
+	Logger Logger
+	// BodyPreview adds the first this many bytes of the (decompressed) body
+	// as body=... Zero leaves the body out.
+	BodyPreview int
+}
+
+// LogfmtLogger writes one logfmt line per logged request and response, such
+// as
+//
+//	ts=2026-01-02T03:04:05Z id=... dir=request method=POST src=... dst=... bytes=42
+//
+// for grepping during local debugging. Responses add status=, and streams
+// that could not be read completely add error=. Values with spaces, quotes or
+// other special characters are quoted as Go strings. Lines are written when a
+// stream ends, so they are ordered by completion.
+type LogfmtLogger struct {
+	writer      io.Writer
+	logger      Logger
+	bodyPreview int
+
+	mu sync.Mutex
+}
+
+// NewLogfmtLogger returns a LogfmtLogger.
+func NewLogfmtLogger(options LogfmtLoggerOptions) *LogfmtLogger {
+	writer := options.Writer
+	if writer == nil {
+		writer = os.Stdout
+	}
+	return &LogfmtLogger{
+		writer:      writer,
+		logger:      options.Logger,
+		bodyPreview: options.BodyPreview,
+	}
+}
+
+// LogRequest forwards the request stream and writes its line
+func (l *LogfmtLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	l.writeLine(metadata, timestamp, "request", rawRequestStream, forwardTo(l.logger, "request", metadata, timestamp))
+}
+
+// LogResponse forwards the response stream and writes its line
+func (l *LogfmtLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	l.writeLine(metadata, timestamp, "response", rawResponseStream, forwardTo(l.logger, "response", metadata, timestamp))
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *LogfmtLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(l.logger, metadata, timestamp)
+}
+
+// Close closes the wrapped logger if it implements io.Closer.
+func (l *LogfmtLogger) Close() error {
+	return closeWrapped(l.logger)
+}
+
+func (l *LogfmtLogger) writeLine(metadata RequestMetadata, timestamp time.Time, direction string, stream io.ReadCloser, forward func(io.ReadCloser)) {
+	body := &logfmtBody{preview: l.bodyPreview}
+	err := copyLoggedStream(stream, body, forward)
+
+	var line bytes.Buffer
+	writeLogfmtField(&line, "ts", timestamp.UTC().Format(time.RFC3339Nano))
+	writeLogfmtField(&line, "id", metadata.ID)
+	writeLogfmtField(&line, "dir", direction)
+	writeLogfmtField(&line, "method", metadata.Method)
+	writeLogfmtField(&line, "src", metadata.SourceURL)
+	writeLogfmtField(&line, "dst", metadata.DestinationURL)
+	if direction == "response" {
+		writeLogfmtField(&line, "status", strconv.Itoa(metadata.ResponseStatusCode))
+	}
+	writeLogfmtField(&line, "bytes", strconv.FormatInt(body.size, 10))
+	if metadata.Blocked {
+		writeLogfmtField(&line, "blocked", "true")
+	}
+	if err != nil {
+		writeLogfmtField(&line, "error", err.Error())
+	}
+	if l.bodyPreview > 0 && body.size > 0 {
+		writeLogfmtField(&line, "body", body.previewText())
+	}
+	line.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writer.Write(line.Bytes())
+}
+
+// copyLoggedStream passes stream to forward, if set, and copies it to w,
+// returning the error that ended the stream early.
+func copyLoggedStream(stream io.ReadCloser, w io.Writer, forward func(io.ReadCloser)) error {
+	defer stream.Close()
+	var streamErr error
+	tee := io.TeeReader(&errorRecorder{Reader: stream, err: &streamErr}, w)
+	if forward != nil {
+		// The wrapped logger closes its stream; keep ours open to drain the rest
+		forward(&readCloser{Reader: tee, Closer: io.NopCloser(nil)})
+	}
+	io.Copy(io.Discard, tee)
+	return streamErr
+}
+
+// errorRecorder remembers the first error other than io.EOF returned by
+// Reader.
+type errorRecorder struct {
+	io.Reader
+	err *error
+}
+
+func (r *errorRecorder) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) && *r.err == nil {
+		*r.err = err
+	}
+	return n, err
+}
+
+// logfmtBody skips the header block of a logged stream and counts the body,
+// keeping the first preview bytes of it.
+type logfmtBody struct {
+	preview int
+	// separator counts the bytes of "\r\n\r\n" matched so far, 4 once the
+	// header block is over.
+	separator int
+	size      int64
+	buf       bytes.Buffer
+}
+
+func (b *logfmtBody) Write(p []byte) (int, error) {
+	written := len(p)
+	for b.separator < 4 && len(p) > 0 {
+		if p[0] == "\r\n\r\n"[b.separator] {
+			b.separator++
+		} else if p[0] == '\r' {
+			b.separator = 1
+		} else {
+			b.separator = 0
+		}
+		p = p[1:]
+	}
+	b.size += int64(len(p))
+	if room := b.preview - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(room, len(p))])
+	}
+	return written, nil
+}
+
+// previewText returns the preview, marking a body that was cut off.
+func (b *logfmtBody) previewText() string {
+	preview := b.buf.Bytes()
+	if int64(len(preview)) < b.size {
+		// Do not end the preview in the middle of a UTF-8 sequence
+		for i := 1; i < utf8.UTFMax && len(preview) > 0 && !utf8.Valid(preview); i++ {
+			preview = preview[:len(preview)-1]
+		}
+		return string(preview) + "..."
+	}
+	return string(preview)
+}
+
+// writeLogfmtField appends key=value, quoting the value if logfmt needs it.
+func writeLogfmtField(line *bytes.Buffer, key, value string) {
+	if line.Len() > 0 {
+		line.WriteByte(' ')
+	}
+	line.WriteString(key)
+	line.WriteByte('=')
+	if strings.IndexFunc(value, needsLogfmtQuoting) >= 0 || !utf8.ValidString(value) {
+		line.WriteString(strconv.Quote(value))
+	} else {
+		line.WriteString(value)
+	}
+}
+
+func needsLogfmtQuoting(r rune) bool {
+	return r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || r == 0x7f
+}
@@ -0,0 +1,105 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parseLogfmt splits a logfmt line into its fields.
+func parseLogfmt(t *testing.T, line string) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for line != "" {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			t.Fatalf("Field without value in %q", line)
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				t.Fatalf("Invalid quoted value in %q: %v", rest, err)
+			}
+			value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			value, rest, _ = strings.Cut(rest, " ")
+			rest = " " + rest
+		}
+		fields[key] = value
+		line = strings.TrimPrefix(rest, " ")
+	}
+	return fields
+}
+
+func TestLogfmtLoggerWritesOneParsableLinePerStream(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created with spaces and \"quotes\"")
+	}))
+	defer backend.Close()
+
+	var output bytes.Buffer
+	testLogger := &TestLogger{}
+	logfmtLogger := NewLogfmtLogger(LogfmtLoggerOptions{Writer: &output, Logger: testLogger, BodyPreview: 12})
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logfmtLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Post(testServer.URL+"/api/items?q=a%20b", "text/plain", strings.NewReader("hello=world"))
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	lines := strings.Split(strings.TrimSuffix(output.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %q", output.String())
+	}
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 || !strings.HasSuffix(testLogger.requests[0].content, "hello=world") {
+		t.Errorf("Expected the wrapped logger to get both full streams")
+	}
+
+	fields := map[string]map[string]string{}
+	for _, line := range lines {
+		parsed := parseLogfmt(t, line)
+		fields[parsed["dir"]] = parsed
+	}
+	request, response := fields["request"], fields["response"]
+	if request == nil || response == nil {
+		t.Fatalf("Expected a request and a response line, got %q", lines)
+	}
+	if request["id"] == "" || request["id"] != response["id"] {
+		t.Errorf("Expected both lines to have the request ID, got %q and %q", request["id"], response["id"])
+	}
+	if _, err := time.Parse(time.RFC3339Nano, request["ts"]); err != nil {
+		t.Errorf("Expected an RFC 3339 timestamp, got %q", request["ts"])
+	}
+	if request["method"] != "POST" || request["dst"] != backend.URL+"/items?q=a%20b" || !strings.HasSuffix(request["src"], "/api/items?q=a%20b") {
+		t.Errorf("Unexpected request fields: %v", request)
+	}
+	if request["bytes"] != "11" || request["body"] != "hello=world" {
+		t.Errorf("Expected the request body size and preview, got %q and %q", request["bytes"], request["body"])
+	}
+	if _, ok := request["status"]; ok {
+		t.Errorf("Expected no status on the request line")
+	}
+	if response["status"] != "201" || response["bytes"] != "32" || response["body"] != "created with..." {
+		t.Errorf("Unexpected response fields: %v", response)
+	}
+	if !strings.Contains(lines[0]+lines[1], `body="created with..."`) {
+		t.Errorf("Expected values with spaces to be quoted, got %q", lines)
+	}
+}
@@ -9,18 +9,71 @@ import (
 type RequestMetadata struct {
 	ID                       string     `json:"id"`
 	Pattern                  string     `json:"pattern"`
+	DestinationTemplate      string     `json:"destination_template,omitempty"`
 	Method                   string     `json:"method"`
 	SourceURL                string     `json:"source_url"`
 	DestinationURL           string     `json:"target_url"`
+	BackendFailover          bool       `json:"backend_failover,omitempty"`
+	Mirror                   bool       `json:"mirror,omitempty"`
+	MirrorOf                 string     `json:"mirror_of,omitempty"`
+	FinalURL                 string     `json:"final_url,omitempty"`
+	EventStream              string     `json:"event_stream,omitempty"`
+	LastEventID              string     `json:"last_event_id,omitempty"`
+	ClientProto              string     `json:"client_proto,omitempty"`
+	ClientTLSVersion         string     `json:"client_tls_version,omitempty"`
+	ClientALPN               string     `json:"client_alpn,omitempty"`
 	RequestStartedAt         time.Time  `json:"request_started_at"`
+	UpstreamTimeoutMS        int64      `json:"upstream_timeout_ms,omitempty"`
+	TraceID                  string     `json:"trace_id,omitempty"`
+	SpanID                   string     `json:"span_id,omitempty"`
+	Subject                  string     `json:"subject,omitempty"`
+	LoggingEnabled           bool       `json:"logging_enabled"`
+	LoggingSource            string     `json:"logging_source,omitempty"`
+	Blocked                  bool       `json:"blocked,omitempty"`
+	BlockedReason            string     `json:"blocked_reason,omitempty"`
+	InvalidBody              bool       `json:"invalid_body,omitempty"`
+	InjectedFaults           []string   `json:"injected_faults,omitempty"`
 	UpstreamResponseAt       *time.Time `json:"upstream_response_at,omitempty"`
 	UpstreamHeaderDurationMS int64      `json:"upstream_header_duration_ms,omitempty"`
+	ConnReused               bool       `json:"conn_reused"`
+	ConnIdleTimeMS           int64      `json:"conn_idle_time_ms,omitempty"`
 	ResponseStatus           string     `json:"response_status,omitempty"`
 	ResponseStatusCode       int        `json:"response_status_code,omitempty"`
+	ClientStatusCode         int        `json:"client_status_code,omitempty"`
+	Fallback                 bool       `json:"fallback,omitempty"`
+	FallbackReason           string     `json:"fallback_reason,omitempty"`
+	Deduplicated             bool       `json:"deduplicated,omitempty"`
+	DeduplicatedFrom         string     `json:"deduplicated_from,omitempty"`
+	UpstreamError            string     `json:"upstream_error,omitempty"`
+	UpstreamErrorAfterMS     int64      `json:"upstream_error_after_ms,omitempty"`
 	RequestContentEncoding   string     `json:"request_content_encoding,omitempty"`
 	ResponseContentEncoding  string     `json:"response_content_encoding,omitempty"`
+	// Delivery is set for responses forwarded from a backend. It is shared
+	// by all copies of the metadata, so it is complete once the response log
+	// stream has ended.
+	Delivery *ResponseDelivery `json:"delivery,omitempty"`
+	// Tags holds the fields extracted from the request body for
+	// RouteOptions.BodyTags, by tag name.
+	Tags map[string]string `json:"tags,omitempty"`
+	// UnsupportedFeatures notes protocol features of the request that the
+	// proxy dropped before forwarding it, such as "upgrade: websocket".
+	UnsupportedFeatures []string `json:"unsupported_features,omitempty"`
 }
 
+// RequestMetadata.LoggingSource tells whether a route's logging was set for
+// the route itself or follows the proxy's default.
+const (
+	LoggingSourceRoute   = "route"
+	LoggingSourceDefault = "default"
+)
+
+// RequestMetadata.EventStream tells whether a Server-Sent Events request
+// starts a new subscription or resumes one after a disconnect.
+const (
+	EventStreamSubscribe = "subscribe"
+	EventStreamReconnect = "reconnect"
+)
+
 // Logger interface for dependency injection of logging functionality
 type Logger interface {
 	// LogRequest logs a request with its metadata and raw HTTP stream
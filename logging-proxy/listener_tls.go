@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	loggingproxy "github.com/mrexodia/logging-proxy"
+)
+
+// ServerTLSConfig is server.tls and terminates TLS on the reverse proxy
+// listener. The certificate and key are PEM files that are reloaded when they
+// change on disk or the process receives SIGHUP.
+type ServerTLSConfig struct {
+	Cert string `yaml:"cert"`
+	Key  string `yaml:"key"`
+	// RedirectPort starts a plain HTTP listener on server.host that redirects
+	// every request to the HTTPS listener. Zero disables it.
+	RedirectPort int `yaml:"redirect_port"`
+}
+
+// reverseListeners builds the reverse proxy listener for handler, serving
+// TLS when server.tls is configured, and the optional redirect listener. The
+// certificate reloader is nil without TLS.
+func reverseListeners(config *ServerConfig, handler http.Handler) ([]namedServer, *loggingproxy.CertificateReloader, error) {
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	reverse := namedServer{name: "reverse", server: newListenerServer(addr, handler, config.ListenerTimeouts)}
+	if config.TLS == nil {
+		return []namedServer{reverse}, nil, nil
+	}
+	if config.TLS.Cert == "" || config.TLS.Key == "" {
+		return nil, nil, errors.New("server.tls requires both cert and key")
+	}
+	if config.TLS.RedirectPort == config.Port {
+		return nil, nil, fmt.Errorf("server.tls.redirect_port %d is the HTTPS port", config.Port)
+	}
+	certificates, err := loggingproxy.NewCertificateReloader(config.TLS.Cert, config.TLS.Key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("server.tls: %w", err)
+	}
+	reverse.server.TLSConfig = certificates.TLSConfig()
+	servers := []namedServer{reverse}
+	if config.TLS.RedirectPort != 0 {
+		redirectAddr := net.JoinHostPort(config.Host, strconv.Itoa(config.TLS.RedirectPort))
+		servers = append(servers, namedServer{
+			name:   "redirect",
+			server: newListenerServer(redirectAddr, httpsRedirect(config.Port), config.ListenerTimeouts),
+		})
+	}
+	return servers, certificates, nil
+}
+
+// httpsRedirect redirects requests to the same host and path on the HTTPS
+// port. 308 makes clients repeat the method and body.
+func httpsRedirect(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(host); err == nil {
+			host = hostname
+		}
+		host = strings.TrimSuffix(net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port)), ":443")
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// listenAndServe serves s on its address.
+func (s namedServer) listenAndServe() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	return s.serve(listener)
+}
+
+// serve serves s on listener, with TLS when the server has a TLS config.
+func (s namedServer) serve(listener net.Listener) error {
+	if s.server.TLSConfig != nil {
+		return s.server.ServeTLS(listener, "", "")
+	}
+	return s.server.Serve(listener)
+}
+
+// reloadCertificatesOnSIGHUP reloads certificates whenever the process
+// receives SIGHUP, in addition to the reload on file changes.
+func reloadCertificatesOnSIGHUP(certificates *loggingproxy.CertificateReloader) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	for range hangups {
+		if err := certificates.Reload(); err != nil {
+			log.Printf("[tls] keeping the previous certificate: %v", err)
+			continue
+		}
+		log.Printf("[tls] reloaded certificate on SIGHUP")
+	}
+}
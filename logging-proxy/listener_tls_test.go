@@ -0,0 +1,173 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	loggingproxy "github.com/mrexodia/logging-proxy"
+)
+
+// sourceURLLogger reports the SourceURL of every logged request.
+type sourceURLLogger struct {
+	sourceURLs chan string
+}
+
+func (l sourceURLLogger) LogRequest(metadata loggingproxy.RequestMetadata, timestamp time.Time, stream io.ReadCloser) {
+	io.Copy(io.Discard, stream)
+	stream.Close()
+	l.sourceURLs <- metadata.SourceURL
+}
+
+func (l sourceURLLogger) LogResponse(metadata loggingproxy.RequestMetadata, timestamp time.Time, stream io.ReadCloser) {
+	io.Copy(io.Discard, stream)
+	stream.Close()
+}
+
+// writeSelfSignedCertificate writes a certificate for 127.0.0.1 and its key
+// to dir and returns the file names and the certificate.
+func writeSelfSignedCertificate(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "logging-proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	certFile := filepath.Join(dir, "proxy.pem")
+	keyFile := filepath.Join(dir, "proxy-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	return certFile, keyFile, certificate
+}
+
+func TestReverseProxyTerminatesTLS(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "backend %s", r.URL.Path)
+	}))
+	defer backend.Close()
+
+	certFile, keyFile, certificate := writeSelfSignedCertificate(t, t.TempDir())
+	configFile := writeTestConfig(t, fmt.Sprintf(`
+server:
+  host: 127.0.0.1
+  port: 5601
+  tls:
+    cert: %q
+    key: %q
+    redirect_port: 5602
+logging:
+  enabled: true
+routes:
+  api:
+    pattern: "/api/"
+    destination: %q
+`, certFile, keyFile, backend.URL+"/"))
+	config, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	logger := sourceURLLogger{sourceURLs: make(chan string, 1)}
+	handler, err := buildReverseProxy(config, logger, loggingproxy.HTTPClientProxyConfig{}, nil, adminEndpoints{})
+	if err != nil {
+		t.Fatalf("failed to build proxy: %v", err)
+	}
+	servers, certificates, err := reverseListeners(config.Server, handler)
+	if err != nil {
+		t.Fatalf("failed to build listeners: %v", err)
+	}
+	if certificates == nil || len(servers) != 2 || servers[0].name != "reverse" || servers[1].name != "redirect" {
+		t.Fatalf("expected a TLS reverse listener and a redirect listener, got %+v", servers)
+	}
+	addrs := make([]string, len(servers))
+	for i, srv := range servers {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("failed to listen: %v", err)
+		}
+		go srv.serve(listener)
+		defer srv.server.Close()
+		addrs[i] = listener.Addr().String()
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get("https://" + addrs[0] + "/api/models")
+	if err != nil {
+		t.Fatalf("HTTPS request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "backend /models" {
+		t.Fatalf("expected the backend response, got %d %q", resp.StatusCode, body)
+	}
+	select {
+	case sourceURL := <-logger.sourceURLs:
+		if expected := "https://" + addrs[0] + "/api/models"; sourceURL != expected {
+			t.Errorf("expected the logged source URL %q, got %q", expected, sourceURL)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to be logged")
+	}
+
+	resp, err = client.Post("http://"+addrs[1]+"/api/chat?stream=true", "application/json", nil)
+	if err != nil {
+		t.Fatalf("redirect request failed: %v", err)
+	}
+	resp.Body.Close()
+	if location := resp.Header.Get("Location"); resp.StatusCode != http.StatusPermanentRedirect || location != "https://127.0.0.1:5601/api/chat?stream=true" {
+		t.Errorf("expected a redirect to the HTTPS listener, got %d %q", resp.StatusCode, location)
+	}
+}
+
+func TestReverseListenersRequireCertificateAndKey(t *testing.T) {
+	config := &ServerConfig{Host: "127.0.0.1", Port: 5601, TLS: &ServerTLSConfig{Cert: "proxy.pem"}}
+	if _, _, err := reverseListeners(config, http.NotFoundHandler()); err == nil {
+		t.Fatal("expected server.tls without a key to be rejected")
+	}
+}
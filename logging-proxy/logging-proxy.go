@@ -1,14 +1,23 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	loggingproxy "github.com/mrexodia/logging-proxy"
 	"golang.org/x/net/http/httpproxy"
@@ -22,13 +31,328 @@ import (
 //   - "/" is a catch-all that matches everything
 //   - Go ServeMux supports wildcards, but this proxy currently rejects named
 //     wildcards like "{id}" and "{path...}" in configured patterns
-//   - The special end-anchor pattern "{$}" is still allowed
+//   - The special end-anchor pattern "{$}" is still allowed, so "/healthz/{$}"
+//     matches only "/healthz/"; setting Exact does the same for "/healthz/"
 //
 // Logging defaults to logging.enabled unless explicitly overridden per-route.
 type Route struct {
 	Pattern     string `yaml:"pattern"`
 	Destination string `yaml:"destination"`
 	Logging     *bool  `yaml:"logging"`
+	Exact       bool   `yaml:"exact"`
+	// PreservePath forwards the full request path instead of stripping the
+	// pattern prefix.
+	PreservePath bool `yaml:"preserve_path"`
+	// StripSegments drops leading path segments before they are appended.
+	StripSegments int `yaml:"strip_segments"`
+	// CompressRequests gzips request bodies sent to the backend.
+	CompressRequests bool `yaml:"compress_requests"`
+	// NegotiateCompression requests compressed responses for clients that do
+	// not, and decompresses them for the client.
+	NegotiateCompression bool `yaml:"negotiate_compression"`
+	// CompressResponses compresses uncompressed responses of at least
+	// CompressMinSize bytes for clients that accept gzip or br.
+	CompressResponses bool  `yaml:"compress_responses"`
+	CompressMinSize   int64 `yaml:"compress_min_size"`
+	// MaxInFlight caps concurrent upstream requests; excess requests wait up
+	// to InFlightQueueTimeout and are then rejected with 503.
+	MaxInFlight          int           `yaml:"max_in_flight"`
+	InFlightQueueTimeout time.Duration `yaml:"in_flight_queue_timeout"`
+	// ClientTLS overrides server.client_tls for this route.
+	ClientTLS *ClientTLSConfig `yaml:"client_tls"`
+	// AddressOverride adds to server.address_override for this route.
+	AddressOverride map[string]string `yaml:"address_override"`
+	// CORS overrides server.cors for this route.
+	CORS *CORSConfig `yaml:"cors"`
+	// AllowedMethods answers other request methods with 405.
+	AllowedMethods []string `yaml:"allowed_methods"`
+	// MaxRequestBytes answers larger request bodies with 413.
+	MaxRequestBytes int64 `yaml:"max_request_bytes"`
+	// CanonicalHeaders forwards and logs header names in canonical form.
+	CanonicalHeaders bool `yaml:"canonical_headers"`
+	// StatusMap rewrites upstream status codes sent to the client.
+	StatusMap map[int]int `yaml:"status_map"`
+	// Fallback is served when the backend cannot be reached.
+	Fallback *FallbackConfig `yaml:"fallback"`
+	// BodyCapture is "full", "headers", "none" or "truncated:N".
+	BodyCapture string `yaml:"body_capture"`
+	// SkipLargeBodies logs only the headers of larger or binary responses.
+	SkipLargeBodies int64 `yaml:"skip_large_bodies"`
+	// RequestSchema is a JSON Schema file that JSON request bodies must match.
+	RequestSchema        string `yaml:"request_schema"`
+	RequestSchemaMaxBody int64  `yaml:"request_schema_max_body"`
+	// BodyTags records fields of JSON request bodies as metadata tags.
+	BodyTags        map[string]string `yaml:"body_tags"`
+	BodyTagsMaxBody int64             `yaml:"body_tags_max_body"`
+	// EncryptLogs limits logging.encryption to the routes that set it.
+	EncryptLogs bool `yaml:"encrypt_logs"`
+	// UpstreamAuth injects the Authorization header sent to the backend.
+	UpstreamAuth *UpstreamAuthConfig `yaml:"upstream_auth"`
+	// RequestFilter and ResponseFilter pipe bodies through a command. They
+	// need server.allow_filter_commands.
+	RequestFilter  *BodyFilterConfig `yaml:"request_filter"`
+	ResponseFilter *BodyFilterConfig `yaml:"response_filter"`
+	// FaultInjection adds synthetic latency, errors and resets. It needs
+	// server.allow_fault_injection.
+	FaultInjection *FaultInjectionConfig `yaml:"fault_injection"`
+	// Idempotency answers repeated idempotency keys from a cache.
+	Idempotency *IdempotencyConfig `yaml:"idempotency"`
+	// Backends replace Destination to spread requests over several
+	// destinations by weight. A backend that cannot be reached is skipped
+	// for BackendFailTimeout.
+	Backends           []BackendConfig `yaml:"backends"`
+	BackendFailTimeout time.Duration   `yaml:"backend_fail_timeout"`
+	// HealthCheck probes the backends and takes those that are down out of
+	// rotation.
+	HealthCheck *HealthCheckConfig `yaml:"health_check"`
+	// MirrorTo receives a copy of requests with bodies of up to
+	// MirrorMaxBody bytes; its responses are discarded unless LogMirror.
+	MirrorTo      string `yaml:"mirror_to"`
+	MirrorMaxBody int64  `yaml:"mirror_max_body"`
+	LogMirror     bool   `yaml:"log_mirror"`
+	// MirrorCompare records how mirrored responses differ from the primary
+	// responses.
+	MirrorCompare *MirrorCompareConfig `yaml:"mirror_compare"`
+}
+
+// MirrorCompareConfig writes a JSON line to DiffFile per mirrored response
+// that differs from the primary response.
+type MirrorCompareConfig struct {
+	DiffFile      string   `yaml:"diff_file"`
+	IgnoreHeaders []string `yaml:"ignore_headers"`
+	SortJSONKeys  bool     `yaml:"sort_json_keys"`
+	MaxBody       int64    `yaml:"max_body"`
+}
+
+func (config *MirrorCompareConfig) toLibrary() *loggingproxy.MirrorComparison {
+	if config == nil {
+		return nil
+	}
+	return &loggingproxy.MirrorComparison{
+		Path:          config.DiffFile,
+		IgnoreHeaders: config.IgnoreHeaders,
+		SortJSONKeys:  config.SortJSONKeys,
+		MaxBody:       config.MaxBody,
+	}
+}
+
+// FaultInjectionConfig injects faults into a route for resilience testing.
+type FaultInjectionConfig struct {
+	Latency       time.Duration `yaml:"latency"`
+	LatencyJitter time.Duration `yaml:"latency_jitter"`
+	ErrorRate     float64       `yaml:"error_rate"`
+	ErrorStatus   int           `yaml:"error_status"`
+	ResetRate     float64       `yaml:"reset_rate"`
+	Seed          uint64        `yaml:"seed"`
+}
+
+func (config *FaultInjectionConfig) toLibrary() *loggingproxy.FaultInjection {
+	if config == nil {
+		return nil
+	}
+	return &loggingproxy.FaultInjection{
+		Latency:       config.Latency,
+		LatencyJitter: config.LatencyJitter,
+		ErrorRate:     config.ErrorRate,
+		ErrorStatus:   config.ErrorStatus,
+		ResetRate:     config.ResetRate,
+		Seed:          config.Seed,
+	}
+}
+
+// IdempotencyConfig stores responses by idempotency key.
+type IdempotencyConfig struct {
+	Header     string        `yaml:"header"`
+	TTL        time.Duration `yaml:"ttl"`
+	MaxEntries int           `yaml:"max_entries"`
+	MaxBody    int64         `yaml:"max_body"`
+}
+
+func (config *IdempotencyConfig) toLibrary() *loggingproxy.IdempotencyConfig {
+	if config == nil {
+		return nil
+	}
+	return &loggingproxy.IdempotencyConfig{
+		Header:     config.Header,
+		TTL:        config.TTL,
+		MaxEntries: config.MaxEntries,
+		MaxBody:    config.MaxBody,
+	}
+}
+
+// HealthCheckConfig probes every backend of a route at Path.
+type HealthCheckConfig struct {
+	Path     string        `yaml:"path"`
+	Interval time.Duration `yaml:"interval"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+func (config *HealthCheckConfig) toLibrary() *loggingproxy.HealthCheck {
+	if config == nil {
+		return nil
+	}
+	return &loggingproxy.HealthCheck{
+		Path:     config.Path,
+		Interval: config.Interval,
+		Timeout:  config.Timeout,
+	}
+}
+
+// BackendConfig is one destination of a load balanced route.
+type BackendConfig struct {
+	Destination string `yaml:"destination"`
+	Weight      int    `yaml:"weight"`
+}
+
+// describeBackends lists backends with their weights for the route log.
+func describeBackends(backends []loggingproxy.Backend) string {
+	descriptions := make([]string, 0, len(backends))
+	for _, backend := range backends {
+		weight := backend.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s (weight %d)", backend.Destination, weight))
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// BodyFilterConfig runs Command, the program and its arguments, with a body on
+// stdin and uses its stdout as the new body.
+type BodyFilterConfig struct {
+	Command []string      `yaml:"command"`
+	Timeout time.Duration `yaml:"timeout"`
+	MaxBody int64         `yaml:"max_body"`
+}
+
+func (config *BodyFilterConfig) toLibrary() *loggingproxy.BodyFilter {
+	if config == nil {
+		return nil
+	}
+	return &loggingproxy.BodyFilter{
+		Command: config.Command,
+		Timeout: config.Timeout,
+		MaxBody: config.MaxBody,
+	}
+}
+
+// FallbackConfig is a static response served when a route's backend is down.
+type FallbackConfig struct {
+	Status   int               `yaml:"status"`
+	Headers  map[string]string `yaml:"headers"`
+	Body     string            `yaml:"body"`
+	BodyFile string            `yaml:"body_file"`
+}
+
+func (config *FallbackConfig) toLibrary() *loggingproxy.FallbackResponse {
+	if config == nil {
+		return nil
+	}
+	header := http.Header{}
+	for name, value := range config.Headers {
+		header.Set(name, value)
+	}
+	return &loggingproxy.FallbackResponse{
+		StatusCode: config.Status,
+		Header:     header,
+		Body:       []byte(config.Body),
+		BodyFile:   config.BodyFile,
+	}
+}
+
+// CORSConfig makes the reverse proxy answer CORS preflight requests and add
+// CORS headers to responses.
+type CORSConfig struct {
+	AllowedOrigins   []string      `yaml:"allowed_origins"`
+	AllowedMethods   []string      `yaml:"allowed_methods"`
+	AllowedHeaders   []string      `yaml:"allowed_headers"`
+	ExposedHeaders   []string      `yaml:"exposed_headers"`
+	AllowCredentials bool          `yaml:"allow_credentials"`
+	MaxAge           time.Duration `yaml:"max_age"`
+}
+
+// defaultUpstreamAuthRefresh is how often the secret of upstream_auth is read
+// again when refresh is not set.
+const defaultUpstreamAuthRefresh = time.Minute
+
+// UpstreamAuthConfig injects a route's upstream Authorization header from a
+// secret in File or Env, which is read again every Refresh. Scheme is
+// "bearer" (default) for tokens, "basic" for the password of Username, or
+// "raw" for a complete header value.
+type UpstreamAuthConfig struct {
+	Scheme   string        `yaml:"scheme"`
+	File     string        `yaml:"file"`
+	Env      string        `yaml:"env"`
+	Username string        `yaml:"username"`
+	Refresh  time.Duration `yaml:"refresh"`
+}
+
+func (config *UpstreamAuthConfig) toLibrary() (loggingproxy.CredentialProvider, error) {
+	if config == nil {
+		return nil, nil
+	}
+	var secret loggingproxy.CredentialProvider
+	switch {
+	case config.File != "" && config.Env != "":
+		return nil, errors.New("upstream_auth: set file or env, not both")
+	case config.File != "":
+		secret = loggingproxy.FileCredential(config.File)
+	case config.Env != "":
+		secret = loggingproxy.EnvCredential(config.Env)
+	default:
+		return nil, errors.New("upstream_auth: file or env is required")
+	}
+
+	var provider loggingproxy.CredentialProvider
+	switch strings.ToLower(config.Scheme) {
+	case "", "bearer":
+		provider = loggingproxy.BearerCredential(secret)
+	case "basic":
+		if config.Username == "" {
+			return nil, errors.New("upstream_auth: basic requires a username")
+		}
+		provider = loggingproxy.BasicCredential(config.Username, secret)
+	case "raw":
+		provider = secret
+	default:
+		return nil, fmt.Errorf("upstream_auth: unknown scheme %q (expected bearer, basic or raw)", config.Scheme)
+	}
+
+	refresh := config.Refresh
+	if refresh <= 0 {
+		refresh = defaultUpstreamAuthRefresh
+	}
+	return loggingproxy.CachedCredential(provider, refresh), nil
+}
+
+func (config *CORSConfig) toLibrary() *loggingproxy.CORSConfig {
+	if config == nil {
+		return nil
+	}
+	return &loggingproxy.CORSConfig{
+		AllowedOrigins:   config.AllowedOrigins,
+		AllowedMethods:   config.AllowedMethods,
+		AllowedHeaders:   config.AllowedHeaders,
+		ExposedHeaders:   config.ExposedHeaders,
+		AllowCredentials: config.AllowCredentials,
+		MaxAge:           config.MaxAge,
+	}
+}
+
+// ClientTLSConfig configures TLS towards upstream backends, including the
+// client certificate for mutual TLS.
+type ClientTLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+}
+
+func (config ClientTLSConfig) toLibrary() loggingproxy.ClientTLSConfig {
+	return loggingproxy.ClientTLSConfig{
+		CertFile: config.CertFile,
+		KeyFile:  config.KeyFile,
+		CAFile:   config.CAFile,
+	}
 }
 
 type ProxyAuthConfig struct {
@@ -50,30 +374,297 @@ type ProxyConfig struct {
 		ExcludeHosts              []string `yaml:"exclude_hosts"`
 		LoggingExcludeURLPrefixes []string `yaml:"logging_exclude_url_prefixes"`
 	} `yaml:"mitm"`
+	ListenerTimeouts `yaml:",inline"`
+}
+
+// defaultReadHeaderTimeout bounds how long a client may take to send request
+// headers when read_header_timeout is not configured.
+const defaultReadHeaderTimeout = 10 * time.Second
+
+// LogHeadersConfig selects and bounds the headers written to logged
+// transcripts of both listeners.
+type LogHeadersConfig struct {
+	// HeaderAllowList logs only these headers. Empty logs every header.
+	HeaderAllowList []string `yaml:"header_allow_list"`
+	// MaxHeaderValue truncates longer logged header values, and
+	// MaxHeaderBytes leaves out headers beyond this size of a logged header
+	// block. Zero is unlimited.
+	MaxHeaderValue int `yaml:"max_header_value"`
+	MaxHeaderBytes int `yaml:"max_header_bytes"`
+}
+
+// ListenerTimeouts are the inbound connection timeouts of a listener. They
+// protect against slow clients (slowloris). ReadTimeout and WriteTimeout cover
+// whole request and response bodies, including streamed responses and CONNECT
+// tunnels, so they are off by default.
+type ListenerTimeouts struct {
+	// ReadHeaderTimeout defaults to defaultReadHeaderTimeout; 0 disables it.
+	ReadHeaderTimeout *time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration  `yaml:"read_timeout"`
+	WriteTimeout      time.Duration  `yaml:"write_timeout"`
+	IdleTimeout       time.Duration  `yaml:"idle_timeout"`
+}
+
+// newListenerServer builds the http.Server for a listener.
+func newListenerServer(addr string, handler http.Handler, timeouts ListenerTimeouts) *http.Server {
+	readHeaderTimeout := defaultReadHeaderTimeout
+	if timeouts.ReadHeaderTimeout != nil {
+		readHeaderTimeout = *timeouts.ReadHeaderTimeout
+	}
+	return &http.Server{
+		Addr:                         addr,
+		Handler:                      handler,
+		DisableGeneralOptionsHandler: true,
+		ReadHeaderTimeout:            readHeaderTimeout,
+		ReadTimeout:                  timeouts.ReadTimeout,
+		WriteTimeout:                 timeouts.WriteTimeout,
+		IdleTimeout:                  timeouts.IdleTimeout,
+	}
 }
 
 type HTTPClientConfig struct {
 	ProxyURL             string `yaml:"proxy_url"`
 	ProxyFromEnvironment *bool  `yaml:"proxy_from_environment"`
+	// MaxConnLifetime recycles upstream connections of both listeners once
+	// they are this old, so backends are re-resolved. Zero keeps them.
+	MaxConnLifetime time.Duration `yaml:"max_conn_lifetime"`
 }
 
 type ServerConfig struct {
-	Port     int    `yaml:"port"`
-	Host     string `yaml:"host"`
-	NotFound string `yaml:"not_found"`
+	Port              int           `yaml:"port"`
+	Host              string        `yaml:"host"`
+	NotFound          string        `yaml:"not_found"`
+	MaxRedirects      int           `yaml:"max_redirects"`
+	RequestTimeout    time.Duration `yaml:"request_timeout"`
+	TimeoutHeader     string        `yaml:"timeout_header"`
+	MaxRequestTimeout time.Duration `yaml:"max_request_timeout"`
+	// MaxBufferedBody is the largest request body, in bytes, that is read
+	// completely before the backend is contacted.
+	MaxBufferedBody int64 `yaml:"max_buffered_body"`
+	// StreamThreshold is the largest response, in bytes, that is read
+	// completely before it is sent to the client.
+	StreamThreshold int64           `yaml:"stream_threshold"`
+	ClientTLS       ClientTLSConfig `yaml:"client_tls"`
+	DebugHeaders    bool            `yaml:"debug_headers"`
+	// AddressOverride dials backend hosts at fixed addresses, keeping the
+	// host name in URLs, Host headers and TLS.
+	AddressOverride map[string]string `yaml:"address_override"`
+	// TraceContext forwards or generates W3C traceparent headers.
+	TraceContext bool `yaml:"trace_context"`
+	// CaseInsensitiveRoutes matches route patterns ignoring case.
+	CaseInsensitiveRoutes bool `yaml:"case_insensitive_routes"`
+	// AllowFilterCommands permits routes to run request_filter and
+	// response_filter commands.
+	AllowFilterCommands bool `yaml:"allow_filter_commands"`
+	// AllowFaultInjection permits routes to inject faults.
+	AllowFaultInjection bool `yaml:"allow_fault_injection"`
+	// DestinationGuard refuses to proxy to internal addresses.
+	DestinationGuard *DestinationGuardConfig `yaml:"destination_guard"`
+	AdminStream      bool                    `yaml:"admin_stream"`
+	// AdminMetrics serves body size metrics at /admin/metrics.
+	AdminMetrics bool `yaml:"admin_metrics"`
+	// AdminDrain serves /readyz and POST /admin/drain, which makes /readyz
+	// report 503 so load balancers stop sending traffic, until DELETE
+	// /admin/drain.
+	AdminDrain bool `yaml:"admin_drain"`
+	// AdminToken is the bearer token required by the /admin/ endpoints. It
+	// must be set when any of them is enabled.
+	AdminToken string `yaml:"admin_token"`
+	// RoutesURL is polled for the route table every RoutesInterval. The
+	// routes in the config file are used until the first successful fetch.
+	RoutesURL        string        `yaml:"routes_url"`
+	RoutesInterval   time.Duration `yaml:"routes_interval"`
+	CORS             *CORSConfig   `yaml:"cors"`
+	ListenerTimeouts `yaml:",inline"`
+	// TLS serves the reverse proxy over HTTPS.
+	TLS *ServerTLSConfig `yaml:"tls"`
+}
+
+// DestinationGuardConfig rejects requests to loopback, private and link-local
+// destinations unless they are allow-listed.
+type DestinationGuardConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Allow   []string `yaml:"allow"`
+}
+
+func (config *DestinationGuardConfig) toLibrary() *loggingproxy.DestinationGuard {
+	if config == nil || !config.Enabled {
+		return nil
+	}
+	return &loggingproxy.DestinationGuard{Allow: config.Allow}
+}
+
+// RequestIDConfig is logging.request_id and selects how request IDs are
+// generated: "uuid" (default), "base62" random IDs of Length characters, or
+// "counter" IDs made of Prefix and an incrementing number.
+type RequestIDConfig struct {
+	Format string `yaml:"format"`
+	Length int    `yaml:"length"`
+	// Prefix tells counter IDs from several proxies apart. Empty uses
+	// "<hostname>-".
+	Prefix string `yaml:"prefix"`
+}
+
+func (config RequestIDConfig) toLibrary() (loggingproxy.IDGenerator, error) {
+	switch strings.ToLower(config.Format) {
+	case "", "uuid":
+		return nil, nil
+	case "base62":
+		return loggingproxy.Base62IDs(config.Length), nil
+	case "counter":
+		prefix := config.Prefix
+		if prefix == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				return nil, fmt.Errorf("logging.request_id: counter IDs need a prefix: %w", err)
+			}
+			prefix = hostname + "-"
+		}
+		return loggingproxy.CounterIDs(prefix), nil
+	}
+	return nil, fmt.Errorf("logging.request_id: unknown format %q (expected uuid, base62 or counter)", config.Format)
+}
+
+// LogEncryptionConfig is logging.encryption. The AES key is 16, 24 or 32 bytes,
+// hex or base64 encoded, given inline (typically as "${LOG_KEY}") or in a file.
+type LogEncryptionConfig struct {
+	Key     string `yaml:"key"`
+	KeyFile string `yaml:"key_file"`
+}
+
+// loadKey returns the decoded key, or nil if encryption is not configured.
+func (config LogEncryptionConfig) loadKey() ([]byte, error) {
+	encoded := config.Key
+	if config.KeyFile != "" {
+		if encoded != "" {
+			return nil, errors.New("logging.encryption: set key or key_file, not both")
+		}
+		data, err := os.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("logging.encryption: %w", err)
+		}
+		encoded = string(data)
+	}
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		if config.KeyFile != "" {
+			return nil, fmt.Errorf("logging.encryption: %s is empty", config.KeyFile)
+		}
+		return nil, nil
+	}
+	if key, err := hex.DecodeString(encoded); err == nil {
+		return key, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("logging.encryption: key must be hex or base64 encoded")
+	}
+	return key, nil
+}
+
+// LogLevel is logging.level: "error", "info" (default) or "debug".
+type LogLevel loggingproxy.LogLevel
+
+func (l *LogLevel) UnmarshalYAML(value *yaml.Node) error {
+	level, err := loggingproxy.ParseLogLevel(value.Value)
+	if err != nil {
+		return err
+	}
+	*l = LogLevel(level)
+	return nil
 }
 
 type Config struct {
 	Server  *ServerConfig `yaml:"server"`
 	Logging struct {
-		Enabled bool   `yaml:"enabled"`
-		Console bool   `yaml:"console"`
-		LogDir  string `yaml:"log_dir"`
+		Enabled bool `yaml:"enabled"`
+		Console bool `yaml:"console"`
+		// Level filters the proxy's own console messages.
+		Level         LogLevel      `yaml:"level"`
+		LogDir        string        `yaml:"log_dir"`
+		MaxConcurrent int           `yaml:"max_concurrent"`
+		QueueTimeout  time.Duration `yaml:"queue_timeout"`
+		// Timeout abandons a logger that has not finished a stream in time.
+		Timeout time.Duration `yaml:"timeout"`
+		// Ordered finishes each request log before its response log starts.
+		Ordered       bool          `yaml:"ordered"`
+		SampleRate    *float64      `yaml:"sample_rate"`
+		BufferSize    int           `yaml:"buffer_size"`
+		FlushInterval time.Duration `yaml:"flush_interval"`
+		// FilenameTemplate customizes log file names (see README).
+		FilenameTemplate string `yaml:"filename_template"`
+		// Index appends one line per logged stream to <log_dir>/index.jsonl.
+		Index bool `yaml:"index"`
+		// MaxCaptures and MaxBytes cap what is written to log_dir; CapPolicy
+		// is "stop" (default) or "evict".
+		MaxCaptures int    `yaml:"max_captures"`
+		MaxBytes    int64  `yaml:"max_bytes"`
+		CapPolicy   string `yaml:"cap_policy"`
+		// Encryption encrypts .bin files of every route, or only of routes
+		// with encrypt_logs if any set it.
+		Encryption LogEncryptionConfig `yaml:"encryption"`
+		// SubjectHeader records this request header as the subject that
+		// FileLogger.Purge deletes logs by.
+		SubjectHeader string `yaml:"subject_header"`
+		// RequestID selects the request ID format used in metadata and file names.
+		RequestID        RequestIDConfig `yaml:"request_id"`
+		LogHeadersConfig `yaml:",inline"`
+		// MultipartSummary logs multipart/form-data request bodies as a JSON
+		// summary of their parts instead of the raw upload.
+		MultipartSummary      bool `yaml:"multipart_summary"`
+		MultipartMaxValueSize int  `yaml:"multipart_max_value_size"`
+		// Contract compares outgoing requests against recorded goldens.
+		Contract struct {
+			GoldenDir  string `yaml:"golden_dir"`
+			ReportFile string `yaml:"report_file"`
+		} `yaml:"contract"`
+		// Throughput records the transfer rate of every logged stream.
+		Throughput struct {
+			Enabled    bool   `yaml:"enabled"`
+			ReportFile string `yaml:"report_file"`
+		} `yaml:"throughput"`
+		// Logfmt prints one key=value line per logged stream to stdout.
+		Logfmt struct {
+			Enabled     bool `yaml:"enabled"`
+			BodyPreview int  `yaml:"body_preview"`
+		} `yaml:"logfmt"`
+		// Loki writes one JSON line per logged stream for Loki or Vector.
+		Loki struct {
+			File       string        `yaml:"file"`
+			MaxSize    int64         `yaml:"max_size"`
+			MaxAge     time.Duration `yaml:"max_age"`
+			MaxBackups int           `yaml:"max_backups"`
+		} `yaml:"loki"`
+		// Transcripts writes every logged stream with its metadata as a JSON
+		// line, in batches.
+		Transcripts struct {
+			File          string        `yaml:"file"`
+			MaxSize       int64         `yaml:"max_size"`
+			MaxAge        time.Duration `yaml:"max_age"`
+			MaxBackups    int           `yaml:"max_backups"`
+			MaxStreamSize int           `yaml:"max_stream_size"`
+			BatchSize     int           `yaml:"batch_size"`
+			FlushInterval time.Duration `yaml:"flush_interval"`
+		} `yaml:"transcripts"`
+		// HAR additionally writes completed exchanges to an HTTP Archive file.
+		HAR struct {
+			File        string `yaml:"file"`
+			FlushEvery  int    `yaml:"flush_every"`
+			MaxEntries  int    `yaml:"max_entries"`
+			MaxBodySize int    `yaml:"max_body_size"`
+		} `yaml:"har"`
 	} `yaml:"logging"`
 	HTTPClient HTTPClientConfig `yaml:"http_client"`
 	// proxy is optional. If present, a forward proxy listener is started.
 	Proxy  *ProxyConfig     `yaml:"proxy"`
 	Routes map[string]Route `yaml:"routes"`
+	// EnvExpansion controls how ${VAR} and $VAR in config values are expanded:
+	// "strict" (default) fails on unset variables, "empty" replaces them with
+	// an empty string and "disabled" leaves values untouched.
+	EnvExpansion string `yaml:"env_expansion"`
+	// ShutdownTimeout is how long in-flight requests may take to finish after
+	// SIGTERM or interrupt before their connections are closed.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
 }
 
 type namedServer struct {
@@ -88,6 +679,12 @@ func main() {
 		configFile = os.Args[1]
 	}
 
+	jsonStartup, err := jsonStartupOutput()
+	if err != nil {
+		log.Fatal(err)
+	}
+	quietStartup = jsonStartup
+
 	config, err := loadConfig(configFile)
 	if err != nil {
 		log.Fatal("Error loading config:", err)
@@ -106,50 +703,96 @@ func main() {
 	if err := validateHTTPClientProxyEndpoints(proxyEndpoints, configuredListenerAddresses(config)); err != nil {
 		log.Fatal(err)
 	}
-	log.Print(proxyLogMessage)
+	startupf("%s", proxyLogMessage)
+
+	idGenerator, err := config.Logging.RequestID.toLibrary()
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	servers := []namedServer{}
 	if config.Server != nil {
-		reverseHandler, err := buildReverseProxy(config, logger, clientProxyConfig)
+		admin := newAdminEndpoints(config)
+		reverseHandler, err := buildReverseProxy(config, logger, clientProxyConfig, idGenerator, admin)
 		if err != nil {
 			log.Fatal(err)
 		}
-		servers = append(servers, namedServer{
-			name: "reverse",
-			server: &http.Server{
-				Addr:                         fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port),
-				Handler:                      reverseHandler,
-				DisableGeneralOptionsHandler: true,
-			},
-		})
+		if config.Server.RoutesURL != "" {
+			routes := newRemoteRoutes(config.Server.RoutesURL, config.Routes, reverseHandler, func(routes map[string]Route) (http.Handler, error) {
+				routeConfig := *config
+				routeConfig.Routes = routes
+				return buildReverseProxy(&routeConfig, logger, clientProxyConfig, idGenerator, admin)
+			})
+			routes.poll()
+			go routes.run(config.Server.RoutesInterval)
+			reverseHandler = routes
+		}
+		reverseServers, certificates, err := reverseListeners(config.Server, reverseHandler)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if certificates != nil {
+			certificates.LogLevel = loggingproxy.LogLevel(config.Logging.Level)
+			certificates.Watch(loggingproxy.DefaultCertificateCheckInterval)
+			go reloadCertificatesOnSIGHUP(certificates)
+		}
+		servers = append(servers, reverseServers...)
 	}
 
 	if config.Proxy != nil {
-		forwardHandler, err := buildForwardProxy(config.Proxy, logger, clientProxyConfig)
+		forwardHandler, err := buildForwardProxy(config.Proxy, logger, clientProxyConfig, config.HTTPClient.MaxConnLifetime, config.Logging.LogHeadersConfig, idGenerator)
 		if err != nil {
 			log.Fatal(err)
 		}
 		servers = append(servers, namedServer{
-			name: "forward",
-			server: &http.Server{
-				Addr:                         fmt.Sprintf("%s:%d", config.Proxy.Host, config.Proxy.Port),
-				Handler:                      forwardHandler,
-				DisableGeneralOptionsHandler: true,
-			},
+			name:   "forward",
+			server: newListenerServer(fmt.Sprintf("%s:%d", config.Proxy.Host, config.Proxy.Port), forwardHandler, config.Proxy.ListenerTimeouts),
 		})
 	}
 
+	if jsonStartup {
+		if err := writeStartupInfo(os.Stdout, newStartupInfo(configFile, config, servers)); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	errCh := make(chan error, len(servers))
 	for _, srv := range servers {
-		log.Printf("%s proxy starting on %s", srv.name, srv.server.Addr)
+		if srv.server.TLSConfig != nil {
+			startupf("%s proxy starting on %s (TLS)", srv.name, srv.server.Addr)
+		} else {
+			startupf("%s proxy starting on %s", srv.name, srv.server.Addr)
+		}
 		go func(s namedServer) {
-			if err := s.server.ListenAndServe(); err != nil {
+			if err := s.listenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 				errCh <- fmt.Errorf("%s proxy failed: %w", s.name, err)
 			}
 		}(srv)
 	}
 
-	log.Fatal(<-errCh)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	select {
+	case err := <-errCh:
+		closeLogger(logger)
+		log.Fatal(err)
+	case sig := <-signals:
+		log.Printf("Received %s, waiting for in-flight requests", sig)
+		shutdownServers(servers, config.ShutdownTimeout)
+		log.Printf("Flushing logs and exiting")
+		closeLogger(logger)
+	}
+}
+
+// closeLogger flushes buffered log data if the logger supports it.
+func closeLogger(logger loggingproxy.Logger) {
+	closer, ok := logger.(io.Closer)
+	if !ok {
+		return
+	}
+	if err := closer.Close(); err != nil {
+		log.Printf("[error] Failed to close logger: %v", err)
+	}
 }
 
 func buildGlobalLogger(config *Config) (loggingproxy.Logger, error) {
@@ -163,12 +806,136 @@ func buildGlobalLogger(config *Config) (loggingproxy.Logger, error) {
 		logDir = "logs"
 	}
 
-	fileLogger, err := loggingproxy.NewFileLogger(logDir, config.Logging.Console)
+	encryptionKey, err := config.Logging.Encryption.loadKey()
+	if err != nil {
+		return nil, err
+	}
+	var encryptPatterns []string
+	for _, route := range config.Routes {
+		if route.EncryptLogs {
+			encryptPatterns = append(encryptPatterns, route.Pattern)
+		}
+	}
+	if encryptionKey == nil && len(encryptPatterns) > 0 {
+		return nil, errors.New("routes set encrypt_logs but logging.encryption has no key")
+	}
+
+	fileLogger, err := loggingproxy.NewFileLoggerWithOptions(loggingproxy.FileLoggerOptions{
+		LogDir:           logDir,
+		Console:          config.Logging.Console,
+		BufferSize:       config.Logging.BufferSize,
+		FlushInterval:    config.Logging.FlushInterval,
+		FilenameTemplate: config.Logging.FilenameTemplate,
+		Index:            config.Logging.Index,
+		LogLevel:         loggingproxy.LogLevel(config.Logging.Level),
+		MaxCaptures:      config.Logging.MaxCaptures,
+		MaxBytes:         config.Logging.MaxBytes,
+		CapPolicy:        loggingproxy.LogCapPolicy(config.Logging.CapPolicy),
+		EncryptionKey:    encryptionKey,
+		EncryptPatterns:  encryptPatterns,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create file logger: %w", err)
 	}
-	log.Printf("Logging requests/responses to: %s", logDir)
-	return fileLogger, nil
+	startupf("Logging requests/responses to: %s", logDir)
+
+	logLevel := loggingproxy.LogLevel(config.Logging.Level)
+	var logger loggingproxy.Logger = fileLogger
+	if har := config.Logging.HAR; har.File != "" {
+		startupf("Writing HAR archive to: %s", har.File)
+		harLogger := loggingproxy.NewHARLogger(har.File, har.FlushEvery, logger)
+		harLogger.MaxEntries = har.MaxEntries
+		harLogger.MaxBodySize = har.MaxBodySize
+		harLogger.LogLevel = logLevel
+		logger = harLogger
+	}
+	if config.Logging.MultipartSummary {
+		startupf("Summarizing multipart/form-data request bodies")
+		logger = loggingproxy.NewMultipartSummaryLogger(logger, config.Logging.MultipartMaxValueSize)
+	}
+
+	if contract := config.Logging.Contract; contract.GoldenDir != "" {
+		goldens, err := loggingproxy.LoadContractGoldens(contract.GoldenDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load contract goldens: %w", err)
+		}
+		reportFile := contract.ReportFile
+		if reportFile == "" {
+			reportFile = filepath.Join(logDir, "contract-report.jsonl")
+		}
+		contractLogger, err := loggingproxy.NewContractLogger(goldens, reportFile, logger)
+		if err != nil {
+			return nil, err
+		}
+		contractLogger.LogLevel = logLevel
+		logger = contractLogger
+		startupf("Checking requests against %d contract goldens, mismatches go to %s", len(goldens), reportFile)
+	}
+
+	if throughput := config.Logging.Throughput; throughput.Enabled {
+		reportFile := throughput.ReportFile
+		if reportFile == "" {
+			reportFile = filepath.Join(logDir, "throughput.jsonl")
+		}
+		startupf("Recording stream throughput to %s", reportFile)
+		throughputLogger := loggingproxy.NewThroughputLogger(logger, reportFile)
+		throughputLogger.LogLevel = logLevel
+		logger = throughputLogger
+	}
+
+	if logfmt := config.Logging.Logfmt; logfmt.Enabled {
+		logger = loggingproxy.NewLogfmtLogger(loggingproxy.LogfmtLoggerOptions{Logger: logger, BodyPreview: logfmt.BodyPreview})
+	}
+
+	if loki := config.Logging.Loki; loki.File != "" {
+		lokiLogger, err := loggingproxy.NewLokiLogger(loggingproxy.LokiLoggerOptions{
+			Path:       loki.File,
+			Logger:     logger,
+			MaxSize:    loki.MaxSize,
+			MaxAge:     loki.MaxAge,
+			MaxBackups: loki.MaxBackups,
+			LogLevel:   logLevel,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open loki log: %w", err)
+		}
+		startupf("Writing Loki/Vector JSON lines to: %s", loki.File)
+		logger = lokiLogger
+	}
+
+	if transcripts := config.Logging.Transcripts; transcripts.File != "" {
+		sink, err := loggingproxy.NewJSONLinesLogger(loggingproxy.JSONLinesLoggerOptions{
+			Path:          transcripts.File,
+			MaxStreamSize: transcripts.MaxStreamSize,
+			MaxSize:       transcripts.MaxSize,
+			MaxAge:        transcripts.MaxAge,
+			MaxBackups:    transcripts.MaxBackups,
+			LogLevel:      logLevel,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open transcript log: %w", err)
+		}
+		logger, err = loggingproxy.NewBatchingLogger(loggingproxy.BatchingLoggerOptions{
+			Logger:        sink,
+			Forward:       logger,
+			MaxCount:      transcripts.BatchSize,
+			FlushInterval: transcripts.FlushInterval,
+			MaxStreamSize: transcripts.MaxStreamSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		startupf("Writing transcripts as JSON lines to: %s", transcripts.File)
+	}
+
+	if sampleRate := config.Logging.SampleRate; sampleRate != nil && *sampleRate < 1 {
+		if *sampleRate < 0 {
+			return nil, fmt.Errorf("logging.sample_rate must be between 0 and 1, got %v", *sampleRate)
+		}
+		startupf("Sampling %.1f%% of requests for logging", *sampleRate*100)
+		return loggingproxy.NewSamplingLogger(logger, *sampleRate, uint64(time.Now().UnixNano())), nil
+	}
+	return logger, nil
 }
 
 func buildHTTPClientProxyConfig(config *Config) loggingproxy.HTTPClientProxyConfig {
@@ -235,6 +1002,9 @@ func configuredListenerAddresses(config *Config) []listenerAddress {
 	listeners := []listenerAddress{}
 	if config.Server != nil {
 		listeners = append(listeners, listenerAddress{name: "reverse", host: config.Server.Host, port: config.Server.Port})
+		if config.Server.TLS != nil && config.Server.TLS.RedirectPort != 0 {
+			listeners = append(listeners, listenerAddress{name: "redirect", host: config.Server.Host, port: config.Server.TLS.RedirectPort})
+		}
 	}
 	if config.Proxy != nil {
 		listeners = append(listeners, listenerAddress{name: "forward", host: config.Proxy.Host, port: config.Proxy.Port})
@@ -407,32 +1177,195 @@ func normalizeAddressHost(host string) string {
 	return strings.ToLower(host)
 }
 
-func buildReverseProxy(config *Config, globalLogger loggingproxy.Logger, clientProxyConfig loggingproxy.HTTPClientProxyConfig) (http.Handler, error) {
-	proxy, err := loggingproxy.NewProxyServerWithHTTPClientProxy(config.Server.NotFound, clientProxyConfig)
+// adminEndpoints are the optional admin endpoints of the reverse proxy. They
+// are created once so that rebuilding the reverse proxy for new routes keeps
+// stream subscribers and collected metrics.
+type adminEndpoints struct {
+	liveStream *loggingproxy.LiveStream
+	metrics    *loggingproxy.Metrics
+	readiness  *loggingproxy.Readiness
+}
+
+func newAdminEndpoints(config *Config) adminEndpoints {
+	var admin adminEndpoints
+	if config.Server.AdminStream {
+		admin.liveStream = loggingproxy.NewLiveStream(0)
+		startupf("Live traffic stream: http://%s:%d/admin/stream", config.Server.Host, config.Server.Port)
+	}
+	if config.Server.AdminMetrics {
+		admin.metrics = loggingproxy.NewMetrics()
+		startupf("Metrics: http://%s:%d/admin/metrics", config.Server.Host, config.Server.Port)
+	}
+	if config.Server.AdminDrain {
+		admin.readiness = loggingproxy.NewReadiness()
+		startupf("Readiness: http://%s:%d/readyz (drain with POST /admin/drain, undo with DELETE)", config.Server.Host, config.Server.Port)
+	}
+	return admin
+}
+
+func buildReverseProxy(config *Config, globalLogger loggingproxy.Logger, clientProxyConfig loggingproxy.HTTPClientProxyConfig, idGenerator loggingproxy.IDGenerator, admin adminEndpoints) (http.Handler, error) {
+	// The admin endpoints share the listener with proxied traffic
+	if (admin.liveStream != nil || admin.metrics != nil || admin.readiness != nil) && config.Server.AdminToken == "" {
+		return nil, errors.New("server.admin_stream, admin_metrics and admin_drain require server.admin_token")
+	}
+	proxy, err := loggingproxy.NewProxyServerWithOptions(loggingproxy.ProxyServerOptions{
+		NotFoundEndpoint:       config.Server.NotFound,
+		ClientProxy:            clientProxyConfig,
+		MaxConnLifetime:        config.HTTPClient.MaxConnLifetime,
+		CaseInsensitiveRoutes:  config.Server.CaseInsensitiveRoutes,
+		DefaultLoggingDisabled: !config.Logging.Enabled,
+		MaxRedirects:           config.Server.MaxRedirects,
+		RequestTimeout:         config.Server.RequestTimeout,
+		TimeoutHeader:          config.Server.TimeoutHeader,
+		MaxRequestTimeout:      config.Server.MaxRequestTimeout,
+		MaxBufferedRequestBody: config.Server.MaxBufferedBody,
+		StreamThreshold:        config.Server.StreamThreshold,
+		ClientTLS:              config.Server.ClientTLS.toLibrary(),
+		AddressOverride:        config.Server.AddressOverride,
+		Metrics:                admin.metrics,
+		MaxConcurrentLogs:      config.Logging.MaxConcurrent,
+		LogQueueTimeout:        config.Logging.QueueTimeout,
+		LogTimeout:             config.Logging.Timeout,
+		DebugHeaders:           config.Server.DebugHeaders,
+		CORS:                   config.Server.CORS.toLibrary(),
+		LogHeaderAllowList:     config.Logging.HeaderAllowList,
+		MaxLoggedHeaderValue:   config.Logging.MaxHeaderValue,
+		MaxLoggedHeaderBytes:   config.Logging.MaxHeaderBytes,
+		LogLevel:               loggingproxy.LogLevel(config.Logging.Level),
+		OrderedLogs:            config.Logging.Ordered,
+		TraceContext:           config.Server.TraceContext,
+		DestinationGuard:       config.Server.DestinationGuard.toLibrary(),
+		SubjectHeader:          config.Logging.SubjectHeader,
+		IDGenerator:            idGenerator,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to configure reverse proxy HTTP client: %w", err)
 	}
+	// Stop the health checks of routes that were added if a later route fails
+	built := false
+	defer func() {
+		if !built {
+			proxy.Close()
+		}
+	}()
 	noOpLogger := &loggingproxy.NoOpLogger{}
 
+	// The live stream sees every route, whether or not it is logged to disk
+	adminToken := config.Server.AdminToken
+	adminHandlers := map[string]http.Handler{}
+	if admin.liveStream != nil {
+		adminHandlers["/admin/stream"] = loggingproxy.RequireBearerToken(adminToken, admin.liveStream)
+	}
+	if admin.metrics != nil {
+		adminHandlers["/admin/metrics"] = loggingproxy.RequireBearerToken(adminToken, admin.metrics)
+	}
+	if admin.readiness != nil {
+		// Load balancer probes do not authenticate, and /readyz changes nothing
+		adminHandlers["/readyz"] = admin.readiness
+		adminHandlers["/admin/drain"] = loggingproxy.RequireBearerToken(adminToken, admin.readiness.DrainHandler())
+	}
+	for pattern, handler := range adminHandlers {
+		if err := proxy.Handle(pattern, handler); err != nil {
+			return nil, fmt.Errorf("failed to register %s: %w", pattern, err)
+		}
+	}
+
 	hasCatchAll := false
 	for _, route := range config.Routes {
 		logger := loggingproxy.Logger(noOpLogger)
+		destination := route.Destination
+		var backends []loggingproxy.Backend
+		for _, backend := range route.Backends {
+			backends = append(backends, loggingproxy.Backend{Destination: backend.Destination, Weight: backend.Weight})
+		}
+		if len(backends) > 0 {
+			destination = describeBackends(backends)
+		}
 		loggingEnabled := config.Logging.Enabled
 		if route.Logging != nil {
 			loggingEnabled = *route.Logging
 		}
 		if loggingEnabled {
 			logger = globalLogger
-			log.Printf("[route] %s -> %s (logging enabled)", route.Pattern, route.Destination)
+			startupf("[route] %s -> %s (logging enabled)", route.Pattern, destination)
 		} else {
-			log.Printf("[route] %s -> %s (logging disabled)", route.Pattern, route.Destination)
+			startupf("[route] %s -> %s (logging disabled)", route.Pattern, destination)
 		}
 
-		if !strings.HasSuffix(route.Pattern, "/") {
+		if !strings.HasSuffix(route.Pattern, "/") && !route.Exact && !strings.HasSuffix(route.Pattern, "{$}") {
 			log.Printf("  (warning) Pattern %q has no trailing '/'; will not match subpaths", route.Pattern)
 		}
 
-		if err := proxy.AddRoute(route.Pattern, route.Destination, logger); err != nil {
+		if admin.liveStream != nil {
+			logger = loggingproxy.NewLiveStreamLogger(logger, admin.liveStream)
+		}
+		if admin.metrics != nil {
+			logger = loggingproxy.NewMetricsLogger(logger, admin.metrics)
+		}
+
+		routeOptions := loggingproxy.RouteOptions{
+			Exact:                route.Exact,
+			PreservePath:         route.PreservePath,
+			StripSegments:        route.StripSegments,
+			CompressRequests:     route.CompressRequests,
+			NegotiateCompression: route.NegotiateCompression,
+			MaxInFlight:          route.MaxInFlight,
+			InFlightQueueTimeout: route.InFlightQueueTimeout,
+			CORS:                 route.CORS.toLibrary(),
+			AllowedMethods:       route.AllowedMethods,
+			StatusMap:            route.StatusMap,
+			Fallback:             route.Fallback.toLibrary(),
+			SkipLargeBodies:      route.SkipLargeBodies,
+			RequestSchemaMaxBody: route.RequestSchemaMaxBody,
+			Logging:              route.Logging,
+			Backends:             backends,
+			BackendFailTimeout:   route.BackendFailTimeout,
+			HealthCheck:          route.HealthCheck.toLibrary(),
+			MirrorTo:             route.MirrorTo,
+			MirrorMaxBody:        route.MirrorMaxBody,
+			LogMirror:            route.LogMirror,
+			MirrorCompare:        route.MirrorCompare.toLibrary(),
+		}
+		if routeOptions.BodyCapture, err = loggingproxy.ParseBodyCaptureMode(route.BodyCapture); err != nil {
+			return nil, fmt.Errorf("failed to add route %s: %w", route.Pattern, err)
+		}
+		if route.RequestSchema != "" {
+			data, err := os.ReadFile(route.RequestSchema)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read request schema for route %s: %w", route.Pattern, err)
+			}
+			if routeOptions.RequestSchema, err = loggingproxy.ParseJSONSchema(data); err != nil {
+				return nil, fmt.Errorf("failed to add route %s: %w", route.Pattern, err)
+			}
+		}
+		if routeOptions.Authorization, err = route.UpstreamAuth.toLibrary(); err != nil {
+			return nil, fmt.Errorf("failed to add route %s: %w", route.Pattern, err)
+		}
+		if (route.RequestFilter != nil || route.ResponseFilter != nil) && !config.Server.AllowFilterCommands {
+			return nil, fmt.Errorf("route %s uses a body filter, which requires server.allow_filter_commands", route.Pattern)
+		}
+		if route.FaultInjection != nil {
+			if !config.Server.AllowFaultInjection {
+				return nil, fmt.Errorf("route %s injects faults, which requires server.allow_fault_injection", route.Pattern)
+			}
+			log.Printf("WARNING: injecting faults into route %s", route.Pattern)
+			routeOptions.FaultInjection = route.FaultInjection.toLibrary()
+		}
+		routeOptions.MaxRequestBytes = route.MaxRequestBytes
+		routeOptions.CanonicalHeaders = route.CanonicalHeaders
+		routeOptions.BodyTags = route.BodyTags
+		routeOptions.BodyTagsMaxBody = route.BodyTagsMaxBody
+		routeOptions.CompressResponses = route.CompressResponses
+		routeOptions.CompressResponsesMinSize = route.CompressMinSize
+		routeOptions.Idempotency = route.Idempotency.toLibrary()
+		routeOptions.RequestFilter = route.RequestFilter.toLibrary()
+		routeOptions.ResponseFilter = route.ResponseFilter.toLibrary()
+		if route.ClientTLS != nil {
+			clientTLS := route.ClientTLS.toLibrary()
+			routeOptions.ClientTLS = &clientTLS
+		}
+		routeOptions.AddressOverride = route.AddressOverride
+		if err := proxy.AddRouteWithOptions(route.Pattern, route.Destination, logger, routeOptions); err != nil {
 			return nil, fmt.Errorf("failed to add route %s: %w", route.Pattern, err)
 		}
 		if route.Pattern == "/" {
@@ -443,7 +1376,7 @@ func buildReverseProxy(config *Config, globalLogger loggingproxy.Logger, clientP
 	// Set up catch-all handler if no "/" route was configured
 	if !hasCatchAll && config.Server.NotFound != "" {
 		notFoundURL := fmt.Sprintf("http://%s:%d%s", config.Server.Host, config.Server.Port, config.Server.NotFound)
-		log.Printf("Registering catch-all handler: %s", notFoundURL)
+		startupf("Registering catch-all handler: %s", notFoundURL)
 		logger := loggingproxy.Logger(noOpLogger)
 		if config.Logging.Enabled {
 			logger = globalLogger
@@ -453,10 +1386,11 @@ func buildReverseProxy(config *Config, globalLogger loggingproxy.Logger, clientP
 		}
 	}
 
+	built = true
 	return proxy, nil
 }
 
-func buildForwardProxy(config *ProxyConfig, globalLogger loggingproxy.Logger, clientProxyConfig loggingproxy.HTTPClientProxyConfig) (http.Handler, error) {
+func buildForwardProxy(config *ProxyConfig, globalLogger loggingproxy.Logger, clientProxyConfig loggingproxy.HTTPClientProxyConfig, maxConnLifetime time.Duration, logHeaders LogHeadersConfig, idGenerator loggingproxy.IDGenerator) (http.Handler, error) {
 	options := loggingproxy.HTTPProxyOptions{
 		Logger:                    globalLogger,
 		MITM:                      config.MITM.Enabled,
@@ -464,7 +1398,12 @@ func buildForwardProxy(config *ProxyConfig, globalLogger loggingproxy.Logger, cl
 		MITMExcludeHosts:          config.MITM.ExcludeHosts,
 		LoggingExcludeURLPrefixes: config.MITM.LoggingExcludeURLPrefixes,
 		ClientProxy:               clientProxyConfig,
+		MaxConnLifetime:           maxConnLifetime,
 		Verbose:                   config.Verbose,
+		LogHeaderAllowList:        logHeaders.HeaderAllowList,
+		MaxLoggedHeaderValue:      logHeaders.MaxHeaderValue,
+		MaxLoggedHeaderBytes:      logHeaders.MaxHeaderBytes,
+		IDGenerator:               idGenerator,
 	}
 
 	if config.Auth != nil {
@@ -475,7 +1414,7 @@ func buildForwardProxy(config *ProxyConfig, globalLogger loggingproxy.Logger, cl
 			Username: config.Auth.Username,
 			Password: config.Auth.Password,
 		}
-		log.Printf("Forward proxy authentication enabled for user %q", config.Auth.Username)
+		startupf("Forward proxy authentication enabled for user %q", config.Auth.Username)
 	}
 
 	if config.MITM.Enabled {
@@ -493,13 +1432,13 @@ func buildForwardProxy(config *ProxyConfig, globalLogger loggingproxy.Logger, cl
 		}
 		options.MITMCA = ca
 		if len(config.MITM.IncludeHosts) > 0 {
-			log.Printf("MITM included hosts: %s", strings.Join(config.MITM.IncludeHosts, ", "))
+			startupf("MITM included hosts: %s", strings.Join(config.MITM.IncludeHosts, ", "))
 		}
 		if len(config.MITM.ExcludeHosts) > 0 {
-			log.Printf("MITM excluded hosts: %s", strings.Join(config.MITM.ExcludeHosts, ", "))
+			startupf("MITM excluded hosts: %s", strings.Join(config.MITM.ExcludeHosts, ", "))
 		}
 		if len(config.MITM.LoggingExcludeURLPrefixes) > 0 {
-			log.Printf("MITM logging excluded URL prefixes: %s", strings.Join(config.MITM.LoggingExcludeURLPrefixes, ", "))
+			startupf("MITM logging excluded URL prefixes: %s", strings.Join(config.MITM.LoggingExcludeURLPrefixes, ", "))
 		}
 	}
 
@@ -531,16 +1470,76 @@ func crlHostname(configuredHostname, listenHost string, port int) (string, error
 	return net.JoinHostPort(host, strconv.Itoa(port)), nil
 }
 
+// expandConfigEnv expands environment variables in the scalar values of a
+// parsed config document. Keys and comments are left alone, and expanded values
+// are never parsed as YAML. Use $$ for a literal $.
+func expandConfigEnv(document *yaml.Node) error {
+	var mode struct {
+		EnvExpansion string `yaml:"env_expansion"`
+	}
+	if err := document.Decode(&mode); err != nil {
+		return err
+	}
+	switch mode.EnvExpansion {
+	case "", "strict", "empty":
+	case "disabled":
+		return nil
+	default:
+		return fmt.Errorf("invalid env_expansion %q (expected strict, empty or disabled)", mode.EnvExpansion)
+	}
+
+	var missing []string
+	expand := func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok && !slices.Contains(missing, name) {
+			missing = append(missing, name)
+		}
+		return value
+	}
+	var walk func(node *yaml.Node)
+	walk = func(node *yaml.Node) {
+		if node.Kind == yaml.ScalarNode {
+			node.Value = os.Expand(node.Value, expand)
+			return
+		}
+		for i, child := range node.Content {
+			// Mapping keys are at even indices
+			if node.Kind == yaml.MappingNode && i%2 == 0 {
+				continue
+			}
+			walk(child)
+		}
+	}
+	walk(document)
+
+	if len(missing) > 0 && mode.EnvExpansion != "empty" {
+		return fmt.Errorf("config references unset environment variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
 func loadConfig(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
 		return nil, err
 	}
+	var config Config
+	if len(document.Content) > 0 {
+		if err := expandConfigEnv(&document); err != nil {
+			return nil, err
+		}
+		if err := document.Decode(&config); err != nil {
+			return nil, err
+		}
+	}
 
 	if config.Server == nil && len(config.Routes) > 0 {
 		return nil, fmt.Errorf("routes require a server section")
@@ -1,10 +1,13 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	loggingproxy "github.com/mrexodia/logging-proxy"
 )
@@ -210,3 +213,215 @@ logging:
 		t.Fatalf("unexpected error: %v", err)
 	}
 }
+
+func TestLoadConfigParsesLogLevel(t *testing.T) {
+	config, err := loadConfig(writeTestConfig(t, `
+logging:
+  level: error
+proxy:
+  host: "127.0.0.1"
+`))
+	if err != nil {
+		t.Fatalf("loadConfig returned error: %v", err)
+	}
+	if loggingproxy.LogLevel(config.Logging.Level) != loggingproxy.LogLevelError {
+		t.Fatalf("expected error level, got %v", loggingproxy.LogLevel(config.Logging.Level))
+	}
+
+	_, err = loadConfig(writeTestConfig(t, `
+logging:
+  level: verbose
+proxy:
+  host: "127.0.0.1"
+`))
+	if err == nil || !strings.Contains(err.Error(), "invalid log level") {
+		t.Fatalf("expected an invalid log level error, got %v", err)
+	}
+}
+
+func TestLoadConfigExpandsEnvironmentVariables(t *testing.T) {
+	t.Setenv("TEST_BACKEND_URL", "http://127.0.0.1:9000")
+	t.Setenv("TEST_LOG_DIR", "custom-logs")
+	config, err := loadConfig(writeTestConfig(t, `
+server:
+  port: 5601
+logging:
+  log_dir: "$TEST_LOG_DIR"
+routes:
+  api:
+    pattern: "/api/{$}"
+    destination: "${TEST_BACKEND_URL}/api/?price=$$5"
+`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	if config.Logging.LogDir != "custom-logs" {
+		t.Fatalf("expected expanded log dir, got %q", config.Logging.LogDir)
+	}
+	route := config.Routes["api"]
+	if route.Destination != "http://127.0.0.1:9000/api/?price=$5" {
+		t.Fatalf("expected expanded destination, got %q", route.Destination)
+	}
+	if route.Pattern != "/api/{$}" {
+		t.Fatalf("expected pattern end anchor to be kept, got %q", route.Pattern)
+	}
+}
+
+func TestLoadConfigRejectsUnsetEnvironmentVariables(t *testing.T) {
+	_, err := loadConfig(writeTestConfig(t, `
+server:
+  port: 5601
+routes:
+  api:
+    pattern: "/api/"
+    destination: "${TEST_UNSET_BACKEND_URL}/api/"
+`))
+	if err == nil || !strings.Contains(err.Error(), "TEST_UNSET_BACKEND_URL") {
+		t.Fatalf("expected unset variable error, got %v", err)
+	}
+}
+
+func TestLoadConfigExpandsUnsetEnvironmentVariablesToEmpty(t *testing.T) {
+	config, err := loadConfig(writeTestConfig(t, `
+env_expansion: empty
+server:
+  port: 5601
+routes:
+  api:
+    pattern: "/api/"
+    destination: "http://127.0.0.1:9000${TEST_UNSET_PREFIX}/api/"
+`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	if destination := config.Routes["api"].Destination; destination != "http://127.0.0.1:9000/api/" {
+		t.Fatalf("expected unset variable to expand to empty, got %q", destination)
+	}
+}
+
+func TestLogEncryptionKeyIsDecoded(t *testing.T) {
+	hexKey := strings.Repeat("ab", 32)
+	keyFile := filepath.Join(t.TempDir(), "log.key")
+	if err := os.WriteFile(keyFile, []byte("q6urq6urq6urq6urq6urqw==\n"), 0600); err != nil {
+		t.Fatalf("failed to write key file: %v", err)
+	}
+
+	for name, test := range map[string]struct {
+		config LogEncryptionConfig
+		length int
+	}{
+		"hex":    {LogEncryptionConfig{Key: hexKey}, 32},
+		"base64": {LogEncryptionConfig{KeyFile: keyFile}, 16},
+	} {
+		key, err := test.config.loadKey()
+		if err != nil {
+			t.Fatalf("%s: loadKey failed: %v", name, err)
+		}
+		if len(key) != test.length {
+			t.Errorf("%s: expected a %d byte key, got %d bytes", name, test.length, len(key))
+		}
+	}
+	if key, err := (LogEncryptionConfig{}).loadKey(); key != nil || err != nil {
+		t.Errorf("expected no key without configuration, got %v, %v", key, err)
+	}
+	if _, err := (LogEncryptionConfig{Key: hexKey, KeyFile: keyFile}).loadKey(); err == nil {
+		t.Error("expected key and key_file together to be rejected")
+	}
+	if _, err := (LogEncryptionConfig{Key: "not a key!"}).loadKey(); err == nil {
+		t.Error("expected an undecodable key to be rejected")
+	}
+}
+
+func TestListenerServerUsesConfiguredTimeouts(t *testing.T) {
+	config, err := loadConfig(writeTestConfig(t, `
+server:
+  port: 5601
+  read_header_timeout: 5s
+  read_timeout: 1m
+  write_timeout: 2m
+  idle_timeout: 3m
+proxy:
+  port: 8080
+`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+
+	server := newListenerServer("localhost:5601", http.NotFoundHandler(), config.Server.ListenerTimeouts)
+	if server.ReadHeaderTimeout != 5*time.Second || server.ReadTimeout != time.Minute ||
+		server.WriteTimeout != 2*time.Minute || server.IdleTimeout != 3*time.Minute {
+		t.Fatalf("unexpected server timeouts: read header %v, read %v, write %v, idle %v",
+			server.ReadHeaderTimeout, server.ReadTimeout, server.WriteTimeout, server.IdleTimeout)
+	}
+
+	// Unconfigured listeners only get the header timeout, so streaming keeps working
+	forward := newListenerServer("localhost:8080", http.NotFoundHandler(), config.Proxy.ListenerTimeouts)
+	if forward.ReadHeaderTimeout != defaultReadHeaderTimeout || forward.ReadTimeout != 0 || forward.WriteTimeout != 0 {
+		t.Fatalf("unexpected default timeouts: read header %v, read %v, write %v",
+			forward.ReadHeaderTimeout, forward.ReadTimeout, forward.WriteTimeout)
+	}
+}
+
+func TestListenerServerReadHeaderTimeoutCanBeDisabled(t *testing.T) {
+	config, err := loadConfig(writeTestConfig(t, `
+server:
+  port: 5601
+  read_header_timeout: 0s
+`))
+	if err != nil {
+		t.Fatalf("loadConfig failed: %v", err)
+	}
+	server := newListenerServer("localhost:5601", http.NotFoundHandler(), config.Server.ListenerTimeouts)
+	if server.ReadHeaderTimeout != 0 {
+		t.Fatalf("expected read header timeout to be disabled, got %v", server.ReadHeaderTimeout)
+	}
+}
+
+func TestAdminEndpointsRequireToken(t *testing.T) {
+	configFile := writeTestConfig(t, `
+server:
+  admin_drain: true
+  admin_metrics: true
+routes:
+  api:
+    pattern: "/api/"
+    destination: "http://127.0.0.1:1/"
+`)
+	config, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+	if _, err := buildReverseProxy(config, &loggingproxy.NoOpLogger{}, loggingproxy.HTTPClientProxyConfig{}, nil, newAdminEndpoints(config)); err == nil || !strings.Contains(err.Error(), "admin_token") {
+		t.Fatalf("expected admin endpoints without a token to be rejected, got %v", err)
+	}
+
+	config.Server.AdminToken = "secret"
+	admin := newAdminEndpoints(config)
+	handler, err := buildReverseProxy(config, &loggingproxy.NoOpLogger{}, loggingproxy.HTTPClientProxyConfig{}, nil, admin)
+	if err != nil {
+		t.Fatalf("failed to build proxy: %v", err)
+	}
+	serve := func(method, path, token string) int {
+		request := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+	if status := serve(http.MethodPost, "/admin/drain", ""); status != http.StatusUnauthorized || admin.readiness.Draining() {
+		t.Errorf("expected an unauthenticated drain to be refused, got %d", status)
+	}
+	if status := serve(http.MethodGet, "/admin/metrics", "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("expected metrics with a wrong token to be refused, got %d", status)
+	}
+	if status := serve(http.MethodPost, "/admin/drain", "secret"); status != http.StatusOK || !admin.readiness.Draining() {
+		t.Errorf("expected an authenticated drain to succeed, got %d", status)
+	}
+	// The probe stays open for load balancers
+	if status := serve(http.MethodGet, "/readyz", ""); status != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz to report draining without a token, got %d", status)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultRoutesInterval is how often server.routes_url is polled when
+// server.routes_interval is not set.
+const defaultRoutesInterval = 30 * time.Second
+
+// maxRoutesDocumentSize bounds the route document read from server.routes_url.
+const maxRoutesDocumentSize = 10 << 20
+
+// remoteRoutes serves the reverse proxy built from the route table last
+// fetched from a control-plane URL. When the fetched routes differ from the
+// current ones, a new reverse proxy is built and swapped in; requests already
+// in flight finish on the old one, which is closed to stop its health checks.
+// A failed fetch or build keeps the last good routes.
+type remoteRoutes struct {
+	url    string
+	client *http.Client
+	build  func(routes map[string]Route) (http.Handler, error)
+
+	mu      sync.RWMutex
+	routes  map[string]Route
+	handler http.Handler
+}
+
+func newRemoteRoutes(url string, routes map[string]Route, handler http.Handler, build func(map[string]Route) (http.Handler, error)) *remoteRoutes {
+	return &remoteRoutes{
+		url:     url,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		build:   build,
+		routes:  routes,
+		handler: handler,
+	}
+}
+
+func (r *remoteRoutes) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.RLock()
+	handler := r.handler
+	r.mu.RUnlock()
+	handler.ServeHTTP(w, req)
+}
+
+// run polls the route URL every interval. It never returns.
+func (r *remoteRoutes) run(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultRoutesInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		r.poll()
+	}
+}
+
+// poll fetches the routes once and applies them if they changed. It reports
+// whether new routes were applied.
+func (r *remoteRoutes) poll() bool {
+	routes, err := fetchRoutes(r.client, r.url)
+	if err != nil {
+		log.Printf("[error] Failed to fetch routes from %s, keeping the last good routes: %v", r.url, err)
+		return false
+	}
+
+	r.mu.RLock()
+	unchanged := reflect.DeepEqual(routes, r.routes)
+	r.mu.RUnlock()
+	if unchanged {
+		return false
+	}
+
+	handler, err := r.buildHandler(routes)
+	if err != nil {
+		log.Printf("[error] Failed to apply routes from %s, keeping the last good routes: %v", r.url, err)
+		return false
+	}
+	r.mu.Lock()
+	r.routes = routes
+	old := r.handler
+	r.handler = handler
+	r.mu.Unlock()
+	if closer, ok := old.(io.Closer); ok {
+		closer.Close()
+	}
+	log.Printf("[routes] Applied %d routes from %s", len(routes), r.url)
+	return true
+}
+
+// buildHandler builds a reverse proxy for routes. http.ServeMux panics on
+// conflicting patterns, which must not take down a running proxy.
+func (r *remoteRoutes) buildHandler(routes map[string]Route) (handler http.Handler, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%v", recovered)
+		}
+	}()
+	return r.build(routes)
+}
+
+// fetchRoutes reads a route table from url. The document has the same routes
+// section as the config file, in YAML or JSON.
+func fetchRoutes(client *http.Client, url string) (map[string]Route, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRoutesDocumentSize))
+	if err != nil {
+		return nil, err
+	}
+
+	var document struct {
+		Routes map[string]Route `yaml:"routes"`
+	}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if document.Routes == nil {
+		return nil, fmt.Errorf("document has no routes section")
+	}
+	return document.Routes, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	loggingproxy "github.com/mrexodia/logging-proxy"
+)
+
+func TestRemoteRoutesAppliesUpdatedRoutes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "backend %s", r.URL.Path)
+	}))
+	defer backend.Close()
+
+	var mu sync.Mutex
+	document := fmt.Sprintf(`{"routes": {"old": {"pattern": "/old/", "destination": %q}}}`, backend.URL+"/")
+	status := http.StatusOK
+	controlPlane := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+		io.WriteString(w, document)
+	}))
+	defer controlPlane.Close()
+	setDocument := func(newStatus int, newDocument string) {
+		mu.Lock()
+		defer mu.Unlock()
+		status, document = newStatus, newDocument
+	}
+
+	config := &Config{Server: &ServerConfig{Host: "localhost", Port: 5601}}
+	build := func(routes map[string]Route) (http.Handler, error) {
+		routeConfig := *config
+		routeConfig.Routes = routes
+		return buildReverseProxy(&routeConfig, &loggingproxy.NoOpLogger{}, loggingproxy.HTTPClientProxyConfig{}, nil, adminEndpoints{})
+	}
+	initial, err := build(nil)
+	if err != nil {
+		t.Fatalf("failed to build initial proxy: %v", err)
+	}
+	routes := newRemoteRoutes(controlPlane.URL, nil, initial, build)
+	proxyServer := httptest.NewServer(routes)
+	defer proxyServer.Close()
+
+	get := func(path string) (int, string) {
+		t.Helper()
+		resp, err := http.Get(proxyServer.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if !routes.poll() {
+		t.Fatal("expected the first poll to apply the remote routes")
+	}
+	if status, body := get("/old/items"); status != http.StatusOK || body != "backend /items" {
+		t.Fatalf("expected /old/ to be proxied, got %d %q", status, body)
+	}
+	if status, _ := get("/new/items"); status != http.StatusNotFound {
+		t.Fatalf("expected /new/ to be unknown before the update, got %d", status)
+	}
+	if routes.poll() {
+		t.Fatal("expected an unchanged route set not to be reapplied")
+	}
+
+	setDocument(http.StatusOK, fmt.Sprintf(`{"routes": {"new": {"pattern": "/new/", "destination": %q}}}`, backend.URL+"/v2/"))
+	if !routes.poll() {
+		t.Fatal("expected the updated routes to be applied")
+	}
+	if status, body := get("/new/items"); status != http.StatusOK || body != "backend /v2/items" {
+		t.Fatalf("expected /new/ to be proxied after the update, got %d %q", status, body)
+	}
+	if status, _ := get("/old/items"); status != http.StatusNotFound {
+		t.Fatalf("expected /old/ to be removed after the update, got %d", status)
+	}
+
+	// Failed fetches and route sets that cannot be built keep the last good routes
+	setDocument(http.StatusInternalServerError, "unavailable")
+	if routes.poll() {
+		t.Fatal("expected a failed fetch not to change the routes")
+	}
+	setDocument(http.StatusOK, fmt.Sprintf(`{"routes": {"a": {"pattern": "/dup/", "destination": %q}, "b": {"pattern": "/dup/", "destination": %q}}}`, backend.URL, backend.URL))
+	if routes.poll() {
+		t.Fatal("expected conflicting routes not to be applied")
+	}
+	if status, body := get("/new/items"); status != http.StatusOK || body != "backend /v2/items" {
+		t.Fatalf("expected the last good routes to stay active, got %d %q", status, body)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// defaultShutdownTimeout is how long in-flight requests may take to finish on
+// shutdown when shutdown_timeout is not set.
+const defaultShutdownTimeout = 30 * time.Second
+
+// shutdownServers stops the listeners from accepting new connections and
+// waits up to timeout for in-flight requests, including streaming responses,
+// to finish. Responses that have not started yet are sent with
+// "Connection: close". Connections still active after the timeout are closed.
+// Tunnels of the forward proxy are not tracked by net/http and end with the
+// process.
+func shutdownServers(servers []namedServer, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(s namedServer) {
+			defer wg.Done()
+			s.server.SetKeepAlivesEnabled(false)
+			if err := s.server.Shutdown(ctx); err != nil {
+				log.Printf("[shutdown] %s proxy: requests still active after %s, closing them", s.name, timeout)
+				s.server.Close()
+			}
+		}(srv)
+	}
+	wg.Wait()
+}
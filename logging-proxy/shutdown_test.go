@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// startStreamingServer serves chunks lines, one every interval, and returns
+// the server and its URL.
+func startStreamingServer(t *testing.T, chunks int, interval time.Duration) (namedServer, string) {
+	t.Helper()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < chunks && r.Context().Err() == nil; i++ {
+			fmt.Fprintf(w, "chunk %d\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(interval)
+		}
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	srv := namedServer{name: "reverse", server: newListenerServer(listener.Addr().String(), handler, ListenerTimeouts{})}
+	go srv.server.Serve(listener)
+	t.Cleanup(func() { srv.server.Close() })
+	return srv, "http://" + listener.Addr().String()
+}
+
+// startStream starts a streaming request and waits for its first chunk.
+func startStream(t *testing.T, url string) *http.Response {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestShutdownLetsStreamingRequestFinish(t *testing.T) {
+	srv, url := startStreamingServer(t, 5, 50*time.Millisecond)
+	resp := startStream(t, url)
+	reader := bufio.NewReader(resp.Body)
+	if line, err := reader.ReadString('\n'); err != nil || line != "chunk 0\n" {
+		t.Fatalf("expected the first chunk, got %q: %v", line, err)
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		shutdownServers([]namedServer{srv}, 5*time.Second)
+		close(shutdownDone)
+	}()
+
+	rest, err := io.ReadAll(reader)
+	if err != nil || string(rest) != "chunk 1\nchunk 2\nchunk 3\nchunk 4\n" {
+		t.Fatalf("expected the stream to finish, got %q: %v", rest, err)
+	}
+	select {
+	case <-shutdownDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown did not return after the stream finished")
+	}
+	if _, err := http.Get(url); err == nil {
+		t.Error("expected new connections to be refused after shutdown")
+	}
+}
+
+func TestShutdownClosesStreamsAfterTimeout(t *testing.T) {
+	srv, url := startStreamingServer(t, 100, 50*time.Millisecond)
+	resp := startStream(t, url)
+
+	start := time.Now()
+	shutdownServers([]namedServer{srv}, 200*time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected shutdown to give up after the timeout, took %s", elapsed)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err == nil {
+		t.Errorf("expected the stream to be cut off, read %d bytes without error", len(body))
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"runtime/debug"
+	"slices"
+	"strings"
+)
+
+// startupOutputEnv selects how startup is reported: "text", the default,
+// logs a line per route, listener and logging feature; "json" prints a single
+// JSON object to stdout instead, for supervisors that parse it.
+const startupOutputEnv = "LOGGING_PROXY_STARTUP_OUTPUT"
+
+// version is the release version, set with -ldflags "-X main.version=...".
+// Without it the module version from the build info is reported.
+var version string
+
+// quietStartup suppresses the informational startup lines. Warnings and
+// errors are still logged.
+var quietStartup bool
+
+// startupf logs an informational startup line unless quietStartup is set.
+func startupf(format string, args ...any) {
+	if !quietStartup {
+		log.Printf(format, args...)
+	}
+}
+
+// jsonStartupOutput reports whether startupOutputEnv asks for JSON.
+func jsonStartupOutput() (bool, error) {
+	switch value := strings.ToLower(strings.TrimSpace(os.Getenv(startupOutputEnv))); value {
+	case "", "text":
+		return false, nil
+	case "json":
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s must be text or json, got %q", startupOutputEnv, value)
+	}
+}
+
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// startupInfo is the JSON startup output.
+type startupInfo struct {
+	Version   string            `json:"version"`
+	Config    string            `json:"config"`
+	Listeners []startupListener `json:"listeners"`
+	Routes    []startupRoute    `json:"routes"`
+}
+
+type startupListener struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+// startupRoute describes a configured route. Routes fetched from
+// server.routes_url later are not included.
+type startupRoute struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Destination string `json:"destination"`
+	Logging     bool   `json:"logging"`
+}
+
+func newStartupInfo(configFile string, config *Config, servers []namedServer) startupInfo {
+	info := startupInfo{
+		Version:   buildVersion(),
+		Config:    configFile,
+		Listeners: []startupListener{},
+		Routes:    []startupRoute{},
+	}
+	for _, srv := range servers {
+		info.Listeners = append(info.Listeners, startupListener{Name: srv.name, Address: srv.server.Addr})
+	}
+	if config.Server == nil {
+		return info
+	}
+	for name, route := range config.Routes {
+		destination := route.Destination
+		if len(route.Backends) > 0 {
+			var destinations []string
+			for _, backend := range route.Backends {
+				destinations = append(destinations, backend.Destination)
+			}
+			destination = strings.Join(destinations, ", ")
+		}
+		logging := config.Logging.Enabled
+		if route.Logging != nil {
+			logging = *route.Logging
+		}
+		info.Routes = append(info.Routes, startupRoute{Name: name, Pattern: route.Pattern, Destination: destination, Logging: logging})
+	}
+	slices.SortFunc(info.Routes, func(a, b startupRoute) int { return strings.Compare(a.Name, b.Name) })
+	return info
+}
+
+// writeStartupInfo writes info as one line of JSON.
+func writeStartupInfo(w io.Writer, info startupInfo) error {
+	return json.NewEncoder(w).Encode(info)
+}
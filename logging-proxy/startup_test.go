@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	loggingproxy "github.com/mrexodia/logging-proxy"
+)
+
+func TestJSONStartupOutputListsRoutesAndAddress(t *testing.T) {
+	configFile := writeTestConfig(t, `
+server:
+  host: 127.0.0.1
+  port: 5601
+logging:
+  enabled: true
+routes:
+  openai:
+    pattern: "/openai/"
+    destination: "https://api.openai.com/"
+  local:
+    pattern: "/local"
+    destination: "http://127.0.0.1:8080/"
+    logging: false
+`)
+	config, err := loadConfig(configFile)
+	if err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+
+	t.Setenv(startupOutputEnv, "json")
+	jsonStartup, err := jsonStartupOutput()
+	if err != nil || !jsonStartup {
+		t.Fatalf("expected JSON startup output, got %v (%v)", jsonStartup, err)
+	}
+	quietStartup = jsonStartup
+	defer func() { quietStartup = false }()
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+
+	handler, err := buildReverseProxy(config, &loggingproxy.NoOpLogger{}, loggingproxy.HTTPClientProxyConfig{}, nil, newAdminEndpoints(config))
+	if err != nil {
+		t.Fatalf("failed to build proxy: %v", err)
+	}
+	servers := []namedServer{{
+		name:   "reverse",
+		server: newListenerServer(fmt.Sprintf("%s:%d", config.Server.Host, config.Server.Port), handler, config.Server.ListenerTimeouts),
+	}}
+	var output bytes.Buffer
+	if err := writeStartupInfo(&output, newStartupInfo(configFile, config, servers)); err != nil {
+		t.Fatalf("failed to write startup info: %v", err)
+	}
+
+	// Only the warning about /local is logged
+	if strings.Contains(logged.String(), "[route]") || !strings.Contains(logged.String(), "(warning)") {
+		t.Errorf("expected only warnings to be logged, got %q", logged.String())
+	}
+	if strings.Count(output.String(), "\n") != 1 {
+		t.Errorf("expected a single line of JSON, got %q", output.String())
+	}
+	var info startupInfo
+	if err := json.Unmarshal(output.Bytes(), &info); err != nil {
+		t.Fatalf("invalid startup JSON %q: %v", output.String(), err)
+	}
+	if info.Version == "" || info.Config != configFile {
+		t.Errorf("expected version and config file, got %+v", info)
+	}
+	if len(info.Listeners) != 1 || info.Listeners[0] != (startupListener{Name: "reverse", Address: "127.0.0.1:5601"}) {
+		t.Errorf("expected the reverse listener address, got %+v", info.Listeners)
+	}
+	expectedRoutes := []startupRoute{
+		{Name: "local", Pattern: "/local", Destination: "http://127.0.0.1:8080/", Logging: false},
+		{Name: "openai", Pattern: "/openai/", Destination: "https://api.openai.com/", Logging: true},
+	}
+	if fmt.Sprint(info.Routes) != fmt.Sprint(expectedRoutes) {
+		t.Errorf("expected routes %+v, got %+v", expectedRoutes, info.Routes)
+	}
+
+	t.Setenv(startupOutputEnv, "yaml")
+	if _, err := jsonStartupOutput(); err == nil {
+		t.Error("expected an unknown startup output to be rejected")
+	}
+}
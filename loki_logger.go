@@ -0,0 +1,250 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LokiLoggerOptions configures a LokiLogger.
+type LokiLoggerOptions struct {
+	// Path is the file the lines are appended to.
+	Path string
+	// Logger receives every stream as well, typically a FileLogger. Nil only
+	// writes the lines.
+	Logger Logger
+	// MaxSize rotates the file before a line would grow it past this many
+	// bytes. Zero does not rotate by size.
+	MaxSize int64
+	// MaxAge rotates the file once it has been written to for this long.
+	// Zero does not rotate by time.
+	MaxAge time.Duration
+	// MaxBackups removes the oldest rotated files beyond this many. Zero
+	// keeps them all.
+	MaxBackups int
+	// Clock drives MaxAge and names rotated files. Nil uses the wall clock.
+	Clock Clock
+	// LogLevel filters the logger's own messages, such as write failures.
+	LogLevel LogLevel
+}
+
+// LokiEntry is one line written by LokiLogger. Timestamp, Level, Message and
+// Labels are the fields Grafana Loki and Vector pipelines expect; the rest
+// identify the exchange.
+type LokiEntry struct {
+	Timestamp time.Time         `json:"timestamp"`
+	Level     string            `json:"level"`
+	Message   string            `json:"message"`
+	Labels    map[string]string `json:"labels"`
+
+	RequestID      string `json:"request_id"`
+	SourceURL      string `json:"source_url"`
+	DestinationURL string `json:"destination_url"`
+	BodyBytes      int64  `json:"body_bytes"`
+	DurationMS     *int64 `json:"duration_ms,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// LokiLogger writes one JSON line per logged request and response to a file
+// for ingestion into Grafana Loki or Vector. The labels are the route
+// pattern, method and direction, plus the status code of responses. The
+// level is "error" for 5xx responses and streams that could not be read
+// completely, "warn" for 4xx responses and blocked requests, and "info"
+// otherwise. Rotated files keep the name of Path with the rotation time
+// inserted before the extension, as in proxy-20260102T030405.000.ndjson.
+type LokiLogger struct {
+	logger Logger
+
+	mu   sync.Mutex
+	file *rotatingFile
+}
+
+// NewLokiLogger opens the file and returns a LokiLogger.
+func NewLokiLogger(options LokiLoggerOptions) (*LokiLogger, error) {
+	if options.Path == "" {
+		return nil, errors.New("loki logger needs a path")
+	}
+	file := &rotatingFile{
+		path:       options.Path,
+		maxSize:    options.MaxSize,
+		maxAge:     options.MaxAge,
+		maxBackups: options.MaxBackups,
+		clock:      clockOrReal(options.Clock),
+		ops:        levelLogger{level: options.LogLevel},
+	}
+	if err := file.open(); err != nil {
+		return nil, err
+	}
+	return &LokiLogger{logger: options.Logger, file: file}, nil
+}
+
+// LogRequest forwards the request stream and writes its line
+func (l *LokiLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	l.writeLine(metadata, timestamp, "request", rawRequestStream, forwardTo(l.logger, "request", metadata, timestamp))
+}
+
+// LogResponse forwards the response stream and writes its line
+func (l *LokiLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	l.writeLine(metadata, timestamp, "response", rawResponseStream, forwardTo(l.logger, "response", metadata, timestamp))
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *LokiLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(l.logger, metadata, timestamp)
+}
+
+// Close closes the file and the wrapped logger if it implements io.Closer.
+func (l *LokiLogger) Close() error {
+	l.mu.Lock()
+	err := l.file.close()
+	l.mu.Unlock()
+	if closeErr := closeWrapped(l.logger); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (l *LokiLogger) writeLine(metadata RequestMetadata, timestamp time.Time, direction string, stream io.ReadCloser, forward func(io.ReadCloser)) {
+	body := &logfmtBody{}
+	streamErr := copyLoggedStream(stream, body, forward)
+
+	entry := LokiEntry{
+		Timestamp: timestamp.UTC(),
+		Level:     "info",
+		Message:   fmt.Sprintf("%s %s", metadata.Method, metadata.DestinationURL),
+		Labels: map[string]string{
+			"route":     metadata.Pattern,
+			"method":    metadata.Method,
+			"direction": direction,
+		},
+		RequestID:      metadata.ID,
+		SourceURL:      metadata.SourceURL,
+		DestinationURL: metadata.DestinationURL,
+		BodyBytes:      body.size,
+	}
+	if direction == "response" {
+		status := metadata.ResponseStatusCode
+		entry.Labels["status"] = strconv.Itoa(status)
+		entry.Message += " " + strconv.Itoa(status)
+		durationMS := timestamp.Sub(metadata.RequestStartedAt).Milliseconds()
+		entry.DurationMS = &durationMS
+		if status >= 500 {
+			entry.Level = "error"
+		} else if status >= 400 {
+			entry.Level = "warn"
+		}
+	}
+	if metadata.Blocked {
+		entry.Level = "warn"
+		entry.Message += " (blocked)"
+	}
+	if streamErr != nil {
+		entry.Level = "error"
+		entry.Error = streamErr.Error()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.file.write(append(line, '\n')); err != nil {
+		l.file.ops.Errorf("Failed to write loki log %s: %v", l.file.path, err)
+	}
+}
+
+// rotatingFile appends to path, moving it aside once it grows past maxSize or
+// gets older than maxAge. It is not safe for concurrent use.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	clock      Clock
+	ops        levelLogger
+
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = f.clock.Now()
+	return nil
+}
+
+func (f *rotatingFile) write(line []byte) error {
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	if f.size > 0 && ((f.maxSize > 0 && f.size+int64(len(line)) > f.maxSize) ||
+		(f.maxAge > 0 && f.clock.Now().Sub(f.openedAt) >= f.maxAge)) {
+		if err := f.rotate(); err != nil {
+			if f.file == nil {
+				return err
+			}
+			f.ops.Errorf("Failed to rotate %s: %v", f.path, err)
+		}
+	}
+	n, err := f.file.Write(line)
+	f.size += int64(n)
+	return err
+}
+
+func (f *rotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	extension := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, extension)
+	backup := base + "-" + f.clock.Now().UTC().Format("20060102T150405.000") + extension
+	// Keep appending to the current file if it cannot be moved aside
+	renameErr := os.Rename(f.path, backup)
+	if err := f.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return renameErr
+	}
+
+	if f.maxBackups > 0 {
+		// The timestamps sort in rotation order
+		backups, _ := filepath.Glob(base + "-????????T??????.???" + extension)
+		slices.Sort(backups)
+		for len(backups) > f.maxBackups {
+			os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+	return nil
+}
+
+func (f *rotatingFile) close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
@@ -0,0 +1,118 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLokiLoggerWritesEntriesWithLabels(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "not here")
+	}))
+	defer backend.Close()
+
+	path := filepath.Join(t.TempDir(), "proxy.ndjson")
+	testLogger := &TestLogger{}
+	lokiLogger, err := NewLokiLogger(LokiLoggerOptions{Path: path, Logger: testLogger})
+	if err != nil {
+		t.Fatal("Failed to create logger:", err)
+	}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", lokiLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Post(testServer.URL+"/api/items", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	lokiLogger.Close()
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal("Failed to read log:", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(raw)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected a request and a response line, got %q", raw)
+	}
+	for _, line := range lines {
+		var fields map[string]any
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			t.Fatalf("Invalid JSON line %q: %v", line, err)
+		}
+		for _, field := range []string{"timestamp", "level", "message", "labels"} {
+			if _, ok := fields[field]; !ok {
+				t.Errorf("Expected a top-level %s in %s", field, line)
+			}
+		}
+		var entry LokiEntry
+		json.Unmarshal([]byte(line), &entry)
+		if entry.Labels["route"] != "/api/" || entry.Labels["method"] != http.MethodPost {
+			t.Errorf("Expected the route and method labels, got %v", entry.Labels)
+		}
+		switch entry.Labels["direction"] {
+		case "request":
+			if entry.Level != "info" || entry.BodyBytes != 5 || entry.Labels["status"] != "" {
+				t.Errorf("Unexpected request entry: %s", line)
+			}
+		case "response":
+			if entry.Level != "warn" || entry.BodyBytes != 8 || entry.Labels["status"] != "404" || !strings.HasSuffix(entry.Message, " 404") {
+				t.Errorf("Unexpected response entry: %s", line)
+			}
+		default:
+			t.Errorf("Unexpected direction in %s", line)
+		}
+	}
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Errorf("Expected the streams to be forwarded, got %d requests and %d responses", len(testLogger.requests), len(testLogger.responses))
+	}
+}
+
+func TestLokiLoggerRotatesBySizeAndAge(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "proxy.ndjson")
+	clock := &fakeClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), step: time.Second}
+	lokiLogger, err := NewLokiLogger(LokiLoggerOptions{Path: path, MaxSize: 400, MaxAge: time.Hour, MaxBackups: 2, Clock: clock})
+	if err != nil {
+		t.Fatal("Failed to create logger:", err)
+	}
+	defer lokiLogger.Close()
+
+	metadata := RequestMetadata{ID: "id", Pattern: "/", Method: http.MethodGet, DestinationURL: "http://backend/"}
+	for range 5 {
+		lokiLogger.LogRequest(metadata, time.Now(), io.NopCloser(strings.NewReader("GET / HTTP/1.1\r\n\r\n")))
+	}
+	backups, _ := filepath.Glob(filepath.Join(dir, "proxy-*.ndjson"))
+	if len(backups) != 2 {
+		t.Errorf("Expected the two newest rotated files to be kept, got %v", backups)
+	}
+	raw, _ := os.ReadFile(path)
+	if lines := strings.Count(string(raw), "\n"); lines == 0 || int64(len(raw)) > 400 {
+		t.Errorf("Expected the current file to stay under the size limit, got %d bytes", len(raw))
+	}
+
+	// A file older than MaxAge is rotated on the next line
+	clock.mu.Lock()
+	clock.now = clock.now.Add(2 * time.Hour)
+	clock.mu.Unlock()
+	lokiLogger.LogRequest(metadata, time.Now(), io.NopCloser(strings.NewReader("GET / HTTP/1.1\r\n\r\n")))
+	raw, _ = os.ReadFile(path)
+	if lines := strings.Count(string(raw), "\n"); lines != 1 {
+		t.Errorf("Expected a fresh file after MaxAge, got %d lines", lines)
+	}
+}
@@ -0,0 +1,258 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsSizeBuckets are the upper bounds, in bytes, of the body size
+// histogram buckets.
+var metricsSizeBuckets = []int64{256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20}
+
+// responseSizeBuckets are the upper bounds, in bytes, of the response size
+// histogram buckets: 128 B to 32 MiB in steps of four, which spreads typical
+// API payloads over several buckets.
+var responseSizeBuckets = exponentialBuckets(128, 4, 10)
+
+func exponentialBuckets(start, factor int64, count int) []int64 {
+	buckets := make([]int64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// Metrics collects per-route body size histograms by direction and content
+// type, and serves them in the Prometheus text format. Content types are
+// bucketed into a fixed set of labels (see ContentTypeBucket), so the number
+// of series is bounded by the number of routes. It also collects a histogram
+// of the response bytes sent to clients per route, see
+// ProxyServerOptions.Metrics.
+type Metrics struct {
+	mu        sync.Mutex
+	series    map[metricsKey]*bodySizeHistogram
+	responses map[string]*bodySizeHistogram
+}
+
+type metricsKey struct {
+	route       string
+	direction   string
+	contentType string
+}
+
+type bodySizeHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     int64
+}
+
+// NewMetrics creates an empty metrics collector.
+func NewMetrics() *Metrics {
+	return &Metrics{series: map[metricsKey]*bodySizeHistogram{}, responses: map[string]*bodySizeHistogram{}}
+}
+
+func (h *bodySizeHistogram) observe(bounds []int64, size int64) {
+	for i, bound := range bounds {
+		if size <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += size
+}
+
+func (h *bodySizeHistogram) write(buf *bytes.Buffer, name, labels string, bounds []int64) {
+	for i, bound := range bounds {
+		fmt.Fprintf(buf, "%s_bucket{%s,le=\"%d\"} %d\n", name, labels, bound, h.buckets[i])
+	}
+	fmt.Fprintf(buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(buf, "%s_sum{%s} %d\n", name, labels, h.sum)
+	fmt.Fprintf(buf, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// ObserveBody records a request or response body of size bytes for route.
+// direction is "request" or "response" and contentType the raw Content-Type
+// header value.
+func (m *Metrics) ObserveBody(route string, direction string, contentType string, size int64) {
+	key := metricsKey{route: route, direction: direction, contentType: ContentTypeBucket(contentType)}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	histogram, ok := m.series[key]
+	if !ok {
+		histogram = &bodySizeHistogram{buckets: make([]uint64, len(metricsSizeBuckets))}
+		m.series[key] = histogram
+	}
+	histogram.observe(metricsSizeBuckets, size)
+}
+
+// ObserveResponseSize records a response of size body bytes sent to the
+// client of route. Unlike ObserveBody, the size is the one on the wire, so
+// compressed responses count their compressed size.
+func (m *Metrics) ObserveResponseSize(route string, size int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	histogram, ok := m.responses[route]
+	if !ok {
+		histogram = &bodySizeHistogram{buckets: make([]uint64, len(responseSizeBuckets))}
+		m.responses[route] = histogram
+	}
+	histogram.observe(responseSizeBuckets, size)
+}
+
+// ServeHTTP writes the collected metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the collected metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	keys := make([]metricsKey, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.route != b.route {
+			return a.route < b.route
+		}
+		if a.direction != b.direction {
+			return a.direction < b.direction
+		}
+		return a.contentType < b.contentType
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("# HELP logging_proxy_body_size_bytes Size of logged request and response bodies by route, direction and content type.\n")
+	buf.WriteString("# TYPE logging_proxy_body_size_bytes histogram\n")
+	for _, key := range keys {
+		histogram := m.series[key]
+		labels := fmt.Sprintf(`route="%s",direction="%s",content_type="%s"`,
+			escapeMetricLabel(key.route), escapeMetricLabel(key.direction), escapeMetricLabel(key.contentType))
+		histogram.write(&buf, "logging_proxy_body_size_bytes", labels, metricsSizeBuckets)
+	}
+
+	if len(m.responses) > 0 {
+		routes := make([]string, 0, len(m.responses))
+		for route := range m.responses {
+			routes = append(routes, route)
+		}
+		sort.Strings(routes)
+		buf.WriteString("# HELP logging_proxy_response_size_bytes Size of response bodies sent to clients by route.\n")
+		buf.WriteString("# TYPE logging_proxy_response_size_bytes histogram\n")
+		for _, route := range routes {
+			labels := fmt.Sprintf(`route="%s"`, escapeMetricLabel(route))
+			m.responses[route].write(&buf, "logging_proxy_response_size_bytes", labels, responseSizeBuckets)
+		}
+	}
+	m.mu.Unlock()
+
+	return buf.WriteTo(w)
+}
+
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// ContentTypeBucket maps a Content-Type header value to one of a fixed set of
+// metric labels: json, sse, ndjson, html, xml, form, multipart, text, image,
+// audio, video, binary, none (no Content-Type) and other.
+func ContentTypeBucket(contentType string) string {
+	if strings.TrimSpace(contentType) == "" {
+		return "none"
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "other"
+	}
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return "json"
+	case mediaType == "text/event-stream":
+		return "sse"
+	case mediaType == "application/x-ndjson" || mediaType == "application/jsonl":
+		return "ndjson"
+	case mediaType == "text/html":
+		return "html"
+	case mediaType == "application/xml" || mediaType == "text/xml" || strings.HasSuffix(mediaType, "+xml"):
+		return "xml"
+	case mediaType == "application/x-www-form-urlencoded":
+		return "form"
+	case strings.HasPrefix(mediaType, "multipart/"):
+		return "multipart"
+	case strings.HasPrefix(mediaType, "text/"):
+		return "text"
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image"
+	case strings.HasPrefix(mediaType, "audio/"):
+		return "audio"
+	case strings.HasPrefix(mediaType, "video/"):
+		return "video"
+	case mediaType == "application/octet-stream" || mediaType == "application/pdf" ||
+		mediaType == "application/zip" || mediaType == "application/gzip" || mediaType == "application/protobuf":
+		return "binary"
+	}
+	return "other"
+}
+
+// MetricsLogger records the content type and body size of every logged
+// request and response in Metrics, labeled with the route pattern. Sizes are
+// those of the logged, decompressed bodies. A nil Logger discards the streams.
+type MetricsLogger struct {
+	Logger  Logger
+	Metrics *Metrics
+}
+
+// NewMetricsLogger wraps logger so body sizes are recorded in metrics.
+func NewMetricsLogger(logger Logger, metrics *Metrics) *MetricsLogger {
+	return &MetricsLogger{
+		Logger:  logger,
+		Metrics: metrics,
+	}
+}
+
+// LogRequest forwards the request stream and records its body
+func (l *MetricsLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	l.observe(metadata, "request", rawRequestStream, forwardTo(l.Logger, "request", metadata, timestamp))
+}
+
+// LogResponse forwards the response stream and records its body
+func (l *MetricsLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	l.observe(metadata, "response", rawResponseStream, forwardTo(l.Logger, "response", metadata, timestamp))
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *MetricsLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(l.Logger, metadata, timestamp)
+}
+
+// Close closes the wrapped logger if it implements io.Closer.
+func (l *MetricsLogger) Close() error {
+	return closeWrapped(l.Logger)
+}
+
+// observe reads the head of stream for its Content-Type, forwards the whole
+// stream and records the size of the body the wrapped logger consumed.
+func (l *MetricsLogger) observe(metadata RequestMetadata, direction string, stream io.ReadCloser, forward func(io.ReadCloser)) {
+	reader := bufio.NewReader(stream)
+	head, header, _ := readStreamHead(reader)
+	body := &countingReadCloser{ReadCloser: &readCloser{Reader: reader, Closer: stream}}
+	forwarded := &readCloser{Reader: io.MultiReader(bytes.NewReader(head), body), Closer: body}
+	if l.Logger == nil {
+		discardStream(forwarded)
+	} else {
+		forward(forwarded)
+	}
+	l.Metrics.ObserveBody(metadata.Pattern, direction, header.Get("Content-Type"), body.n.Load())
+}
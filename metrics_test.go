@@ -0,0 +1,174 @@
+package loggingproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContentTypeBucket(t *testing.T) {
+	tests := map[string]string{
+		"":                                "none",
+		"application/json; charset=utf-8": "json",
+		"application/problem+json":        "json",
+		"text/event-stream":               "sse",
+		"application/x-ndjson":            "ndjson",
+		"text/plain":                      "text",
+		"multipart/form-data; boundary=x": "multipart",
+		"image/png":                       "image",
+		"application/octet-stream":        "binary",
+		"application/x-custom-thing":      "other",
+		"not a content type;;":            "other",
+	}
+	for contentType, expected := range tests {
+		if bucket := ContentTypeBucket(contentType); bucket != expected {
+			t.Errorf("ContentTypeBucket(%q) = %q, want %q", contentType, bucket, expected)
+		}
+	}
+}
+
+func TestMetricsLoggerRecordsContentTypes(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		switch r.URL.Path {
+		case "/chat":
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: one\n\ndata: two\n\n")
+		case "/download":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(make([]byte, 5000))
+		default:
+			w.Header().Set("Content-Type", "application/vnd.custom")
+			io.WriteString(w, "custom")
+		}
+	}))
+	defer backend.Close()
+
+	metrics := NewMetrics()
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", NewMetricsLogger(nil, metrics)); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	requests := []struct {
+		path        string
+		contentType string
+		body        string
+	}{
+		{"/api/chat", "application/json", `{"prompt":"hi"}`},
+		{"/api/chat", "application/json", `{"prompt":"again"}`},
+		{"/api/download", "", ""},
+		{"/api/custom", "text/plain", "hello"},
+	}
+	for _, request := range requests {
+		req, _ := http.NewRequest(http.MethodPost, testServer.URL+request.path, strings.NewReader(request.body))
+		if request.contentType != "" {
+			req.Header.Set("Content-Type", request.contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request to %s failed: %v", request.path, err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	scrape := httptest.NewRecorder()
+	metrics.ServeHTTP(scrape, httptest.NewRequest(http.MethodGet, "/admin/metrics", nil))
+	output := scrape.Body.String()
+	for _, expected := range []string{
+		"# TYPE logging_proxy_body_size_bytes histogram",
+		`logging_proxy_body_size_bytes_count{route="/api/",direction="request",content_type="json"} 2`,
+		`logging_proxy_body_size_bytes_sum{route="/api/",direction="request",content_type="json"} 33`,
+		`logging_proxy_body_size_bytes_count{route="/api/",direction="request",content_type="none"} 1`,
+		`logging_proxy_body_size_bytes_count{route="/api/",direction="request",content_type="text"} 1`,
+		`logging_proxy_body_size_bytes_count{route="/api/",direction="response",content_type="sse"} 2`,
+		`logging_proxy_body_size_bytes_sum{route="/api/",direction="response",content_type="binary"} 5000`,
+		`logging_proxy_body_size_bytes_bucket{route="/api/",direction="response",content_type="binary",le="4096"} 0`,
+		`logging_proxy_body_size_bytes_bucket{route="/api/",direction="response",content_type="binary",le="16384"} 1`,
+		`logging_proxy_body_size_bytes_count{route="/api/",direction="response",content_type="other"} 1`,
+	} {
+		if !strings.Contains(output, expected+"\n") {
+			t.Errorf("expected metrics to contain %q, got:\n%s", expected, output)
+		}
+	}
+}
+
+func TestMetricsLoggerForwardsFullStream(t *testing.T) {
+	testLogger := &TestLogger{}
+	metrics := NewMetrics()
+	transcript := "HTTP/1.1 200 OK\r\nContent-Type: application/json\r\n\r\n{\"ok\":true}"
+	NewMetricsLogger(testLogger, metrics).LogResponse(RequestMetadata{Pattern: "/api/"}, time.Now(), io.NopCloser(strings.NewReader(transcript)))
+
+	if len(testLogger.responses) != 1 || testLogger.responses[0].content != transcript {
+		t.Fatalf("expected the wrapped logger to get the full transcript, got %+v", testLogger.responses)
+	}
+	var output strings.Builder
+	metrics.WriteTo(&output)
+	if !strings.Contains(output.String(), `logging_proxy_body_size_bytes_sum{route="/api/",direction="response",content_type="json"} 11`) {
+		t.Fatalf("expected the 11 byte body to be recorded, got:\n%s", output.String())
+	}
+}
+
+func TestResponseSizeHistogramPerRoute(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var size int
+		fmt.Sscan(strings.TrimPrefix(r.URL.Path, "/"), &size)
+		w.Write(make([]byte, size))
+	}))
+	defer backend.Close()
+
+	metrics := NewMetrics()
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy: HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		Metrics:     metrics,
+	})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	for _, pattern := range []string{"/api/", "/other/"} {
+		if err := proxyServer.AddRoute(pattern, backend.URL+"/", &NoOpLogger{}); err != nil {
+			t.Fatal("Failed to add route:", err)
+		}
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for _, path := range []string{"/api/100", "/api/1000", "/api/5000", "/other/200000"} {
+		resp, err := http.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	var output strings.Builder
+	metrics.WriteTo(&output)
+	for _, line := range []string{
+		"# TYPE logging_proxy_response_size_bytes histogram",
+		`logging_proxy_response_size_bytes_bucket{route="/api/",le="128"} 1`,
+		`logging_proxy_response_size_bytes_bucket{route="/api/",le="512"} 1`,
+		`logging_proxy_response_size_bytes_bucket{route="/api/",le="2048"} 2`,
+		`logging_proxy_response_size_bytes_bucket{route="/api/",le="8192"} 3`,
+		`logging_proxy_response_size_bytes_bucket{route="/api/",le="33554432"} 3`,
+		`logging_proxy_response_size_bytes_bucket{route="/api/",le="+Inf"} 3`,
+		`logging_proxy_response_size_bytes_sum{route="/api/"} 6100`,
+		`logging_proxy_response_size_bytes_count{route="/api/"} 3`,
+		`logging_proxy_response_size_bytes_bucket{route="/other/",le="131072"} 0`,
+		`logging_proxy_response_size_bytes_bucket{route="/other/",le="524288"} 1`,
+	} {
+		if !strings.Contains(output.String(), line+"\n") {
+			t.Errorf("Expected metrics to contain %q, got:\n%s", line, output.String())
+		}
+	}
+}
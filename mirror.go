@@ -0,0 +1,236 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultMirrorMaxBody is the largest request body mirrored when
+// RouteOptions.MirrorMaxBody is not positive.
+const DefaultMirrorMaxBody = 1 << 20
+
+// mirrorTimeout bounds a mirrored request, including reading its response.
+const mirrorTimeout = 30 * time.Second
+
+// maxMirrorsInFlight caps the mirrored requests of a route that have not
+// finished yet. Requests over the cap are not mirrored, so a slow shadow
+// backend cannot pile up goroutines and buffered bodies.
+const maxMirrorsInFlight = 64
+
+// routeMirror is the shadow backend of a route with RouteOptions.MirrorTo.
+type routeMirror struct {
+	destination    string
+	destinationURL url.URL
+	maxBody        int64
+	log            bool
+	// compare is set for routes with RouteOptions.MirrorCompare.
+	compare *mirrorComparer
+	slots   chan struct{}
+}
+
+// newRouteMirror returns nil if destination is empty.
+func newRouteMirror(destination string, maxBody int64, log bool, comparison *MirrorComparison, ops levelLogger) (*routeMirror, error) {
+	if destination == "" {
+		if comparison != nil {
+			return nil, errors.New("mirror comparison needs a mirror")
+		}
+		return nil, nil
+	}
+	destinationURL, err := parseDestinationURL(destination)
+	if err != nil {
+		return nil, fmt.Errorf("mirror: %w", err)
+	}
+	if maxBody <= 0 {
+		maxBody = DefaultMirrorMaxBody
+	}
+	compare, err := newMirrorComparer(comparison, ops)
+	if err != nil {
+		return nil, err
+	}
+	return &routeMirror{
+		destination:    destination,
+		destinationURL: *destinationURL,
+		maxBody:        maxBody,
+		log:            log,
+		compare:        compare,
+		slots:          make(chan struct{}, maxMirrorsInFlight),
+	}, nil
+}
+
+// mirrors reports whether a request body can be buffered for the mirror.
+// Bodies of unknown length are streaming uploads and are not mirrored.
+func (m *routeMirror) mirrors(request *http.Request) bool {
+	return m != nil && request.ContentLength >= 0 && request.ContentLength <= m.maxBody
+}
+
+// mirroredRequest is a copy of an outgoing request for the mirror.
+type mirroredRequest struct {
+	method          string
+	url             url.URL
+	header          http.Header
+	body            []byte
+	contentEncoding string
+}
+
+// sendMirror sends the copy of a request to the route's mirror in the
+// background and discards the response, logging the exchange with
+// RequestMetadata.Mirror set if the route asks for it. metadata describes the
+// primary request, and primary captures its response if the route compares
+// the responses.
+func (s *ProxyServer) sendMirror(route *proxyRoute, mirrored mirroredRequest, primary *responseCapture, metadata RequestMetadata, logger Logger) {
+	select {
+	case route.mirror.slots <- struct{}{}:
+	default:
+		s.ops.Debugf("[mirror] %s: too many mirrored requests in flight, not mirroring", shortMetadataID(metadata))
+		return
+	}
+
+	go func() {
+		defer func() { <-route.mirror.slots }()
+		ctx, cancel := context.WithTimeout(context.Background(), mirrorTimeout)
+		defer cancel()
+
+		request, err := http.NewRequestWithContext(ctx, mirrored.method, mirrored.url.String(), bytes.NewReader(mirrored.body))
+		if err != nil {
+			s.ops.Infof("[mirror] %s: failed to create mirrored request: %v", shortMetadataID(metadata), err)
+			return
+		}
+		request.Header = mirrored.header
+		if len(mirrored.body) == 0 {
+			request.Body = http.NoBody
+		}
+
+		mirrorMetadata := RequestMetadata{
+			ID:                  newRequestID(s.idGenerator),
+			Pattern:             metadata.Pattern,
+			DestinationTemplate: route.mirror.destination,
+			Method:              metadata.Method,
+			SourceURL:           metadata.SourceURL,
+			DestinationURL:      mirrored.url.String(),
+			RequestStartedAt:    s.clock.Now(),
+			Subject:             metadata.Subject,
+			LoggingEnabled:      metadata.LoggingEnabled,
+			LoggingSource:       metadata.LoggingSource,
+			Mirror:              true,
+			MirrorOf:            metadata.ID,
+		}
+		captured := route.mirror.compare.newCapture()
+		if captured != nil {
+			defer func() {
+				captured.finish()
+				route.mirror.compare.compare(MirrorDiff{
+					RequestID:      metadata.ID,
+					MirrorID:       mirrorMetadata.ID,
+					Timestamp:      mirrorMetadata.RequestStartedAt,
+					Method:         metadata.Method,
+					DestinationURL: metadata.DestinationURL,
+					MirrorURL:      mirrorMetadata.DestinationURL,
+				}, primary, captured, ctx.Done())
+			}()
+		}
+		if route.mirror.log {
+			s.logMirroredRequest(mirrorMetadata, logger, route.bodyCapture, mirrored)
+		}
+
+		response, err := route.client.Do(request)
+		if err != nil {
+			captured.failed(err)
+			s.ops.Infof("[mirror] %s: mirrored request failed: %v", shortMetadataID(metadata), err)
+			if route.mirror.log {
+				closed := make(chan struct{})
+				close(closed)
+				statusCode := upstreamErrorStatus(err)
+				s.logUpstreamFailure(mirrorMetadata, logger, closed, statusCode, fmt.Sprintf("[%s] mirrored request failed: %v\n", mirrorMetadata.ID, err), err)
+			}
+			return
+		}
+		defer response.Body.Close()
+		captured.capture(response)
+		if !route.mirror.log {
+			io.Copy(io.Discard, response.Body)
+			return
+		}
+
+		responseTime := s.clock.Now()
+		mirrorMetadata.UpstreamResponseAt = &responseTime
+		mirrorMetadata.UpstreamHeaderDurationMS = responseTime.Sub(mirrorMetadata.RequestStartedAt).Milliseconds()
+		mirrorMetadata.ResponseStatus = response.Status
+		mirrorMetadata.ResponseStatusCode = response.StatusCode
+		mirrorMetadata.ResponseContentEncoding = response.Header.Get("Content-Encoding")
+		s.logMirroredResponse(mirrorMetadata, responseTime, logger, route.bodyCapture, response)
+	}()
+}
+
+func (s *ProxyServer) logMirroredRequest(metadata RequestMetadata, logger Logger, bodyCapture BodyCaptureMode, mirrored mirroredRequest) {
+	var headerBuf bytes.Buffer
+	fmt.Fprintf(&headerBuf, "%s %s HTTP/1.1\r\n", mirrored.method, mirrored.url.String())
+	headers := s.logHeaderLimits.block(&headerBuf)
+	for name, values := range mirrored.header {
+		if shouldSkipLoggedRequestHeader(name) || !s.logHeaders.allows(name) {
+			continue
+		}
+		if name == "Authorization" {
+			values = []string{redactedHeaderValue}
+		}
+		for _, value := range values {
+			headers.write(name, value)
+		}
+	}
+	headers.close()
+
+	var body io.Reader = bytes.NewReader(mirrored.body)
+	if mirrored.contentEncoding != "" && bodyCapture.logsBody() {
+		decompressed, err := decompressForLogging(body, mirrored.contentEncoding)
+		if err != nil {
+			fmt.Fprintf(&headerBuf, "X-Decompression-Error: %v\r\n", err)
+		}
+		defer decompressed.Close()
+		body = decompressed
+	}
+	body = bodyCapture.captureBody(body)
+	headerBuf.WriteString("\r\n")
+	logger.LogRequest(metadata, metadata.RequestStartedAt, &readCloser{
+		Reader: io.MultiReader(&headerBuf, body),
+		Closer: io.NopCloser(nil),
+	})
+}
+
+func (s *ProxyServer) logMirroredResponse(metadata RequestMetadata, responseTime time.Time, logger Logger, bodyCapture BodyCaptureMode, response *http.Response) {
+	var headerBuf bytes.Buffer
+	fmt.Fprintf(&headerBuf, "%s %s\r\n", response.Proto, s.logHeaderLimits.truncate(response.Status))
+	headers := s.logHeaderLimits.block(&headerBuf)
+	for name, values := range response.Header {
+		if name == "Content-Encoding" || !s.logHeaders.allows(name) {
+			continue
+		}
+		for _, value := range values {
+			headers.write(name, value)
+		}
+	}
+	headers.close()
+	writeTransferEncodingMarker(&headerBuf, response.TransferEncoding)
+
+	var body io.Reader = response.Body
+	if metadata.ResponseContentEncoding != "" && bodyCapture.logsBody() {
+		decompressed, err := decompressForLogging(body, metadata.ResponseContentEncoding)
+		if err != nil {
+			fmt.Fprintf(&headerBuf, "X-Decompression-Error: %v\r\n", err)
+		}
+		defer decompressed.Close()
+		body = decompressed
+	}
+	body = bodyCapture.captureBody(body)
+	headerBuf.WriteString("\r\n")
+	logger.LogResponse(metadata, responseTime, &readCloser{
+		Reader: io.MultiReader(&headerBuf, body),
+		Closer: io.NopCloser(nil),
+	})
+	// Read what the logger left, so the connection can be reused
+	io.Copy(io.Discard, response.Body)
+}
@@ -0,0 +1,430 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultMirrorIgnoredHeaders differ between otherwise identical responses.
+var defaultMirrorIgnoredHeaders = []string{
+	"Age",
+	"Connection",
+	"Content-Length",
+	"Date",
+	"Expires",
+	"Keep-Alive",
+	"Last-Modified",
+	"Set-Cookie",
+	"X-Request-Id",
+}
+
+// maxMirrorDifferences caps the differences recorded per compared pair, so a
+// completely different JSON document does not produce a huge record.
+const maxMirrorDifferences = 20
+
+// mirrorDiffContext is how many bytes around the first differing byte of a
+// non-JSON body are recorded.
+const mirrorDiffContext = 32
+
+// MirrorComparison configures comparing the mirror's responses with the
+// primary responses of a route with RouteOptions.MirrorTo.
+type MirrorComparison struct {
+	// Path receives one JSON line, a MirrorDiff, per mirrored request whose
+	// response differs from the primary response.
+	Path string
+	// IgnoreHeaders are never compared. Nil uses a default list of headers
+	// that vary per response, such as Date and Set-Cookie.
+	IgnoreHeaders []string
+	// SortJSONKeys compares JSON bodies as values, ignoring key order and
+	// whitespace, and records the JSON paths that differ. Otherwise bodies
+	// are compared byte by byte.
+	SortJSONKeys bool
+	// MaxBody is how much of each (decompressed) body is compared. Bodies
+	// over it are not compared. Zero uses DefaultMirrorMaxBody.
+	MaxBody int64
+}
+
+// MirrorDiff describes how a mirrored response differed from the primary
+// response to the same request.
+type MirrorDiff struct {
+	// RequestID is the ID of the primary request and MirrorID that of its
+	// mirrored copy, as in the logs.
+	RequestID      string             `json:"request_id"`
+	MirrorID       string             `json:"mirror_id"`
+	Timestamp      time.Time          `json:"timestamp"`
+	Method         string             `json:"method"`
+	DestinationURL string             `json:"destination_url"`
+	MirrorURL      string             `json:"mirror_url"`
+	Differences    []MirrorDifference `json:"differences"`
+}
+
+// MirrorDifference is one way a mirrored response differed from the primary.
+type MirrorDifference struct {
+	// Kind is "status", "header", "body" or "error".
+	Kind string `json:"kind"`
+	// Header is the header name of a "header" difference.
+	Header string `json:"header,omitempty"`
+	// At locates a "body" difference: a JSON path such as $.items[0].name,
+	// or the byte offset of the first difference.
+	At      string `json:"at,omitempty"`
+	Primary string `json:"primary"`
+	Mirror  string `json:"mirror"`
+}
+
+// mirrorComparer compares the responses of one route and writes the diffs.
+type mirrorComparer struct {
+	path          string
+	ignoreHeaders []string
+	sortJSONKeys  bool
+	maxBody       int64
+	ops           levelLogger
+
+	mu sync.Mutex
+}
+
+// newMirrorComparer returns nil if comparison is nil.
+func newMirrorComparer(comparison *MirrorComparison, ops levelLogger) (*mirrorComparer, error) {
+	if comparison == nil {
+		return nil, nil
+	}
+	if comparison.Path == "" {
+		return nil, errors.New("mirror comparison needs a path for its diffs")
+	}
+	ignoreHeaders := comparison.IgnoreHeaders
+	if ignoreHeaders == nil {
+		ignoreHeaders = defaultMirrorIgnoredHeaders
+	}
+	maxBody := comparison.MaxBody
+	if maxBody <= 0 {
+		maxBody = DefaultMirrorMaxBody
+	}
+	return &mirrorComparer{
+		path:          comparison.Path,
+		ignoreHeaders: ignoreHeaders,
+		sortJSONKeys:  comparison.SortJSONKeys,
+		maxBody:       maxBody,
+		ops:           ops,
+	}, nil
+}
+
+// newCapture returns a capture for one response, or nil if c is nil.
+func (c *mirrorComparer) newCapture() *responseCapture {
+	if c == nil {
+		return nil
+	}
+	return &responseCapture{max: c.maxBody, done: make(chan struct{})}
+}
+
+// responseCapture records the status, headers and the start of the body of a
+// response as it is read. finish marks it complete; until then only the
+// goroutine reading the response may use it. All methods accept nil.
+type responseCapture struct {
+	max        int64
+	statusCode int
+	header     http.Header
+	encoding   string
+	body       bytes.Buffer
+	size       int64
+	eof        bool
+	err        error
+	done       chan struct{}
+}
+
+// capture starts recording response, reading its body through the capture.
+func (c *responseCapture) capture(response *http.Response) {
+	if c == nil {
+		return
+	}
+	c.statusCode = response.StatusCode
+	c.header = response.Header.Clone()
+	c.encoding = response.Header.Get("Content-Encoding")
+	response.Body = &readCloser{Reader: &captureReader{Reader: response.Body, capture: c}, Closer: response.Body}
+}
+
+// failed records that there is no complete response.
+func (c *responseCapture) failed(err error) {
+	if c != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+// finish marks the capture complete, recording an error if the body was not
+// read to its end.
+func (c *responseCapture) finish() {
+	if c == nil {
+		return
+	}
+	if c.err == nil && c.statusCode == 0 {
+		c.err = errors.New("no response")
+	} else if c.err == nil && !c.eof {
+		c.err = errors.New("response body was not read completely")
+	}
+	close(c.done)
+}
+
+type captureReader struct {
+	io.Reader
+	capture *responseCapture
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	c := r.capture
+	if room := int(c.max) - c.body.Len(); room > 0 {
+		c.body.Write(p[:min(n, room)])
+	}
+	c.size += int64(n)
+	if errors.Is(err, io.EOF) {
+		c.eof = true
+	} else if err != nil && c.err == nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// decodedBody returns the decompressed body, and whether all of it fits the
+// capture.
+func (c *responseCapture) decodedBody() ([]byte, bool) {
+	if c.size > c.max {
+		return nil, false
+	}
+	if c.encoding == "" {
+		return c.body.Bytes(), true
+	}
+	decompressed, _, err := decompressBody(bytes.NewReader(c.body.Bytes()), c.encoding)
+	if err != nil {
+		return c.body.Bytes(), true
+	}
+	defer decompressed.Close()
+	body, err := io.ReadAll(io.LimitReader(decompressed, c.max+1))
+	if err != nil || int64(len(body)) > c.max {
+		return nil, false
+	}
+	return body, true
+}
+
+// compare waits for both captures and records how they differ, unless done
+// is closed first. diff holds the identifying fields of the record.
+func (c *mirrorComparer) compare(diff MirrorDiff, primary, mirror *responseCapture, done <-chan struct{}) {
+	for _, capture := range []*responseCapture{primary, mirror} {
+		select {
+		case <-capture.done:
+		case <-done:
+			return
+		}
+	}
+
+	if primary.err != nil || mirror.err != nil {
+		diff.Differences = append(diff.Differences, MirrorDifference{
+			Kind:    "error",
+			Primary: errorText(primary.err),
+			Mirror:  errorText(mirror.err),
+		})
+		c.record(diff)
+		return
+	}
+
+	if primary.statusCode != mirror.statusCode {
+		diff.Differences = append(diff.Differences, MirrorDifference{
+			Kind:    "status",
+			Primary: strconv.Itoa(primary.statusCode),
+			Mirror:  strconv.Itoa(mirror.statusCode),
+		})
+	}
+	diff.Differences = append(diff.Differences, c.headerDifferences(primary.header, mirror.header)...)
+	primaryBody, primaryComplete := primary.decodedBody()
+	mirrorBody, mirrorComplete := mirror.decodedBody()
+	if primaryComplete && mirrorComplete {
+		diff.Differences = append(diff.Differences, c.bodyDifferences(primaryBody, mirrorBody)...)
+	}
+	if len(diff.Differences) > 0 {
+		c.record(diff)
+	}
+}
+
+func (c *mirrorComparer) headerDifferences(primary, mirror http.Header) []MirrorDifference {
+	names := make(map[string]bool)
+	for name := range primary {
+		names[name] = true
+	}
+	for name := range mirror {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		if !c.ignoresHeader(name) {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	var differences []MirrorDifference
+	for _, name := range sorted {
+		primaryValue := strings.Join(primary.Values(name), ", ")
+		mirrorValue := strings.Join(mirror.Values(name), ", ")
+		if primaryValue != mirrorValue {
+			differences = append(differences, MirrorDifference{
+				Kind:    "header",
+				Header:  name,
+				Primary: primaryValue,
+				Mirror:  mirrorValue,
+			})
+		}
+	}
+	return differences
+}
+
+func (c *mirrorComparer) ignoresHeader(name string) bool {
+	for _, ignoredName := range c.ignoreHeaders {
+		if strings.EqualFold(name, ignoredName) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *mirrorComparer) bodyDifferences(primary, mirror []byte) []MirrorDifference {
+	if bytes.Equal(primary, mirror) {
+		return nil
+	}
+	if c.sortJSONKeys {
+		var primaryJSON, mirrorJSON any
+		if json.Unmarshal(primary, &primaryJSON) == nil && json.Unmarshal(mirror, &mirrorJSON) == nil {
+			var differences []MirrorDifference
+			jsonDifferences("$", primaryJSON, mirrorJSON, &differences)
+			return differences
+		}
+	}
+
+	offset := 0
+	for offset < len(primary) && offset < len(mirror) && primary[offset] == mirror[offset] {
+		offset++
+	}
+	start := max(offset-mirrorDiffContext, 0)
+	return []MirrorDifference{{
+		Kind:    "body",
+		At:      fmt.Sprintf("offset %d", offset),
+		Primary: diffExcerpt(primary, start, offset),
+		Mirror:  diffExcerpt(mirror, start, offset),
+	}}
+}
+
+// jsonDifferences appends the paths at which two decoded JSON values differ.
+func jsonDifferences(path string, primary, mirror any, differences *[]MirrorDifference) {
+	if len(*differences) >= maxMirrorDifferences {
+		return
+	}
+	switch primaryValue := primary.(type) {
+	case map[string]any:
+		if mirrorValue, ok := mirror.(map[string]any); ok {
+			keys := make(map[string]bool)
+			for key := range primaryValue {
+				keys[key] = true
+			}
+			for key := range mirrorValue {
+				keys[key] = true
+			}
+			sorted := make([]string, 0, len(keys))
+			for key := range keys {
+				sorted = append(sorted, key)
+			}
+			sort.Strings(sorted)
+			for _, key := range sorted {
+				primaryField, inPrimary := primaryValue[key]
+				mirrorField, inMirror := mirrorValue[key]
+				keyPath := path + "." + key
+				if inPrimary != inMirror {
+					*differences = append(*differences, MirrorDifference{
+						Kind:    "body",
+						At:      keyPath,
+						Primary: jsonText(primaryField, inPrimary),
+						Mirror:  jsonText(mirrorField, inMirror),
+					})
+					continue
+				}
+				jsonDifferences(keyPath, primaryField, mirrorField, differences)
+			}
+			return
+		}
+	case []any:
+		if mirrorValue, ok := mirror.([]any); ok && len(primaryValue) == len(mirrorValue) {
+			for i := range primaryValue {
+				jsonDifferences(fmt.Sprintf("%s[%d]", path, i), primaryValue[i], mirrorValue[i], differences)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(primary, mirror) && len(*differences) < maxMirrorDifferences {
+		*differences = append(*differences, MirrorDifference{
+			Kind:    "body",
+			At:      path,
+			Primary: jsonText(primary, true),
+			Mirror:  jsonText(mirror, true),
+		})
+	}
+}
+
+// jsonText encodes a decoded JSON value, or returns "" if it is missing.
+func jsonText(value any, present bool) string {
+	if !present {
+		return ""
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(encoded)
+}
+
+// diffExcerpt returns body from start up to mirrorDiffContext bytes past
+// offset, marking cut off ends.
+func diffExcerpt(body []byte, start, offset int) string {
+	end := min(offset+mirrorDiffContext, len(body))
+	start = min(start, end)
+	excerpt := string(body[start:end])
+	if !utf8.ValidString(excerpt) {
+		excerpt = strconv.Quote(excerpt)
+	}
+	if start > 0 {
+		excerpt = "..." + excerpt
+	}
+	if end < len(body) {
+		excerpt += "..."
+	}
+	return excerpt
+}
+
+func errorText(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (c *mirrorComparer) record(diff MirrorDiff) {
+	line, err := json.Marshal(diff)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	report, err := os.OpenFile(c.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		c.ops.Errorf("Failed to open mirror diff log %s: %v", c.path, err)
+		return
+	}
+	defer report.Close()
+	report.Write(append(line, '\n'))
+}
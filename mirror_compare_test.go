@@ -0,0 +1,84 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirrorComparisonRecordsDifferences(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Version", "1")
+		io.WriteString(w, `{"user": {"name": "alice", "roles": ["admin", "dev"]}, "count": 2}`)
+	}))
+	defer primary.Close()
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Same document with other key order and whitespace, one changed role
+		// and a Date that is ignored
+		w.Header().Set("X-Version", "1")
+		w.Header().Set("Date", "Thu, 01 Jan 1970 00:00:00 GMT")
+		io.WriteString(w, `{"count":2,"user":{"roles":["admin","ops"],"name":"alice"}}`)
+	}))
+	defer shadow.Close()
+
+	diffPath := filepath.Join(t.TempDir(), "mirror-diff.jsonl")
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", primary.URL+"/", &TestLogger{}, RouteOptions{
+		MirrorTo: shadow.URL + "/",
+		MirrorCompare: &MirrorComparison{
+			Path:         diffPath,
+			SortJSONKeys: true,
+		},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/api/users/1")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	var diffs []MirrorDiff
+	for deadline := time.Now().Add(2 * time.Second); len(diffs) == 0 && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+		raw, _ := os.ReadFile(diffPath)
+		for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+			var diff MirrorDiff
+			if json.Unmarshal([]byte(line), &diff) == nil {
+				diffs = append(diffs, diff)
+			}
+		}
+	}
+	if len(diffs) != 1 {
+		t.Fatalf("Expected one diff record, got %d", len(diffs))
+	}
+	diff := diffs[0]
+	if diff.RequestID == "" || diff.MirrorID == "" || diff.RequestID == diff.MirrorID {
+		t.Errorf("Expected the primary and mirror IDs, got %q and %q", diff.RequestID, diff.MirrorID)
+	}
+	if !strings.HasSuffix(diff.MirrorURL, "/users/1") {
+		t.Errorf("Expected the mirror URL, got %q", diff.MirrorURL)
+	}
+	expected := MirrorDifference{Kind: "body", At: "$.user.roles[1]", Primary: `"dev"`, Mirror: `"ops"`}
+	if len(diff.Differences) != 1 || diff.Differences[0] != expected {
+		t.Errorf("Expected only %+v, got %+v", expected, diff.Differences)
+	}
+
+	// Without SortJSONKeys the bodies are compared byte by byte
+	comparer, _ := newMirrorComparer(&MirrorComparison{Path: diffPath}, levelLogger{})
+	differences := comparer.bodyDifferences([]byte(`{"a":1}`), []byte(`{"a":2}`))
+	if len(differences) != 1 || differences[0].At != "offset 5" || differences[0].Primary != `{"a":1}` || differences[0].Mirror != `{"a":2}` {
+		t.Errorf("Unexpected byte difference: %+v", differences)
+	}
+}
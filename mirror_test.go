@@ -0,0 +1,154 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirrorReceivesCopyWhileClientGetsPrimaryResponse(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, "primary got "+string(body))
+	}))
+	defer primary.Close()
+	type mirroredCall struct{ method, uri, body, header string }
+	calls := make(chan mirroredCall, 10)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		calls <- mirroredCall{r.Method, r.RequestURI, string(body), r.Header.Get("X-Test")}
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "shadow response")
+	}))
+	defer shadow.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", primary.URL+"/v1/", testLogger, RouteOptions{
+		MirrorTo:      shadow.URL + "/v2/",
+		MirrorMaxBody: 10,
+		LogMirror:     true,
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	request, _ := http.NewRequest(http.MethodPost, testServer.URL+"/api/items?x=1", strings.NewReader("hello"))
+	request.Header.Set("X-Test", "copied")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "primary got hello" {
+		t.Errorf("Expected the primary response, got %d: %q", resp.StatusCode, body)
+	}
+
+	select {
+	case call := <-calls:
+		if call.method != http.MethodPost || call.uri != "/v2/items?x=1" || call.body != "hello" || call.header != "copied" {
+			t.Errorf("Unexpected mirrored request: %+v", call)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the mirror to receive the request")
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 2 || len(testLogger.responses) != 2 {
+		t.Fatalf("Expected the primary and mirrored exchanges to be logged, got %d requests and %d responses", len(testLogger.requests), len(testLogger.responses))
+	}
+	var primaryID string
+	for _, entry := range testLogger.requests {
+		if !entry.metadata.Mirror {
+			primaryID = entry.metadata.ID
+		}
+	}
+	mirroredLogs := 0
+	for _, entry := range append(testLogger.requests, testLogger.responses...) {
+		if !entry.metadata.Mirror {
+			continue
+		}
+		mirroredLogs++
+		if entry.metadata.MirrorOf != primaryID || entry.metadata.ID == primaryID {
+			t.Errorf("Expected the mirror log to point at %s with its own ID, got %s of %s", primaryID, entry.metadata.ID, entry.metadata.MirrorOf)
+		}
+		if !strings.HasSuffix(entry.content, "hello") && !strings.HasSuffix(entry.content, "shadow response") {
+			t.Errorf("Expected the mirrored bodies to be logged, got %q", entry.content)
+		}
+	}
+	if mirroredLogs != 2 {
+		t.Errorf("Expected a mirrored request and response log, got %d", mirroredLogs)
+	}
+
+	// Bodies over MirrorMaxBody and of unknown length are only sent to the primary
+	for _, body := range []io.Reader{strings.NewReader("more than ten bytes"), io.MultiReader(strings.NewReader("chunked"))} {
+		resp, err := http.Post(testServer.URL+"/api/items", "text/plain", body)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected the primary response, got %d", resp.StatusCode)
+		}
+	}
+	select {
+	case call := <-calls:
+		t.Errorf("Expected no mirrored request, got %+v", call)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestMirrorNeverReceivesRouteCredential(t *testing.T) {
+	primaryAuthorization := make(chan string, 1)
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryAuthorization <- r.Header.Get("Authorization")
+	}))
+	defer primary.Close()
+	shadowAuthorization := make(chan []string, 2)
+	shadow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		shadowAuthorization <- r.Header.Values("Authorization")
+	}))
+	defer shadow.Close()
+
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", primary.URL+"/", &NoOpLogger{}, RouteOptions{
+		Authorization: StaticCredential("Bearer upstream-secret"),
+		MirrorTo:      shadow.URL + "/",
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for _, clientAuthorization := range []string{"Bearer client-token", ""} {
+		request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/items", nil)
+		if clientAuthorization != "" {
+			request.Header.Set("Authorization", clientAuthorization)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		resp.Body.Close()
+		if got := <-primaryAuthorization; got != "Bearer upstream-secret" {
+			t.Errorf("Expected the primary to get the route credential, got %q", got)
+		}
+		select {
+		case got := <-shadowAuthorization:
+			if clientAuthorization == "" && len(got) != 0 || clientAuthorization != "" && (len(got) != 1 || got[0] != clientAuthorization) {
+				t.Errorf("Expected the mirror to get only the client's Authorization %q, got %q", clientAuthorization, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Expected the mirror to receive the request")
+		}
+	}
+}
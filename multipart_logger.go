@@ -0,0 +1,192 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMultipartMaxValueSize is the largest text part value included in a
+// multipart summary when MultipartSummaryLogger.MaxValueSize is zero.
+const DefaultMultipartMaxValueSize = 1024
+
+// MultipartSummaryLogger replaces the body of logged multipart/form-data
+// requests with a JSON summary of their parts, so uploads show up as field
+// names, filenames, content types and sizes instead of boundaries and binary
+// data. Small text values are kept. Only the logged copy is changed; all other
+// requests and all responses are forwarded unchanged.
+type MultipartSummaryLogger struct {
+	Logger Logger
+
+	// MaxValueSize is the largest text part value included in the summary.
+	// Zero uses DefaultMultipartMaxValueSize; negative omits all values.
+	MaxValueSize int
+}
+
+// NewMultipartSummaryLogger wraps logger with multipart request summaries.
+func NewMultipartSummaryLogger(logger Logger, maxValueSize int) *MultipartSummaryLogger {
+	return &MultipartSummaryLogger{
+		Logger:       logger,
+		MaxValueSize: maxValueSize,
+	}
+}
+
+// MultipartSummary is the logged body of a multipart/form-data request.
+type MultipartSummary struct {
+	Parts []MultipartPartSummary `json:"parts"`
+	Error string                 `json:"error,omitempty"`
+}
+
+// MultipartPartSummary describes a single part of a multipart/form-data body.
+type MultipartPartSummary struct {
+	Name        string `json:"name"`
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size"`
+	Value       string `json:"value,omitempty"`
+}
+
+// LogRequest summarizes multipart/form-data bodies before forwarding the stream
+func (l *MultipartSummaryLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	// Remember a failure of the stream itself, so a summary of an aborted
+	// upload is still logged as incomplete
+	source := &sourceErrorReader{reader: rawRequestStream}
+	reader := bufio.NewReader(source)
+	head, header, err := readStreamHead(reader)
+	if err != nil {
+		// Not a parseable HTTP head; log what was read followed by the rest as-is
+		l.Logger.LogRequest(metadata, timestamp, &readCloser{
+			Reader: io.MultiReader(bytes.NewReader(head), reader),
+			Closer: rawRequestStream,
+		})
+		return
+	}
+
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		l.Logger.LogRequest(metadata, timestamp, &readCloser{
+			Reader: io.MultiReader(bytes.NewReader(head), reader),
+			Closer: rawRequestStream,
+		})
+		return
+	}
+
+	summary := l.summarize(multipart.NewReader(reader, params["boundary"]))
+	// Drain whatever the multipart reader left unread so the proxy never blocks
+	discardStream(&readCloser{Reader: reader, Closer: rawRequestStream})
+
+	body, _ := json.MarshalIndent(summary, "", "  ")
+
+	// Mark the logged body as a summary by inserting a header before the separator
+	var logged bytes.Buffer
+	logged.Write(bytes.TrimRight(head, "\r\n"))
+	logged.WriteString("\r\nX-Logged-Body: multipart-summary\r\n\r\n")
+	logged.Write(body)
+	l.Logger.LogRequest(metadata, timestamp, &readCloser{
+		Reader: failedAfter(logged.Bytes(), source.err),
+		Closer: io.NopCloser(nil),
+	})
+}
+
+// LogResponse forwards the response stream unchanged
+func (l *MultipartSummaryLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	l.Logger.LogResponse(metadata, timestamp, rawResponseStream)
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *MultipartSummaryLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(l.Logger, metadata, timestamp)
+}
+
+// Close closes the wrapped logger if it implements io.Closer.
+func (l *MultipartSummaryLogger) Close() error {
+	return closeWrapped(l.Logger)
+}
+
+func (l *MultipartSummaryLogger) summarize(reader *multipart.Reader) MultipartSummary {
+	maxValueSize := l.MaxValueSize
+	if maxValueSize == 0 {
+		maxValueSize = DefaultMultipartMaxValueSize
+	}
+
+	summary := MultipartSummary{Parts: []MultipartPartSummary{}}
+	for {
+		part, err := reader.NextRawPart()
+		if err == io.EOF {
+			return summary
+		}
+		if err != nil {
+			summary.Error = err.Error()
+			return summary
+		}
+
+		partSummary := MultipartPartSummary{
+			Name:        part.FormName(),
+			Filename:    part.FileName(),
+			ContentType: part.Header.Get("Content-Type"),
+		}
+
+		// Keep a prefix of the value for small text fields, count the rest
+		var value bytes.Buffer
+		keepValue := maxValueSize > 0 && partSummary.Filename == "" && isTextContentType(partSummary.ContentType)
+		if keepValue {
+			partSummary.Size, err = io.Copy(&value, io.LimitReader(part, int64(maxValueSize)+1))
+		}
+		if err == nil {
+			var rest int64
+			rest, err = io.Copy(io.Discard, part)
+			partSummary.Size += rest
+		}
+		if keepValue && partSummary.Size <= int64(maxValueSize) && utf8.Valid(value.Bytes()) {
+			partSummary.Value = value.String()
+		}
+		summary.Parts = append(summary.Parts, partSummary)
+		if err != nil {
+			summary.Error = err.Error()
+			return summary
+		}
+	}
+}
+
+// readStreamHead reads the start line and headers of a logged HTTP stream. It
+// returns the raw bytes read, including the blank separator line.
+func readStreamHead(reader *bufio.Reader) ([]byte, textproto.MIMEHeader, error) {
+	var head bytes.Buffer
+	for {
+		line, err := reader.ReadBytes('\n')
+		head.Write(line)
+		if err != nil {
+			return head.Bytes(), nil, err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+	}
+
+	// Skip the start line, then parse the header block
+	headerBlock := head.Bytes()[bytes.IndexByte(head.Bytes(), '\n')+1:]
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(headerBlock))).ReadMIMEHeader()
+	if err != nil {
+		return head.Bytes(), nil, err
+	}
+	return head.Bytes(), header, nil
+}
+
+func isTextContentType(contentType string) bool {
+	if contentType == "" {
+		// Form fields without a Content-Type are text/plain
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/json"
+}
@@ -0,0 +1,134 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMultipartSummaryLoggerSummarizesParts(t *testing.T) {
+	var receivedFile []byte
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, _, err := r.FormFile("upload")
+		if err == nil {
+			receivedFile, _ = io.ReadAll(file)
+		}
+		io.WriteString(w, "uploaded")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", NewMultipartSummaryLogger(testLogger, 0)); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	fileContent := bytes.Repeat([]byte{0x00, 0xff, 0x10}, 1000)
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("description", "holiday photo")
+	fileWriter, _ := writer.CreateFormFile("upload", "photo.bin")
+	fileWriter.Write(fileContent)
+	writer.Close()
+
+	resp, err := http.Post(testServer.URL+"/api/upload", writer.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if !bytes.Equal(receivedFile, fileContent) {
+		t.Fatalf("expected the backend to receive the original file (%d bytes), got %d bytes", len(fileContent), len(receivedFile))
+	}
+	if len(testLogger.requests) != 1 {
+		t.Fatalf("expected 1 request log, got %d", len(testLogger.requests))
+	}
+
+	logged := testLogger.requests[0].content
+	headers, loggedBody, found := strings.Cut(logged, "\r\n\r\n")
+	if !found {
+		t.Fatalf("expected logged request to contain a header separator, got:\n%s", logged)
+	}
+	if !strings.Contains(headers, "X-Logged-Body: multipart-summary") {
+		t.Errorf("expected summary marker header, got:\n%s", headers)
+	}
+
+	var summary MultipartSummary
+	if err := json.Unmarshal([]byte(loggedBody), &summary); err != nil {
+		t.Fatalf("expected JSON summary body, got %v:\n%s", err, loggedBody)
+	}
+	if summary.Error != "" || len(summary.Parts) != 2 {
+		t.Fatalf("expected 2 parts without error, got %+v", summary)
+	}
+
+	field := summary.Parts[0]
+	if field.Name != "description" || field.Value != "holiday photo" || field.Size != int64(len("holiday photo")) {
+		t.Errorf("unexpected text field summary: %+v", field)
+	}
+	file := summary.Parts[1]
+	if file.Name != "upload" || file.Filename != "photo.bin" || file.ContentType != "application/octet-stream" {
+		t.Errorf("unexpected file part summary: %+v", file)
+	}
+	if file.Size != int64(len(fileContent)) || file.Value != "" {
+		t.Errorf("expected file part of %d bytes without a value, got %+v", len(fileContent), file)
+	}
+}
+
+func TestMultipartSummaryLoggerPassesOtherRequestsThrough(t *testing.T) {
+	testLogger := &TestLogger{}
+	logger := NewMultipartSummaryLogger(testLogger, 0)
+
+	raw := "POST http://example.test/api HTTP/1.1\r\nContent-Type: application/json\r\n\r\n{\"a\":1}"
+	logger.LogRequest(RequestMetadata{ID: "json"}, time.Now(), io.NopCloser(strings.NewReader(raw)))
+
+	if len(testLogger.requests) != 1 || testLogger.requests[0].content != raw {
+		t.Fatalf("expected non-multipart request to be logged unchanged, got %+v", testLogger.requests)
+	}
+}
+
+func TestMultipartSummaryLoggerMarksAbortedUploadsIncomplete(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{LogDir: logDir})
+	if err != nil {
+		t.Fatalf("failed to create file logger: %v", err)
+	}
+	defer fileLogger.Close()
+	logger := NewMultipartSummaryLogger(fileLogger, 0)
+
+	// The client disconnects halfway through the file part
+	raw := "POST http://example.test/upload HTTP/1.1\r\nContent-Type: multipart/form-data; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Disposition: form-data; name=\"upload\"; filename=\"a.bin\"\r\n\r\npartial"
+	stream := io.MultiReader(strings.NewReader(raw), errorReader{err: errors.New("client disconnected")})
+	logger.LogRequest(RequestMetadata{ID: "aborted"}, time.Now(), io.NopCloser(stream))
+
+	matches, _ := filepath.Glob(filepath.Join(logDir, "*_metadata.json"))
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 metadata file, got %v", matches)
+	}
+	data, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	var metadata fileLogMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		t.Fatalf("failed to parse metadata: %v", err)
+	}
+	if metadata.Completed || !strings.Contains(metadata.Error, "client disconnected") {
+		t.Errorf("expected the aborted upload to be logged incomplete, got completed=%v error=%q", metadata.Completed, metadata.Error)
+	}
+}
@@ -0,0 +1,147 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDestinationPathComposition(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+
+	tests := []struct {
+		name        string
+		pattern     string
+		destination string
+		options     RouteOptions
+		request     string
+		backendPath string
+	}{
+		{
+			name:        "exact route uses the destination verbatim",
+			pattern:     "/lmstudio/mockfile",
+			destination: "/static/mockfile.txt",
+			request:     "/lmstudio/mockfile",
+			backendPath: "/static/mockfile.txt",
+		},
+		{
+			name:        "exact prefix-style route ignores the tail",
+			pattern:     "/healthz/",
+			destination: "/status",
+			options:     RouteOptions{Exact: true, StripSegments: 1},
+			request:     "/healthz/",
+			backendPath: "/status",
+		},
+		{
+			name:        "prefix route appends the tail",
+			pattern:     "/api/",
+			destination: "/v1/",
+			request:     "/api/models/llama",
+			backendPath: "/v1/models/llama",
+		},
+		{
+			name:        "prefix route keeps the query and trailing slash",
+			pattern:     "/api/",
+			destination: "/v1",
+			request:     "/api/models/?page=2",
+			backendPath: "/v1/models/?page=2",
+		},
+		{
+			name:        "strip drops leading segments of the tail",
+			pattern:     "/api/",
+			destination: "/v1/",
+			options:     RouteOptions{StripSegments: 1},
+			request:     "/api/v2/models/llama",
+			backendPath: "/v1/models/llama",
+		},
+		{
+			name:        "strip of every segment leaves the destination",
+			pattern:     "/api/",
+			destination: "/v1/",
+			options:     RouteOptions{StripSegments: 3},
+			request:     "/api/v2/models",
+			backendPath: "/v1/",
+		},
+		{
+			name:        "strip applies to the full path with PreservePath",
+			pattern:     "/api/",
+			destination: "/backend",
+			options:     RouteOptions{PreservePath: true, StripSegments: 1},
+			request:     "/api/v2/models",
+			backendPath: "/backend/v2/models",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			proxyServer := NewProxyServer("")
+			if err := proxyServer.AddRouteWithOptions(test.pattern, backend.URL+test.destination, &NoOpLogger{}, test.options); err != nil {
+				t.Fatal("Failed to add route:", err)
+			}
+			testServer := httptest.NewServer(proxyServer)
+			defer testServer.Close()
+
+			resp, err := http.Get(testServer.URL + test.request)
+			if err != nil {
+				t.Fatal("Request failed:", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != test.backendPath {
+				t.Errorf("Expected %s to reach the backend as %s, got %d %s", test.request, test.backendPath, resp.StatusCode, body)
+			}
+		})
+	}
+
+	if err := NewProxyServer("").AddRouteWithOptions("/api/", backend.URL, &NoOpLogger{}, RouteOptions{StripSegments: -1}); err == nil {
+		t.Error("Expected a negative StripSegments to be rejected")
+	}
+}
+
+func TestInvalidDestinationURLFailsClearly(t *testing.T) {
+	tests := []struct {
+		destination string
+		problem     string
+	}{
+		{destination: "localhost:8080/v1/", problem: "the scheme must be http or https"},
+		{destination: "http:///v1/", problem: "the host is empty"},
+		{destination: "/v1/", problem: "the scheme must be http or https"},
+	}
+	for _, test := range tests {
+		t.Run(test.destination, func(t *testing.T) {
+			testLogger := &TestLogger{}
+			proxyServer := NewProxyServer("")
+			if err := proxyServer.AddRoute("/api/", test.destination, testLogger); err != nil {
+				t.Fatal("Failed to add route:", err)
+			}
+			testServer := httptest.NewServer(proxyServer)
+			defer testServer.Close()
+
+			resp, err := http.Get(testServer.URL + "/api/models")
+			if err != nil {
+				t.Fatal("Request failed:", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusInternalServerError || !strings.Contains(string(body), "invalid upstream URL") || !strings.Contains(string(body), test.problem) {
+				t.Errorf("Expected a clear 500 error mentioning %q, got %d %q", test.problem, resp.StatusCode, body)
+			}
+
+			// Give async logging a moment to complete
+			time.Sleep(100 * time.Millisecond)
+			if len(testLogger.responses) != 1 || !strings.Contains(testLogger.responses[0].metadata.UpstreamError, test.problem) {
+				t.Errorf("Expected the error to be logged, got %+v", testLogger.responses)
+			}
+		})
+	}
+
+	if err := validateUpstreamURL(&url.URL{Scheme: "http", Host: "example.com", Path: "relative"}); err == nil || !strings.Contains(err.Error(), "the path is relative") {
+		t.Errorf("Expected a relative path to be rejected, got %v", err)
+	}
+}
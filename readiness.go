@@ -0,0 +1,71 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// Readiness is the readiness state reported to load balancers. Draining
+// flips the readiness endpoint to unhealthy so no new traffic is routed to
+// the proxy, while requests and connections that still arrive are served
+// as usual. Unlike shutdown, the process keeps running until an orchestrator
+// stops it.
+type Readiness struct {
+	draining atomic.Bool
+}
+
+// NewReadiness creates a readiness state that starts out ready.
+func NewReadiness() *Readiness {
+	return &Readiness{}
+}
+
+// Drain marks the proxy as draining.
+func (r *Readiness) Drain() {
+	r.draining.Store(true)
+}
+
+// Undrain marks the proxy as ready again, for a deploy that was called off.
+func (r *Readiness) Undrain() {
+	r.draining.Store(false)
+}
+
+// Draining reports whether Drain was called.
+func (r *Readiness) Draining() bool {
+	return r.draining.Load()
+}
+
+// ServeHTTP answers readiness probes with 200 OK, or 503 Service
+// Unavailable once draining.
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Draining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, "draining\n")
+		return
+	}
+	io.WriteString(w, "ready\n")
+}
+
+// DrainHandler returns a handler that drains on POST and undrains on DELETE.
+func (r *Readiness) DrainHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, request *http.Request) {
+		switch request.Method {
+		case http.MethodPost:
+			r.Drain()
+		case http.MethodDelete:
+			r.Undrain()
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if r.Draining() {
+			io.WriteString(w, "draining\n")
+			return
+		}
+		io.WriteString(w, "ready\n")
+	})
+}
@@ -0,0 +1,58 @@
+package loggingproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDrainFlipsReadinessButKeepsProxying(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	readiness := NewReadiness()
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	proxyServer.Handle("/readyz", readiness)
+	proxyServer.Handle("/admin/drain", readiness.DrainHandler())
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	if status, body := getStatusAndBody(t, testServer.URL+"/readyz"); status != http.StatusOK || body != "ready\n" {
+		t.Fatalf("Expected /readyz to be ready, got %d: %q", status, body)
+	}
+	if status, _ := getStatusAndBody(t, testServer.URL+"/admin/drain"); status != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET /admin/drain to be rejected, got %d", status)
+	}
+
+	resp, err := http.Post(testServer.URL+"/admin/drain", "", nil)
+	if err != nil {
+		t.Fatal("Drain request failed:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected drain to succeed, got %d", resp.StatusCode)
+	}
+
+	if status, body := getStatusAndBody(t, testServer.URL+"/readyz"); status != http.StatusServiceUnavailable || body != "draining\n" {
+		t.Errorf("Expected /readyz to report draining, got %d: %q", status, body)
+	}
+	if status, body := getStatusAndBody(t, testServer.URL+"/api/"); status != http.StatusOK || body != "backend" {
+		t.Errorf("Expected proxied requests to still succeed while draining, got %d: %q", status, body)
+	}
+
+	// DELETE calls the drain off
+	request, _ := http.NewRequest(http.MethodDelete, testServer.URL+"/admin/drain", nil)
+	resp, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Undrain request failed:", err)
+	}
+	resp.Body.Close()
+	if status, body := getStatusAndBody(t, testServer.URL+"/readyz"); status != http.StatusOK || body != "ready\n" {
+		t.Errorf("Expected /readyz to be ready after undraining, got %d: %q", status, body)
+	}
+}
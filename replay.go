@@ -0,0 +1,110 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// replaySkippedHeaders are logged headers that are not sent again when a
+// transcript is replayed. Host and Content-Length follow from the replayed
+// URL and body, and the rest are annotations added by the logger.
+var replaySkippedHeaders = []string{
+	"Host",
+	"Content-Length",
+	"Transfer-Encoding",
+	"X-Decompression-Error",
+	loggedTransferEncodingHeader,
+}
+
+// ReplayRequest reads a request transcript written by FileLogger and sends it
+// again. The request is sent to target, a base URL such as
+// "http://localhost:8080" whose scheme, host and path prefix replace those of
+// the logged URL; an empty target sends it to the logged URL. The logged body
+// is already decompressed, so it is sent without a Content-Encoding. The
+// caller must close the response body.
+func ReplayRequest(transcriptPath string, target string) (*http.Response, error) {
+	file, err := os.Open(transcriptPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	transcript, err := ParseTranscript(file)
+	if err != nil {
+		return nil, err
+	}
+	if transcript.IsResponse {
+		return nil, fmt.Errorf("%s is a response transcript", transcriptPath)
+	}
+
+	replayURL, err := replayRequestURL(transcript.URL, target)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequest(transcript.Method, replayURL, bytes.NewReader(transcript.Body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, values := range transcript.Header {
+		if isReplaySkippedHeader(name) {
+			continue
+		}
+		request.Header[name] = append([]string(nil), values...)
+	}
+
+	// Keep the response as the backend sent it, like the proxy does
+	client := &http.Client{Transport: &http.Transport{
+		Proxy:              http.ProxyFromEnvironment,
+		DisableCompression: true,
+	}}
+	defer client.CloseIdleConnections()
+	return client.Do(request)
+}
+
+// replayRequestURL resolves the URL of a logged request against the replay
+// target. Forward proxy logs of tunneled requests only have a path, so they
+// need a target.
+func replayRequestURL(loggedURL string, target string) (string, error) {
+	logged, err := url.Parse(loggedURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid logged URL %q: %w", loggedURL, err)
+	}
+	if target == "" {
+		if logged.Scheme == "" || logged.Host == "" {
+			return "", fmt.Errorf("logged URL %q is not absolute, a replay target is required", loggedURL)
+		}
+		return logged.String(), nil
+	}
+
+	targetURL, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("invalid replay target %q: %w", target, err)
+	}
+	if targetURL.Scheme == "" || targetURL.Host == "" {
+		return "", fmt.Errorf("replay target %q must be an absolute URL", target)
+	}
+	logged.Scheme = targetURL.Scheme
+	logged.Host = targetURL.Host
+	logged.User = targetURL.User
+	targetURL.Path = strings.TrimSuffix(targetURL.Path, "/")
+	targetURL.RawPath = strings.TrimSuffix(targetURL.RawPath, "/")
+	if prefix := targetURL.Path; prefix != "" {
+		logged.Path = prefix + logged.Path
+		if logged.RawPath != "" {
+			logged.RawPath = targetURL.EscapedPath() + logged.RawPath
+		}
+	}
+	return logged.String(), nil
+}
+
+func isReplaySkippedHeader(name string) bool {
+	for _, skipped := range replaySkippedHeaders {
+		if strings.EqualFold(name, skipped) {
+			return true
+		}
+	}
+	return false
+}
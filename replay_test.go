@@ -0,0 +1,95 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayRequestResendsLoggedRequest(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir:           logDir,
+		FilenameTemplate: "{{.StreamType}}",
+	})
+	if err != nil {
+		t.Fatalf("failed to create file logger: %v", err)
+	}
+
+	echo := func() http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusAccepted)
+			io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+r.Header.Get("X-Client")+" "+string(body))
+		})
+	}
+	original := httptest.NewServer(echo())
+	defer original.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", original.URL+"/v1/", fileLogger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	request, _ := http.NewRequest(http.MethodPost, testServer.URL+"/api/items?id=7", strings.NewReader(`{"name":"replayed"}`))
+	request.Header.Set("X-Client", "test")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	originalBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	fresh := httptest.NewServer(echo())
+	defer fresh.Close()
+
+	replayed, err := ReplayRequest(filepath.Join(logDir, "request.bin"), fresh.URL)
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	defer replayed.Body.Close()
+	replayedBody, _ := io.ReadAll(replayed.Body)
+
+	if replayed.StatusCode != resp.StatusCode {
+		t.Errorf("expected status %d, got %d", resp.StatusCode, replayed.StatusCode)
+	}
+	if string(replayedBody) != string(originalBody) {
+		t.Errorf("expected replayed response %q, got %q", originalBody, replayedBody)
+	}
+	if want := `POST /v1/items?id=7 test {"name":"replayed"}`; string(replayedBody) != want {
+		t.Errorf("expected backend to see %q, got %q", want, replayedBody)
+	}
+
+	if _, err := ReplayRequest(filepath.Join(logDir, "response.bin"), fresh.URL); err == nil {
+		t.Error("expected replaying a response transcript to fail")
+	}
+}
+
+func TestReplayRequestURL(t *testing.T) {
+	for _, test := range []struct {
+		logged, target, want string
+	}{
+		{"http://backend/v1/items?id=1", "", "http://backend/v1/items?id=1"},
+		{"http://backend/v1/items?id=1", "https://staging:8443", "https://staging:8443/v1/items?id=1"},
+		{"http://backend/v1/items", "http://staging/prefix/", "http://staging/prefix/v1/items"},
+		{"/tunneled/path", "https://example.com", "https://example.com/tunneled/path"},
+	} {
+		got, err := replayRequestURL(test.logged, test.target)
+		if err != nil || got != test.want {
+			t.Errorf("replayRequestURL(%q, %q) = %q, %v; want %q", test.logged, test.target, got, err, test.want)
+		}
+	}
+	if _, err := replayRequestURL("/tunneled/path", ""); err == nil {
+		t.Error("expected a relative logged URL without a target to fail")
+	}
+}
@@ -0,0 +1,50 @@
+package loggingproxy
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// shouldCompressRequest reports whether a request body can be gzipped for the
+// backend: it must have a body that is not already encoded.
+func shouldCompressRequest(request *http.Request) bool {
+	return request.Body != nil && request.Body != http.NoBody && request.ContentLength != 0 &&
+		request.Header.Get("Content-Encoding") == ""
+}
+
+// gzipRequestBody compresses source on the fly. Compression starts with the
+// first Read, so the source is only read once the transport sends the body
+// and "Expect: 100-continue" still waits for the backend.
+type gzipRequestBody struct {
+	source io.ReadCloser
+	reader *io.PipeReader
+	writer *io.PipeWriter
+	start  sync.Once
+}
+
+func newGzipRequestBody(source io.ReadCloser) *gzipRequestBody {
+	reader, writer := io.Pipe()
+	return &gzipRequestBody{source: source, reader: reader, writer: writer}
+}
+
+func (b *gzipRequestBody) Read(p []byte) (int, error) {
+	b.start.Do(func() {
+		go func() {
+			compressor := gzip.NewWriter(b.writer)
+			_, err := io.Copy(compressor, b.source)
+			if err == nil {
+				err = compressor.Close()
+			}
+			b.writer.CloseWithError(err)
+		}()
+	})
+	return b.reader.Read(p)
+}
+
+// Close stops the compression and closes the source.
+func (b *gzipRequestBody) Close() error {
+	b.reader.Close()
+	return b.source.Close()
+}
@@ -0,0 +1,82 @@
+package loggingproxy
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator returns a new request ID for RequestMetadata.ID. It is called
+// concurrently. An empty ID is replaced with a UUID.
+type IDGenerator func() string
+
+// DefaultBase62IDLength is the length of Base62IDs when a non-positive
+// length is given.
+const DefaultBase62IDLength = 16
+
+const base62Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// UUIDs generates random UUID v4 request IDs. It is the default.
+func UUIDs() IDGenerator {
+	return func() string {
+		return uuid.New().String()
+	}
+}
+
+// Base62IDs generates random request IDs of length characters from
+// [0-9A-Za-z]. Sixteen characters carry about 95 bits of randomness.
+func Base62IDs(length int) IDGenerator {
+	if length <= 0 {
+		length = DefaultBase62IDLength
+	}
+	alphabetSize := big.NewInt(int64(len(base62Alphabet)))
+	return func() string {
+		id := make([]byte, length)
+		for i := range id {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				panic(fmt.Sprintf("failed to read random bytes: %v", err))
+			}
+			id[i] = base62Alphabet[n.Int64()]
+		}
+		return string(id)
+	}
+}
+
+// CounterIDs generates prefix followed by an incrementing decimal counter that
+// starts at 1, such as "node1-42". IDs are only unique per generator, so give
+// every proxy process its own prefix.
+func CounterIDs(prefix string) IDGenerator {
+	var counter atomic.Uint64
+	return func() string {
+		return prefix + strconv.FormatUint(counter.Add(1), 10)
+	}
+}
+
+// newRequestID calls generate, falling back to a UUID for a nil generator or
+// an empty ID.
+func newRequestID(generate IDGenerator) string {
+	if generate != nil {
+		if id := generate(); id != "" {
+			return id
+		}
+	}
+	return uuid.New().String()
+}
+
+// idPrefix returns the first n characters of id, or all of id if it is
+// shorter. Custom generators may return IDs of any length or non-ASCII IDs,
+// so it never cuts a multi-byte character in half.
+func idPrefix(id string, n int) string {
+	for i := range id {
+		if n == 0 {
+			return id[:i]
+		}
+		n--
+	}
+	return id
+}
@@ -0,0 +1,104 @@
+package loggingproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIDGenerators(t *testing.T) {
+	if id := UUIDs()(); len(id) != 36 {
+		t.Errorf("Expected a 36 character UUID, got %q", id)
+	}
+
+	base62 := Base62IDs(10)
+	first, second := base62(), base62()
+	if len(first) != 10 || first == second {
+		t.Errorf("Expected two different 10 character IDs, got %q and %q", first, second)
+	}
+	for _, c := range first {
+		if !strings.ContainsRune(base62Alphabet, c) {
+			t.Errorf("Unexpected character %q in base62 ID %q", c, first)
+		}
+	}
+	if id := Base62IDs(0)(); len(id) != DefaultBase62IDLength {
+		t.Errorf("Expected default length %d, got %q", DefaultBase62IDLength, id)
+	}
+
+	counter := CounterIDs("node1-")
+	if first, second := counter(), counter(); first != "node1-1" || second != "node1-2" {
+		t.Errorf("Expected node1-1 and node1-2, got %q and %q", first, second)
+	}
+
+	if id := newRequestID(func() string { return "" }); len(id) != 36 {
+		t.Errorf("Expected an empty ID to fall back to a UUID, got %q", id)
+	}
+}
+
+func TestIDPrefix(t *testing.T) {
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"", ""},
+		{"a1", "a1"},
+		{"12345678", "12345678"},
+		{"123456789", "12345678"},
+		{"äöüäöüäöü", "äöüäöüäö"},
+	}
+	for _, test := range tests {
+		if got := idPrefix(test.id, 8); got != test.want {
+			t.Errorf("idPrefix(%q, 8) = %q, want %q", test.id, got, test.want)
+		}
+	}
+}
+
+func TestShortCustomRequestIDs(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLogger(logDir, true)
+	if err != nil {
+		t.Fatalf("Failed to create file logger: %v", err)
+	}
+	defer fileLogger.Close()
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		DebugHeaders: true,
+		IDGenerator:  CounterIDs("a"),
+	})
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", fileLogger); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/api/items")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get("X-Proxy-Request-Id"); id != "a1" {
+		t.Errorf("Expected request ID a1, got %q", id)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	for _, streamType := range []string{"request", "response"} {
+		matches, _ := filepath.Glob(filepath.Join(logDir, "*_a1_"+streamType+".bin"))
+		if len(matches) != 1 {
+			entries, _ := os.ReadDir(logDir)
+			t.Fatalf("Expected one %s log for ID a1, got %v in %v", streamType, matches, entries)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package loggingproxy
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// RequestPolicy inspects an incoming request before it is forwarded. Returning
+// allow=false rejects the request with status and message instead; a zero
+// status means 403 Forbidden and an empty message uses the status text. The
+// request is the incoming one, before it is rewritten for the destination. A
+// policy that reads the body must replace it so allowed requests can still be
+// forwarded.
+type RequestPolicy func(*http.Request) (allow bool, status int, message string)
+
+// parseAllowedMethods validates RouteOptions.AllowedMethods and returns them
+// in upper case, or nil if every method is allowed.
+func parseAllowedMethods(methods []string) ([]string, error) {
+	if len(methods) == 0 {
+		return nil, nil
+	}
+	allowed := make([]string, 0, len(methods))
+	for _, method := range methods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" || strings.ContainsAny(method, " \t,") {
+			return nil, fmt.Errorf("invalid allowed method %q", method)
+		}
+		allowed = append(allowed, method)
+	}
+	return allowed, nil
+}
+
+// checkMethod rejects a request whose method the route does not allow with
+// 405 Method Not Allowed.
+func (r *proxyRoute) checkMethod(method string) (bool, int, string) {
+	if r.allowedMethods == nil || slices.Contains(r.allowedMethods, method) {
+		return true, 0, ""
+	}
+	return false, http.StatusMethodNotAllowed, fmt.Sprintf("method %s is not allowed", method)
+}
+
+// checkRequestSize rejects a request whose declared body is larger than the
+// route's MaxRequestBytes with 413 Request Entity Too Large. A body of unknown
+// length is limited as it is read instead, so reading it fails with an
+// *http.MaxBytesError once it goes over.
+func (r *proxyRoute) checkRequestSize(w http.ResponseWriter, request *http.Request) (bool, int, string) {
+	if r.maxRequestBytes <= 0 || request.Body == nil || request.Body == http.NoBody {
+		return true, 0, ""
+	}
+	if request.ContentLength > r.maxRequestBytes {
+		return false, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body of %d bytes exceeds the limit of %d bytes", request.ContentLength, r.maxRequestBytes)
+	}
+	if request.ContentLength < 0 {
+		request.Body = http.MaxBytesReader(w, request.Body, r.maxRequestBytes)
+	}
+	return true, 0, ""
+}
+
+// requestBodyErrorStatus is the status for a request body that could not be
+// read: 413 if it went over MaxRequestBytes, 400 otherwise.
+func requestBodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// evaluateRequestPolicy runs the configured policy, filling in defaults for a
+// denied request.
+func (s *ProxyServer) evaluateRequestPolicy(request *http.Request) (bool, int, string) {
+	if s.requestPolicy == nil {
+		return true, 0, ""
+	}
+	allow, status, message := s.requestPolicy(request)
+	if allow {
+		return true, 0, ""
+	}
+	if status == 0 {
+		status = http.StatusForbidden
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	return false, status, message
+}
@@ -0,0 +1,253 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newPolicyTestProxy(t *testing.T, policy RequestPolicy, logger Logger) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var backendHits atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHits.Add(1)
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "forwarded")
+	}))
+	t.Cleanup(backend.Close)
+
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:   HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		RequestPolicy: policy,
+	})
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	t.Cleanup(testServer.Close)
+	return testServer, &backendHits
+}
+
+func blockAdminPaths(request *http.Request) (bool, int, string) {
+	if strings.HasPrefix(request.URL.Path, "/api/admin") {
+		return false, http.StatusUnavailableForLegalReasons, "admin is blocked"
+	}
+	return true, 0, ""
+}
+
+func TestRequestPolicyAllowsRequests(t *testing.T) {
+	testLogger := &TestLogger{}
+	testServer, backendHits := newPolicyTestProxy(t, blockAdminPaths, testLogger)
+
+	resp, err := http.Post(testServer.URL+"/api/chat", "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if resp.StatusCode != http.StatusOK || string(body) != "forwarded" {
+		t.Fatalf("expected allowed request to be forwarded, got %d %q", resp.StatusCode, string(body))
+	}
+	if backendHits.Load() != 1 {
+		t.Fatalf("expected 1 backend hit, got %d", backendHits.Load())
+	}
+	if len(testLogger.requests) != 1 || testLogger.requests[0].metadata.Blocked {
+		t.Fatalf("expected 1 unblocked request log, got %+v", testLogger.requests)
+	}
+}
+
+func TestRequestPolicyDeniesAndLogsBlockedRequests(t *testing.T) {
+	testLogger := &TestLogger{}
+	testServer, backendHits := newPolicyTestProxy(t, blockAdminPaths, testLogger)
+
+	resp, err := http.Post(testServer.URL+"/api/admin/users", "text/plain", strings.NewReader("secret payload"))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if resp.StatusCode != http.StatusUnavailableForLegalReasons {
+		t.Fatalf("expected status 451, got %d", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "admin is blocked") {
+		t.Errorf("expected policy message in response, got %q", string(body))
+	}
+	if backendHits.Load() != 0 {
+		t.Fatalf("expected denied request not to reach the backend, got %d hits", backendHits.Load())
+	}
+
+	if len(testLogger.requests) != 1 {
+		t.Fatalf("expected 1 request log, got %d", len(testLogger.requests))
+	}
+	logged := testLogger.requests[0]
+	if !logged.metadata.Blocked || logged.metadata.BlockedReason != "admin is blocked" {
+		t.Errorf("expected request to be logged as blocked, got %+v", logged.metadata)
+	}
+	if logged.metadata.ResponseStatusCode != http.StatusUnavailableForLegalReasons {
+		t.Errorf("expected logged status 451, got %d", logged.metadata.ResponseStatusCode)
+	}
+	if !strings.Contains(logged.content, "/admin/users") || strings.Contains(logged.content, "secret payload") {
+		t.Errorf("expected blocked request to be logged with headers only, got:\n%s", logged.content)
+	}
+	if len(testLogger.responses) != 0 {
+		t.Errorf("expected no response log for a blocked request, got %d", len(testLogger.responses))
+	}
+}
+
+func TestRequestPolicyDefaultsToForbidden(t *testing.T) {
+	denyAll := func(*http.Request) (bool, int, string) { return false, 0, "" }
+	testServer, _ := newPolicyTestProxy(t, denyAll, &NoOpLogger{})
+
+	resp, err := http.Get(testServer.URL + "/api/anything")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(body), "Forbidden") {
+		t.Fatalf("expected 403 Forbidden, got %d %q", resp.StatusCode, string(body))
+	}
+}
+
+func TestAllowedMethodsRejectOtherMethods(t *testing.T) {
+	var forwarded []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = append(forwarded, r.Method)
+		io.WriteString(w, "received")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/webhook/", backend.URL+"/", testLogger, RouteOptions{
+		AllowedMethods: []string{"post", "PUT"},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Post(testServer.URL+"/webhook/events", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "received" {
+		t.Errorf("Expected POST to be forwarded, got %d %q", resp.StatusCode, body)
+	}
+
+	resp, err = http.Get(testServer.URL + "/webhook/events")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "POST, PUT" {
+		t.Errorf("Expected 405 with Allow: POST, PUT, got %d with Allow %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+	if len(forwarded) != 1 || forwarded[0] != http.MethodPost {
+		t.Errorf("Expected only the POST to reach the backend, got %v", forwarded)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	var blocked []RequestMetadata
+	for _, entry := range testLogger.requests {
+		if entry.metadata.Blocked {
+			blocked = append(blocked, entry.metadata)
+		}
+	}
+	if len(blocked) != 1 || blocked[0].Method != http.MethodGet || blocked[0].ResponseStatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Expected the GET to be logged as blocked with 405, got %+v", blocked)
+	}
+
+	if err := NewProxyServer("").AddRouteWithOptions("/x/", backend.URL, &NoOpLogger{}, RouteOptions{AllowedMethods: []string{"GET, POST"}}); err == nil {
+		t.Error("Expected a comma-separated method to be rejected")
+	}
+}
+
+func TestMaxRequestBytesRejectsLargeBodies(t *testing.T) {
+	var received atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		received.Add(1)
+		io.WriteString(w, "stored "+strconv.Itoa(len(body)))
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/upload/", backend.URL+"/", testLogger, RouteOptions{MaxRequestBytes: 1024})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	upload := func(path string, body io.Reader) (int, string) {
+		t.Helper()
+		resp, err := http.Post(testServer.URL+path, "application/octet-stream", body)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		responseBody, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(responseBody)
+	}
+
+	if status, body := upload("/upload/small", strings.NewReader(strings.Repeat("a", 1024))); status != http.StatusOK || body != "stored 1024" {
+		t.Errorf("Expected a body at the limit to be forwarded, got %d %q", status, body)
+	}
+	if status, body := upload("/upload/declared", strings.NewReader(strings.Repeat("a", 1025))); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a declared length over the limit, got %d %q", status, body)
+	}
+	// A reader of unknown length is sent chunked and cut off midway
+	chunked := io.MultiReader(strings.NewReader(strings.Repeat("a", 1000)), strings.NewReader(strings.Repeat("b", 1000)))
+	if status, body := upload("/upload/chunked", chunked); status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected 413 for a chunked body over the limit, got %d %q", status, body)
+	}
+	if received.Load() != 1 {
+		t.Errorf("Expected only the small body to reach the backend, got %d", received.Load())
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	var declared, chunkedResponse *RequestMetadata
+	for _, entry := range testLogger.requests {
+		if entry.metadata.Blocked && strings.HasSuffix(entry.metadata.SourceURL, "/declared") {
+			declared = &entry.metadata
+		}
+	}
+	for _, entry := range testLogger.responses {
+		if entry.metadata.Blocked && strings.HasSuffix(entry.metadata.SourceURL, "/chunked") {
+			chunkedResponse = &entry.metadata
+		}
+	}
+	if declared == nil || declared.ResponseStatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the declared upload to be logged as blocked with 413, got %+v", declared)
+	}
+	if chunkedResponse == nil || chunkedResponse.ResponseStatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the chunked upload to be logged as blocked with 413, got %+v", chunkedResponse)
+	}
+}
@@ -0,0 +1,65 @@
+package loggingproxy
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// upstreamTimeout returns the deadline to apply to the upstream request. Zero
+// means no deadline, which long-running streaming responses rely on.
+//
+// If a timeout header is configured and present, its value overrides the
+// default: a Go duration ("120s", "2m") or bare seconds ("120"). Values above
+// MaxRequestTimeout are clamped to it, and invalid values fall back to the
+// default. A header value of zero only disables the deadline when there is no
+// maximum.
+func (s *ProxyServer) upstreamTimeout(request *http.Request) time.Duration {
+	if s.timeoutHeader == "" {
+		return s.requestTimeout
+	}
+	rawTimeout := request.Header.Get(s.timeoutHeader)
+	if rawTimeout == "" {
+		return s.requestTimeout
+	}
+
+	timeout, ok := parseTimeoutHeader(rawTimeout)
+	if !ok {
+		return s.requestTimeout
+	}
+	if s.maxRequestTimeout > 0 && (timeout == 0 || timeout > s.maxRequestTimeout) {
+		return s.maxRequestTimeout
+	}
+	return timeout
+}
+
+func parseTimeoutHeader(value string) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 || seconds > math.MaxInt64/int64(time.Second) {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, false
+	}
+	return timeout, true
+}
+
+// withUpstreamDeadline cancels ctx with context.DeadlineExceeded as its cause
+// once timeout has passed. Unlike context.WithTimeout, the deadline can be
+// lifted: lift stops it, so a response that has started streaming by then
+// runs for as long as it needs.
+func withUpstreamDeadline(parent context.Context, timeout time.Duration) (ctx context.Context, lift func(), cancel context.CancelFunc) {
+	ctx, cancelCause := context.WithCancelCause(parent)
+	timer := time.AfterFunc(timeout, func() { cancelCause(context.DeadlineExceeded) })
+	return ctx, func() { timer.Stop() }, func() {
+		timer.Stop()
+		cancelCause(context.Canceled)
+	}
+}
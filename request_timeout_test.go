@@ -0,0 +1,146 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTimeoutTestProxy(t *testing.T, backendDelay time.Duration, options ProxyServerOptions) (*httptest.Server, chan string) {
+	t.Helper()
+	seenHeaders := make(chan string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case seenHeaders <- r.Header.Get("X-Proxy-Timeout"):
+		default:
+		}
+		select {
+		case <-time.After(backendDelay):
+		case <-r.Context().Done():
+			return
+		}
+		io.WriteString(w, "slow response")
+	}))
+	t.Cleanup(backend.Close)
+
+	options.ClientProxy = HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)}
+	options.TimeoutHeader = "X-Proxy-Timeout"
+	proxyServer, err := NewProxyServerWithOptions(options)
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	t.Cleanup(testServer.Close)
+	return testServer, seenHeaders
+}
+
+func getWithTimeoutHeader(t *testing.T, url, timeout string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	if timeout != "" {
+		req.Header.Set("X-Proxy-Timeout", timeout)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	io.ReadAll(resp.Body)
+	return resp.StatusCode
+}
+
+func TestTimeoutHeaderExtendsDeadline(t *testing.T) {
+	testServer, seenHeaders := newTimeoutTestProxy(t, 200*time.Millisecond, ProxyServerOptions{
+		RequestTimeout:    50 * time.Millisecond,
+		MaxRequestTimeout: 5 * time.Second,
+	})
+
+	if status := getWithTimeoutHeader(t, testServer.URL+"/api/slow", "2s"); status != http.StatusOK {
+		t.Fatalf("expected extended deadline to allow the slow backend, got status %d", status)
+	}
+	if header := <-seenHeaders; header != "" {
+		t.Fatalf("expected timeout header to be stripped before forwarding, backend saw %q", header)
+	}
+}
+
+func TestTimeoutHeaderIsClampedToMax(t *testing.T) {
+	testServer, _ := newTimeoutTestProxy(t, 500*time.Millisecond, ProxyServerOptions{
+		RequestTimeout:    50 * time.Millisecond,
+		MaxRequestTimeout: 100 * time.Millisecond,
+	})
+
+	started := time.Now()
+	if status := getWithTimeoutHeader(t, testServer.URL+"/api/slow", "120s"); status != http.StatusGatewayTimeout {
+		t.Fatalf("expected over-max timeout to be clamped and time out, got status %d", status)
+	}
+	if elapsed := time.Since(started); elapsed >= 500*time.Millisecond {
+		t.Fatalf("expected request to be cut off at the max timeout, took %v", elapsed)
+	}
+}
+
+func TestInvalidTimeoutHeaderFallsBackToDefault(t *testing.T) {
+	testServer, _ := newTimeoutTestProxy(t, 500*time.Millisecond, ProxyServerOptions{
+		RequestTimeout:    50 * time.Millisecond,
+		MaxRequestTimeout: 5 * time.Second,
+	})
+
+	for _, value := range []string{"soon", "-5s", "9223372037"} {
+		if status := getWithTimeoutHeader(t, testServer.URL+"/api/slow", value); status != http.StatusGatewayTimeout {
+			t.Fatalf("expected invalid timeout %q to fall back to the default deadline, got status %d", value, status)
+		}
+	}
+}
+
+func TestUpstreamTimeoutDefaultsToNoDeadline(t *testing.T) {
+	testServer, _ := newTimeoutTestProxy(t, 100*time.Millisecond, ProxyServerOptions{})
+
+	if status := getWithTimeoutHeader(t, testServer.URL+"/api/slow", ""); status != http.StatusOK {
+		t.Fatalf("expected no deadline by default, got status %d", status)
+	}
+}
+
+func TestUpstreamTimeoutParsing(t *testing.T) {
+	proxyServer := &ProxyServer{
+		requestTimeout:    30 * time.Second,
+		timeoutHeader:     "X-Proxy-Timeout",
+		maxRequestTimeout: 5 * time.Minute,
+	}
+	cases := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 30 * time.Second},
+		{"120s", 120 * time.Second},
+		{"90", 90 * time.Second},
+		{"1h", 5 * time.Minute},
+		{"0", 5 * time.Minute},
+		{"garbage", 30 * time.Second},
+		{"9223372037", 30 * time.Second},
+	}
+	for _, tc := range cases {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tc.header != "" {
+			request.Header.Set("X-Proxy-Timeout", tc.header)
+		}
+		if got := proxyServer.upstreamTimeout(request); got != tc.want {
+			t.Errorf("upstreamTimeout(%q) = %v, want %v", tc.header, got, tc.want)
+		}
+	}
+
+	// Without a maximum, zero disables the deadline for long streams.
+	proxyServer.maxRequestTimeout = 0
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set("X-Proxy-Timeout", "0")
+	if got := proxyServer.upstreamTimeout(request); got != 0 {
+		t.Errorf("upstreamTimeout(%q) without max = %v, want 0", "0", got)
+	}
+}
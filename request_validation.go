@@ -0,0 +1,49 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// DefaultRequestSchemaMaxBody is the largest request body validated against a
+// route's RequestSchema when RouteOptions.RequestSchemaMaxBody is not positive.
+const DefaultRequestSchemaMaxBody = 1 << 20
+
+// validateRequestBody buffers a JSON request body and checks it against the
+// route's schema. The body is replaced so a valid request can still be
+// forwarded, and an invalid one logged. It returns a zero status for requests
+// that may be forwarded.
+func (r *proxyRoute) validateRequestBody(request *http.Request) (int, string) {
+	if r.requestSchema == nil || request.Body == nil || request.Body == http.NoBody || request.ContentLength == 0 {
+		return 0, ""
+	}
+	if !isJSONContentType(request.Header.Get("Content-Type")) {
+		return 0, ""
+	}
+	if request.ContentLength > r.requestSchemaMaxBody {
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("request body of %d bytes is too large to validate (limit %d)", request.ContentLength, r.requestSchemaMaxBody)
+	}
+
+	// Read one byte past the limit to catch chunked bodies that exceed it
+	body, err := io.ReadAll(io.LimitReader(request.Body, r.requestSchemaMaxBody+1))
+	request.Body = &readCloser{Reader: io.MultiReader(bytes.NewReader(body), request.Body), Closer: request.Body}
+	if err != nil {
+		return requestBodyErrorStatus(err), fmt.Sprintf("failed to read request body: %v", err)
+	}
+	if int64(len(body)) > r.requestSchemaMaxBody {
+		return http.StatusRequestEntityTooLarge, fmt.Sprintf("request body is too large to validate (limit %d bytes)", r.requestSchemaMaxBody)
+	}
+	if err := r.requestSchema.Validate(body); err != nil {
+		return http.StatusBadRequest, fmt.Sprintf("request body does not match the schema: %v", err)
+	}
+	return 0, ""
+}
+
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
@@ -0,0 +1,73 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// shouldBufferResponse reports whether a response may be read completely
+// before it is sent to the client, which is the case when StreamThreshold is
+// set and the response is not known to be larger or a stream. Responses of
+// unknown length are buffered until they exceed the threshold.
+func (s *ProxyServer) shouldBufferResponse(method string, response *http.Response) bool {
+	if s.streamThreshold <= 0 || method == http.MethodHead {
+		return false
+	}
+	if response.StatusCode < http.StatusOK || response.StatusCode == http.StatusNoContent || response.StatusCode == http.StatusNotModified {
+		return false
+	}
+	if isStreamingContentType(response.Header.Get("Content-Type")) {
+		return false
+	}
+	return response.ContentLength < 0 || response.ContentLength <= s.streamThreshold
+}
+
+// isStreamingContentType reports whether a content type is consumed
+// incrementally by clients, so it must never be held back.
+func isStreamingContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch mediaType {
+	case "text/event-stream", "application/x-ndjson":
+		return true
+	}
+	return false
+}
+
+// bufferResponseBody reads body after its first chunk start until the body
+// ends or exceeds limit bytes, and returns everything read so far. The error
+// is io.EOF when the whole body was read and nil when it is larger than limit.
+func bufferResponseBody(body io.Reader, start []byte, limit int64) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(start)
+	remaining := limit + 1 - int64(len(start))
+	if remaining > 0 {
+		if _, err := buf.ReadFrom(io.LimitReader(body, remaining)); err != nil {
+			return buf.Bytes(), err
+		}
+	}
+	if int64(buf.Len()) > limit {
+		return buf.Bytes(), nil
+	}
+	return buf.Bytes(), io.EOF
+}
+
+// flushWriter flushes every write to the client, so streamed chunks are not
+// held back in the server's write buffer. Writers that cannot flush are
+// written to as usual.
+type flushWriter struct {
+	w          io.Writer
+	controller *http.ResponseController
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		f.controller.Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,131 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newStreamThresholdProxy returns a proxy test server with StreamThreshold
+// forwarding /api/ to backend.
+func newStreamThresholdProxy(t *testing.T, backend *httptest.Server, threshold int64) *httptest.Server {
+	t.Helper()
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{StreamThreshold: threshold})
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	t.Cleanup(testServer.Close)
+	return testServer
+}
+
+func TestStreamThresholdBuffersSmallResponse(t *testing.T) {
+	body := `{"items": [` + strings.Repeat(`"item", `, 500) + `"last"]}`
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flushing midway makes the backend send the body chunked
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, body[:10])
+		w.(http.Flusher).Flush()
+		io.WriteString(w, body[10:])
+	}))
+	defer backend.Close()
+	testServer := newStreamThresholdProxy(t, backend, 64*1024)
+
+	resp, err := http.Get(testServer.URL + "/api/items")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	received, _ := io.ReadAll(resp.Body)
+
+	if string(received) != body {
+		t.Fatalf("expected the full body, got %d bytes", len(received))
+	}
+	if resp.ContentLength != int64(len(body)) || len(resp.TransferEncoding) != 0 {
+		t.Fatalf("expected Content-Length %d without chunking, got %d %v", len(body), resp.ContentLength, resp.TransferEncoding)
+	}
+	if resp.Header.Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Fatalf("expected a Content-Length header, got %q", resp.Header.Get("Content-Length"))
+	}
+}
+
+func TestStreamThresholdBufferedFailureIsBadGateway(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 4096))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer backend.Close()
+	testServer := newStreamThresholdProxy(t, backend, 64*1024)
+
+	resp, err := http.Get(testServer.URL + "/api/broken")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected 502 for a buffered response that failed midway, got %d", resp.StatusCode)
+	}
+}
+
+// assertStreamed checks that the proxy delivers the first part of a response
+// while the backend is still waiting to send the rest.
+func assertStreamed(t *testing.T, contentType string, declareLength bool) {
+	t.Helper()
+	first := "data: first\n"
+	rest := strings.Repeat("r", 128*1024)
+	received := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		if declareLength {
+			w.Header().Set("Content-Length", strconv.Itoa(len(first)+len(rest)))
+		}
+		io.WriteString(w, first)
+		w.(http.Flusher).Flush()
+		select {
+		case <-received:
+		case <-time.After(2 * time.Second):
+		}
+		io.WriteString(w, rest)
+	}))
+	defer backend.Close()
+	testServer := newStreamThresholdProxy(t, backend, 64*1024)
+
+	resp, err := http.Get(testServer.URL + "/api/stream")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	start := time.Now()
+	reader := bufio.NewReader(resp.Body)
+	line, err := reader.ReadString('\n')
+	if err != nil || line != first {
+		t.Fatalf("expected the first line, got %q, %v", line, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("first line took %v, expected it to be streamed before the backend finished", elapsed)
+	}
+	close(received)
+
+	remaining, _ := io.ReadAll(reader)
+	if string(remaining) != rest {
+		t.Fatalf("expected the rest of the body, got %d bytes", len(remaining))
+	}
+}
+
+func TestStreamThresholdStreamsEventStream(t *testing.T) {
+	assertStreamed(t, "text/event-stream", false)
+}
+
+func TestStreamThresholdStreamsLargeResponse(t *testing.T) {
+	assertStreamed(t, "application/octet-stream", true)
+}
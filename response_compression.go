@@ -0,0 +1,162 @@
+package loggingproxy
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DefaultCompressResponsesMinSize is the smallest response body compressed
+// for RouteOptions.CompressResponses when CompressResponsesMinSize is not set.
+const DefaultCompressResponsesMinSize = 1024
+
+// acceptEncodingQualities parses acceptEncoding into the q-value of each
+// lower-case encoding, with x-gzip counted as gzip, and the q-value of "*",
+// which is -1 if the client did not send it.
+func acceptEncodingQualities(acceptEncoding string) (qualities map[string]float64, wildcard float64) {
+	qualities = map[string]float64{}
+	wildcard = -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		quality := 1.0
+		if key, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.EqualFold(strings.TrimSpace(key), "q") {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		switch name {
+		case "*":
+			wildcard = quality
+		case "x-gzip":
+			name = "gzip"
+			fallthrough
+		default:
+			qualities[name] = max(qualities[name], quality)
+		}
+	}
+	return qualities, wildcard
+}
+
+// acceptsEncoding reports whether a client sending acceptEncoding accepts a
+// body in contentEncoding, which may list several encodings. A client that
+// sends no Accept-Encoding is treated as accepting none, as it is when the
+// proxy negotiates compression for it.
+func acceptsEncoding(acceptEncoding, contentEncoding string) bool {
+	qualities, wildcard := acceptEncodingQualities(acceptEncoding)
+	for _, encoding := range strings.Split(contentEncoding, ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding == "x-gzip" {
+			encoding = "gzip"
+		}
+		if encoding == "" || encoding == "identity" {
+			continue
+		}
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality = wildcard
+		}
+		if quality <= 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// clientResponseEncoding returns the encoding a response is compressed in for
+// a client sending acceptEncoding: "br" or "gzip", whichever has the higher
+// q-value, preferring br on a tie, or "" if the client accepts neither.
+func clientResponseEncoding(acceptEncoding string) string {
+	qualities, wildcard := acceptEncodingQualities(acceptEncoding)
+	best, bestQuality := "", 0.0
+	for _, encoding := range []string{"br", "gzip"} {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality = wildcard
+		}
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// compressibleContentType reports whether a body of contentType is worth
+// compressing. Images, audio, video and archives are already compressed and
+// are left alone, as are bodies without a Content-Type.
+func compressibleContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "text/") || strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml") {
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/xml", "application/javascript", "application/x-javascript",
+		"application/graphql", "application/x-www-form-urlencoded", "application/wasm", "image/svg+xml":
+		return true
+	}
+	return false
+}
+
+// shouldCompressResponse reports whether an uncompressed response is
+// compressed for the client. start is the start of the body, and complete
+// tells whether it is the whole body. Streaming content types are never
+// compressed, because compression holds bytes back until a block is full.
+func shouldCompressResponse(method string, response *http.Response, start []byte, complete bool, minSize int64) bool {
+	if method == http.MethodHead || response.Body == http.NoBody || response.StatusCode == http.StatusPartialContent {
+		return false
+	}
+	if response.Header.Get("Content-Encoding") != "" || response.Header.Get("Content-Range") != "" ||
+		strings.Contains(strings.ToLower(response.Header.Get("Cache-Control")), "no-transform") {
+		return false
+	}
+	contentType := response.Header.Get("Content-Type")
+	if isStreamingContentType(contentType) || !compressibleContentType(contentType) {
+		return false
+	}
+	size := response.ContentLength
+	if complete {
+		size = int64(len(start))
+	}
+	// A body of unknown length is compressed
+	return size < 0 || size >= minSize
+}
+
+// compressResponseHeader adjusts the client response header for a body
+// compressed in encoding. A strong ETag becomes weak, since the compressed
+// bytes differ from the backend's.
+func compressResponseHeader(header http.Header, encoding string) {
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+	header.Set("Content-Encoding", encoding)
+	header.Add("Vary", "Accept-Encoding")
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
+
+// responseCompressor compresses a response body for the client. Flush sends
+// what has been compressed so far without ending the stream.
+type responseCompressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newResponseCompressor(w io.Writer, encoding string) responseCompressor {
+	if encoding == "br" {
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression)
+	}
+	return gzip.NewWriter(w)
+}
@@ -0,0 +1,120 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCompressResponsesForAcceptingClient(t *testing.T) {
+	var items []string
+	for i := range 200 {
+		items = append(items, fmt.Sprintf(`{"id":%d,"name":"item %d"}`, i, i))
+	}
+	largeJSON := "[" + strings.Join(items, ",") + "]"
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/large":
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"v1"`)
+			io.WriteString(w, largeJSON)
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"ok":true}`)
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, largeJSON)
+		case "/events":
+			w.Header().Set("Content-Type", "text/event-stream")
+			io.WriteString(w, "data: "+largeJSON+"\n\n")
+		}
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{CompressResponses: true})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(path, acceptEncoding string) (*http.Response, []byte) {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodGet, testServer.URL+path, nil)
+		if acceptEncoding != "" {
+			request.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := client.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, body
+	}
+
+	resp, body := get("/api/large", "gzip, deflate")
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Vary") != "Accept-Encoding" || resp.Header.Get("ETag") != `W/"v1"` {
+		t.Fatalf("Expected a gzip response, got headers %v", resp.Header)
+	}
+	if len(body) >= len(largeJSON) {
+		t.Errorf("Expected the compressed body to be smaller than %d bytes, got %d", len(largeJSON), len(body))
+	}
+	gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal("Failed to open gzip body:", err)
+	}
+	if decoded, err := io.ReadAll(gzipReader); err != nil || string(decoded) != largeJSON {
+		t.Errorf("Expected the gzip body to decode to the original, got %d bytes (%v)", len(decoded), err)
+	}
+
+	resp, body = get("/api/large", "gzip;q=0.5, br")
+	if resp.Header.Get("Content-Encoding") != "br" {
+		t.Fatalf("Expected a brotli response, got headers %v", resp.Header)
+	}
+	if decoded, err := io.ReadAll(brotli.NewReader(bytes.NewReader(body))); err != nil || string(decoded) != largeJSON {
+		t.Errorf("Expected the brotli body to decode to the original, got %d bytes (%v)", len(decoded), err)
+	}
+
+	// Clients that do not accept compression, small bodies, compressed and
+	// streaming content types are forwarded as they are
+	for _, request := range []struct{ path, acceptEncoding string }{
+		{"/api/large", ""},
+		{"/api/large", "gzip;q=0, identity"},
+		{"/api/small", "gzip"},
+		{"/api/image", "gzip"},
+		{"/api/events", "gzip"},
+	} {
+		resp, _ := get(request.path, request.acceptEncoding)
+		if encoding := resp.Header.Get("Content-Encoding"); encoding != "" {
+			t.Errorf("%s with %q: expected no compression, got %q", request.path, request.acceptEncoding, encoding)
+		}
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	var large int
+	for _, entry := range testLogger.responses {
+		if !strings.HasSuffix(entry.metadata.DestinationURL, "/large") {
+			continue
+		}
+		large++
+		if !strings.HasSuffix(entry.content, "\r\n\r\n"+largeJSON) || strings.Contains(entry.content, "Content-Encoding") {
+			t.Errorf("Expected the uncompressed body in the log, got %q", entry.content[:min(len(entry.content), 200)])
+		}
+	}
+	if large != 4 {
+		t.Errorf("Expected 4 logged responses for /large, got %d", large)
+	}
+}
@@ -0,0 +1,48 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"io"
+	"sync/atomic"
+)
+
+// ResponseDelivery counts the bytes of a response body that reached the
+// client and the log. The client copy and the log are fed separately, so a
+// client that disconnects early gets fewer bytes than were logged. The counts
+// grow while the response streams and are final once the response log stream
+// has ended, which is when loggers record their completion.
+type ResponseDelivery struct {
+	toClient atomic.Int64
+	logged   atomic.Int64
+}
+
+// BytesToClient returns the number of body bytes written to the client,
+// after any compression by the proxy.
+func (d *ResponseDelivery) BytesToClient() int64 {
+	return d.toClient.Load()
+}
+
+// BytesLogged returns the number of body bytes passed to the logger, as
+// received from the backend.
+func (d *ResponseDelivery) BytesLogged() int64 {
+	return d.logged.Load()
+}
+
+func (d *ResponseDelivery) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		BytesToClient int64 `json:"bytes_to_client"`
+		BytesLogged   int64 `json:"bytes_logged"`
+	}{d.BytesToClient(), d.BytesLogged()})
+}
+
+// deliveryWriter counts the bytes written to the client.
+type deliveryWriter struct {
+	w     io.Writer
+	count *atomic.Int64
+}
+
+func (d *deliveryWriter) Write(p []byte) (int, error) {
+	n, err := d.w.Write(p)
+	d.count.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,79 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// disconnectingWriter is a client that goes away after limit body bytes.
+type disconnectingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (w *disconnectingWriter) Write(p []byte) (int, error) {
+	if w.limit <= 0 {
+		return 0, errors.New("client disconnected")
+	}
+	if len(p) > w.limit {
+		n, _ := w.ResponseRecorder.Write(p[:w.limit])
+		w.limit = 0
+		return n, errors.New("client disconnected")
+	}
+	w.limit -= len(p)
+	return w.ResponseRecorder.Write(p)
+}
+
+func TestResponseDeliveryCountsEarlyClientDisconnect(t *testing.T) {
+	body := bytes.Repeat([]byte("0123456789abcdef"), 16*1024)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+
+	// A client that reads everything gets what was logged
+	proxyServer.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/complete", nil))
+	client := &disconnectingWriter{ResponseRecorder: httptest.NewRecorder(), limit: 1000}
+	proxyServer.ServeHTTP(client, httptest.NewRequest(http.MethodGet, "/api/partial", nil))
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 2 {
+		t.Fatalf("Expected 2 response logs, got %d", len(testLogger.responses))
+	}
+	for _, entry := range testLogger.responses {
+		delivery := entry.metadata.Delivery
+		if delivery == nil {
+			t.Fatal("Expected delivery counts in the metadata")
+		}
+		_, logged, _ := strings.Cut(entry.content, "\r\n\r\n")
+		if delivery.BytesLogged() != int64(len(logged)) {
+			t.Errorf("Expected %d bytes logged, got %d", len(logged), delivery.BytesLogged())
+		}
+		if strings.HasSuffix(entry.metadata.DestinationURL, "/complete") {
+			if delivery.BytesToClient() != int64(len(body)) || delivery.BytesLogged() != int64(len(body)) {
+				t.Errorf("Expected %d bytes to the client and logged, got %d and %d",
+					len(body), delivery.BytesToClient(), delivery.BytesLogged())
+			}
+			continue
+		}
+		if delivery.BytesToClient() != 1000 || client.Body.Len() != 1000 {
+			t.Errorf("Expected 1000 bytes to the client, got %d (%d received)", delivery.BytesToClient(), client.Body.Len())
+		}
+		if delivery.BytesLogged() <= delivery.BytesToClient() {
+			t.Errorf("Expected more bytes logged than delivered, got %d logged and %d delivered",
+				delivery.BytesLogged(), delivery.BytesToClient())
+		}
+	}
+}
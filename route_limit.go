@@ -0,0 +1,58 @@
+package loggingproxy
+
+import (
+	"context"
+	"time"
+)
+
+// routeLimiter caps the number of in-flight upstream requests of a route. A
+// request holds its slot until the response has been streamed to the client.
+type routeLimiter struct {
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+func newRouteLimiter(maxInFlight int, timeout time.Duration) *routeLimiter {
+	if maxInFlight <= 0 {
+		return nil
+	}
+	return &routeLimiter{
+		slots:   make(chan struct{}, maxInFlight),
+		timeout: timeout,
+	}
+}
+
+// acquire takes a slot, waiting up to the limiter's timeout for one to free
+// up. It returns false if none did or ctx ended first. A nil limiter always
+// succeeds.
+func (l *routeLimiter) acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		if l.timeout <= 0 {
+			return false
+		}
+	}
+
+	timer := time.NewTimer(l.timeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (l *routeLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,81 @@
+package loggingproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// RouteMatcher sends requests for which Match returns true to Destination
+// instead of the route's default destination. Matchers cover routing decisions
+// that http.ServeMux patterns cannot express, such as the request body size.
+type RouteMatcher struct {
+	Match       func(*http.Request) bool
+	Destination string
+}
+
+type routeMatcher struct {
+	match          func(*http.Request) bool
+	destination    string
+	destinationURL url.URL
+}
+
+func newRouteMatchers(matchers []RouteMatcher) ([]routeMatcher, error) {
+	parsed := make([]routeMatcher, 0, len(matchers))
+	for _, matcher := range matchers {
+		if matcher.Match == nil {
+			return nil, fmt.Errorf("route matcher for %q has no Match function", matcher.Destination)
+		}
+		destinationURL, err := parseDestinationURL(matcher.Destination)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, routeMatcher{
+			match:          matcher.Match,
+			destination:    matcher.Destination,
+			destinationURL: *destinationURL,
+		})
+	}
+	return parsed, nil
+}
+
+// selectDestination returns the destination URL and its configured template
+// for a request. For routes with backends, failover reports that unhealthy
+// backends were skipped, and an error that none is up.
+func (r *proxyRoute) selectDestination(request *http.Request) (destinationURL url.URL, template string, failover bool, err error) {
+	for _, matcher := range r.matchers {
+		if matcher.match(request) {
+			return matcher.destinationURL, matcher.destination, false, nil
+		}
+	}
+	if r.balancer != nil {
+		backend, failover := r.balancer.next()
+		if backend == nil {
+			return url.URL{}, "", true, errNoHealthyBackend
+		}
+		return backend.destinationURL, backend.destination, failover, nil
+	}
+	return r.destinationURL, r.destination, false, nil
+}
+
+// MatchContentLengthAbove matches requests that declare a Content-Length larger
+// than limit. Requests with an unknown length (chunked uploads) never match.
+func MatchContentLengthAbove(limit int64) func(*http.Request) bool {
+	return func(request *http.Request) bool {
+		return request.ContentLength > limit
+	}
+}
+
+// MatchContentLengthAtMost matches requests that declare a Content-Length of at
+// most limit. Requests with an unknown length (chunked uploads) never match.
+func MatchContentLengthAtMost(limit int64) func(*http.Request) bool {
+	return func(request *http.Request) bool {
+		return request.ContentLength >= 0 && request.ContentLength <= limit
+	}
+}
+
+// MatchUnknownContentLength matches requests whose body length is unknown,
+// typically chunked uploads without a Content-Length header.
+func MatchUnknownContentLength(request *http.Request) bool {
+	return request.ContentLength < 0
+}
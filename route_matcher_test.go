@@ -0,0 +1,175 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newNamedBackend(t *testing.T, name string) *httptest.Server {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, name+" "+r.URL.Path)
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func postAndReadBody(t *testing.T, url string, body io.Reader, contentLength int64) string {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, body)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.ContentLength = contentLength
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return string(responseBody)
+}
+
+func TestRouteMatchersRouteByBodySize(t *testing.T) {
+	small := newNamedBackend(t, "small")
+	large := newNamedBackend(t, "large")
+	unknown := newNamedBackend(t, "unknown")
+
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/upload/", small.URL+"/", &NoOpLogger{}, RouteOptions{
+		Matchers: []RouteMatcher{
+			{Match: MatchContentLengthAbove(1024 * 1024), Destination: large.URL + "/storage/"},
+			{Match: MatchUnknownContentLength, Destination: unknown.URL + "/"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	smallBody := bytes.Repeat([]byte("a"), 10)
+	if got := postAndReadBody(t, testServer.URL+"/upload/file", bytes.NewReader(smallBody), int64(len(smallBody))); got != "small /file" {
+		t.Errorf("expected 10-byte body to reach small backend, got %q", got)
+	}
+
+	largeBody := bytes.Repeat([]byte("b"), 10*1024*1024)
+	if got := postAndReadBody(t, testServer.URL+"/upload/file", bytes.NewReader(largeBody), int64(len(largeBody))); got != "large /storage/file" {
+		t.Errorf("expected 10MB body to reach large backend, got %q", got)
+	}
+
+	if got := postAndReadBody(t, testServer.URL+"/upload/file", strings.NewReader("chunked body"), -1); got != "unknown /file" {
+		t.Errorf("expected chunked body to reach unknown-length backend, got %q", got)
+	}
+}
+
+func TestRouteMatchersFallBackToRouteDestination(t *testing.T) {
+	small := newNamedBackend(t, "small")
+	large := newNamedBackend(t, "large")
+
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/upload/", small.URL+"/", &NoOpLogger{}, RouteOptions{
+		Matchers: []RouteMatcher{
+			{Match: MatchContentLengthAbove(16), Destination: large.URL + "/"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	// Without an unknown-length matcher, chunked uploads use the route destination.
+	if got := postAndReadBody(t, testServer.URL+"/upload/file", strings.NewReader("a chunked body that is long"), -1); got != "small /file" {
+		t.Errorf("expected chunked body to reach default backend, got %q", got)
+	}
+}
+
+func TestRouteMatcherRequiresMatchFunction(t *testing.T) {
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/upload/", "http://example.com/", &NoOpLogger{}, RouteOptions{
+		Matchers: []RouteMatcher{{Destination: "http://large.example.com/"}},
+	})
+	if err == nil {
+		t.Fatal("expected AddRouteWithOptions to reject a matcher without Match")
+	}
+}
+
+func TestMatchContentLengthAtMost(t *testing.T) {
+	match := MatchContentLengthAtMost(10)
+	cases := []struct {
+		contentLength int64
+		want          bool
+	}{
+		{0, true},
+		{10, true},
+		{11, false},
+		{-1, false},
+	}
+	for _, tc := range cases {
+		if got := match(&http.Request{ContentLength: tc.contentLength}); got != tc.want {
+			t.Errorf("MatchContentLengthAtMost(10) with Content-Length %d = %v, want %v", tc.contentLength, got, tc.want)
+		}
+	}
+}
+
+func TestAddRouteRejectsDuplicatePatterns(t *testing.T) {
+	proxyServer := NewProxyServer("/notfound/")
+	if err := proxyServer.AddRoute("/api/", "http://127.0.0.1:8080/", &NoOpLogger{}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+
+	err := proxyServer.AddRoute("/api/", "http://127.0.0.1:9090/", &NoOpLogger{})
+	if err == nil || !strings.Contains(err.Error(), "/api/ is already registered") {
+		t.Errorf("Expected a duplicate pattern error, got %v", err)
+	}
+	// Patterns that conflict on the mux without being identical fail too
+	if err := proxyServer.AddRoute("/notfound/", "http://127.0.0.1:8080/", &NoOpLogger{}); err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("Expected a conflicting pattern error, got %v", err)
+	}
+	// An exact route for the same path is a different pattern
+	if err := proxyServer.AddRouteWithOptions("/api/", "http://127.0.0.1:9090/", &NoOpLogger{}, RouteOptions{Exact: true}); err != nil {
+		t.Errorf("Expected an exact route beside the prefix route to be accepted, got %v", err)
+	}
+	// So do admin handlers
+	if err := proxyServer.Handle("/api/{path...}", http.NotFoundHandler()); err == nil || !strings.Contains(err.Error(), "conflicts") {
+		t.Errorf("Expected a conflicting handler error, got %v", err)
+	}
+}
+
+func TestRejectedRouteLeavesNothingRunning(t *testing.T) {
+	var probes atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probes.Add(1)
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("/notfound/")
+	defer proxyServer.Close()
+	err := proxyServer.AddRouteWithOptions("/notfound/", "", &NoOpLogger{}, RouteOptions{
+		Backends:    []Backend{{Destination: backend.URL + "/"}},
+		HealthCheck: &HealthCheck{Path: "/healthz", Interval: 10 * time.Millisecond},
+	})
+	if err == nil {
+		t.Fatal("Expected the conflicting route to be rejected")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := probes.Load(); n != 0 {
+		t.Errorf("Expected no health checks for the rejected route, got %d probes", n)
+	}
+	if _, ok := proxyServer.Stats().Routes["/notfound/"]; ok {
+		t.Error("Expected no stats for the rejected route")
+	}
+}
@@ -0,0 +1,95 @@
+package loggingproxy
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+	"math"
+	"time"
+)
+
+// SamplingLogger forwards a configurable fraction of traffic to the wrapped
+// logger and discards the rest. The decision is a seeded hash of the request ID,
+// so a request and its paired response are always sampled together without
+// keeping per-request state.
+type SamplingLogger struct {
+	Logger      Logger
+	Probability float64
+	Seed        uint64
+}
+
+// NewSamplingLogger wraps logger so that each request is logged with the given
+// probability (0 logs nothing, 1 logs everything).
+func NewSamplingLogger(logger Logger, probability float64, seed uint64) *SamplingLogger {
+	return &SamplingLogger{
+		Logger:      logger,
+		Probability: probability,
+		Seed:        seed,
+	}
+}
+
+// LogRequest forwards the request stream if the request is sampled
+func (l *SamplingLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	if !l.Sampled(metadata) {
+		discardStream(rawRequestStream)
+		return
+	}
+	l.Logger.LogRequest(metadata, timestamp, rawRequestStream)
+}
+
+// LogResponse forwards the response stream if the request is sampled
+func (l *SamplingLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	if !l.Sampled(metadata) {
+		discardStream(rawResponseStream)
+		return
+	}
+	l.Logger.LogResponse(metadata, timestamp, rawResponseStream)
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them and the event is sampled.
+func (l *SamplingLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	connectLogger, ok := l.Logger.(ConnectLogger)
+	if !ok || !l.Sampled(metadata) {
+		return
+	}
+	connectLogger.LogConnect(metadata, timestamp)
+}
+
+// Close closes the wrapped logger if it implements io.Closer.
+func (l *SamplingLogger) Close() error {
+	return closeWrapped(l.Logger)
+}
+
+// Sampled reports whether the request identified by metadata.ID is logged.
+func (l *SamplingLogger) Sampled(metadata RequestMetadata) bool {
+	if l.Probability <= 0 {
+		return false
+	}
+	if l.Probability >= 1 {
+		return true
+	}
+
+	hash := fnv.New64a()
+	var seed [8]byte
+	binary.LittleEndian.PutUint64(seed[:], l.Seed)
+	hash.Write(seed[:])
+	hash.Write([]byte(metadata.ID))
+	return float64(mixHash(hash.Sum64()))/math.MaxUint64 < l.Probability
+}
+
+// mixHash is the splitmix64 finalizer. FNV alone distributes sequential IDs
+// poorly across the high bits used for the sampling threshold.
+func mixHash(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// discardStream consumes and closes a stream so the proxy's TeeReader never blocks.
+func discardStream(stream io.ReadCloser) {
+	defer stream.Close()
+	io.Copy(io.Discard, stream)
+}
@@ -0,0 +1,97 @@
+package loggingproxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxySamplingRequests(t *testing.T, logger Logger, count int) {
+	t.Helper()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "response body")
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for i := 0; i < count; i++ {
+		resp, err := http.Post(testServer.URL+"/api/sample", "text/plain", strings.NewReader("request body"))
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "response body" {
+			t.Fatalf("expected proxied response body, got %q", string(body))
+		}
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestSamplingLoggerProbabilityZeroLogsNothing(t *testing.T) {
+	testLogger := &TestLogger{}
+	proxySamplingRequests(t, NewSamplingLogger(testLogger, 0, 1), 5)
+
+	if len(testLogger.requests) != 0 || len(testLogger.responses) != 0 {
+		t.Fatalf("expected nothing logged, got %d requests and %d responses", len(testLogger.requests), len(testLogger.responses))
+	}
+}
+
+func TestSamplingLoggerProbabilityOneLogsEverything(t *testing.T) {
+	testLogger := &TestLogger{}
+	proxySamplingRequests(t, NewSamplingLogger(testLogger, 1, 1), 5)
+
+	if len(testLogger.requests) != 5 || len(testLogger.responses) != 5 {
+		t.Fatalf("expected 5 requests and 5 responses logged, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	if !strings.Contains(testLogger.requests[0].content, "request body") {
+		t.Errorf("expected sampled request to include body, got:\n%s", testLogger.requests[0].content)
+	}
+}
+
+func TestSamplingLoggerIsDeterministicAndPaired(t *testing.T) {
+	const total = 2000
+	testLogger := &TestLogger{}
+	sampler := NewSamplingLogger(testLogger, 0.25, 42)
+	sameSeed := NewSamplingLogger(&NoOpLogger{}, 0.25, 42)
+
+	sampled := 0
+	for i := 0; i < total; i++ {
+		metadata := RequestMetadata{ID: fmt.Sprintf("request-%d", i)}
+		if sampler.Sampled(metadata) != sameSeed.Sampled(metadata) {
+			t.Fatalf("expected identical decisions for the same seed and ID %q", metadata.ID)
+		}
+		sampler.LogRequest(metadata, time.Now(), io.NopCloser(strings.NewReader("request")))
+		sampler.LogResponse(metadata, time.Now(), io.NopCloser(strings.NewReader("response")))
+		if sampler.Sampled(metadata) {
+			sampled++
+		}
+	}
+
+	if len(testLogger.requests) != sampled || len(testLogger.responses) != sampled {
+		t.Fatalf("expected %d paired logs, got %d requests and %d responses", sampled, len(testLogger.requests), len(testLogger.responses))
+	}
+	for i := range testLogger.requests {
+		if testLogger.requests[i].metadata.ID != testLogger.responses[i].metadata.ID {
+			t.Fatalf("expected request and response logs to be paired, got %q and %q", testLogger.requests[i].metadata.ID, testLogger.responses[i].metadata.ID)
+		}
+	}
+
+	ratio := float64(sampled) / total
+	if ratio < 0.2 || ratio > 0.3 {
+		t.Fatalf("expected roughly 25%% sampled, got %.3f", ratio)
+	}
+}
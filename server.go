@@ -1,30 +1,272 @@
 package loggingproxy
 
 import (
+	"bufio"
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/andybalholm/brotli"
-	"github.com/google/uuid"
 )
 
 type ProxyServer struct {
-	mux    *http.ServeMux
-	client *http.Client
+	mux               *http.ServeMux
+	client            *http.Client
+	logWorkers        *logWorkerPool
+	logWatchdog       *logWatchdog
+	requestTimeout    time.Duration
+	timeoutHeader     string
+	maxRequestTimeout time.Duration
+	debugHeaders      bool
+	requestPolicy     RequestPolicy
+	cors              *CORSConfig
+	logHeaders        logHeaderFilter
+	logHeaderLimits   loggedHeaderLimits
+	maxBufferedBody   int64
+	ops               levelLogger
+	orderedLogs       bool
+	traceContext      bool
+	metrics           *Metrics
+	destinationGuard  *destinationGuard
+	subjectHeader     string
+	streamThreshold   int64
+	idGenerator       IDGenerator
+	maxConnLifetime   time.Duration
+	addressOverrides  addressOverrides
+	caseInsensitive   bool
+	loggingDefault    bool
+	patternsMu        sync.RWMutex
+	patterns          map[string]struct{}
+	notFoundPattern   string
+	// registerMu serializes checking a pattern and registering it, so a
+	// route is only built once its pattern is known to fit on the mux
+	registerMu sync.Mutex
+	// closers stop the routes' health checks and close the idle connections
+	// of their own clients
+	closersMu sync.Mutex
+	closers   []func()
+	stats     serverStats
+	clock     Clock
+}
+
+// ProxyServerOptions configures a reverse proxy server.
+type ProxyServerOptions struct {
+	// NotFoundEndpoint registers a 404 handler below this path when non-empty.
+	NotFoundEndpoint string
+
+	// ClientProxy configures the upstream proxy used for outbound requests.
+	ClientProxy HTTPClientProxyConfig
+
+	// ClientTLS configures TLS towards upstream backends, such as the client
+	// certificate for mutual TLS. Routes can override it with RouteOptions.ClientTLS.
+	ClientTLS ClientTLSConfig
+
+	// MaxConnLifetime closes upstream connections once they are this old
+	// instead of reusing them, so backend host names are re-resolved. Zero
+	// reuses connections until they are idle for too long.
+	MaxConnLifetime time.Duration
+
+	// AddressOverride dials backend hosts at fixed addresses instead of
+	// resolving them, for example {"api.example.com": "127.0.0.1:8443"}.
+	// Keys are a host name or "host:port"; values are "ip:port", or an IP
+	// that keeps the destination's port. URLs, the Host header and TLS server
+	// names keep the host name. Routes can add to it with
+	// RouteOptions.AddressOverride. Requests sent through ClientProxy dial the
+	// proxy, so only an override of the proxy's own host applies to them.
+	AddressOverride map[string]string
+
+	// MaxRedirects is the number of upstream redirects the proxy follows itself.
+	// Zero forwards every 3xx response to the client unchanged, which is what a
+	// transparent proxy should do. When redirects are followed, the final URL is
+	// recorded in RequestMetadata.FinalURL.
+	MaxRedirects int
+
+	// RequestTimeout is the default deadline for an upstream request, including
+	// streaming the response body. Event streams and NDJSON streams are only
+	// bound by it until their response starts, so long-lived subscriptions
+	// are not cut off. Zero means no deadline.
+	RequestTimeout time.Duration
+
+	// TimeoutHeader names an incoming request header (for example
+	// "X-Proxy-Timeout") that lets clients override RequestTimeout per request.
+	// The header is not forwarded upstream.
+	TimeoutHeader string
+
+	// MaxRequestTimeout clamps timeouts requested through TimeoutHeader.
+	// Zero means no maximum.
+	MaxRequestTimeout time.Duration
+
+	// MaxConcurrentLogs caps the number of concurrently running logging
+	// goroutines. Zero means unbounded (one request and one response logging
+	// goroutine per proxied request).
+	MaxConcurrentLogs int
+
+	// LogQueueTimeout is how long a request waits for a free logging slot when
+	// MaxConcurrentLogs is reached. Once it expires the log is dropped and
+	// counted; the request itself is still proxied. Zero drops immediately.
+	LogQueueTimeout time.Duration
+
+	// LogTimeout is the longest a Logger may take to log one request or
+	// response stream, measured from when its logging goroutine starts. A
+	// logger that is still running at the deadline is abandoned: its stream
+	// is closed, so proxied traffic no longer waits on it, and the timeout is
+	// counted. The deadline covers the whole stream, so it must exceed the
+	// longest expected response. Zero never abandons a logger.
+	LogTimeout time.Duration
+
+	// DebugHeaders adds X-Proxy-Request-Id and X-Proxy-Route to every response
+	// so clients can correlate it with the logs.
+	DebugHeaders bool
+
+	// RequestPolicy can reject requests before they are forwarded. Rejected
+	// requests are still logged, with RequestMetadata.Blocked set and only
+	// their headers recorded.
+	RequestPolicy RequestPolicy
+
+	// CORS answers preflight requests and adds CORS headers to responses for
+	// every route. Routes can override it with RouteOptions.CORS.
+	CORS *CORSConfig
+
+	// LogHeaderAllowList restricts the headers written to logged transcripts to
+	// the listed names. Forwarded traffic keeps all headers. Empty logs every
+	// header.
+	LogHeaderAllowList []string
+
+	// MaxLoggedHeaderValue truncates header values and status texts longer
+	// than this many bytes in logged transcripts, with a "...[truncated N
+	// bytes]" note. MaxLoggedHeaderBytes leaves out the headers that would
+	// grow a logged header block beyond this many bytes and adds an
+	// "X-Logged-Headers: omitted N" note. Forwarded headers are not changed.
+	// Zero is unlimited.
+	MaxLoggedHeaderValue int
+	MaxLoggedHeaderBytes int
+
+	// MaxBufferedRequestBody reads request bodies of up to this many bytes
+	// completely before the backend is contacted, so an upload that fails
+	// midway is never forwarded. The proxy then answers "Expect: 100-continue"
+	// itself. Larger bodies, and all bodies when zero, are streamed: a failed
+	// upload aborts the upstream request after forwarding what was read.
+	// Either way the request log fails with an IncompleteRequestError.
+	MaxBufferedRequestBody int64
+
+	// LogLevel filters the server's own operational messages: failed
+	// upstream requests are info, forwarded requests are debug.
+	LogLevel LogLevel
+
+	// OrderedLogs holds back each response log until the logger has returned
+	// from LogRequest for the same request, so sinks never see the two
+	// interleaved. The response stream to the client waits for it too, so a
+	// slow request log adds latency.
+	OrderedLogs bool
+
+	// TraceContext propagates W3C trace context headers: a valid incoming
+	// traceparent and tracestate are forwarded unchanged, otherwise a new
+	// traceparent is generated for the upstream request. The trace and parent
+	// IDs are recorded as RequestMetadata.TraceID and SpanID.
+	TraceContext bool
+
+	// Metrics records the response body bytes sent to clients in a
+	// per-route histogram (logging_proxy_response_size_bytes) as responses
+	// finish, whether or not the route is logged. Nil records nothing.
+	Metrics *Metrics
+
+	// DestinationGuard rejects requests to internal addresses with 403
+	// Forbidden. Nil proxies to any destination.
+	DestinationGuard *DestinationGuard
+
+	// StreamThreshold makes the proxy read responses of up to this many bytes
+	// completely before sending them, with an exact Content-Length, so a
+	// backend failure midway becomes a clean 502. Larger responses, responses
+	// of unknown length once they exceed it, and event streams are streamed,
+	// flushing every chunk to the client as it arrives. Zero streams every
+	// response without extra flushes.
+	StreamThreshold int64
+
+	// SubjectHeader names a request header, such as "X-User-Id", whose value
+	// is recorded as RequestMetadata.Subject on both streams of a request, so
+	// FileLogger.Purge can later delete everything logged for that subject.
+	SubjectHeader string
+
+	// IDGenerator generates RequestMetadata.ID. Nil uses UUIDs.
+	IDGenerator IDGenerator
+
+	// CaseInsensitiveRoutes matches request paths against route patterns
+	// ignoring the case of ASCII letters, so "/API/v1" matches "/api/". The
+	// path is forwarded and logged as the client sent it.
+	CaseInsensitiveRoutes bool
+
+	// DefaultLoggingDisabled records that routes without RouteOptions.Logging
+	// are not logged, in RequestMetadata.LoggingEnabled. It only describes the
+	// loggers the caller passed to AddRoute and does not change them.
+	DefaultLoggingDisabled bool
+
+	// Clock provides request and response timestamps. Nil uses the wall clock.
+	Clock Clock
 }
 
 func NewProxyServer(notFoundEndpoint string) *ProxyServer {
 	return newProxyServerWithClient(notFoundEndpoint, newDirectHTTPClient())
 }
 
+func NewProxyServerWithOptions(options ProxyServerOptions) (*ProxyServer, error) {
+	server, err := NewProxyServerWithHTTPClientProxy(options.NotFoundEndpoint, options.ClientProxy)
+	if err != nil {
+		return nil, err
+	}
+	server.destinationGuard, err = newDestinationGuard(options.DestinationGuard)
+	if err != nil {
+		return nil, err
+	}
+	server.client.CheckRedirect = redirectPolicy(options.MaxRedirects, server.destinationGuard)
+	if options.ClientTLS.enabled() {
+		server.client, err = withClientTLS(server.client, options.ClientTLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+	server.maxConnLifetime = options.MaxConnLifetime
+	server.addressOverrides, err = newAddressOverrides(nil, options.AddressOverride)
+	if err != nil {
+		return nil, err
+	}
+	setDialer(server.client.Transport.(*http.Transport), server.maxConnLifetime, server.addressOverrides, server.destinationGuard)
+	server.requestTimeout = options.RequestTimeout
+	server.timeoutHeader = http.CanonicalHeaderKey(strings.TrimSpace(options.TimeoutHeader))
+	server.maxRequestTimeout = options.MaxRequestTimeout
+	server.logWorkers = newLogWorkerPool(options.MaxConcurrentLogs, options.LogQueueTimeout)
+	server.logWatchdog = newLogWatchdog(options.LogTimeout)
+	server.debugHeaders = options.DebugHeaders
+	server.requestPolicy = options.RequestPolicy
+	server.cors = options.CORS
+	server.logHeaders = newLogHeaderFilter(options.LogHeaderAllowList)
+	server.logHeaderLimits = loggedHeaderLimits{maxValue: options.MaxLoggedHeaderValue, maxBlock: options.MaxLoggedHeaderBytes}
+	server.maxBufferedBody = options.MaxBufferedRequestBody
+	server.ops = levelLogger{level: options.LogLevel}
+	server.orderedLogs = options.OrderedLogs
+	server.traceContext = options.TraceContext
+	server.metrics = options.Metrics
+	server.subjectHeader = strings.TrimSpace(options.SubjectHeader)
+	server.streamThreshold = options.StreamThreshold
+	server.idGenerator = options.IDGenerator
+	server.caseInsensitive = options.CaseInsensitiveRoutes
+	server.loggingDefault = !options.DefaultLoggingDisabled
+	server.clock = clockOrReal(options.Clock)
+	return server, nil
+}
+
 func NewProxyServerWithHTTPClientProxy(notFoundEndpoint string, proxyConfig HTTPClientProxyConfig) (*ProxyServer, error) {
 	client, err := newHTTPClient(proxyConfig)
 	if err != nil {
@@ -46,32 +288,572 @@ func newProxyServerWithClient(notFoundEndpoint string, client *http.Client) *Pro
 	if client == nil {
 		client = newDirectHTTPClient()
 	}
+	// Forward upstream redirects to the client instead of following them, so
+	// the logged destination is where the response actually came from.
+	client.CheckRedirect = redirectPolicy(0, nil)
 	return &ProxyServer{
-		mux:    mux,
-		client: client,
+		mux:             mux,
+		client:          client,
+		loggingDefault:  true,
+		clock:           realClock{},
+		notFoundPattern: notFoundEndpoint,
 	}
 }
 
 // ServeHTTP implements http.Handler interface
 func (s *ProxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.caseInsensitive {
+		s.serveCaseInsensitive(w, r)
+		return
+	}
 	s.mux.ServeHTTP(w, r)
 }
 
+// Handle registers an additional handler, such as an admin endpoint, on the
+// proxy's mux. The same precedence rules as for routes apply, and a pattern
+// that is already registered or conflicts with a route is an error.
+func (s *ProxyServer) Handle(pattern string, handler http.Handler) error {
+	if s.caseInsensitive {
+		pattern = lowerPatternPath(pattern)
+	}
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+	if err := s.checkPattern(pattern); err != nil {
+		return err
+	}
+	s.registerPattern(pattern, handler)
+	return nil
+}
+
+// DroppedLogs returns the number of request/response logs dropped because
+// MaxConcurrentLogs was reached.
+func (s *ProxyServer) DroppedLogs() uint64 {
+	return s.logWorkers.Dropped()
+}
+
+// TimedOutLogs returns the number of request/response logs abandoned because
+// the logger did not finish within LogTimeout.
+func (s *ProxyServer) TimedOutLogs() uint64 {
+	return s.logWatchdog.TimedOut()
+}
+
+// RouteOptions configures optional per-route behavior for AddRouteWithOptions.
+type RouteOptions struct {
+	// Matchers select an alternative destination per request. They are evaluated
+	// in order and the first match wins; if none match, the route destination is used.
+	Matchers []RouteMatcher
+	// Exact matches only the pattern itself, even when it ends in "/". The
+	// same can be spelled with the ServeMux end anchor, as in "/healthz/{$}".
+	Exact bool
+	// PreservePath joins the full request path onto the destination instead
+	// of only the part after the pattern, so "/api/v1/test" on the route
+	// "/api/v1/" is forwarded as "<destination>/api/v1/test".
+	PreservePath bool
+	// StripSegments drops this many leading segments of the path before it
+	// is joined onto the destination, so with 1 "/api/v2/models" on the route
+	// "/api/" is forwarded as "<destination>/models". It applies after
+	// PreservePath and has no effect on exact routes, which always forward to
+	// the destination as configured.
+	StripSegments int
+	// CompressRequests gzips request bodies sent to the backend, unless they
+	// already have a Content-Encoding. Logs keep the uncompressed body.
+	CompressRequests bool
+	// MaxInFlight caps the concurrent upstream requests of this route,
+	// counting until each response has been streamed. Zero is unlimited.
+	MaxInFlight int
+	// InFlightQueueTimeout is how long a request over MaxInFlight waits for a
+	// free slot before it is rejected with 503 Service Unavailable. Zero
+	// rejects immediately.
+	InFlightQueueTimeout time.Duration
+	// ClientTLS replaces the server-wide ProxyServerOptions.ClientTLS for this
+	// route, giving it a dedicated upstream transport.
+	ClientTLS *ClientTLSConfig
+	// AddressOverride adds to ProxyServerOptions.AddressOverride for this
+	// route, giving it a dedicated upstream transport. Its entries win over
+	// the server-wide ones for the same host.
+	AddressOverride map[string]string
+	// CORS replaces the server-wide ProxyServerOptions.CORS for this route.
+	CORS *CORSConfig
+	// AllowedMethods restricts the route to these request methods. Other
+	// methods are answered with 405 Method Not Allowed and an Allow header,
+	// without being forwarded, and logged as blocked. CORS preflights are
+	// still answered. Empty allows every method.
+	AllowedMethods []string
+	// StatusMap rewrites upstream status codes before they reach the client,
+	// for example {201: 200} or {429: 503}. Logs keep the original status.
+	StatusMap map[int]int
+	// BodyTags records fields of JSON request bodies in RequestMetadata.Tags,
+	// keyed by tag name, with paths such as "$.model" or
+	// "$.messages[0].role". Strings are recorded as they are and other
+	// values as JSON. Only uncompressed bodies of at most BodyTagsMaxBody
+	// bytes with a JSON Content-Type are read.
+	BodyTags map[string]string
+	// BodyTagsMaxBody is the largest body read for BodyTags. Zero uses
+	// DefaultBodyTagsMaxBody.
+	BodyTagsMaxBody int64
+	// MaxRequestBytes caps request bodies. A larger Content-Length is
+	// answered with 413 Request Entity Too Large without being forwarded, and
+	// logged as blocked. A body of unknown length is cut off once it goes
+	// over, failing the upstream request with 413. Zero is unlimited.
+	MaxRequestBytes int64
+	// CanonicalHeaders forwards and logs request and response header names
+	// in canonical form, such as Content-Type, with the usual spelling of
+	// headers like WWW-Authenticate and ETag.
+	CanonicalHeaders bool
+	// Fallback is served when the backend cannot be reached, instead of the
+	// 502/504 error response.
+	Fallback *FallbackResponse
+	// NegotiateCompression asks the backend for "gzip, br" on behalf of
+	// clients that send no Accept-Encoding, and decompresses the response for
+	// them. Clients that send Accept-Encoding get the backend response as is.
+	NegotiateCompression bool
+	// CompressResponses gzip- or brotli-compresses uncompressed responses of
+	// at least CompressResponsesMinSize bytes for clients whose
+	// Accept-Encoding allows it. Streaming and already compressed content
+	// types are sent as they are, and logs keep the uncompressed body.
+	CompressResponses bool
+	// CompressResponsesMinSize is the smallest body compressed. Zero uses
+	// DefaultCompressResponsesMinSize.
+	CompressResponsesMinSize int64
+	// RequestSchema validates JSON request bodies before they are forwarded.
+	// Bodies that do not match are rejected with 400 Bad Request listing the
+	// violations, and logged as blocked together with the body. Only bodies
+	// with a JSON Content-Type are validated.
+	RequestSchema *JSONSchema
+	// RequestSchemaMaxBody is the largest body buffered for RequestSchema.
+	// Larger JSON bodies are rejected with 413. Zero uses
+	// DefaultRequestSchemaMaxBody.
+	RequestSchemaMaxBody int64
+	// BodyCapture selects how much of the request and response bodies are
+	// logged: all of them (the default), the first N bytes, only the
+	// headers, or nothing at all.
+	BodyCapture BodyCaptureMode
+	// SkipLargeBodies uses BodyCaptureHeadersOnly for responses that declare
+	// a Content-Length above this many bytes or have a binary, image, audio
+	// or video content type, whatever BodyCapture is. Zero logs every body
+	// according to BodyCapture.
+	SkipLargeBodies int64
+
+	// Authorization provides the Authorization header sent upstream,
+	// replacing the client's. It is fetched for every request before the
+	// backend is contacted; if it fails, the client gets 502 Bad Gateway.
+	// Logged transcripts show the injected header as "[redacted]".
+	Authorization CredentialProvider
+
+	// RequestFilter and ResponseFilter transform bodies with an external
+	// command. The logs record the transformed bodies. A filter that fails
+	// fails the request with 502 Bad Gateway.
+	RequestFilter  *BodyFilter
+	ResponseFilter *BodyFilter
+
+	// Backends spread the route's requests over several destinations by
+	// weighted round-robin. The destination passed to AddRouteWithOptions
+	// must then be empty. Matchers still take precedence. The chosen backend
+	// is recorded as RequestMetadata.DestinationTemplate.
+	Backends []Backend
+	// BackendFailTimeout is how long a backend that could not be reached is
+	// skipped, unless every backend failed. Zero uses
+	// DefaultBackendFailTimeout.
+	BackendFailTimeout time.Duration
+	// HealthCheck probes the Backends in the background until the server
+	// is closed. When every backend is down, requests are rejected with 503
+	// Service Unavailable.
+	HealthCheck *HealthCheck
+
+	// MirrorTo sends a copy of every request to this shadow destination in
+	// the background, with the same path and query as the primary request.
+	// The client always gets the primary response and the mirror's response
+	// is discarded. Only bodies of a known length of at most MirrorMaxBody
+	// (zero uses DefaultMirrorMaxBody) are buffered for the copy, so larger
+	// and streaming uploads are not mirrored. With LogMirror, the mirrored
+	// exchange is logged too, with RequestMetadata.Mirror set.
+	MirrorTo      string
+	MirrorMaxBody int64
+	LogMirror     bool
+	// MirrorCompare compares each mirrored response with the primary
+	// response and records the differences in a dedicated log. Pairs that
+	// take longer than the mirror's 30 second timeout are not compared.
+	MirrorCompare *MirrorComparison
+
+	// FaultInjection adds synthetic latency, errors and connection resets to
+	// the route's requests for resilience testing. Nil injects nothing.
+	FaultInjection *FaultInjection
+
+	// Idempotency answers requests that repeat an idempotency key with the
+	// response stored for the first one, without contacting the backend.
+	// Nil forwards every request.
+	Idempotency *IdempotencyConfig
+
+	// Logging records whether the route's logger was explicitly enabled or
+	// disabled for this route, overriding the server default, in
+	// RequestMetadata.LoggingEnabled and LoggingSource. Nil follows
+	// ProxyServerOptions.DefaultLoggingDisabled. Like that option, it does
+	// not change the logger.
+	Logging *bool
+}
+
+// proxyRoute is the per-route state associated with a registered mux handler.
+type proxyRoute struct {
+	// pattern is the pattern as supplied by the caller, before {path...} is appended.
+	pattern string
+	// destination is the destination URL as configured, before the request path is joined.
+	destination    string
+	destinationURL url.URL
+	logger         Logger
+	matchers       []routeMatcher
+	// balancer replaces destination for routes with RouteOptions.Backends.
+	balancer         *loadBalancer
+	preservePath     bool
+	stripSegments    int
+	compressRequests bool
+	// negotiateCompression is RouteOptions.NegotiateCompression.
+	negotiateCompression bool
+	limiter              *routeLimiter
+	// client overrides the server client for routes with their own ClientTLS.
+	client      *http.Client
+	cors        *CORSConfig
+	statusMap   map[int]int
+	fallback    *FallbackResponse
+	bodyCapture BodyCaptureMode
+	// allowedMethods is RouteOptions.AllowedMethods in upper case.
+	allowedMethods []string
+	// compressResponsesMinSize is RouteOptions.CompressResponsesMinSize, or
+	// zero if responses are not compressed.
+	compressResponsesMinSize int64
+	// maxRequestBytes is RouteOptions.MaxRequestBytes.
+	maxRequestBytes int64
+	bodyTags        *bodyTags
+	// canonicalHeaders is RouteOptions.CanonicalHeaders.
+	canonicalHeaders bool
+	// skipLargeBodies is RouteOptions.SkipLargeBodies.
+	skipLargeBodies      int64
+	requestSchema        *JSONSchema
+	requestSchemaMaxBody int64
+	authorization        CredentialProvider
+	requestFilter        *BodyFilter
+	responseFilter       *BodyFilter
+	mirror               *routeMirror
+	faults               *routeFaults
+	idempotency          *idempotencyCache
+	// loggingEnabled and loggingSource are recorded in the metadata.
+	loggingEnabled bool
+	loggingSource  string
+	stats          *trafficCounters
+}
+
+// AddRoute proxies requests matching pattern to destination.
+//
+// Patterns ending in "/" are prefix routes: the rest of the request path is
+// appended to the destination. Other patterns match only that exact path, as do
+// patterns ending in the "{$}" anchor and routes added with RouteOptions.Exact,
+// and forward to the destination verbatim. RouteOptions.PreservePath and
+// StripSegments change what a prefix route appends; the query is always kept.
+// Precedence follows http.ServeMux and does not depend on registration order:
+//   - the most specific pattern wins, so "/api/v1/" beats "/api/" beats "/"
+//   - an exact route "/api" beats the prefix route "/api/" for the path "/api"
+//   - a request for a prefix route's root without its trailing slash
+//     ("/api/v1" with a "/api/v1/" route) is redirected to "/api/v1/" rather
+//     than being matched by a shorter prefix such as "/api/" or "/"
 func (s *ProxyServer) AddRoute(pattern string, destination string, logger Logger) error {
+	return s.AddRouteWithOptions(pattern, destination, logger, RouteOptions{})
+}
+
+func (s *ProxyServer) AddRouteWithOptions(pattern string, destination string, logger Logger, options RouteOptions) error {
 	// Make sure the pattern doesn't contain a wildcard
 	wildcardRegex := regexp.MustCompile(`{[a-zA-Z0-9_.]+`)
 	if wildcardRegex.MatchString(pattern) {
 		return fmt.Errorf("pattern %s contains a wildcard, which is not supported", pattern)
 	}
 
-	// Append a named wildcard so we can extract the path from the request
+	// Keep the clean pattern for logging before it is mangled for the mux
+	routePattern := pattern
+
+	if s.caseInsensitive {
+		pattern = lowerPatternPath(pattern)
+	}
+
+	// Append a named wildcard so we can extract the path from the request,
+	// or the end anchor if the caller wants an exact match
 	if strings.HasSuffix(pattern, "/") {
-		pattern += "{path...}"
+		if options.Exact {
+			pattern += "{$}"
+		} else {
+			pattern += "{path...}"
+		}
 	}
+	// Check the pattern before the route starts its health checks and gets
+	// its stats, which a pattern that cannot be registered would leave behind
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+	if s.hasPattern(pattern) {
+		return fmt.Errorf("route pattern %s is already registered", routePattern)
+	}
+	if err := s.checkPattern(pattern); err != nil {
+		return fmt.Errorf("route pattern %s: %w", routePattern, err)
+	}
+
+	route, err := s.newRoute(routePattern, destination, logger, options)
+	if err != nil {
+		return err
+	}
+	s.registerPattern(pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handleRequest(w, r, route)
+	}))
+	return nil
+}
+
+// checkPattern returns the conflict the mux would panic with if pattern were
+// registered, by registering it after the server's patterns on a scratch mux.
+func (s *ProxyServer) checkPattern(pattern string) (err error) {
+	scratch := http.NewServeMux()
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("%v", recovered)
+		}
+	}()
+	if s.notFoundPattern != "" {
+		scratch.Handle(s.notFoundPattern, http.NotFoundHandler())
+	}
+	s.patternsMu.RLock()
+	for registered := range s.patterns {
+		scratch.Handle(registered, http.NotFoundHandler())
+	}
+	s.patternsMu.RUnlock()
+	scratch.Handle(pattern, http.NotFoundHandler())
+	return nil
+}
+
+// registerPattern registers handler on the mux once checkPattern accepted
+// pattern.
+func (s *ProxyServer) registerPattern(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+	s.addPattern(pattern)
+}
+
+// RouteHandler returns a handler that proxies every request it receives to
+// destination, for mounting on a mux or router owned by the caller. It uses the
+// server's client and options like a route added with AddRouteWithOptions, but
+// it is not registered on the server's own mux. The full request path is
+// joined onto the destination, so wrap the handler in http.StripPrefix to drop
+// a mount prefix. Exact and PreservePath do not apply to it.
+func (s *ProxyServer) RouteHandler(destination string, logger Logger, options RouteOptions) (http.Handler, error) {
+	options.PreservePath = true
+	route, err := s.newRoute("", destination, logger, options)
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handleRequest(w, r, route)
+	}), nil
+}
+
+// NewRouteHandler returns a handler that proxies and logs requests to
+// destination with default server options. See ProxyServer.RouteHandler.
+func NewRouteHandler(destination string, logger Logger) (http.Handler, error) {
+	return NewProxyServer("").RouteHandler(destination, logger, RouteOptions{})
+}
 
+// newRoute validates the options and builds the state shared by all requests
+// to a route. The pattern is only used for logging.
+func (s *ProxyServer) newRoute(pattern string, destination string, logger Logger, options RouteOptions) (*proxyRoute, error) {
+	balancer, err := newLoadBalancer(options.Backends, options.BackendFailTimeout, s.clock)
+	if err != nil {
+		return nil, err
+	}
+	destinationURL := &url.URL{}
+	if balancer == nil {
+		destinationURL, err = parseDestinationURL(destination)
+		if err != nil {
+			return nil, err
+		}
+	} else if destination != "" {
+		return nil, fmt.Errorf("destination %q cannot be combined with backends", destination)
+	}
+
+	matchers, err := newRouteMatchers(options.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.StripSegments < 0 {
+		return nil, fmt.Errorf("invalid strip segments %d", options.StripSegments)
+	}
+
+	for from, to := range options.StatusMap {
+		if from < 100 || from > 999 || to < 100 || to > 999 {
+			return nil, fmt.Errorf("invalid status mapping %d -> %d", from, to)
+		}
+	}
+
+	var fallback *FallbackResponse
+	if options.Fallback != nil {
+		fallback, err = options.Fallback.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	route := &proxyRoute{
+		pattern:              pattern,
+		destination:          destination,
+		destinationURL:       *destinationURL,
+		logger:               logger,
+		matchers:             matchers,
+		balancer:             balancer,
+		preservePath:         options.PreservePath,
+		stripSegments:        options.StripSegments,
+		compressRequests:     options.CompressRequests,
+		limiter:              newRouteLimiter(options.MaxInFlight, options.InFlightQueueTimeout),
+		client:               s.client,
+		cors:                 s.cors,
+		statusMap:            options.StatusMap,
+		fallback:             fallback,
+		bodyCapture:          options.BodyCapture,
+		skipLargeBodies:      options.SkipLargeBodies,
+		negotiateCompression: options.NegotiateCompression,
+		requestSchema:        options.RequestSchema,
+		requestSchemaMaxBody: options.RequestSchemaMaxBody,
+		authorization:        options.Authorization,
+		loggingEnabled:       s.loggingDefault,
+		loggingSource:        LoggingSourceDefault,
+	}
+	if route.mirror, err = newRouteMirror(options.MirrorTo, options.MirrorMaxBody, options.LogMirror, options.MirrorCompare, s.ops); err != nil {
+		return nil, err
+	}
+	if route.faults, err = newRouteFaults(options.FaultInjection); err != nil {
+		return nil, err
+	}
+	if route.idempotency, err = newIdempotencyCache(options.Idempotency, s.clock); err != nil {
+		return nil, err
+	}
+	if route.allowedMethods, err = parseAllowedMethods(options.AllowedMethods); err != nil {
+		return nil, err
+	}
+	if options.BodyCapture == BodyCaptureNone {
+		route.logger = &NoOpLogger{}
+	}
+	if options.Logging != nil {
+		route.loggingEnabled = *options.Logging
+		route.loggingSource = LoggingSourceRoute
+	}
+	if route.requestFilter, err = options.RequestFilter.withDefaults(); err != nil {
+		return nil, fmt.Errorf("request filter: %w", err)
+	}
+	if route.responseFilter, err = options.ResponseFilter.withDefaults(); err != nil {
+		return nil, fmt.Errorf("response filter: %w", err)
+	}
+	if route.requestSchemaMaxBody <= 0 {
+		route.requestSchemaMaxBody = DefaultRequestSchemaMaxBody
+	}
+	if route.bodyTags, err = newBodyTags(options.BodyTags, options.BodyTagsMaxBody); err != nil {
+		return nil, err
+	}
+	if options.MaxRequestBytes < 0 {
+		return nil, fmt.Errorf("invalid max request bytes %d", options.MaxRequestBytes)
+	}
+	route.maxRequestBytes = options.MaxRequestBytes
+	route.canonicalHeaders = options.CanonicalHeaders
+	if options.CompressResponses {
+		route.compressResponsesMinSize = options.CompressResponsesMinSize
+		if route.compressResponsesMinSize <= 0 {
+			route.compressResponsesMinSize = DefaultCompressResponsesMinSize
+		}
+	}
+	if options.CORS != nil {
+		route.cors = options.CORS
+	}
+	if options.ClientTLS != nil {
+		route.client, err = withClientTLS(s.client, *options.ClientTLS)
+		if err != nil {
+			return nil, err
+		}
+		setDialer(route.client.Transport.(*http.Transport), s.maxConnLifetime, s.addressOverrides, s.destinationGuard)
+	}
+	if len(options.AddressOverride) > 0 {
+		overrides, err := newAddressOverrides(s.addressOverrides, options.AddressOverride)
+		if err != nil {
+			return nil, err
+		}
+		if route.client, err = withAddressOverrides(route.client, overrides, s.maxConnLifetime, s.destinationGuard); err != nil {
+			return nil, err
+		}
+	}
+	// The health checks start last, so a route that fails to build leaves
+	// nothing running
+	if options.HealthCheck != nil {
+		if balancer == nil {
+			return nil, errors.New("a health check requires backends")
+		}
+		stop, err := balancer.startHealthChecks(*options.HealthCheck, route.client, pattern, s.ops)
+		if err != nil {
+			return nil, err
+		}
+		s.addCloser(stop)
+	}
+	if route.client != s.client {
+		s.addCloser(route.client.CloseIdleConnections)
+	}
+	route.stats = s.stats.route(pattern)
+	return route, nil
+}
+
+func (s *ProxyServer) addCloser(closer func()) {
+	s.closersMu.Lock()
+	defer s.closersMu.Unlock()
+	s.closers = append(s.closers, closer)
+}
+
+// Close stops the health checks of the server's routes and closes the idle
+// upstream connections of the server and its routes. The server keeps
+// serving, with every backend in the state of its last probe, so a server
+// that was replaced can be closed while its last requests finish; the
+// connections those requests return to the pool are closed once they have
+// been idle for the transport's IdleConnTimeout.
+func (s *ProxyServer) Close() error {
+	s.closersMu.Lock()
+	closers := s.closers
+	s.closers = nil
+	s.closersMu.Unlock()
+	for _, closer := range closers {
+		closer()
+	}
+	s.client.CloseIdleConnections()
+	return nil
+}
+
+// skipsResponseBody reports whether only the headers of response are logged
+// because of RouteOptions.SkipLargeBodies.
+func (r *proxyRoute) skipsResponseBody(response *http.Response) bool {
+	if r.skipLargeBodies <= 0 {
+		return false
+	}
+	if response.ContentLength > r.skipLargeBodies {
+		return true
+	}
+	switch ContentTypeBucket(response.Header.Get("Content-Type")) {
+	case "binary", "image", "audio", "video":
+		return true
+	}
+	return false
+}
+
+// stripPathSegments drops the first n segments of path, keeping a trailing
+// slash. Paths with no more than n segments become empty.
+func stripPathSegments(path string, n int) string {
+	if n <= 0 || path == "" {
+		return path
+	}
+	segments := strings.SplitN(strings.TrimPrefix(path, "/"), "/", n+1)
+	if len(segments) <= n {
+		return ""
+	}
+	return segments[n]
+}
+
+func parseDestinationURL(destination string) (*url.URL, error) {
 	destinationURL, err := url.Parse(destination)
 	if err != nil {
-		return fmt.Errorf("failed to parse destination URL %q: %v", destination, err)
+		return nil, fmt.Errorf("failed to parse destination URL %q: %v", destination, err)
 	}
 
 	// Go URLs support relative paths, but passing them to the http.Client after
@@ -80,12 +862,27 @@ func (s *ProxyServer) AddRoute(pattern string, destination string, logger Logger
 	if destinationURL.Path == "" {
 		destinationURL.Path = "/"
 	}
+	return destinationURL, nil
+}
 
-	s.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
-		s.handleRequest(w, r, *destinationURL, logger)
-	})
-
-	return nil
+// validateUpstreamURL checks that a composed destination URL can be sent by
+// the http.Client, so a misconfigured destination fails with a clear error
+// instead of an opaque one from the transport.
+func validateUpstreamURL(destinationURL *url.URL) error {
+	var problem string
+	switch {
+	case !strings.EqualFold(destinationURL.Scheme, "http") && !strings.EqualFold(destinationURL.Scheme, "https"):
+		problem = "the scheme must be http or https"
+	case destinationURL.Opaque != "":
+		problem = "the URL is opaque, a destination needs the form scheme://host/path"
+	case destinationURL.Host == "":
+		problem = "the host is empty"
+	case !strings.HasPrefix(destinationURL.Path, "/"):
+		problem = "the path is relative"
+	default:
+		return nil
+	}
+	return fmt.Errorf("invalid upstream URL %q: %s", destinationURL.String(), problem)
 }
 
 type readCloser struct {
@@ -93,6 +890,77 @@ type readCloser struct {
 	io.Closer
 }
 
+// IncompleteResponseError is the error a response log stream fails with when
+// the backend closed the response body before it was complete. Loggers that
+// check read errors, such as FileLogger, record it as an incomplete stream.
+type IncompleteResponseError struct {
+	Err error
+}
+
+func (e *IncompleteResponseError) Error() string {
+	return fmt.Sprintf("incomplete response: %v", e.Err)
+}
+
+func (e *IncompleteResponseError) Unwrap() error {
+	return e.Err
+}
+
+// IncompleteRequestError is the error a request log stream fails with when
+// reading the client's request body failed, for example because the client
+// disconnected during an upload.
+type IncompleteRequestError struct {
+	Err error
+}
+
+func (e *IncompleteRequestError) Error() string {
+	return fmt.Sprintf("incomplete request: %v", e.Err)
+}
+
+func (e *IncompleteRequestError) Unwrap() error {
+	return e.Err
+}
+
+// sourceErrorReader remembers the first read error other than io.EOF, so a
+// failing source can be told apart from a failing destination after io.Copy.
+type sourceErrorReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *sourceErrorReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// errorReader fails every read with err.
+type errorReader struct {
+	err error
+}
+
+func (r errorReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+// waitForRequestLog blocks a response log until its request log is done when
+// OrderedLogs is set.
+func (s *ProxyServer) waitForRequestLog(requestLogDone <-chan struct{}) {
+	if s.orderedLogs {
+		<-requestLogDone
+	}
+}
+
+// upstreamErrorStatus maps a failed upstream exchange to the status returned
+// to the client.
+func upstreamErrorStatus(err error) int {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
+
 func shouldSkipLoggedRequestHeader(name string) bool {
 	return strings.EqualFold(name, "Host") ||
 		strings.EqualFold(name, "Content-Encoding") ||
@@ -140,8 +1008,17 @@ func decompressReader(r io.Reader, encoding string) (io.ReadCloser, error) {
 		return gr, nil
 
 	case "deflate":
-		// deflate is flate without the zlib wrapper
-		return flate.NewReader(r), nil
+		// deflate is specified as zlib-wrapped flate, but many servers send raw
+		// flate instead. Sniff the zlib header to tell the two apart.
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil && isZlibHeader(header) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create zlib reader: %w", err)
+			}
+			return zr, nil
+		}
+		return flate.NewReader(br), nil
 
 	case "br":
 		// Brotli compression
@@ -157,9 +1034,45 @@ func decompressReader(r io.Reader, encoding string) (io.ReadCloser, error) {
 	}
 }
 
-func (s *ProxyServer) handleRequest(w http.ResponseWriter, request *http.Request, destinationURL url.URL, logger Logger) {
+// isZlibHeader reports whether header starts a zlib stream (RFC 1950): the
+// deflate compression method with a check value that makes the first two bytes
+// a multiple of 31.
+func isZlibHeader(header []byte) bool {
+	return header[0]&0x0f == 8 && header[0]>>4 <= 7 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+}
+
+// setProxyHeaders adds the headers the proxy itself contributes to a response:
+// CORS headers for the route and, when enabled, the request ID and route.
+func (s *ProxyServer) setProxyHeaders(w http.ResponseWriter, route *proxyRoute, origin string, metadata RequestMetadata) {
+	route.cors.setResponseHeaders(w.Header(), origin)
+	if !s.debugHeaders {
+		return
+	}
+	w.Header().Set("X-Proxy-Request-Id", metadata.ID)
+	w.Header().Set("X-Proxy-Route", metadata.Pattern)
+}
+
+func (s *ProxyServer) handleRequest(w http.ResponseWriter, request *http.Request, route *proxyRoute) {
+	// Count the request in Stats once it has been handled
+	tracked := s.stats.track(route.stats, w, request)
+	defer tracked.end()
+	if s.metrics != nil {
+		defer tracked.observeSize(s.metrics, route.pattern)
+	}
+	w = tracked
+
+	// Answer CORS preflights for the route without contacting the backend
+	if route.cors != nil && isPreflight(request) {
+		route.cors.writePreflight(w, request)
+		return
+	}
+	origin := request.Header.Get("Origin")
+	path := restoreOriginalURL(request)
+
 	// Capture request data
-	requestTime := time.Now()
+	requestTime := s.clock.Now()
+	destinationURL, destinationTemplate, backendFailover, backendErr := route.selectDestination(request)
+	logger := route.logger
 
 	// Construct the full source URL (incoming request)
 	scheme := "http"
@@ -168,8 +1081,12 @@ func (s *ProxyServer) handleRequest(w http.ResponseWriter, request *http.Request
 	}
 	sourceURL := fmt.Sprintf("%s://%s%s", scheme, request.Host, request.URL.String())
 
-	// Construct the target URL
-	path := request.PathValue("path")
+	// Construct the target URL. Exact routes have no {path...} wildcard, so
+	// they forward to the destination as configured, plus the query.
+	if route.preservePath {
+		path = request.URL.Path
+	}
+	path = stripPathSegments(path, route.stripSegments)
 	if len(path) > 0 {
 		destinationURL = *destinationURL.JoinPath(path)
 	}
@@ -180,33 +1097,173 @@ func (s *ProxyServer) handleRequest(w http.ResponseWriter, request *http.Request
 	// Capture request Content-Encoding before modifying the request
 	requestContentEncoding := request.Header.Get("Content-Encoding")
 
+	// Apply the upstream deadline (if any) for the whole round-trip, including
+	// streaming the response body, except for streams that have started. The
+	// timeout header is proxy-only.
+	upstreamTimeout := s.upstreamTimeout(request)
+	if s.timeoutHeader != "" {
+		request.Header.Del(s.timeoutHeader)
+	}
+	liftDeadline := func() {}
+	if upstreamTimeout > 0 {
+		ctx, lift, cancel := withUpstreamDeadline(request.Context(), upstreamTimeout)
+		defer cancel()
+		liftDeadline = lift
+		request = request.WithContext(ctx)
+	}
+
+	// Give the policy a chance to reject the request before anything is forwarded
+	allowed, deniedStatus, deniedMessage := route.checkMethod(request.Method)
+	if allowed {
+		allowed, deniedStatus, deniedMessage = route.checkRequestSize(w, request)
+	}
+	if allowed {
+		allowed, deniedStatus, deniedMessage = s.evaluateRequestPolicy(request)
+	}
+	if allowed && backendErr != nil {
+		allowed, deniedStatus, deniedMessage = false, http.StatusServiceUnavailable, backendErr.Error()
+	}
+	if allowed {
+		if reason := s.destinationGuard.check(request.Context(), destinationURL.Hostname()); reason != "" {
+			allowed, deniedStatus, deniedMessage = false, http.StatusForbidden, reason
+		}
+	}
+	invalidBody := false
+	if allowed {
+		if status, reason := route.validateRequestBody(request); status != 0 {
+			allowed, deniedStatus, deniedMessage = false, status, reason
+			invalidBody = status == http.StatusBadRequest
+		}
+	}
+
+	if allowed && route.requestFilter != nil {
+		if status, reason := route.filterRequestBody(request); status != 0 {
+			allowed, deniedStatus, deniedMessage = false, status, reason
+		}
+		requestContentEncoding = request.Header.Get("Content-Encoding")
+	}
+	var authorization string
+	if allowed && route.authorization != nil {
+		value, err := route.authorization()
+		if err != nil {
+			allowed, deniedStatus, deniedMessage = false, http.StatusBadGateway, fmt.Sprintf("failed to get upstream credentials: %v", err)
+		}
+		authorization = value
+	}
+
+	// Handlers mounted on a caller's mux log the pattern they were mounted with
+	pattern := route.pattern
+	if pattern == "" {
+		pattern = request.Pattern
+	}
+
 	// Create request metadata
 	metadata := RequestMetadata{
-		ID:                     uuid.New().String(),
-		Pattern:                request.Pattern,
+		ID:                     newRequestID(s.idGenerator),
+		Pattern:                pattern,
+		DestinationTemplate:    destinationTemplate,
 		Method:                 request.Method,
 		SourceURL:              sourceURL,
 		DestinationURL:         destinationURL.String(),
 		RequestStartedAt:       requestTime,
 		RequestContentEncoding: requestContentEncoding,
+		UpstreamTimeoutMS:      upstreamTimeout.Milliseconds(),
+		LoggingEnabled:         route.loggingEnabled,
+		LoggingSource:          route.loggingSource,
+		BackendFailover:        backendFailover,
 	}
-
-	// Split request body stream for logging
-	requestLogReader, requestLogWriter := io.Pipe()
-	requestBody := readCloser{
-		Reader: io.TeeReader(request.Body, requestLogWriter),
-		Closer: request.Body,
+	metadata.EventStream, metadata.LastEventID = eventStreamSubscription(request)
+	recordClientConnection(&metadata, request)
+	if allowed {
+		metadata.Tags = route.bodyTags.extract(request)
+	}
+	metadata.UnsupportedFeatures = stripUnsupportedFeatures(request)
+	for _, note := range metadata.UnsupportedFeatures {
+		s.ops.Infof("[unsupported] %s: %s is not supported, forwarding without it", shortMetadataID(metadata), note)
+	}
+	if s.subjectHeader != "" {
+		metadata.Subject = request.Header.Get(s.subjectHeader)
+	}
+	// A repeated idempotency key is answered from the cache, so it gets no
+	// injected faults
+	var faults injectedFaults
+	var duplicate *idempotentResponse
+	var duplicateInFlight bool
+	idempotencyKey := route.idempotency.key(request, metadata.Subject)
+	if allowed {
+		duplicate, duplicateInFlight = route.idempotency.begin(idempotencyKey, metadata.ID)
+		defer route.idempotency.release(idempotencyKey, metadata.ID)
+		if duplicate != nil {
+			metadata.Deduplicated = true
+			metadata.DeduplicatedFrom = duplicate.requestID
+		} else if !duplicateInFlight {
+			faults = route.faults.decide()
+			metadata.InjectedFaults = faults.names()
+		}
+	}
+	if !allowed {
+		metadata.Blocked = true
+		metadata.ResponseStatusCode = deniedStatus
+		metadata.BlockedReason = deniedMessage
+		metadata.InvalidBody = invalidBody
+		s.ops.Debugf("[blocked] %s: %s (%s)", shortMetadataID(metadata), formatConsoleRequest(metadata), deniedMessage)
+	} else {
+		s.ops.Debugf("[forward] %s: %s", shortMetadataID(metadata), formatConsoleRequest(metadata))
 	}
-	defer requestBody.Close()
 
 	// Modify the existing request to become the proxy request
 	request.URL = &destinationURL
-	request.Body = requestBody
 	request.Host = destinationURL.Host
 	request.RequestURI = "" // Must be empty in a client request
 
+	// The mirror gets the client's own credentials, never the route's
+	clientAuthorization := request.Header.Values("Authorization")
+
+	// Headers are changed here, before the request log reads them
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+	if s.traceContext {
+		metadata.TraceID, metadata.SpanID = propagateTraceContext(request.Header)
+	}
+
+	// Decide before compression hides the length of the body
+	mirror := route.mirror.mirrors(request)
+
+	// The compressed length is unknown, so compressed bodies are sent chunked.
+	compressRequest := route.compressRequests && shouldCompressRequest(request)
+	if compressRequest {
+		request.Header.Set("Content-Encoding", "gzip")
+		request.Header.Del("Content-Length")
+		request.ContentLength = -1
+	}
+
+	// The client's own Accept-Encoding, for CompressResponses
+	clientAcceptEncoding := request.Header.Get("Accept-Encoding")
+
+	// Save bandwidth to the backend for clients that do not ask for
+	// compression themselves; the response is decoded for them below
+	negotiateCompression := route.negotiateCompression && request.Header.Get("Accept-Encoding") == ""
+	if negotiateCompression {
+		request.Header.Set("Accept-Encoding", negotiatedAcceptEncoding)
+	}
+
+	if route.canonicalHeaders {
+		canonicalizeHeader(request.Header)
+	}
+
+	// Split request body stream for logging
+	requestLogReader, requestLogWriter := io.Pipe()
+
+	// Closed once LogRequest has returned, for OrderedLogs
+	requestLogDone := make(chan struct{})
+
 	// Async request logging with header reconstruction (log the outgoing proxy request)
-	go func() {
+	// The handler keeps filling in metadata once the response arrives, which
+	// can happen before a slow or abandoned request log has read it
+	requestMetadata := metadata
+	requestLogged := s.logWorkers.Go(s.logWatchdog.wrap(requestLogReader, func() {
+		defer close(requestLogDone)
 		defer requestLogReader.Close()
 
 		// Reconstruct proxy request headers
@@ -216,53 +1273,277 @@ func (s *ProxyServer) handleRequest(w http.ResponseWriter, request *http.Request
 		fmt.Fprintf(&headerBuf, "%s %s %s\r\n", request.Method, destinationURL.String(), request.Proto)
 
 		// Write remaining headers, excluding hop-by-hop proxy auth and decompressed encoding headers.
+		headers := s.logHeaderLimits.block(&headerBuf)
 		for name, values := range request.Header {
-			if shouldSkipLoggedRequestHeader(name) {
+			if shouldSkipLoggedRequestHeader(name) || !s.logHeaders.allows(name) {
 				continue
 			}
+			if authorization != "" && name == "Authorization" {
+				values = []string{redactedHeaderValue}
+			}
 			for _, value := range values {
-				fmt.Fprintf(&headerBuf, "%s: %s\r\n", name, value)
+				headers.write(name, value)
 			}
 		}
+		headers.close()
+		writeTransferEncodingMarker(&headerBuf, request.TransferEncoding)
 
-		// Write separator between headers and body
-		headerBuf.WriteString("\r\n")
-
-		// Decompress the request body if needed
+		// Decompress the request body if needed, before the separator so that
+		// a decompression error can still be recorded as a header
 		var bodyReader io.Reader = requestLogReader
-		if requestContentEncoding != "" {
-			decompressed, err := decompressReader(requestLogReader, requestContentEncoding)
+		if requestContentEncoding != "" && route.bodyCapture.logsBody() {
+			decompressed, err := decompressForLogging(requestLogReader, requestContentEncoding)
 			if err != nil {
 				// If decompression fails, log the compressed data as-is
 				fmt.Fprintf(&headerBuf, "X-Decompression-Error: %v\r\n", err)
-			} else {
-				defer decompressed.Close()
-				bodyReader = decompressed
 			}
+			defer decompressed.Close()
+			bodyReader = decompressed
 		}
+		bodyReader = route.bodyCapture.captureBody(bodyReader)
+
+		// Write separator between headers and body
+		headerBuf.WriteString("\r\n")
 
 		// Combine headers + body
-		logger.LogRequest(metadata, requestTime, &readCloser{
+		logger.LogRequest(requestMetadata, requestTime, &readCloser{
 			Reader: io.MultiReader(&headerBuf, bodyReader),
 			Closer: io.NopCloser(nil), // The pipe closer is already deferred
 		})
-	}()
+	}))
+
+	// Only tee the request body if a logging goroutine is reading the pipe.
+	// The tee is the flush point for streaming uploads: each chunk reaches the
+	// logger before the transport sends it, long before the response arrives.
+	// Logging is best-effort: if the logger stops reading early, the request
+	// is still forwarded in full. The body is only read as the transport sends
+	// it, so an "Expect: 100-continue" header is forwarded and the client is
+	// only told to continue once the backend has agreed to receive the body.
+	requestBody := &teeReadCloser{
+		source:          request.Body,
+		writer:          requestLogWriter,
+		loggingDisabled: !requestLogged,
+		sourceError: func(err error) error {
+			return &IncompleteRequestError{Err: err}
+		},
+	}
+	if !requestLogged {
+		requestLogReader.Close()
+		close(requestLogDone)
+	}
+	defer requestBody.Close()
+	request.Body = requestBody
+
+	if !allowed {
+		// The blocked request is logged without its body, which is never read,
+		// except for an invalid body that was already buffered for validation
+		if invalidBody {
+			io.Copy(io.Discard, io.LimitReader(requestBody, route.requestSchemaMaxBody+1))
+		}
+		requestLogWriter.Close()
+		s.setProxyHeaders(w, route, origin, metadata)
+		if deniedStatus == http.StatusMethodNotAllowed && route.allowedMethods != nil {
+			w.Header().Set("Allow", strings.Join(route.allowedMethods, ", "))
+		}
+		http.Error(w, fmt.Sprintf("[%s] %s", metadata.ID, deniedMessage), deniedStatus)
+		return
+	}
+	if duplicate != nil || duplicateInFlight {
+		// The repeated request is not forwarded; its body is only read for the
+		// log and to check that it repeats the original
+		sameBody := duplicate != nil && duplicate.matches(requestBody)
+		io.Copy(io.Discard, requestBody)
+		requestLogWriter.Close()
+		if sameBody {
+			s.serveDeduplicated(w, route, origin, request.Method, clientAcceptEncoding, metadata, logger, requestLogDone, duplicate)
+			return
+		}
+		s.setProxyHeaders(w, route, origin, metadata)
+		if duplicate != nil {
+			http.Error(w, fmt.Sprintf("[%s] the idempotency key was already used with a different request body", metadata.ID), http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, fmt.Sprintf("[%s] a request with the same idempotency key is in progress", metadata.ID), http.StatusConflict)
+		return
+	}
+	var idempotentRequest *idempotentRequestBody
+	if idempotencyKey != "" {
+		idempotentRequest = newIdempotentRequestBody(request.Body, request.ContentLength == 0)
+		request.Body = idempotentRequest
+	}
+	// A destination that cannot form a valid URL is a configuration error
+	if err := validateUpstreamURL(&destinationURL); err != nil {
+		requestLogWriter.Close()
+		message := fmt.Sprintf("[%s] %v", metadata.ID, err)
+		s.ops.Infof("[upstream] %s: %v", shortMetadataID(metadata), err)
+		s.setProxyHeaders(w, route, origin, metadata)
+		http.Error(w, message, http.StatusInternalServerError)
+		s.logUpstreamFailure(metadata, logger, requestLogDone, http.StatusInternalServerError, message+"\n", err)
+		return
+	}
+
+	// Read small bodies completely before contacting the backend, so a client
+	// that disconnects during the upload never produces a partial request
+	if s.maxBufferedBody > 0 && request.ContentLength != 0 && request.ContentLength <= s.maxBufferedBody {
+		buffered, err := io.ReadAll(io.LimitReader(requestBody, s.maxBufferedBody+1))
+		if err != nil {
+			// The tee already failed the request log with an IncompleteRequestError
+			s.setProxyHeaders(w, route, origin, metadata)
+			http.Error(w, fmt.Sprintf("[%s] failed to read request body: %v", metadata.ID, err), requestBodyErrorStatus(err))
+			return
+		}
+		// A body of unknown length that exceeds the limit streams the rest
+		request.Body = &readCloser{
+			Reader: io.MultiReader(bytes.NewReader(buffered), requestBody),
+			Closer: requestBody,
+		}
+	}
+	// Buffer the body for the mirror, before it is compressed
+	var mirrored *mirroredRequest
+	if mirror {
+		body, err := io.ReadAll(io.LimitReader(request.Body, route.mirror.maxBody+1))
+		if err != nil {
+			s.setProxyHeaders(w, route, origin, metadata)
+			http.Error(w, fmt.Sprintf("[%s] failed to read request body: %v", metadata.ID, err), requestBodyErrorStatus(err))
+			return
+		}
+		request.Body = &readCloser{Reader: bytes.NewReader(body), Closer: request.Body}
+		mirrorURL := route.mirror.destinationURL
+		if len(path) > 0 {
+			mirrorURL = *mirrorURL.JoinPath(path)
+		}
+		mirrorURL.RawQuery = destinationURL.RawQuery
+		mirrored = &mirroredRequest{
+			method:          request.Method,
+			url:             mirrorURL,
+			header:          request.Header.Clone(),
+			body:            body,
+			contentEncoding: requestContentEncoding,
+		}
+		if compressRequest {
+			mirrored.header.Del("Content-Encoding")
+		}
+		mirrored.header.Del("Authorization")
+		for _, value := range clientAuthorization {
+			mirrored.header.Add("Authorization", value)
+		}
+	}
+	if compressRequest {
+		// The tee sits before the compressor, so the log gets the original body
+		request.Body = newGzipRequestBody(request.Body)
+	}
+
+	// Wait for a free upstream slot if the route limits in-flight requests
+	if !route.limiter.acquire(request.Context()) {
+		requestLogWriter.Close()
+		s.setProxyHeaders(w, route, origin, metadata)
+		http.Error(w, fmt.Sprintf("[%s] too many in-flight requests for %s", metadata.ID, pattern), http.StatusServiceUnavailable)
+		return
+	}
+	defer route.limiter.release()
+
+	// Injected faults stand in for a slow or failing backend. An injected
+	// error is answered without reading the request body.
+	faults.delay(request.Context())
+	if faults.status != 0 {
+		requestLogWriter.Close()
+		message := fmt.Sprintf("[%s] %s", metadata.ID, errInjectedFault)
+		s.setProxyHeaders(w, route, origin, metadata)
+		http.Error(w, message, faults.status)
+		s.logUpstreamFailure(metadata, logger, requestLogDone, faults.status, message+"\n", errInjectedFault)
+		return
+	}
+
+	// Trace whether the upstream connection came from the pool. With redirects
+	// this describes the connection used for the last hop.
+	var gotConn httptrace.GotConnInfo
+	tracedContext := s.destinationGuard.guard(request.Context(), destinationURL.Hostname())
+	tracedRequest := request.WithContext(httptrace.WithClientTrace(tracedContext, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			gotConn = info
+		},
+	}))
+
+	// The primary response is captured for comparison as the client reads it
+	var primaryCapture *responseCapture
+	if mirrored != nil {
+		primaryCapture = route.mirror.compare.newCapture()
+		defer primaryCapture.finish()
+		s.sendMirror(route, *mirrored, primaryCapture, metadata, logger)
+	}
+
+	// A connection past MaxConnLifetime refuses the request before sending
+	// it, and the transport can only send it again with a rewindable body
+	release := func() {}
+	if s.maxConnLifetime > 0 {
+		tracedRequest, release = withRewindableBody(tracedRequest)
+	}
 
 	// Execute the proxy request synchronously
-	response, err := s.client.Do(request)
+	response, err := route.client.Do(tracedRequest)
+	release()
 
-	// Close the request writer now that request body has been consumed
+	// Close the request writer now that request body has been consumed. This
+	// is a no-op if the tee already failed it because the client body failed.
 	requestLogWriter.Close()
 
 	if err != nil {
-		// TODO: add a test case for this
-		http.Error(w, fmt.Sprintf("[%s] proxy request failed: %v", metadata.ID, err), http.StatusBadGateway)
+		primaryCapture.failed(err)
+		// An upload over MaxRequestBytes is the client's fault, not the backend's
+		if requestBodyErrorStatus(err) == http.StatusRequestEntityTooLarge {
+			reason := fmt.Sprintf("request body exceeds the limit of %d bytes", route.maxRequestBytes)
+			message := fmt.Sprintf("[%s] %s", metadata.ID, reason)
+			metadata.Blocked = true
+			metadata.BlockedReason = reason
+			s.setProxyHeaders(w, route, origin, metadata)
+			http.Error(w, message, http.StatusRequestEntityTooLarge)
+			s.logUpstreamFailure(metadata, logger, requestLogDone, http.StatusRequestEntityTooLarge, message+"\n", err)
+			return
+		}
+		// A redirect or a DNS answer that leads to an internal address
+		var blocked *blockedDestinationError
+		if errors.As(err, &blocked) {
+			message := fmt.Sprintf("[%s] %s", metadata.ID, blocked.reason)
+			metadata.Blocked = true
+			metadata.BlockedReason = blocked.reason
+			s.setProxyHeaders(w, route, origin, metadata)
+			http.Error(w, message, http.StatusForbidden)
+			s.logUpstreamFailure(metadata, logger, requestLogDone, http.StatusForbidden, message+"\n", err)
+			return
+		}
+		if request.Context().Err() == nil {
+			route.balancer.failed(destinationTemplate)
+		}
+		if route.fallback != nil {
+			s.serveFallback(w, route, origin, metadata, logger, requestLogDone, err)
+			return
+		}
+		s.ops.Infof("[upstream] %s: proxy request failed: %v", shortMetadataID(metadata), err)
+		statusCode := upstreamErrorStatus(err)
+		message := fmt.Sprintf("[%s] proxy request failed: %v", metadata.ID, err)
+		s.setProxyHeaders(w, route, origin, metadata)
+		http.Error(w, message, statusCode)
+		s.logUpstreamFailure(metadata, logger, requestLogDone, statusCode, message+"\n", err)
 		return
 	}
 	defer response.Body.Close()
+	primaryCapture.capture(response)
+	if isStreamingContentType(response.Header.Get("Content-Type")) {
+		liftDeadline()
+	}
 
 	// Capture response timestamp and Content-Encoding
-	responseTime := time.Now()
+	responseTime := s.clock.Now()
+	// Filter the body before anything reads it, so the client and the log
+	// both get the output
+	if err := route.filterResponseBody(request.Context(), request.Method, response); err != nil {
+		primaryCapture.failed(err)
+		message := fmt.Sprintf("[%s] %v", metadata.ID, err)
+		s.setProxyHeaders(w, route, origin, metadata)
+		http.Error(w, message, http.StatusBadGateway)
+		s.logUpstreamFailure(metadata, logger, requestLogDone, http.StatusBadGateway, message+"\n", err)
+		return
+	}
 	responseContentEncoding := response.Header.Get("Content-Encoding")
 
 	// Update metadata with response encoding
@@ -272,65 +1553,278 @@ func (s *ProxyServer) handleRequest(w http.ResponseWriter, request *http.Request
 	metadata.ResponseStatus = response.Status
 	metadata.ResponseStatusCode = response.StatusCode
 	metadata.ResponseContentEncoding = responseContentEncoding
-
-	// Send response headers as quickly as possible
-	for key, values := range response.Header {
-		for _, value := range values {
-			w.Header().Add(key, value)
-		}
+	metadata.ConnReused = gotConn.Reused
+	metadata.ConnIdleTimeMS = gotConn.IdleTime.Milliseconds()
+	metadata.Delivery = &ResponseDelivery{}
+	if response.Request != nil && response.Request.URL != nil && response.Request.URL.String() != metadata.DestinationURL {
+		metadata.FinalURL = response.Request.URL.String()
+	}
+	clientStatusCode := response.StatusCode
+	if mappedStatusCode, ok := route.statusMap[response.StatusCode]; ok {
+		clientStatusCode = mappedStatusCode
+		metadata.ClientStatusCode = mappedStatusCode
 	}
-	w.WriteHeader(response.StatusCode)
 
 	// Split response stream for logging
 	responseLogReader, responseLogWriter := io.Pipe()
-	responseBody := io.TeeReader(response.Body, responseLogWriter)
-	defer response.Body.Close()
 
-	// Async response logging with header reconstruction
-	go func() {
+	// Async response logging with header reconstruction. A skipped body is
+	// not tee'd to the log: it is counted on the client path instead, and its
+	// log is written once the response is done, so that neither the logger
+	// nor the log timeout is tied to the download.
+	bodyCapture := route.bodyCapture
+	responseSource := response.Body
+	var skippedBody *countingReadCloser
+	if route.skipsResponseBody(response) {
+		bodyCapture = BodyCaptureHeadersOnly
+		skippedBody = &countingReadCloser{ReadCloser: response.Body}
+		responseSource = skippedBody
+	}
+	// With a fallback, a body that fails before the response is committed is
+	// answered with the fallback, which logs the response itself. Until that
+	// is ruled out the log is not started, and what the client path reads is
+	// held for it instead.
+	var heldBody *heldReadCloser
+	if route.fallback != nil && skippedBody == nil {
+		heldBody = &heldReadCloser{ReadCloser: responseSource}
+		responseSource = heldBody
+	}
+	var responseLogSource io.Reader = responseLogReader
+	logResponse := func() {
 		defer responseLogReader.Close()
+		s.waitForRequestLog(requestLogDone)
 
 		// Reconstruct response headers
 		var headerBuf bytes.Buffer
 
-		// Write response status line
-		fmt.Fprintf(&headerBuf, "%s %s\r\n", response.Proto, response.Status)
+		// Write response status line, as received from upstream
+		fmt.Fprintf(&headerBuf, "%s %s\r\n", response.Proto, s.logHeaderLimits.truncate(response.Status))
+		if metadata.ClientStatusCode != 0 {
+			fmt.Fprintf(&headerBuf, "X-Proxy-Status-Override: %d\r\n", metadata.ClientStatusCode)
+		}
 
 		// Write response headers (skip Content-Encoding as we're logging decompressed)
+		headers := s.logHeaderLimits.block(&headerBuf)
 		for name, values := range response.Header {
-			if strings.EqualFold(name, "Content-Encoding") {
+			if strings.EqualFold(name, "Content-Encoding") || !s.logHeaders.allows(name) {
 				continue
 			}
+			if route.canonicalHeaders {
+				name = canonicalHeaderName(name)
+			}
 			for _, value := range values {
-				fmt.Fprintf(&headerBuf, "%s: %s\r\n", name, value)
+				headers.write(name, value)
 			}
 		}
+		headers.close()
+		writeTransferEncodingMarker(&headerBuf, response.TransferEncoding)
 
-		// Write separator between headers and body
-		headerBuf.WriteString("\r\n")
-
-		// Decompress the response body if needed
-		var bodyReader io.Reader = responseLogReader
-		if responseContentEncoding != "" {
-			decompressed, err := decompressReader(responseLogReader, responseContentEncoding)
+		// Decompress the response body if needed, before the separator so that
+		// a decompression error can still be recorded as a header
+		bodyReader := responseLogSource
+		if responseContentEncoding != "" && bodyCapture.logsBody() {
+			decompressed, err := decompressForLogging(responseLogSource, responseContentEncoding)
 			if err != nil {
 				// If decompression fails, log the compressed data as-is
 				fmt.Fprintf(&headerBuf, "X-Decompression-Error: %v\r\n", err)
-			} else {
-				defer decompressed.Close()
-				bodyReader = decompressed
 			}
+			defer decompressed.Close()
+			bodyReader = decompressed
+		}
+		if skippedBody != nil {
+			bodyReader = omitBody(responseLogReader, &skippedBody.n)
+		} else {
+			bodyReader = bodyCapture.captureBody(bodyReader)
 		}
 
+		// Write separator between headers and body
+		headerBuf.WriteString("\r\n")
+
 		// Combine headers + body
 		logger.LogResponse(metadata, responseTime, &readCloser{
 			Reader: io.MultiReader(&headerBuf, bodyReader),
 			Closer: io.NopCloser(nil), // The pipe closer is already deferred
 		})
-	}()
+	}
+	startResponseLog := func() bool {
+		logged := s.logWorkers.Go(s.logWatchdog.wrap(responseLogReader, logResponse))
+		if !logged {
+			responseLogReader.Close()
+		}
+		return logged
+	}
+	responseLogged := false
+	servedFallback := false
+	switch {
+	case skippedBody != nil:
+		// Every return below closes the log writer first, so the log only
+		// reads how the body ended
+		defer func() {
+			if servedFallback {
+				responseLogReader.Close()
+				return
+			}
+			startResponseLog()
+		}()
+	case heldBody == nil:
+		responseLogged = startResponseLog()
+	}
+
+	// Only tee the response body if a logging goroutine is reading the pipe.
+	// A logger that stops reading early (for example on a corrupt compressed
+	// stream) must not cut off the client.
+	responseBody := &teeReadCloser{
+		source:          responseSource,
+		writer:          responseLogWriter,
+		loggingDisabled: !responseLogged,
+		logged:          &metadata.Delivery.logged,
+	}
+
+	// releaseResponseLog starts a held response log, which first reads what
+	// was held for it
+	releaseResponseLog := func() {
+		if heldBody == nil || heldBody.released {
+			return
+		}
+		responseLogSource = io.MultiReader(heldBody.release(), responseLogReader)
+		responseLogged = startResponseLog()
+		responseBody.loggingDisabled = !responseLogged
+	}
+
+	// An injected reset drops the client connection, but the backend's
+	// response is still read for the log
+	if faults.reset {
+		releaseResponseLog()
+		io.Copy(io.Discard, responseBody)
+		responseLogWriter.Close()
+		panic(http.ErrAbortHandler)
+	}
 
-	// Stream the response body (no error checking, because we already wrote the response)
-	io.Copy(w, responseBody)
+	// Decode the body for a client whose compression the proxy negotiated. The
+	// log still reads the upstream body and decompresses its own copy.
+	var clientBody io.Reader = responseBody
+	decodeForClient := negotiateCompression && responseContentEncoding != ""
+	if decodeForClient {
+		clientBody, decodeForClient = decodeResponseForClient(response, responseBody)
+		if !decodeForClient {
+			s.ops.Infof("[upstream] %s: cannot decode %s response, forwarding it encoded", shortMetadataID(metadata), responseContentEncoding)
+		}
+	}
+
+	// Read the first chunk of the body before committing the status code, so
+	// a backend that fails before sending any body bytes becomes a 502 instead
+	// of an empty response with the upstream status
+	bodyStart := make([]byte, 32*1024)
+	bodyStartSize, bodyErr := clientBody.Read(bodyStart)
+	bodyStart = bodyStart[:bodyStartSize]
+
+	// Small responses are read completely, so they get an exact Content-Length
+	// and a backend failure midway is still a 502
+	bufferBody := (bodyErr == nil || bodyErr == io.EOF) && s.shouldBufferResponse(request.Method, response)
+	if bufferBody && bodyErr == nil {
+		bodyStart, bodyErr = bufferResponseBody(clientBody, bodyStart, s.streamThreshold)
+	}
+	if (len(bodyStart) == 0 || bufferBody) && bodyErr != nil && bodyErr != io.EOF {
+		if route.fallback != nil {
+			servedFallback = true
+			if heldBody != nil {
+				responseLogReader.Close()
+			}
+			responseLogWriter.Close()
+			s.serveFallback(w, route, origin, metadata, logger, requestLogDone, fmt.Errorf("upstream response failed: %w", bodyErr))
+			return
+		}
+		responseLogWriter.CloseWithError(&IncompleteResponseError{Err: bodyErr})
+		s.setProxyHeaders(w, route, origin, metadata)
+		http.Error(w, fmt.Sprintf("[%s] upstream response failed: %v", metadata.ID, bodyErr), upstreamErrorStatus(bodyErr))
+		return
+	}
+
+	releaseResponseLog()
+
+	// Compress for a client that accepts it when the backend did not
+	compressEncoding := ""
+	if route.compressResponsesMinSize > 0 && !decodeForClient &&
+		shouldCompressResponse(request.Method, response, bodyStart, bodyErr == io.EOF, route.compressResponsesMinSize) {
+		compressEncoding = clientResponseEncoding(clientAcceptEncoding)
+	}
+
+	// Send response headers
+	for key, values := range response.Header {
+		if decodeForClient && (key == "Content-Encoding" || key == "Content-Length") {
+			continue
+		}
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	// The response is stored for its idempotency key before the proxy
+	// compresses it, so that each repeat is encoded for its own client
+	var storedHeader http.Header
+	var storedBody *idempotentBody
+	if idempotencyKey != "" {
+		storedHeader = w.Header().Clone()
+		storedBody = route.idempotency.newBody()
+	}
+	if compressEncoding != "" {
+		compressResponseHeader(w.Header(), compressEncoding)
+	} else if bufferBody && bodyErr == io.EOF {
+		w.Header().Set("Content-Length", strconv.Itoa(len(bodyStart)))
+	}
+	if route.canonicalHeaders {
+		canonicalizeHeader(w.Header())
+	}
+	s.setProxyHeaders(w, route, origin, metadata)
+	w.WriteHeader(clientStatusCode)
+
+	// Stream the response body. Client write errors are not checked, because
+	// the response is already committed, except that a response the client
+	// did not receive completely is not stored.
+	var client io.Writer = w
+	if s.streamThreshold > 0 {
+		client = &flushWriter{w: w, controller: http.NewResponseController(w)}
+	}
+	client = &deliveryWriter{w: client, count: &metadata.Delivery.toClient}
+	var compressor responseCompressor
+	if compressEncoding != "" {
+		compressor = newResponseCompressor(client, compressEncoding)
+		client = compressor
+	}
+	if storedBody != nil {
+		client = io.MultiWriter(storedBody, client)
+	}
+	_, clientErr := client.Write(bodyStart)
+	if bodyErr == nil {
+		upstreamBody := &sourceErrorReader{reader: clientBody}
+		if _, err := io.Copy(client, upstreamBody); err != nil && clientErr == nil {
+			clientErr = err
+		}
+		bodyErr = upstreamBody.err
+	}
+	if compressor != nil {
+		// A truncated body is not terminated like a complete one
+		finish := compressor.Close
+		if bodyErr != nil && bodyErr != io.EOF {
+			finish = compressor.Flush
+		}
+		if err := finish(); err != nil && clientErr == nil {
+			clientErr = err
+		}
+	}
+
+	// A backend that failed mid-body is recorded in the log, and the client
+	// response is aborted so the client sees a truncated response rather than
+	// a complete one.
+	if bodyErr != nil && bodyErr != io.EOF {
+		responseLogWriter.CloseWithError(&IncompleteResponseError{Err: bodyErr})
+		// Deliver the bytes received so far before dropping the connection
+		http.NewResponseController(w).Flush()
+		panic(http.ErrAbortHandler)
+	}
+
+	if clientErr == nil {
+		route.idempotency.complete(idempotencyKey, metadata.ID, clientStatusCode, storedHeader, storedBody, idempotentRequest)
+	}
 
 	// Close the response writer now that response body has been consumed
 	responseLogWriter.Close()
@@ -2,15 +2,26 @@ package loggingproxy
 
 import (
 	"bytes"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // Helper function to create test servers with routes
@@ -1003,6 +1014,180 @@ func TestGzipRequestLogging(t *testing.T) {
 	t.Logf("Successfully logged gzip-compressed request with decompressed body")
 }
 
+func TestResponseEncodingPassesThroughUnchanged(t *testing.T) {
+	responseBody := `{"result": "compressed regardless of Accept-Encoding"}`
+	var compressedBuf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressedBuf)
+	gzipWriter.Write([]byte(responseBody))
+	gzipWriter.Close()
+
+	acceptEncoding := make(chan []string, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding <- r.Header.Values("Accept-Encoding")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(compressedBuf.Bytes())
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	// Send no Accept-Encoding and do not decompress, to see the raw response
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Get(testServer.URL + "/api/test")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	defer resp.Body.Close()
+	clientBody, _ := io.ReadAll(resp.Body)
+
+	if values := <-acceptEncoding; len(values) != 0 {
+		t.Errorf("Expected the proxy not to add Accept-Encoding upstream, got %v", values)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected the client to receive Content-Encoding gzip, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	if !bytes.Equal(clientBody, compressedBuf.Bytes()) {
+		t.Errorf("Expected the client to receive the backend's gzip bytes unchanged")
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	responseLog := testLogger.responses[0]
+	if responseLog.metadata.ResponseContentEncoding != "gzip" {
+		t.Errorf("Expected response_content_encoding to be 'gzip', got %q", responseLog.metadata.ResponseContentEncoding)
+	}
+	if !strings.HasSuffix(responseLog.content, responseBody) {
+		t.Errorf("Expected the logged response to be decompressed, got:\n%s", responseLog.content)
+	}
+}
+
+// newNegotiatingBackend gzips its response only for requests that accept
+// gzip, and reports the Accept-Encoding it received.
+func newNegotiatingBackend(t *testing.T, responseBody string, acceptEncoding chan<- string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding <- r.Header.Get("Accept-Encoding")
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write([]byte(responseBody))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gzipWriter := gzip.NewWriter(w)
+		gzipWriter.Write([]byte(responseBody))
+		gzipWriter.Close()
+	}))
+}
+
+func TestNegotiateCompressionDecodesForClientWithoutAcceptEncoding(t *testing.T) {
+	responseBody := `{"result": "negotiated"}`
+	acceptEncoding := make(chan string, 1)
+	backend := newNegotiatingBackend(t, responseBody, acceptEncoding)
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{NegotiateCompression: true}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Get(testServer.URL + "/api/test")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	defer resp.Body.Close()
+	clientBody, _ := io.ReadAll(resp.Body)
+
+	if value := <-acceptEncoding; value != "gzip, br" {
+		t.Errorf("Expected the proxy to request gzip, br upstream, got %q", value)
+	}
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("Expected no Content-Encoding for the client, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	if string(clientBody) != responseBody {
+		t.Errorf("Expected the client to receive plaintext %q, got %q", responseBody, clientBody)
+	}
+	if resp.ContentLength != -1 && resp.ContentLength != int64(len(responseBody)) {
+		t.Errorf("Expected no Content-Length or the decoded length, got %d", resp.ContentLength)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 request and 1 response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	if !strings.Contains(testLogger.requests[0].content, "Accept-Encoding: gzip, br\r\n") {
+		t.Errorf("Expected the logged request to show the negotiated Accept-Encoding, got:\n%s", testLogger.requests[0].content)
+	}
+	responseLog := testLogger.responses[0]
+	if responseLog.metadata.ResponseContentEncoding != "gzip" {
+		t.Errorf("Expected response_content_encoding to be 'gzip', got %q", responseLog.metadata.ResponseContentEncoding)
+	}
+	if !strings.HasSuffix(responseLog.content, "\r\n\r\n"+responseBody) {
+		t.Errorf("Expected the logged response to be decompressed, got:\n%s", responseLog.content)
+	}
+}
+
+func TestNegotiateCompressionPassesThroughForAcceptingClient(t *testing.T) {
+	responseBody := `{"result": "client negotiated"}`
+	acceptEncoding := make(chan string, 1)
+	backend := newNegotiatingBackend(t, responseBody, acceptEncoding)
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{NegotiateCompression: true}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/test", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if value := <-acceptEncoding; value != "gzip" {
+		t.Errorf("Expected the client's Accept-Encoding upstream, got %q", value)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected the client to receive Content-Encoding gzip, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	gzipReader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatalf("Expected a gzip body: %v", err)
+	}
+	if decoded, _ := io.ReadAll(gzipReader); string(decoded) != responseBody {
+		t.Errorf("Expected the compressed body to decode to %q, got %q", responseBody, decoded)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	if !strings.HasSuffix(testLogger.responses[0].content, responseBody) {
+		t.Errorf("Expected the logged response to be decompressed, got:\n%s", testLogger.responses[0].content)
+	}
+}
+
 func TestGzipResponseLogging(t *testing.T) {
 	// Create mock backend that returns gzip-compressed response
 	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -1192,3 +1377,2068 @@ func TestCompressionPassthrough(t *testing.T) {
 	t.Logf("Backend received compressed data: %v", backendReceivedCompressed)
 	t.Logf("Logs contain decompressed data")
 }
+
+func TestMetadataRecordsCleanRoutePattern(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/v1/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/api/models")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if len(testLogger.requests) != 1 {
+		t.Fatalf("Expected 1 request log, got %d", len(testLogger.requests))
+	}
+	metadata := testLogger.requests[0].metadata
+	if metadata.Pattern != "/api/" {
+		t.Errorf("Expected pattern %q, got %q", "/api/", metadata.Pattern)
+	}
+	if metadata.DestinationTemplate != backend.URL+"/v1/" {
+		t.Errorf("Expected destination template %q, got %q", backend.URL+"/v1/", metadata.DestinationTemplate)
+	}
+	if metadata.DestinationURL != backend.URL+"/v1/models" {
+		t.Errorf("Expected destination URL %q, got %q", backend.URL+"/v1/models", metadata.DestinationURL)
+	}
+}
+
+func newRedirectBackend() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			http.Redirect(w, r, "/middle", http.StatusFound)
+		case "/middle":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		default:
+			fmt.Fprintf(w, "final body from %s", r.URL.Path)
+		}
+	}))
+}
+
+func newNoRedirectClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func TestRedirectsAreForwardedByDefault(t *testing.T) {
+	backend := newRedirectBackend()
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := newNoRedirectClient().Get(testServer.URL + "/api/start")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("Expected status 302 to be forwarded, got %d", resp.StatusCode)
+	}
+	if location := resp.Header.Get("Location"); location != "/middle" {
+		t.Errorf("Expected Location /middle, got %q", location)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	if finalURL := testLogger.responses[0].metadata.FinalURL; finalURL != "" {
+		t.Errorf("Expected no final URL when redirects are not followed, got %q", finalURL)
+	}
+}
+
+func TestRedirectsAreFollowedUpToMaxRedirects(t *testing.T) {
+	backend := newRedirectBackend()
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:  HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		MaxRedirects: 2,
+	})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := newNoRedirectClient().Get(testServer.URL + "/api/start")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 after following redirects, got %d", resp.StatusCode)
+	}
+	if string(body) != "final body from /final" {
+		t.Errorf("Expected final body, got %q", string(body))
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	metadata := testLogger.responses[0].metadata
+	if metadata.DestinationURL != backend.URL+"/start" {
+		t.Errorf("Expected destination URL %q, got %q", backend.URL+"/start", metadata.DestinationURL)
+	}
+	if metadata.FinalURL != backend.URL+"/final" {
+		t.Errorf("Expected final URL %q, got %q", backend.URL+"/final", metadata.FinalURL)
+	}
+}
+
+func TestRedirectsBeyondMaxRedirectsAreForwarded(t *testing.T) {
+	backend := newRedirectBackend()
+	defer backend.Close()
+
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:  HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		MaxRedirects: 1,
+	})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := newNoRedirectClient().Get(testServer.URL + "/api/start")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("Expected the second redirect to be forwarded, got %d", resp.StatusCode)
+	}
+	if location := resp.Header.Get("Location"); location != "/final" {
+		t.Errorf("Expected Location /final, got %q", location)
+	}
+}
+
+func TestRoutePrecedenceIsIndependentOfRegistrationOrder(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	routes := map[string]string{
+		"/":        backend.URL + "/root/",
+		"/api/":    backend.URL + "/api-prefix/",
+		"/api/v1/": backend.URL + "/api-v1-prefix/",
+		"/api":     backend.URL + "/api-exact",
+	}
+	orders := [][]string{
+		{"/", "/api/", "/api/v1/", "/api"},
+		{"/api", "/api/v1/", "/api/", "/"},
+		{"/api/", "/", "/api", "/api/v1/"},
+		{"/api/v1/", "/api", "/", "/api/"},
+	}
+	testCases := []struct {
+		path         string
+		expectedPath string
+	}{
+		{"/", "/root/"},
+		{"/other/thing", "/root/other/thing"},
+		{"/api", "/api-exact"},
+		{"/api/", "/api-prefix/"},
+		{"/api/models", "/api-prefix/models"},
+		{"/api/v2/models", "/api-prefix/v2/models"},
+		{"/api/v1/", "/api-v1-prefix/"},
+		{"/api/v1/chat", "/api-v1-prefix/chat"},
+		{"/apix", "/root/apix"},
+	}
+
+	for _, order := range orders {
+		proxyServer := NewProxyServer("")
+		for _, pattern := range order {
+			if err := proxyServer.AddRoute(pattern, routes[pattern], &NoOpLogger{}); err != nil {
+				t.Fatalf("Failed to add route %s: %v", pattern, err)
+			}
+		}
+		testServer := httptest.NewServer(proxyServer)
+
+		for _, tc := range testCases {
+			resp, err := http.Get(testServer.URL + tc.path)
+			if err != nil {
+				t.Fatalf("Request to %s failed: %v", tc.path, err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != tc.expectedPath {
+				t.Errorf("Order %v: expected %s to reach %s, got %q", order, tc.path, tc.expectedPath, string(body))
+			}
+		}
+		testServer.Close()
+	}
+}
+
+func TestPrefixRouteRootWithoutSlashRedirects(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	proxyServer := createTestServer(map[string]string{
+		"/":        backend.URL + "/root/",
+		"/api/":    backend.URL + "/api-prefix/",
+		"/api/v1/": backend.URL + "/api-v1-prefix/",
+	})
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := newNoRedirectClient().Get(testServer.URL + "/api/v1")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTemporaryRedirect {
+		t.Fatalf("Expected 307 redirect to the /api/v1/ route, got %d", resp.StatusCode)
+	}
+	if location := resp.Header.Get("Location"); location != "/api/v1/" {
+		t.Fatalf("Expected redirect to /api/v1/, got %q", location)
+	}
+}
+
+func TestExactRoutesDoNotMatchSubpaths(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/healthz/", backend.URL+"/health", &NoOpLogger{}, RouteOptions{Exact: true}); err != nil {
+		t.Fatalf("Failed to add exact route: %v", err)
+	}
+	if err := proxyServer.AddRoute("/status/{$}", backend.URL+"/status", &NoOpLogger{}); err != nil {
+		t.Fatalf("Failed to add anchored route: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/api/", &NoOpLogger{}); err != nil {
+		t.Fatalf("Failed to add prefix route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	testCases := []struct {
+		path           string
+		expectedStatus int
+		expectedPath   string
+	}{
+		{"/healthz/", http.StatusOK, "/health"},
+		{"/healthz/deep", http.StatusNotFound, ""},
+		{"/status/", http.StatusOK, "/status"},
+		{"/status/deep", http.StatusNotFound, ""},
+		{"/api/", http.StatusOK, "/api/"},
+		{"/api/v1/models", http.StatusOK, "/api/v1/models"},
+	}
+
+	for _, tc := range testCases {
+		resp, err := http.Get(testServer.URL + tc.path)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", tc.path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.expectedStatus {
+			t.Errorf("Expected %s to return %d, got %d", tc.path, tc.expectedStatus, resp.StatusCode)
+			continue
+		}
+		if tc.expectedStatus == http.StatusOK && string(body) != tc.expectedPath {
+			t.Errorf("Expected %s to reach %s, got %q", tc.path, tc.expectedPath, string(body))
+		}
+	}
+}
+
+func TestExactRouteLogsConfiguredDestination(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/healthz", backend.URL+"/health", testLogger); err != nil {
+		t.Fatalf("Failed to add exact route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for _, query := range []string{"", "?verbose=1"} {
+		testLogger.requests, testLogger.responses = nil, nil
+		status, body := getStatusAndBody(t, testServer.URL+"/healthz"+query)
+		if status != http.StatusOK || body != "/health"+query {
+			t.Fatalf("Expected /healthz%s to reach /health%s, got %d %q", query, query, status, body)
+		}
+
+		// Give async logging a moment to complete
+		time.Sleep(100 * time.Millisecond)
+		if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+			t.Fatalf("Expected 1 request and 1 response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+		}
+		for _, logged := range []capturedLog{testLogger.requests[0], testLogger.responses[0]} {
+			metadata := logged.metadata
+			if metadata.Pattern != "/healthz" {
+				t.Errorf("Expected pattern /healthz, got %q", metadata.Pattern)
+			}
+			if metadata.SourceURL != testServer.URL+"/healthz"+query {
+				t.Errorf("Expected source URL %s/healthz%s, got %q", testServer.URL, query, metadata.SourceURL)
+			}
+			if metadata.DestinationURL != backend.URL+"/health"+query {
+				t.Errorf("Expected destination URL %s/health%s, got %q", backend.URL, query, metadata.DestinationURL)
+			}
+		}
+		if requestLine := "GET " + backend.URL + "/health" + query + " HTTP/1.1\r\n"; !strings.HasPrefix(testLogger.requests[0].content, requestLine) {
+			t.Errorf("Expected the logged request to start with %q, got:\n%s", requestLine, testLogger.requests[0].content)
+		}
+	}
+}
+
+func TestPreservePathForwardsFullRequestPath(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/v1/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatalf("Failed to add stripping route: %v", err)
+	}
+	if err := proxyServer.AddRouteWithOptions("/full/v1/", backend.URL+"/base/", &NoOpLogger{}, RouteOptions{PreservePath: true}); err != nil {
+		t.Fatalf("Failed to add preserving route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	testCases := []struct {
+		path         string
+		expectedPath string
+	}{
+		{"/api/v1/test", "/test"},
+		{"/api/v1/test?q=1", "/test?q=1"},
+		{"/full/v1/test", "/base/full/v1/test"},
+		{"/full/v1/test?q=1", "/base/full/v1/test?q=1"},
+	}
+
+	for _, tc := range testCases {
+		status, body := getStatusAndBody(t, testServer.URL+tc.path)
+		if status != http.StatusOK || body != tc.expectedPath {
+			t.Errorf("Expected %s to reach %s, got %d %q", tc.path, tc.expectedPath, status, body)
+		}
+	}
+}
+
+func TestRouteHandlerOnUserMux(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.RequestURI())
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	handler, err := NewRouteHandler(backend.URL+"/v1/", testLogger)
+	if err != nil {
+		t.Fatalf("Failed to create route handler: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/llm/", http.StripPrefix("/llm", handler))
+	mux.HandleFunc("/local", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "local")
+	})
+	testServer := httptest.NewServer(mux)
+	defer testServer.Close()
+
+	status, body := getStatusAndBody(t, testServer.URL+"/llm/models?limit=1")
+	if status != http.StatusOK || body != "/v1/models?limit=1" {
+		t.Errorf("Expected the mounted handler to proxy to /v1/models?limit=1, got %d %q", status, body)
+	}
+	if status, body := getStatusAndBody(t, testServer.URL+"/local"); status != http.StatusOK || body != "local" {
+		t.Errorf("Expected the user's own handler to keep working, got %d %q", status, body)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 request and 1 response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	metadata := testLogger.requests[0].metadata
+	if metadata.Pattern != "/llm/" || metadata.DestinationURL != backend.URL+"/v1/models?limit=1" {
+		t.Errorf("Expected the mount pattern and upstream URL in the metadata, got %q and %q", metadata.Pattern, metadata.DestinationURL)
+	}
+}
+
+// orderLogger records when each stream is logged, with a slow LogRequest so an
+// unordered response log would start first.
+type orderLogger struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (l *orderLogger) record(event string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, event)
+}
+
+func (l *orderLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	defer rawRequestStream.Close()
+	io.Copy(io.Discard, rawRequestStream)
+	time.Sleep(50 * time.Millisecond)
+	l.record("request done")
+}
+
+func (l *orderLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	defer rawResponseStream.Close()
+	l.record("response started")
+	io.Copy(io.Discard, rawResponseStream)
+}
+
+func TestOrderedLogsFinishRequestBeforeResponse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		fmt.Fprint(w, "data: event\n\n")
+	}))
+	defer backend.Close()
+
+	for _, ordered := range []bool{false, true} {
+		logger := &orderLogger{}
+		proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{OrderedLogs: ordered})
+		if err != nil {
+			t.Fatal("Failed to create proxy server:", err)
+		}
+		if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+			t.Fatal("Failed to add route:", err)
+		}
+		testServer := httptest.NewServer(proxyServer)
+
+		resp, err := http.Post(testServer.URL+"/api/stream", "application/json", strings.NewReader(`{"stream":true}`))
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		testServer.Close()
+
+		// Give async logging a moment to complete
+		time.Sleep(100 * time.Millisecond)
+
+		logger.mu.Lock()
+		events := strings.Join(logger.events, ", ")
+		logger.mu.Unlock()
+		if ordered && events != "request done, response started" {
+			t.Errorf("Expected the request log to finish before the response log starts, got %s", events)
+		}
+		if !ordered && events != "response started, request done" {
+			t.Errorf("Expected unordered logs to overlap, got %s", events)
+		}
+	}
+}
+
+func TestMaxInFlightLimitsConcurrentUpstreamRequests(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		queueTimeout time.Duration
+		secondStatus int
+	}{
+		{"reject", 0, http.StatusServiceUnavailable},
+		{"queue", 5 * time.Second, http.StatusOK},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var inFlight, maxInFlight atomic.Int32
+			started := make(chan struct{}, 2)
+			release := make(chan struct{})
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				current := inFlight.Add(1)
+				defer inFlight.Add(-1)
+				if current > maxInFlight.Load() {
+					maxInFlight.Store(current)
+				}
+				started <- struct{}{}
+				<-release
+				fmt.Fprint(w, "done")
+			}))
+			defer backend.Close()
+
+			proxyServer := NewProxyServer("")
+			options := RouteOptions{MaxInFlight: 1, InFlightQueueTimeout: tc.queueTimeout}
+			if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", &NoOpLogger{}, options); err != nil {
+				t.Fatal("Failed to add route:", err)
+			}
+			testServer := httptest.NewServer(proxyServer)
+			defer testServer.Close()
+
+			firstStatus := make(chan int, 1)
+			go func() {
+				resp, err := http.Get(testServer.URL + "/api/slow")
+				if err != nil {
+					firstStatus <- 0
+					return
+				}
+				resp.Body.Close()
+				firstStatus <- resp.StatusCode
+			}()
+			<-started
+
+			secondStatus := make(chan int, 1)
+			go func() {
+				resp, err := http.Get(testServer.URL + "/api/slow")
+				if err != nil {
+					secondStatus <- 0
+					return
+				}
+				resp.Body.Close()
+				secondStatus <- resp.StatusCode
+			}()
+
+			if tc.queueTimeout == 0 {
+				if status := <-secondStatus; status != tc.secondStatus {
+					t.Errorf("Expected the second request to be rejected with %d, got %d", tc.secondStatus, status)
+				}
+				close(release)
+			} else {
+				// The queued request must not reach the backend while the first is in flight
+				select {
+				case <-started:
+					t.Fatal("Expected the second request to wait for a free slot")
+				case <-time.After(100 * time.Millisecond):
+				}
+				close(release)
+				if status := <-secondStatus; status != tc.secondStatus {
+					t.Errorf("Expected the queued request to succeed, got %d", status)
+				}
+			}
+			if status := <-firstStatus; status != http.StatusOK {
+				t.Errorf("Expected the first request to succeed, got %d", status)
+			}
+			if got := maxInFlight.Load(); got != 1 {
+				t.Errorf("Expected at most 1 concurrent upstream request, got %d", got)
+			}
+		})
+	}
+}
+
+func TestMetadataRecordsUpstreamConnectionReuse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(testServer.URL + "/api/test")
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		// Wait for the response log so the logger is not appended to concurrently
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(testLogger.responses) != 2 {
+		t.Fatalf("Expected 2 response logs, got %d", len(testLogger.responses))
+	}
+	if testLogger.responses[0].metadata.ConnReused {
+		t.Error("Expected the first request to open a new upstream connection")
+	}
+	if !testLogger.responses[1].metadata.ConnReused {
+		t.Error("Expected the second request to reuse the pooled upstream connection")
+	}
+}
+
+func TestDebugHeadersExposeRequestIDAndRoute(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:  HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		DebugHeaders: true,
+	})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/api/test")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	requestID := resp.Header.Get("X-Proxy-Request-Id")
+	if _, err := uuid.Parse(requestID); err != nil {
+		t.Errorf("Expected X-Proxy-Request-Id to be a UUID, got %q", requestID)
+	}
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	if loggedID := testLogger.responses[0].metadata.ID; requestID != loggedID {
+		t.Errorf("Expected X-Proxy-Request-Id %q to match the logged ID %q", requestID, loggedID)
+	}
+	if route := resp.Header.Get("X-Proxy-Route"); route != "/api/" {
+		t.Errorf("Expected X-Proxy-Route /api/, got %q", route)
+	}
+}
+
+func TestDebugHeadersAreOffByDefault(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	proxyServer := createTestServer(map[string]string{"/api/": backend.URL + "/"})
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/api/test")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	resp.Body.Close()
+
+	if resp.Header.Get("X-Proxy-Request-Id") != "" || resp.Header.Get("X-Proxy-Route") != "" {
+		t.Errorf("Expected no debug headers by default, got %v", resp.Header)
+	}
+}
+
+func TestChunkedGzipResponseLogging(t *testing.T) {
+	var expectedBody strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&expectedBody, "data: {\"chunk\": %d, \"text\": \"%s\"}\n\n", i, strings.Repeat("x", i%37))
+	}
+
+	// Backend streams a gzip body in many flushed chunks without Content-Length,
+	// so it is sent with Transfer-Encoding: chunked and chunk boundaries fall
+	// in the middle of deflate blocks
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Encoding", "gzip")
+		gzipWriter := gzip.NewWriter(w)
+		body := expectedBody.String()
+		for offset := 0; offset < len(body); offset += 97 {
+			gzipWriter.Write([]byte(body[offset:min(offset+97, len(body))]))
+			gzipWriter.Flush()
+			w.(http.Flusher).Flush()
+		}
+		gzipWriter.Close()
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	// Ask for gzip explicitly so the client receives the compressed stream as-is
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/stream", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	defer resp.Body.Close()
+
+	if len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("Expected a chunked response, got transfer encoding %v", resp.TransferEncoding)
+	}
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected client to receive the gzip response, got Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	gzipReader, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal("Failed to read gzip response:", err)
+	}
+	clientBody, err := io.ReadAll(gzipReader)
+	if err != nil {
+		t.Fatal("Failed to decompress client response:", err)
+	}
+	if string(clientBody) != expectedBody.String() {
+		t.Errorf("Expected client to receive %d decompressed bytes, got %d", expectedBody.Len(), len(clientBody))
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	_, loggedBody, found := strings.Cut(testLogger.responses[0].content, "\r\n\r\n")
+	if !found {
+		t.Fatalf("Expected logged response to contain a header separator")
+	}
+	if loggedBody != expectedBody.String() {
+		t.Errorf("Expected logged body to be the complete decompressed stream (%d bytes), got %d bytes", expectedBody.Len(), len(loggedBody))
+	}
+	if !strings.Contains(testLogger.responses[0].content, "X-Original-Transfer-Encoding: chunked\r\n") {
+		t.Errorf("Expected chunked response to be marked in the log")
+	}
+}
+
+func TestInvalidGzipResponseStillReachesClient(t *testing.T) {
+	// The body claims to be gzip but is not; decompressing the logged copy fails
+	garbage := bytes.Repeat([]byte("not gzip at all "), 4096)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		for offset := 0; offset < len(garbage); offset += 1024 {
+			w.Write(garbage[offset : offset+1024])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/broken", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	clientBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal("Failed to read response:", err)
+	}
+	if !bytes.Equal(clientBody, garbage) {
+		t.Fatalf("Expected client to receive all %d bytes despite the logging failure, got %d", len(garbage), len(clientBody))
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	headers, loggedBody, _ := strings.Cut(testLogger.responses[0].content, "\r\n\r\n")
+	if !strings.Contains(headers, "X-Decompression-Error:") {
+		t.Errorf("Expected the decompression error in the logged headers, got:\n%s", headers)
+	}
+	if loggedBody != string(garbage) {
+		t.Errorf("Expected the undecodable body to be logged as-is (%d bytes), got %d bytes", len(garbage), len(loggedBody))
+	}
+}
+
+func TestCorruptGzipResponseStillReachesClient(t *testing.T) {
+	// A valid gzip header followed by garbage fails only once the logger is
+	// already decompressing, after which it stops reading the logged copy
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write([]byte("valid start"))
+	gzipWriter.Flush()
+	corrupt := append(compressed.Bytes()[:10:10], bytes.Repeat([]byte{0xff}, 256*1024)...)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		for offset := 0; offset < len(corrupt); offset += 4096 {
+			w.Write(corrupt[offset:min(offset+4096, len(corrupt))])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/logged/", backend.URL+"/", &TestLogger{}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/logged/corrupt", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	clientBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal("Failed to read response:", err)
+	}
+	if !bytes.Equal(clientBody, corrupt) {
+		t.Fatalf("Expected client to receive all %d bytes despite the logging failure, got %d", len(corrupt), len(clientBody))
+	}
+}
+
+func TestStatusMapOverridesClientStatus(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, "created")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	options := RouteOptions{StatusMap: map[int]int{http.StatusCreated: http.StatusOK}}
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, options); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Post(testServer.URL+"/api/items", "text/plain", strings.NewReader("item"))
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if resp.StatusCode != http.StatusOK || string(body) != "created" {
+		t.Fatalf("Expected client to see 200 with the upstream body, got %d %q", resp.StatusCode, string(body))
+	}
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	responseLog := testLogger.responses[0]
+	if responseLog.metadata.ResponseStatusCode != http.StatusCreated || responseLog.metadata.ClientStatusCode != http.StatusOK {
+		t.Errorf("Expected metadata to record 201 upstream and 200 to the client, got %d and %d",
+			responseLog.metadata.ResponseStatusCode, responseLog.metadata.ClientStatusCode)
+	}
+	if !strings.HasPrefix(responseLog.content, "HTTP/1.1 201 Created\r\n") {
+		t.Errorf("Expected the logged status line to keep the upstream status, got:\n%s", responseLog.content)
+	}
+	if !strings.Contains(responseLog.content, "X-Proxy-Status-Override: 200\r\n") {
+		t.Errorf("Expected the logged response to record the override, got:\n%s", responseLog.content)
+	}
+}
+
+func TestStatusMapRejectsInvalidCodes(t *testing.T) {
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", "http://localhost/", &NoOpLogger{}, RouteOptions{StatusMap: map[int]int{429: 0}})
+	if err == nil {
+		t.Fatal("Expected an error for an invalid status mapping")
+	}
+}
+
+func TestFallbackServedWhenBackendIsUnreachable(t *testing.T) {
+	// Close the backend straight away so its address refuses connections
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	options := RouteOptions{Fallback: &FallbackResponse{
+		Header: http.Header{"Content-Type": {"application/json"}},
+		Body:   []byte(`{"error":"offline"}`),
+	}}
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, options); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/api/models")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if resp.StatusCode != http.StatusServiceUnavailable || string(body) != `{"error":"offline"}` {
+		t.Fatalf("Expected the fallback 503 and body, got %d %q", resp.StatusCode, string(body))
+	}
+	if resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("Expected the fallback headers, got %v", resp.Header)
+	}
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+	}
+	responseLog := testLogger.responses[0]
+	if !responseLog.metadata.Fallback || responseLog.metadata.FallbackReason == "" {
+		t.Errorf("Expected the response to be flagged as a fallback, got %+v", responseLog.metadata)
+	}
+	if !strings.HasPrefix(responseLog.content, "HTTP/1.1 503 Service Unavailable\r\nX-Proxy-Fallback: ") ||
+		!strings.HasSuffix(responseLog.content, "\r\n\r\n"+`{"error":"offline"}`) {
+		t.Errorf("Unexpected logged fallback response:\n%s", responseLog.content)
+	}
+}
+
+func TestUpstreamFailureIsLoggedAsResponse(t *testing.T) {
+	// Close the backend straight away so its address refuses connections
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	status, body := getStatusAndBody(t, testServer.URL+"/api/models")
+	if status != http.StatusBadGateway {
+		t.Fatalf("Expected 502, got %d %q", status, body)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 request and 1 response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	responseLog := testLogger.responses[0]
+	if !strings.Contains(responseLog.metadata.UpstreamError, "connection refused") {
+		t.Errorf("Expected the upstream error in the metadata, got %q", responseLog.metadata.UpstreamError)
+	}
+	if responseLog.metadata.ResponseStatusCode != http.StatusBadGateway || responseLog.metadata.ID != testLogger.requests[0].metadata.ID {
+		t.Errorf("Expected a 502 failure record for the logged request, got %+v", responseLog.metadata)
+	}
+	if !strings.HasPrefix(responseLog.content, "HTTP/1.1 502 Bad Gateway\r\nX-Proxy-Error: ") ||
+		!strings.HasSuffix(responseLog.content, "\r\n\r\n"+body) {
+		t.Errorf("Unexpected logged failure response:\n%s", responseLog.content)
+	}
+}
+
+func TestFallbackBodyFile(t *testing.T) {
+	proxyServer := NewProxyServer("")
+	missing := RouteOptions{Fallback: &FallbackResponse{BodyFile: filepath.Join(t.TempDir(), "missing.json")}}
+	if err := proxyServer.AddRouteWithOptions("/missing/", "http://localhost/", &NoOpLogger{}, missing); err == nil {
+		t.Fatal("Expected an error for a missing fallback body file")
+	}
+
+	bodyFile := filepath.Join(t.TempDir(), "offline.txt")
+	if err := os.WriteFile(bodyFile, []byte("down for maintenance"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	backend := httptest.NewServer(http.NotFoundHandler())
+	backend.Close()
+	options := RouteOptions{Fallback: &FallbackResponse{StatusCode: http.StatusOK, BodyFile: bodyFile}}
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", &NoOpLogger{}, options); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	status, body := getStatusAndBody(t, testServer.URL+"/api/")
+	if status != http.StatusOK || body != "down for maintenance" {
+		t.Errorf("Expected the fallback body file to be served, got %d %q", status, body)
+	}
+}
+
+func TestFallbackServedWhenBodyFailsBeforeAnyByte(t *testing.T) {
+	failing := newTruncatingBackend(t, "")
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "healthy body")
+	}))
+	defer healthy.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	fallback := &FallbackResponse{Body: []byte("offline")}
+	for pattern, backend := range map[string]string{"/failing/": failing.URL, "/healthy/": healthy.URL} {
+		if err := proxyServer.AddRouteWithOptions(pattern, backend+"/", testLogger, RouteOptions{Fallback: fallback}); err != nil {
+			t.Fatal("Failed to add route:", err)
+		}
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	status, body := getStatusAndBody(t, testServer.URL+"/failing/")
+	if status != http.StatusServiceUnavailable || body != "offline" {
+		t.Fatalf("Expected the fallback, got %d %q", status, body)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 1 {
+		t.Fatalf("Expected only the fallback response to be logged, got %d response logs", len(testLogger.responses))
+	}
+	if logged := testLogger.responses[0]; !logged.metadata.Fallback || !strings.Contains(logged.metadata.FallbackReason, "upstream response failed") {
+		t.Errorf("Expected the fallback to be logged with its reason, got %+v", logged.metadata)
+	}
+
+	// A response that starts is logged as usual, including what was read
+	// before it was committed
+	status, body = getStatusAndBody(t, testServer.URL+"/healthy/")
+	if status != http.StatusOK || body != "healthy body" {
+		t.Fatalf("Expected the backend response, got %d %q", status, body)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.responses) != 2 || !strings.HasSuffix(testLogger.responses[1].content, "\r\n\r\nhealthy body") {
+		t.Errorf("Expected the backend response to be logged in full, got %d logs", len(testLogger.responses))
+	}
+}
+
+// streamErrorLogger records the response stream of a single exchange together
+// with the error that ended it.
+type streamErrorLogger struct {
+	NoOpLogger
+	content string
+	err     error
+	done    chan struct{}
+}
+
+func (l *streamErrorLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	defer close(l.done)
+	defer rawResponseStream.Close()
+	content, err := io.ReadAll(rawResponseStream)
+	l.content = string(content)
+	l.err = err
+}
+
+// newTruncatingBackend returns a backend that promises a 100 byte body, writes
+// body instead and then closes the connection.
+func newTruncatingBackend(t *testing.T, body string) *httptest.Server {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack backend connection: %v", err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(buf, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 100\r\n\r\n%s", body)
+		buf.Flush()
+	}))
+	t.Cleanup(backend.Close)
+	return backend
+}
+
+func TestTruncatedUpstreamBodyIsRecordedAndAborted(t *testing.T) {
+	backend := newTruncatingBackend(t, "partial")
+	logger := &streamErrorLogger{done: make(chan struct{})}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	resp, err := http.Get(testServer.URL + "/api/")
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the upstream status once body bytes were sent, got %d", resp.StatusCode)
+	}
+	if err == nil || string(body) != "partial" {
+		t.Errorf("Expected the client to see a truncated body, got %q with error %v", string(body), err)
+	}
+
+	select {
+	case <-logger.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the response log")
+	}
+	var incomplete *IncompleteResponseError
+	if !errors.As(logger.err, &incomplete) {
+		t.Errorf("Expected the response log to end with an IncompleteResponseError, got %v", logger.err)
+	}
+	if !strings.HasSuffix(logger.content, "\r\n\r\npartial") {
+		t.Errorf("Expected the partial body to be logged, got:\n%s", logger.content)
+	}
+}
+
+func TestUpstreamBodyFailingBeforeFirstByteReturnsBadGateway(t *testing.T) {
+	backend := newTruncatingBackend(t, "")
+	logger := &streamErrorLogger{done: make(chan struct{})}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	status, _ := getStatusAndBody(t, testServer.URL+"/api/")
+	if status != http.StatusBadGateway {
+		t.Errorf("Expected 502 when the upstream body fails before any bytes, got %d", status)
+	}
+
+	select {
+	case <-logger.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the response log")
+	}
+	var incomplete *IncompleteResponseError
+	if !errors.As(logger.err, &incomplete) {
+		t.Errorf("Expected the response log to end with an IncompleteResponseError, got %v", logger.err)
+	}
+}
+
+// requestErrorLogger records the request stream of a single exchange together
+// with the error that ended it.
+type requestErrorLogger struct {
+	NoOpLogger
+	content string
+	err     error
+	done    chan struct{}
+}
+
+func (l *requestErrorLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	defer close(l.done)
+	defer rawRequestStream.Close()
+	content, err := io.ReadAll(rawRequestStream)
+	l.content = string(content)
+	l.err = err
+}
+
+// sendPartialUpload announces a 100 byte body, sends only part of it and then
+// disconnects, like a client that goes away during an upload.
+func sendPartialUpload(t *testing.T, proxyURL string) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxyURL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	fmt.Fprintf(conn, "POST /api/upload HTTP/1.1\r\nHost: proxy\r\nContent-Length: 100\r\n\r\npartial")
+	// Give the proxy a moment to start forwarding before disconnecting
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+}
+
+func TestClientDisconnectDuringStreamedUpload(t *testing.T) {
+	backendErr := make(chan error, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := io.ReadAll(r.Body)
+		backendErr <- err
+	}))
+	defer backend.Close()
+
+	logger := &requestErrorLogger{done: make(chan struct{})}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	sendPartialUpload(t, testServer.URL)
+
+	select {
+	case <-logger.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the request log")
+	}
+	var incomplete *IncompleteRequestError
+	if !errors.As(logger.err, &incomplete) {
+		t.Errorf("Expected the request log to end with an IncompleteRequestError, got %v", logger.err)
+	}
+	if !strings.HasSuffix(logger.content, "\r\n\r\npartial") {
+		t.Errorf("Expected the request log to keep the partial body, got:\n%s", logger.content)
+	}
+
+	// The upstream request is aborted, so the backend never sees a complete body
+	select {
+	case err := <-backendErr:
+		if err == nil {
+			t.Error("Expected the backend to see an aborted upload")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the backend to see the aborted upload")
+	}
+}
+
+func TestClientDisconnectDuringBufferedUpload(t *testing.T) {
+	var backendRequests atomic.Int32
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendRequests.Add(1)
+	}))
+	defer backend.Close()
+
+	logger := &requestErrorLogger{done: make(chan struct{})}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{MaxBufferedRequestBody: 1024})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	sendPartialUpload(t, testServer.URL)
+
+	select {
+	case <-logger.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the request log")
+	}
+	var incomplete *IncompleteRequestError
+	if !errors.As(logger.err, &incomplete) {
+		t.Errorf("Expected the request log to end with an IncompleteRequestError, got %v", logger.err)
+	}
+
+	// Give a wrongly forwarded request a moment to arrive
+	time.Sleep(100 * time.Millisecond)
+	if count := backendRequests.Load(); count != 0 {
+		t.Errorf("Expected the partial upload to never reach the backend, got %d requests", count)
+	}
+}
+
+func TestBufferedUploadIsForwarded(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%d:%s", r.ContentLength, body)
+	}))
+	defer backend.Close()
+
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{MaxBufferedRequestBody: 8})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for _, body := range []string{"small", "larger than the buffer"} {
+		// Hide the length so the body is sent chunked and read up to the limit
+		resp, err := http.Post(testServer.URL+"/api/", "text/plain", io.MultiReader(strings.NewReader(body)))
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		got, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(got) != "-1:"+body {
+			t.Errorf("Expected the backend to receive %q, got %q", body, got)
+		}
+	}
+}
+
+func TestDeflateResponseLogging(t *testing.T) {
+	expectedBody := strings.Repeat(`{"message": "deflate me"}`, 20)
+	compress := map[string]func(io.Writer) io.WriteCloser{
+		"zlib": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"raw": func(w io.Writer) io.WriteCloser {
+			flateWriter, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return flateWriter
+		},
+	}
+
+	for name, newWriter := range compress {
+		t.Run(name, func(t *testing.T) {
+			backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Encoding", "deflate")
+				writer := newWriter(w)
+				io.WriteString(writer, expectedBody)
+				writer.Close()
+			}))
+			defer backend.Close()
+
+			testLogger := &TestLogger{}
+			proxyServer := NewProxyServer("")
+			if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+				t.Fatal("Failed to add route:", err)
+			}
+			testServer := httptest.NewServer(proxyServer)
+			defer testServer.Close()
+
+			status, _ := getStatusAndBody(t, testServer.URL+"/api/")
+			if status != http.StatusOK {
+				t.Fatalf("Expected 200, got %d", status)
+			}
+
+			// Give async logging a moment to complete
+			time.Sleep(100 * time.Millisecond)
+
+			if len(testLogger.responses) != 1 {
+				t.Fatalf("Expected 1 response log, got %d", len(testLogger.responses))
+			}
+			content := testLogger.responses[0].content
+			if strings.Contains(content, "X-Decompression-Error") {
+				t.Errorf("Expected %s deflate body to decompress, got:\n%s", name, content)
+			}
+			if !strings.HasSuffix(content, "\r\n\r\n"+expectedBody) {
+				t.Errorf("Expected decompressed body in the log, got:\n%s", content)
+			}
+		})
+	}
+}
+
+func TestLogHeaderAllowListOnlyAffectsLogs(t *testing.T) {
+	var backendHeaders http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendHeaders = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Backend-Secret", "internal")
+		fmt.Fprint(w, `{"ok":true}`)
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:        HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		LogHeaderAllowList: []string{"content-type"},
+	})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	request, _ := http.NewRequest(http.MethodPost, testServer.URL+"/api/", strings.NewReader(`{}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if backendHeaders.Get("Authorization") != "Bearer secret" || resp.Header.Get("X-Backend-Secret") != "internal" {
+		t.Errorf("Expected forwarded traffic to keep all headers")
+	}
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 request and 1 response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	for _, content := range []string{testLogger.requests[0].content, testLogger.responses[0].content} {
+		transcript, err := ParseTranscript(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("Failed to parse logged transcript: %v", err)
+		}
+		if len(transcript.Header) != 1 || transcript.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Expected only Content-Type to be logged, got %v", transcript.Header)
+		}
+	}
+}
+
+func TestLoggedHeadersAreBounded(t *testing.T) {
+	long := strings.Repeat("a", 8<<10)
+	var backendValue string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendValue = r.Header.Get("X-Long")
+		w.Header().Set("X-Long", long)
+		for i := 0; i < 20; i++ {
+			w.Header().Set(fmt.Sprintf("X-Filler-%02d", i), strings.Repeat("b", 50))
+		}
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		MaxLoggedHeaderValue: 100,
+		MaxLoggedHeaderBytes: 1024,
+	})
+	if err != nil {
+		t.Fatal("Failed to create proxy server:", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/", nil)
+	request.Header.Set("X-Long", long)
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if backendValue != long || resp.Header.Get("X-Long") != long || resp.Header.Get("X-Filler-19") == "" {
+		t.Errorf("Expected forwarded traffic to keep the full headers")
+	}
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 request and 1 response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	truncated := strings.Repeat("a", 100) + "...[truncated 8092 bytes]"
+	for _, content := range []string{testLogger.requests[0].content, testLogger.responses[0].content} {
+		transcript, err := ParseTranscript(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("Failed to parse logged transcript: %v", err)
+		}
+		// Headers are logged in map order, so the long response header may
+		// be one of those left out
+		if value := transcript.Header.Get("X-Long"); value != truncated && (value != "" || !transcript.IsResponse) {
+			t.Errorf("Expected a truncated logged value, got %d bytes", len(value))
+		}
+		if headerBlock, _, _ := strings.Cut(content, "\r\n\r\n"); len(headerBlock) > 1024+100 {
+			t.Errorf("Expected the logged header block to stay near 1024 bytes, got %d", len(headerBlock))
+		}
+	}
+	if !strings.Contains(testLogger.responses[0].content, "\r\nX-Logged-Headers: omitted ") {
+		t.Errorf("Expected a note about omitted response headers, got:\n%s", testLogger.responses[0].content)
+	}
+}
+
+func TestCompressRequestsGzipsUpstreamBody(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			fmt.Fprintf(w, "plain:%s", readAllString(r.Body))
+			return
+		}
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "gzip:%s", readAllString(gzipReader))
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{CompressRequests: true}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	original := strings.Repeat(`{"prompt":"compress me"}`, 50)
+	resp, err := http.Post(testServer.URL+"/api/chat", "application/json", strings.NewReader(original))
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body := readAllString(resp.Body)
+	resp.Body.Close()
+	if body != "gzip:"+original {
+		t.Errorf("Expected the backend to receive a gzip body decoding to the original, got %q", body)
+	}
+
+	// Already encoded bodies are forwarded unchanged
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	io.WriteString(gzipWriter, "already compressed")
+	gzipWriter.Close()
+	encoded, _ := http.NewRequest(http.MethodPost, testServer.URL+"/api/encoded", &compressed)
+	encoded.Header.Set("Content-Encoding", "gzip")
+	resp, err = http.DefaultClient.Do(encoded)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	body = readAllString(resp.Body)
+	resp.Body.Close()
+	if body != "gzip:already compressed" {
+		t.Errorf("Expected the encoded body to be forwarded once, got %q", body)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	if len(testLogger.requests) != 2 {
+		t.Fatalf("Expected 2 request logs, got %d", len(testLogger.requests))
+	}
+	if !strings.HasSuffix(testLogger.requests[0].content, "\r\n\r\n"+original) {
+		t.Errorf("Expected the log to show the plaintext body, got:\n%s", testLogger.requests[0].content)
+	}
+}
+
+func readAllString(r io.Reader) string {
+	body, _ := io.ReadAll(r)
+	return string(body)
+}
+
+func TestChunkedUploadIsMarkedInLog(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Length", "2")
+		fmt.Fprint(w, "ok")
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	// A chunked, gzip-compressed upload and a fixed-length one
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	io.WriteString(gzipWriter, "streamed upload")
+	gzipWriter.Close()
+	chunked, _ := http.NewRequest(http.MethodPost, testServer.URL+"/api/chunked", io.MultiReader(&compressed))
+	chunked.Header.Set("Content-Encoding", "gzip")
+	chunked.ContentLength = -1
+	fixed, _ := http.NewRequest(http.MethodPost, testServer.URL+"/api/fixed", strings.NewReader("fixed upload"))
+
+	for _, request := range []*http.Request{chunked, fixed} {
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		// Give async logging a moment to complete
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(testLogger.requests) != 2 {
+		t.Fatalf("Expected 2 request logs, got %d", len(testLogger.requests))
+	}
+	chunkedLog, fixedLog := testLogger.requests[0].content, testLogger.requests[1].content
+	if !strings.Contains(chunkedLog, "X-Original-Transfer-Encoding: chunked\r\n") {
+		t.Errorf("Expected chunked upload to be marked, got:\n%s", chunkedLog)
+	}
+	if !strings.HasSuffix(chunkedLog, "\r\n\r\nstreamed upload") || strings.Contains(chunkedLog, "X-Decompression-Error") {
+		t.Errorf("Expected the chunked gzip upload to be logged decompressed, got:\n%s", chunkedLog)
+	}
+	if strings.Contains(fixedLog, "X-Original-Transfer-Encoding") {
+		t.Errorf("Expected fixed-length upload not to be marked, got:\n%s", fixedLog)
+	}
+	for _, response := range testLogger.responses {
+		if strings.Contains(response.content, "X-Original-Transfer-Encoding") {
+			t.Errorf("Expected fixed-length response not to be marked, got:\n%s", response.content)
+		}
+	}
+}
+
+// progressLogger reports how much of a request stream it has read so far.
+type progressLogger struct {
+	NoOpLogger
+	progress chan string
+}
+
+func (l *progressLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	defer rawRequestStream.Close()
+	var content []byte
+	buf := make([]byte, 1024)
+	for {
+		n, err := rawRequestStream.Read(buf)
+		content = append(content, buf[:n]...)
+		if n > 0 {
+			l.progress <- string(content)
+		}
+		if err != nil {
+			close(l.progress)
+			return
+		}
+	}
+}
+
+func TestStreamingUploadIsLoggedBeforeResponse(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		fmt.Fprint(w, "done")
+	}))
+	defer backend.Close()
+
+	logger := &progressLogger{progress: make(chan string, 16)}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", logger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	uploadReader, uploadWriter := io.Pipe()
+	request, _ := http.NewRequest(http.MethodPost, testServer.URL+"/api/upload", uploadReader)
+	responded := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(request)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		responded <- err
+	}()
+
+	// waitFor waits until the logged stream ends with suffix
+	waitFor := func(suffix string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for {
+			select {
+			case content, ok := <-logger.progress:
+				if !ok {
+					t.Fatalf("Request log ended before receiving %q", suffix)
+				}
+				if strings.HasSuffix(content, suffix) {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("Timed out waiting for the logger to receive %q", suffix)
+			}
+		}
+	}
+
+	uploadWriter.Write([]byte("first part"))
+	waitFor("\r\n\r\nfirst part")
+	select {
+	case err := <-responded:
+		t.Fatalf("Expected the response to wait for the rest of the upload, got %v", err)
+	default:
+	}
+
+	uploadWriter.Write([]byte(", second part"))
+	waitFor("first part, second part")
+	uploadWriter.Close()
+
+	select {
+	case err := <-responded:
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the response")
+	}
+}
+
+// countingZeroReader produces size zero bytes and counts how many were read.
+type countingZeroReader struct {
+	remaining int64
+	read      atomic.Int64
+}
+
+func (r *countingZeroReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if n > r.remaining {
+		n = r.remaining
+	}
+	clear(p[:n])
+	r.remaining -= n
+	r.read.Add(n)
+	return int(n), nil
+}
+
+func TestExpectContinueRejectedBeforeBodyIsSent(t *testing.T) {
+	var backendExpect atomic.Value
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		backendExpect.Store(r.Header.Get("Expect"))
+		// Reject without reading the body, so the backend never sends 100 Continue
+		w.WriteHeader(http.StatusExpectationFailed)
+	}))
+	defer backend.Close()
+
+	testServer := httptest.NewServer(createTestServer(map[string]string{"/api/": backend.URL + "/"}))
+	defer testServer.Close()
+
+	const size = 64 << 20
+	body := &countingZeroReader{remaining: size}
+	request, _ := http.NewRequest(http.MethodPut, testServer.URL+"/api/upload", body)
+	request.ContentLength = size
+	request.Header.Set("Expect", "100-continue")
+	// Wait long enough that the body is only sent if the proxy asks for it
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 10 * time.Second}}
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusExpectationFailed {
+		t.Errorf("Expected the backend's 417, got %d", resp.StatusCode)
+	}
+	if backendExpect.Load() != "100-continue" {
+		t.Errorf("Expected the expectation to be forwarded, backend saw Expect %q", backendExpect.Load())
+	}
+	if sent := body.read.Load(); sent != 0 {
+		t.Errorf("Expected no body bytes to be sent before the backend agreed, got %d", sent)
+	}
+}
+
+func TestExpectContinueAcceptedForwardsBody(t *testing.T) {
+	var received atomic.Int64
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		received.Store(n)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer backend.Close()
+
+	testServer := httptest.NewServer(createTestServer(map[string]string{"/api/": backend.URL + "/"}))
+	defer testServer.Close()
+
+	const size = 1 << 20
+	body := &countingZeroReader{remaining: size}
+	request, _ := http.NewRequest(http.MethodPut, testServer.URL+"/api/upload", body)
+	request.ContentLength = size
+	request.Header.Set("Expect", "100-continue")
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 10 * time.Second}}
+	start := time.Now()
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated || received.Load() != size {
+		t.Errorf("Expected the backend to receive %d bytes and answer 201, got %d bytes and %d", size, received.Load(), resp.StatusCode)
+	}
+	// The client must have been told to continue rather than waiting for its timeout
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected 100 Continue to be relayed promptly, took %v", elapsed)
+	}
+}
+
+func TestSubjectHeaderRecordedInMetadata(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{SubjectHeader: "X-User-Id"})
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/items", nil)
+	request.Header.Set("X-User-Id", "user-42")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 1 || len(testLogger.responses) != 1 {
+		t.Fatalf("Expected 1 request and 1 response log, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	if subject := testLogger.requests[0].metadata.Subject; subject != "user-42" {
+		t.Errorf("Expected request subject user-42, got %q", subject)
+	}
+	if subject := testLogger.responses[0].metadata.Subject; subject != "user-42" {
+		t.Errorf("Expected response subject user-42, got %q", subject)
+	}
+}
+
+func TestLoggingFlagRecordedInMetadata(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer backend.Close()
+
+	// Logging is enabled by default, and /quiet/ disables it for itself
+	disabled := false
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRouteWithOptions("/quiet/", backend.URL+"/", testLogger, RouteOptions{Logging: &disabled}); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	if err := proxyServer.AddRoute("/loud/", backend.URL+"/", testLogger); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for _, path := range []string{"/quiet/items", "/loud/items"} {
+		resp, err := http.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		// Give async logging a moment to complete
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(testLogger.requests) != 2 || len(testLogger.responses) != 2 {
+		t.Fatalf("Expected 2 request and 2 response logs, got %d and %d", len(testLogger.requests), len(testLogger.responses))
+	}
+	for i, want := range []struct {
+		enabled bool
+		source  string
+	}{{false, LoggingSourceRoute}, {true, LoggingSourceDefault}} {
+		for _, metadata := range []RequestMetadata{testLogger.requests[i].metadata, testLogger.responses[i].metadata} {
+			if metadata.LoggingEnabled != want.enabled || metadata.LoggingSource != want.source {
+				t.Errorf("%s: expected logging enabled=%v from %q, got %v from %q", metadata.Pattern, want.enabled, want.source, metadata.LoggingEnabled, metadata.LoggingSource)
+			}
+		}
+	}
+
+	// A disabled default is recorded for routes without their own setting
+	quietServer, err := NewProxyServerWithOptions(ProxyServerOptions{DefaultLoggingDisabled: true})
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	route, err := quietServer.newRoute("/", backend.URL+"/", testLogger, RouteOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create route: %v", err)
+	}
+	if route.loggingEnabled || route.loggingSource != LoggingSourceDefault {
+		t.Errorf("Expected logging disabled by default, got %v from %q", route.loggingEnabled, route.loggingSource)
+	}
+}
+
+func TestSkipLargeBodiesLogsOnlyHeaders(t *testing.T) {
+	const downloadSize = 50 << 20
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/model.bin":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			chunk := make([]byte, 1<<20)
+			for i := 0; i < downloadSize/len(chunk); i++ {
+				w.Write(chunk)
+			}
+		case "/large.json":
+			body := `[` + strings.Repeat(`0,`, 2048) + `0]`
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			io.WriteString(w, body)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"ok":true}`)
+		}
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{SkipLargeBodies: 1024}); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	expectedSizes := map[string]int64{"/api/model.bin": downloadSize, "/api/large.json": 4099, "/api/small.json": 11}
+	for _, path := range []string{"/api/model.bin", "/api/large.json", "/api/small.json"} {
+		resp, err := http.Get(testServer.URL + path)
+		if err != nil {
+			t.Fatalf("Request to %s failed: %v", path, err)
+		}
+		received, _ := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if received != expectedSizes[path] {
+			t.Fatalf("Expected the client to receive %d bytes from %s, got %d", expectedSizes[path], path, received)
+		}
+		// Log sequentially, the TestLogger is not safe for concurrent use
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(testLogger.responses) != 3 {
+		t.Fatalf("Expected 3 response logs, got %d", len(testLogger.responses))
+	}
+	for i, path := range []string{"/api/model.bin", "/api/large.json"} {
+		content := testLogger.responses[i].content
+		note := fmt.Sprintf("\r\n\r\nX-Logged-Body: omitted; size=%d\r\n", expectedSizes[path])
+		if !strings.HasSuffix(content, note) || !strings.HasPrefix(content, "HTTP/1.1 200 OK\r\n") {
+			t.Errorf("Expected only headers and a size note for %s, got %d bytes:\n%.300s", path, len(content), content)
+		}
+	}
+	if small := testLogger.responses[2].content; !strings.HasSuffix(small, `{"ok":true}`) || strings.Contains(small, "X-Logged-Body") {
+		t.Errorf("Expected the small response body to be logged, got:\n%s", small)
+	}
+}
+
+// gatedLogger reads its response streams only once released.
+type gatedLogger struct {
+	release   chan struct{}
+	responses chan string
+}
+
+func (l *gatedLogger) LogRequest(_ RequestMetadata, _ time.Time, rawRequestStream io.ReadCloser) {
+	io.Copy(io.Discard, rawRequestStream)
+	rawRequestStream.Close()
+}
+
+func (l *gatedLogger) LogResponse(_ RequestMetadata, _ time.Time, rawResponseStream io.ReadCloser) {
+	<-l.release
+	content, err := io.ReadAll(rawResponseStream)
+	rawResponseStream.Close()
+	if err != nil {
+		content = append(content, "\nerror: "+err.Error()...)
+	}
+	l.responses <- string(content)
+}
+
+func TestSkippedBodiesAreNotHeldUpByTheLogger(t *testing.T) {
+	const logTimeout = 100 * time.Millisecond
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// Outlast the log timeout
+		for i := 0; i < 5; i++ {
+			w.Write(make([]byte, 64<<10))
+			w.(http.Flusher).Flush()
+			time.Sleep(logTimeout / 2)
+		}
+	}))
+	defer backend.Close()
+
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy: HTTPClientProxyConfig{ProxyFromEnvironment: new(bool)},
+		LogTimeout:  logTimeout,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create proxy server: %v", err)
+	}
+	logger := &gatedLogger{release: make(chan struct{}), responses: make(chan string, 1)}
+	if err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", logger, RouteOptions{SkipLargeBodies: 1024}); err != nil {
+		t.Fatalf("Failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	downloaded := make(chan int64, 1)
+	go func() {
+		resp, err := http.Get(testServer.URL + "/api/model.bin")
+		if err != nil {
+			downloaded <- -1
+			return
+		}
+		received, _ := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		downloaded <- received
+	}()
+	select {
+	case received := <-downloaded:
+		if received != 5*64<<10 {
+			t.Fatalf("Expected the client to receive %d bytes, got %d", 5*64<<10, received)
+		}
+	case <-time.After(5 * time.Second):
+		close(logger.release)
+		t.Fatal("Expected the download to finish while the logger is blocked")
+	}
+
+	close(logger.release)
+	select {
+	case content := <-logger.responses:
+		if note := fmt.Sprintf("\r\n\r\nX-Logged-Body: omitted; size=%d\r\n", 5*64<<10); !strings.HasSuffix(content, note) {
+			t.Errorf("Expected the complete headers and size note, got:\n%s", content)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the response to be logged")
+	}
+	if timedOut := proxyServer.TimedOutLogs(); timedOut != 0 {
+		t.Errorf("Expected the log of the skipped body not to time out, got %d timed out logs", timedOut)
+	}
+}
+
+func TestCloseClosesIdleUpstreamConnections(t *testing.T) {
+	var open atomic.Int32
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	backend.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			open.Add(1)
+		case http.StateClosed:
+			open.Add(-1)
+		}
+	}
+	backend.Start()
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/shared/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	// A route with address overrides has a client of its own
+	backendHost := strings.TrimPrefix(backend.URL, "http://")
+	err := proxyServer.AddRouteWithOptions("/own/", "http://backend.test/", &NoOpLogger{}, RouteOptions{
+		AddressOverride: map[string]string{"backend.test": backendHost},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for _, path := range []string{"/shared/", "/own/"} {
+		if status, body := getStatusAndBody(t, testServer.URL+path); status != http.StatusOK || body != "ok" {
+			t.Fatalf("Expected %s to be proxied, got %d %q", path, status, body)
+		}
+	}
+	if open.Load() != 2 {
+		t.Fatalf("Expected an idle upstream connection per client, got %d", open.Load())
+	}
+
+	proxyServer.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for open.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if open.Load() != 0 {
+		t.Errorf("Expected Close to close the idle upstream connections, %d still open", open.Load())
+	}
+}
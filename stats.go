@@ -0,0 +1,175 @@
+package loggingproxy
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats is a snapshot of the traffic a ProxyServer has handled since it was
+// created, for embedders that want numbers without scraping Prometheus
+// metrics. The embedded TrafficStats are the totals over all routes.
+type Stats struct {
+	TrafficStats
+	// Routes holds the counters of each route by its pattern. Handlers made
+	// with RouteHandler share the empty pattern.
+	Routes map[string]TrafficStats
+}
+
+// TrafficStats are the counters of a server or route.
+type TrafficStats struct {
+	// Requests counts the requests that reached a route, including those
+	// the proxy answered itself.
+	Requests uint64
+	// StatusCounts counts the responses by the status code sent to the
+	// client. Requests whose connection was dropped before a status was
+	// sent are not counted.
+	StatusCounts map[int]uint64
+	// BytesIn counts the request body bytes read from clients and BytesOut
+	// the response body bytes written to them.
+	BytesIn  uint64
+	BytesOut uint64
+	// ActiveStreams is the number of requests being handled right now,
+	// including responses that are still streaming.
+	ActiveStreams int64
+}
+
+// maxStatsStatus bounds the status codes counted; StatusMap allows up to 999.
+const maxStatsStatus = 999
+
+// trafficCounters back TrafficStats with atomic counters, so requests do not
+// contend on a lock.
+type trafficCounters struct {
+	requests atomic.Uint64
+	statuses [maxStatsStatus + 1]atomic.Uint64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+	active   atomic.Int64
+}
+
+func (c *trafficCounters) snapshot() TrafficStats {
+	stats := TrafficStats{
+		Requests:      c.requests.Load(),
+		StatusCounts:  map[int]uint64{},
+		BytesIn:       c.bytesIn.Load(),
+		BytesOut:      c.bytesOut.Load(),
+		ActiveStreams: c.active.Load(),
+	}
+	for status := range c.statuses {
+		if count := c.statuses[status].Load(); count > 0 {
+			stats.StatusCounts[status] = count
+		}
+	}
+	return stats
+}
+
+// serverStats holds the totals and the counters of every route.
+type serverStats struct {
+	total trafficCounters
+
+	mu     sync.RWMutex
+	routes map[string]*trafficCounters
+}
+
+// route returns the counters of the route with pattern, creating them on
+// first use.
+func (s *serverStats) route(pattern string) *trafficCounters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.routes == nil {
+		s.routes = map[string]*trafficCounters{}
+	}
+	counters, ok := s.routes[pattern]
+	if !ok {
+		counters = &trafficCounters{}
+		s.routes[pattern] = counters
+	}
+	return counters
+}
+
+// Stats returns a snapshot of the server's traffic counters.
+func (s *ProxyServer) Stats() Stats {
+	s.stats.mu.RLock()
+	defer s.stats.mu.RUnlock()
+	stats := Stats{
+		TrafficStats: s.stats.total.snapshot(),
+		Routes:       make(map[string]TrafficStats, len(s.stats.routes)),
+	}
+	for pattern, counters := range s.stats.routes {
+		stats.Routes[pattern] = counters.snapshot()
+	}
+	return stats
+}
+
+// trackedResponse counts one request in the server and route stats. It wraps
+// the response writer to see the status and body size; end records them.
+type trackedResponse struct {
+	http.ResponseWriter
+	counters []*trafficCounters
+	body     *countingReadCloser
+	status   int
+	written  int64
+}
+
+// track counts the start of a request and wraps its body and response writer.
+func (s *serverStats) track(route *trafficCounters, w http.ResponseWriter, request *http.Request) *trackedResponse {
+	tracked := &trackedResponse{ResponseWriter: w, counters: []*trafficCounters{&s.total}}
+	if route != nil {
+		tracked.counters = append(tracked.counters, route)
+	}
+	for _, counters := range tracked.counters {
+		counters.requests.Add(1)
+		counters.active.Add(1)
+	}
+	if request.Body != nil && request.Body != http.NoBody {
+		tracked.body = &countingReadCloser{ReadCloser: request.Body}
+		request.Body = tracked.body
+	}
+	return tracked
+}
+
+func (t *trackedResponse) WriteHeader(status int) {
+	// Informational responses such as 100 Continue are followed by the real one
+	if t.status == 0 && status >= 200 {
+		t.status = status
+	}
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *trackedResponse) Write(p []byte) (int, error) {
+	if t.status == 0 {
+		t.status = http.StatusOK
+	}
+	n, err := t.ResponseWriter.Write(p)
+	t.written += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (t *trackedResponse) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// observeSize records the response body size in metrics, unless no response
+// was sent.
+func (t *trackedResponse) observeSize(metrics *Metrics, route string) {
+	if t.status != 0 {
+		metrics.ObserveResponseSize(route, t.written)
+	}
+}
+
+// end records the outcome of the request.
+func (t *trackedResponse) end() {
+	var bytesIn int64
+	if t.body != nil {
+		bytesIn = t.body.n.Load()
+	}
+	for _, counters := range t.counters {
+		counters.active.Add(-1)
+		counters.bytesIn.Add(uint64(bytesIn))
+		counters.bytesOut.Add(uint64(t.written))
+		if t.status > 0 && t.status <= maxStatsStatus {
+			counters.statuses[t.status].Add(1)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStatsSnapshotCountsRequests(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, "0123456789")
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	if err := proxyServer.AddRoute("/other/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	send := func(method, path, body string) {
+		t.Helper()
+		request, _ := http.NewRequest(method, testServer.URL+path, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	send(http.MethodPost, "/api/items", "hello")
+	send(http.MethodPost, "/api/items", "world!")
+	send(http.MethodGet, "/api/missing", "")
+	send(http.MethodGet, "/other/items", "")
+
+	stats := proxyServer.Stats()
+	if stats.Requests != 4 || stats.ActiveStreams != 0 {
+		t.Errorf("Expected 4 finished requests, got %d with %d active", stats.Requests, stats.ActiveStreams)
+	}
+	if stats.StatusCounts[http.StatusOK] != 3 || stats.StatusCounts[http.StatusNotFound] != 1 || len(stats.StatusCounts) != 2 {
+		t.Errorf("Unexpected status counts: %v", stats.StatusCounts)
+	}
+	if stats.BytesIn != 11 {
+		t.Errorf("Expected 11 request body bytes, got %d", stats.BytesIn)
+	}
+	notFoundSize := uint64(len("404 page not found\n"))
+	if stats.BytesOut != 30+notFoundSize {
+		t.Errorf("Expected %d response body bytes, got %d", 30+notFoundSize, stats.BytesOut)
+	}
+
+	api := stats.Routes["/api/"]
+	if api.Requests != 3 || api.StatusCounts[http.StatusOK] != 2 || api.StatusCounts[http.StatusNotFound] != 1 || api.BytesIn != 11 {
+		t.Errorf("Unexpected /api/ stats: %+v", api)
+	}
+	other := stats.Routes["/other/"]
+	if other.Requests != 1 || other.StatusCounts[http.StatusOK] != 1 || other.BytesOut != 10 {
+		t.Errorf("Unexpected /other/ stats: %+v", other)
+	}
+
+	// The snapshot is a copy
+	stats.StatusCounts[http.StatusOK] = 100
+	if proxyServer.Stats().StatusCounts[http.StatusOK] != 3 {
+		t.Error("Expected later snapshots to be unaffected by changes to an earlier one")
+	}
+}
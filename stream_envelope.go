@@ -0,0 +1,162 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StreamEnvelope is the JSON form of one logged request or response, as
+// published by KafkaLogger and SyslogLogger.
+type StreamEnvelope struct {
+	StreamType string          `json:"stream_type"`
+	Timestamp  time.Time       `json:"timestamp"`
+	Metadata   RequestMetadata `json:"metadata"`
+	// Stream is the logged stream: the request or status line, headers and
+	// the (decompressed) body, cut off after MaxBodySize bytes.
+	Stream string `json:"stream"`
+	// Encoding is "base64" for binary streams.
+	Encoding  string `json:"encoding,omitempty"`
+	Size      int64  `json:"size"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// envelopePublisher turns logged streams into StreamEnvelopes, forwarding
+// them to logger on the way, and sends them as JSON from a single background
+// goroutine through a bounded buffer, so a slow or unavailable sink never
+// blocks the proxy. Messages that cannot be queued or sent are dropped and
+// counted.
+type envelopePublisher struct {
+	logger      Logger
+	maxBodySize int
+	send        func(key, value []byte) error
+
+	messages chan envelopeMessage
+	done     chan struct{}
+	dropped  atomic.Uint64
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// envelopeMessage is a queued envelope, keyed by request ID.
+type envelopeMessage struct {
+	key   []byte
+	value []byte
+}
+
+// newEnvelopePublisher starts the goroutine that calls send. close stops it
+// after the queued messages have been sent.
+func newEnvelopePublisher(logger Logger, maxBodySize, bufferSize int, send func(key, value []byte) error) *envelopePublisher {
+	p := &envelopePublisher{
+		logger:      logger,
+		maxBodySize: maxBodySize,
+		send:        send,
+		messages:    make(chan envelopeMessage, bufferSize),
+		done:        make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *envelopePublisher) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	p.publish("request", metadata, timestamp, rawRequestStream)
+}
+
+func (p *envelopePublisher) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	p.publish("response", metadata, timestamp, rawResponseStream)
+}
+
+func (p *envelopePublisher) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(p.logger, metadata, timestamp)
+}
+
+// publish tees the stream to the wrapped logger, keeps the first maxBodySize
+// bytes for the envelope and queues it.
+func (p *envelopePublisher) publish(streamType string, metadata RequestMetadata, timestamp time.Time, stream io.ReadCloser) {
+	captured := captureStream(stream, p.maxBodySize, forwardTo(p.logger, streamType, metadata, timestamp))
+
+	envelope := StreamEnvelope{
+		StreamType: streamType,
+		Timestamp:  timestamp,
+		Metadata:   metadata,
+		Size:       captured.size,
+		Truncated:  captured.size > int64(captured.Len()),
+	}
+	envelope.Stream, envelope.Encoding = harBodyText(captured.Bytes())
+	value, err := json.Marshal(envelope)
+	if err != nil {
+		p.dropped.Add(1)
+		return
+	}
+	message := envelopeMessage{key: []byte(metadata.ID), value: value}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		p.dropped.Add(1)
+		return
+	}
+	select {
+	case p.messages <- message:
+	default:
+		p.dropped.Add(1)
+	}
+}
+
+func (p *envelopePublisher) run() {
+	defer close(p.done)
+	for message := range p.messages {
+		if err := p.send(message.key, message.value); err != nil {
+			p.dropped.Add(1)
+		}
+	}
+}
+
+// close waits for the queued messages to be sent. The wrapped logger is left
+// to the caller.
+func (p *envelopePublisher) close() {
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		close(p.messages)
+	}
+	p.mu.Unlock()
+	<-p.done
+}
+
+// captureStream passes stream to forward, if set, drains what it leaves
+// unread and returns the first limit bytes of the stream.
+func captureStream(stream io.ReadCloser, limit int, forward func(io.ReadCloser)) *cappedBuffer {
+	defer stream.Close()
+	captured := &cappedBuffer{limit: limit}
+	tee := io.TeeReader(stream, captured)
+	if forward != nil {
+		// The wrapped logger closes its stream; keep ours open to drain the rest
+		forward(&readCloser{Reader: tee, Closer: io.NopCloser(nil)})
+	}
+	io.Copy(io.Discard, tee)
+	return captured
+}
+
+// cappedBuffer keeps the first limit bytes written to it and counts the rest.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+	size  int64
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	b.size += int64(len(p))
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,143 @@
+//go:build !windows && !plan9
+
+package loggingproxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/syslog"
+	"time"
+)
+
+// DefaultSyslogBufferSize is the number of messages SyslogLogger queues for
+// the syslog connection when SyslogLoggerOptions.BufferSize is not positive.
+const DefaultSyslogBufferSize = 1024
+
+// DefaultSyslogMaxBodySize caps the logged stream in a message when
+// SyslogLoggerOptions.MaxBodySize is not positive. Syslog receivers commonly
+// truncate or drop messages much larger than a few kilobytes.
+const DefaultSyslogMaxBodySize = 4096
+
+// DefaultSyslogFacility and DefaultSyslogSeverity are used when
+// SyslogLoggerOptions.Facility or Severity is zero.
+const (
+	DefaultSyslogFacility = syslog.LOG_LOCAL0
+	DefaultSyslogSeverity = syslog.LOG_INFO
+)
+
+// DefaultSyslogTag is the syslog tag used when SyslogLoggerOptions.Tag is empty.
+const DefaultSyslogTag = "logging-proxy"
+
+// SyslogEntry is the JSON text of every message sent by SyslogLogger.
+type SyslogEntry = StreamEnvelope
+
+// SyslogLoggerOptions configures a SyslogLogger.
+type SyslogLoggerOptions struct {
+	// Network and Address select a remote syslog server, for example "udp"
+	// and "logs.example.com:514". Empty logs to the local syslog daemon.
+	Network string
+	Address string
+	// Facility is one of the syslog.LOG_USER to syslog.LOG_LOCAL7 facilities.
+	// Zero uses DefaultSyslogFacility, so syslog.LOG_KERN cannot be selected.
+	Facility syslog.Priority
+	// Severity is one of syslog.LOG_ALERT to syslog.LOG_DEBUG. Zero uses
+	// DefaultSyslogSeverity, so syslog.LOG_EMERG cannot be selected.
+	Severity syslog.Priority
+	// Tag is the syslog tag. Empty uses DefaultSyslogTag.
+	Tag string
+	// Logger receives every stream as well, typically a FileLogger. Nil only
+	// logs to syslog.
+	Logger Logger
+	// MaxBodySize caps the stream included in each message, in bytes.
+	MaxBodySize int
+	// BufferSize bounds the messages waiting for the syslog connection.
+	// Messages that do not fit are dropped and counted.
+	BufferSize int
+}
+
+// SyslogLogger sends each logged request and response to syslog as one
+// SyslogEntry. Messages are written from a single background goroutine
+// through a bounded buffer, so a slow or unreachable syslog server never
+// blocks the proxy. A failed write reconnects and retries once; messages that
+// cannot be queued or written are dropped and counted.
+type SyslogLogger struct {
+	logger    Logger
+	writer    *syslog.Writer
+	publisher *envelopePublisher
+}
+
+// NewSyslogLogger connects to syslog and starts the writer goroutine. Close
+// stops it after the queued messages have been written.
+func NewSyslogLogger(options SyslogLoggerOptions) (*SyslogLogger, error) {
+	if options.Facility == 0 {
+		options.Facility = DefaultSyslogFacility
+	}
+	if options.Severity == 0 {
+		options.Severity = DefaultSyslogSeverity
+	}
+	if options.Facility&^syslog.Priority(0xf8) != 0 || options.Facility > syslog.LOG_LOCAL7 {
+		return nil, fmt.Errorf("invalid syslog facility %d", options.Facility)
+	}
+	if options.Severity&^syslog.Priority(0x07) != 0 {
+		return nil, fmt.Errorf("invalid syslog severity %d", options.Severity)
+	}
+	if (options.Network == "") != (options.Address == "") {
+		return nil, errors.New("syslog logger requires both a network and an address, or neither")
+	}
+	if options.Tag == "" {
+		options.Tag = DefaultSyslogTag
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = DefaultSyslogMaxBodySize
+	}
+	if options.BufferSize <= 0 {
+		options.BufferSize = DefaultSyslogBufferSize
+	}
+
+	writer, err := syslog.Dial(options.Network, options.Address, options.Facility|options.Severity, options.Tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	// syslog.Writer reconnects and retries once when a write fails, so a
+	// restarted syslog server is picked up again
+	write := func(_, message []byte) error {
+		_, err := writer.Write(message)
+		return err
+	}
+	return &SyslogLogger{
+		logger:    options.Logger,
+		writer:    writer,
+		publisher: newEnvelopePublisher(options.Logger, options.MaxBodySize, options.BufferSize, write),
+	}, nil
+}
+
+// LogRequest forwards the request stream and sends it to syslog
+func (l *SyslogLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	l.publisher.LogRequest(metadata, timestamp, rawRequestStream)
+}
+
+// LogResponse forwards the response stream and sends it to syslog
+func (l *SyslogLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	l.publisher.LogResponse(metadata, timestamp, rawResponseStream)
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *SyslogLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	l.publisher.LogConnect(metadata, timestamp)
+}
+
+// Dropped returns the number of messages dropped because the buffer was full,
+// the write failed even after reconnecting or the logger was already closed.
+func (l *SyslogLogger) Dropped() uint64 {
+	return l.publisher.dropped.Load()
+}
+
+// Close waits for the queued messages to be written, closes the syslog
+// connection and closes the wrapped logger if it implements io.Closer.
+func (l *SyslogLogger) Close() error {
+	l.publisher.close()
+	err := l.writer.Close()
+	err = errors.Join(err, closeWrapped(l.logger))
+	return err
+}
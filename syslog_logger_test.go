@@ -0,0 +1,144 @@
+//go:build !windows && !plan9
+
+package loggingproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenSyslog starts a local UDP syslog receiver and returns its address and
+// a channel with every received packet.
+func listenSyslog(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen for syslog: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	packets := make(chan string, 16)
+	go func() {
+		buffer := make([]byte, 64*1024)
+		for {
+			n, _, err := conn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			packets <- string(buffer[:n])
+		}
+	}()
+	return conn.LocalAddr().String(), packets
+}
+
+func TestSyslogLoggerSendsProxiedExchanges(t *testing.T) {
+	address, packets := listenSyslog(t)
+	testLogger := &TestLogger{}
+	syslogLogger, err := NewSyslogLogger(SyslogLoggerOptions{
+		Network:     "udp",
+		Address:     address,
+		Facility:    syslog.LOG_LOCAL3,
+		Severity:    syslog.LOG_NOTICE,
+		Tag:         "proxy-test",
+		Logger:      testLogger,
+		MaxBodySize: 16,
+	})
+	if err != nil {
+		t.Fatalf("failed to create syslog logger: %v", err)
+	}
+	proxySamplingRequests(t, syslogLogger, 1)
+	if err := syslogLogger.Close(); err != nil {
+		t.Fatalf("failed to close syslog logger: %v", err)
+	}
+
+	entries := map[string]SyslogEntry{}
+	for len(entries) < 2 {
+		var packet string
+		select {
+		case packet = <-packets:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expected 2 syslog messages, got %d", len(entries))
+		}
+
+		priority := fmt.Sprintf("<%d>", syslog.LOG_LOCAL3|syslog.LOG_NOTICE)
+		if !strings.HasPrefix(packet, priority) || !strings.Contains(packet, " proxy-test[") {
+			t.Fatalf("expected priority %s and tag proxy-test, got %q", priority, packet)
+		}
+		var entry SyslogEntry
+		if err := json.Unmarshal([]byte(packet[strings.Index(packet, "{"):]), &entry); err != nil {
+			t.Fatalf("message is not a JSON entry: %v: %q", err, packet)
+		}
+		entries[entry.StreamType] = entry
+	}
+
+	request, response := entries["request"], entries["response"]
+	if request.Metadata.ID == "" || request.Metadata.ID != response.Metadata.ID {
+		t.Fatalf("expected both messages to carry the same request ID, got %q and %q", request.Metadata.ID, response.Metadata.ID)
+	}
+	if !strings.HasPrefix(request.Stream, "POST ") || !request.Truncated || len(request.Stream) != 16 {
+		t.Errorf("expected the request stream truncated to 16 bytes, got %q (truncated=%v)", request.Stream, request.Truncated)
+	}
+	if response.Metadata.ResponseStatusCode != 200 || response.Size <= 16 {
+		t.Errorf("unexpected response entry: %+v", response)
+	}
+	if syslogLogger.Dropped() != 0 {
+		t.Errorf("expected no dropped messages, got %d", syslogLogger.Dropped())
+	}
+
+	// The wrapped logger still receives the complete streams
+	if len(testLogger.responses) != 1 || !strings.HasSuffix(testLogger.responses[0].content, "response body") {
+		t.Fatalf("expected the wrapped logger to get the full response, got %+v", testLogger.responses)
+	}
+}
+
+func TestSyslogLoggerDropsAfterClose(t *testing.T) {
+	address, _ := listenSyslog(t)
+	syslogLogger, err := NewSyslogLogger(SyslogLoggerOptions{Network: "udp", Address: address, Facility: syslog.LOG_LOCAL0, Severity: syslog.LOG_INFO})
+	if err != nil {
+		t.Fatalf("failed to create syslog logger: %v", err)
+	}
+	syslogLogger.Close()
+	syslogLogger.LogRequest(RequestMetadata{ID: "late"}, time.Now(), io.NopCloser(strings.NewReader("GET / HTTP/1.1\r\n\r\n")))
+	if syslogLogger.Dropped() != 1 {
+		t.Fatalf("expected 1 dropped message, got %d", syslogLogger.Dropped())
+	}
+}
+
+func TestSyslogLoggerDefaultsPriority(t *testing.T) {
+	address, packets := listenSyslog(t)
+	syslogLogger, err := NewSyslogLogger(SyslogLoggerOptions{Network: "udp", Address: address})
+	if err != nil {
+		t.Fatalf("failed to create syslog logger: %v", err)
+	}
+	syslogLogger.LogRequest(RequestMetadata{ID: "default"}, time.Now(), io.NopCloser(strings.NewReader("GET / HTTP/1.1\r\n\r\n")))
+	syslogLogger.Close()
+
+	// Unset options must not log as kernel emergencies
+	select {
+	case packet := <-packets:
+		priority := fmt.Sprintf("<%d>", syslog.LOG_LOCAL0|syslog.LOG_INFO)
+		if !strings.HasPrefix(packet, priority) {
+			t.Fatalf("expected default priority %s, got %q", priority, packet)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected a syslog message")
+	}
+}
+
+func TestSyslogLoggerRejectsInvalidPriority(t *testing.T) {
+	for _, options := range []SyslogLoggerOptions{
+		{Facility: syslog.LOG_INFO, Severity: syslog.LOG_INFO},
+		{Facility: syslog.LOG_LOCAL0, Severity: syslog.LOG_LOCAL0},
+		{Network: "udp"},
+	} {
+		if _, err := NewSyslogLogger(options); err == nil {
+			t.Errorf("expected options %+v to be rejected", options)
+		}
+	}
+}
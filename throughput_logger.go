@@ -0,0 +1,153 @@
+package loggingproxy
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// ThroughputSample is the measured transfer rate of one logged stream.
+type ThroughputSample struct {
+	ID         string    `json:"id"`
+	StreamType string    `json:"stream_type"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMS int64     `json:"duration_ms"`
+	// Bytes counts the logged stream, including its reconstructed headers.
+	Bytes          int64   `json:"bytes"`
+	BytesPerSecond float64 `json:"bytes_per_second"`
+}
+
+// ThroughputLogger measures how fast each stream is consumed by the wrapped
+// logger, from the first read until the end of the stream. Since streams are
+// consumed as the proxied traffic arrives, this is the transfer rate of the
+// request or response. Each measurement is appended as a JSON line to
+// ReportPath, or printed to the console if ReportPath is empty.
+type ThroughputLogger struct {
+	Logger     Logger
+	ReportPath string
+	// Clock times the transfers. Nil uses the wall clock.
+	Clock Clock
+	// LogLevel filters the console lines, which are printed at info level,
+	// and the logger's errors.
+	LogLevel LogLevel
+
+	mu sync.Mutex
+}
+
+// NewThroughputLogger wraps logger with throughput measurements written to
+// reportPath.
+func NewThroughputLogger(logger Logger, reportPath string) *ThroughputLogger {
+	return &ThroughputLogger{
+		Logger:     logger,
+		ReportPath: reportPath,
+	}
+}
+
+// LogRequest forwards the request stream and records its throughput
+func (l *ThroughputLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	reader := l.newReader(rawRequestStream)
+	if l.Logger == nil {
+		discardStream(reader)
+	} else {
+		l.Logger.LogRequest(metadata, timestamp, reader)
+	}
+	l.record(reader.sample(metadata, "request"))
+}
+
+// LogResponse forwards the response stream and records its throughput
+func (l *ThroughputLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	reader := l.newReader(rawResponseStream)
+	if l.Logger == nil {
+		discardStream(reader)
+	} else {
+		l.Logger.LogResponse(metadata, timestamp, reader)
+	}
+	l.record(reader.sample(metadata, "response"))
+}
+
+// LogConnect forwards CONNECT events if the wrapped logger supports them.
+func (l *ThroughputLogger) LogConnect(metadata RequestMetadata, timestamp time.Time) {
+	forwardConnect(l.Logger, metadata, timestamp)
+}
+
+// Close closes the wrapped logger if it implements io.Closer.
+func (l *ThroughputLogger) Close() error {
+	return closeWrapped(l.Logger)
+}
+
+func (l *ThroughputLogger) newReader(stream io.ReadCloser) *throughputReader {
+	return &throughputReader{ReadCloser: stream, clock: clockOrReal(l.Clock)}
+}
+
+func (l *ThroughputLogger) record(sample ThroughputSample) {
+	if l.ReportPath == "" {
+		levelLogger{level: l.LogLevel}.Infof("[throughput] %s %s: %d bytes in %d ms (%.0f B/s)",
+			sample.StreamType, sample.ID, sample.Bytes, sample.DurationMS, sample.BytesPerSecond)
+		return
+	}
+
+	line, err := json.Marshal(sample)
+	if err != nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	report, err := os.OpenFile(l.ReportPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		levelLogger{level: l.LogLevel}.Errorf("Failed to open throughput report %s: %v", l.ReportPath, err)
+		return
+	}
+	defer report.Close()
+	report.Write(append(line, '\n'))
+}
+
+// throughputReader counts the bytes read through it and times the reads from
+// the first one until the end of the stream.
+type throughputReader struct {
+	io.ReadCloser
+	clock Clock
+
+	bytes int64
+	start time.Time
+	end   time.Time
+}
+
+func (r *throughputReader) Read(p []byte) (int, error) {
+	if r.start.IsZero() {
+		r.start = r.clock.Now()
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.bytes += int64(n)
+	if err != nil && r.end.IsZero() {
+		r.end = r.clock.Now()
+	}
+	return n, err
+}
+
+func (r *throughputReader) sample(metadata RequestMetadata, streamType string) ThroughputSample {
+	sample := ThroughputSample{
+		ID:         metadata.ID,
+		StreamType: streamType,
+		Method:     metadata.Method,
+		URL:        metadata.SourceURL,
+		StartedAt:  r.start,
+		Bytes:      r.bytes,
+	}
+	end := r.end
+	if end.IsZero() {
+		// The wrapped logger stopped before the end of the stream
+		end = r.clock.Now()
+	}
+	if !r.start.IsZero() {
+		duration := end.Sub(r.start)
+		sample.DurationMS = duration.Milliseconds()
+		if duration > 0 {
+			sample.BytesPerSecond = float64(r.bytes) / duration.Seconds()
+		}
+	}
+	return sample
+}
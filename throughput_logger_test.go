@@ -0,0 +1,93 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// throttledReader returns chunk bytes per read after waiting delay.
+type throttledReader struct {
+	remaining int
+	chunk     int
+	delay     time.Duration
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if r.remaining == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	n := min(min(r.chunk, r.remaining), len(p))
+	clear(p[:n])
+	r.remaining -= n
+	return n, nil
+}
+
+func TestThroughputLoggerMeasuresStreamRate(t *testing.T) {
+	reportPath := filepath.Join(t.TempDir(), "throughput.jsonl")
+	testLogger := &TestLogger{}
+	throughputLogger := NewThroughputLogger(testLogger, reportPath)
+
+	const size = 80 * 1024
+	stream := &throttledReader{remaining: size, chunk: 8 * 1024, delay: 20 * time.Millisecond}
+	metadata := RequestMetadata{ID: "throughput-test", Method: "GET", SourceURL: "http://localhost/api/"}
+	started := time.Now()
+	throughputLogger.LogResponse(metadata, started, io.NopCloser(stream))
+	elapsed := time.Since(started)
+
+	if len(testLogger.responses) != 1 || len(testLogger.responses[0].content) != size {
+		t.Fatalf("expected the wrapped logger to receive the whole stream")
+	}
+
+	report, err := os.Open(reportPath)
+	if err != nil {
+		t.Fatalf("failed to open report: %v", err)
+	}
+	defer report.Close()
+	scanner := bufio.NewScanner(report)
+	if !scanner.Scan() {
+		t.Fatal("expected a throughput sample in the report")
+	}
+	var sample ThroughputSample
+	if err := json.Unmarshal(scanner.Bytes(), &sample); err != nil {
+		t.Fatalf("failed to parse sample: %v", err)
+	}
+
+	if sample.ID != metadata.ID || sample.StreamType != "response" || sample.Bytes != size {
+		t.Errorf("unexpected sample: %+v", sample)
+	}
+	expected := float64(size) / elapsed.Seconds()
+	if sample.BytesPerSecond < expected*0.8 || sample.BytesPerSecond > expected*1.5 {
+		t.Errorf("expected about %.0f B/s, got %.0f B/s", expected, sample.BytesPerSecond)
+	}
+	if sample.DurationMS < 150 || sample.DurationMS > elapsed.Milliseconds() {
+		t.Errorf("expected a duration of about %d ms, got %d ms", elapsed.Milliseconds(), sample.DurationMS)
+	}
+}
+
+func TestThroughputLoggerUsesClock(t *testing.T) {
+	reportPath := filepath.Join(t.TempDir(), "throughput.jsonl")
+	throughputLogger := NewThroughputLogger(nil, reportPath)
+	throughputLogger.Clock = &fakeClock{now: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), step: 250 * time.Millisecond}
+
+	// The clock is read on the first read and at EOF, one step apart
+	throughputLogger.LogRequest(RequestMetadata{ID: "clock-test"}, time.Now(), io.NopCloser(strings.NewReader(strings.Repeat("x", 1000))))
+
+	content, err := os.ReadFile(reportPath)
+	if err != nil {
+		t.Fatalf("failed to read report: %v", err)
+	}
+	var sample ThroughputSample
+	if err := json.Unmarshal(content, &sample); err != nil {
+		t.Fatalf("failed to parse sample: %v", err)
+	}
+	if sample.StreamType != "request" || sample.Bytes != 1000 || sample.DurationMS != 250 || sample.BytesPerSecond != 4000 {
+		t.Errorf("unexpected sample: %+v", sample)
+	}
+}
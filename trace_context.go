@@ -0,0 +1,69 @@
+package loggingproxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	traceparentHeader = "Traceparent"
+	tracestateHeader  = "Tracestate"
+)
+
+// propagateTraceContext makes sure request carries a W3C trace context. A valid
+// incoming traceparent is forwarded unchanged together with its tracestate.
+// Otherwise a new trace is started: a fresh traceparent is injected and any
+// tracestate belonging to the invalid one is dropped. It returns the trace ID
+// and the parent ID of the forwarded traceparent.
+func propagateTraceContext(header http.Header) (traceID string, spanID string) {
+	if traceID, spanID, ok := parseTraceparent(header.Get(traceparentHeader)); ok {
+		return traceID, spanID
+	}
+
+	traceID = randomHex(16)
+	spanID = randomHex(8)
+	header.Set(traceparentHeader, "00-"+traceID+"-"+spanID+"-01")
+	header.Del(tracestateHeader)
+	return traceID, spanID
+}
+
+// parseTraceparent validates a traceparent header as described in the W3C
+// Trace Context specification and returns its trace ID and parent ID.
+func parseTraceparent(value string) (traceID string, spanID string, ok bool) {
+	fields := strings.Split(value, "-")
+	if len(fields) < 4 {
+		return "", "", false
+	}
+	version, traceID, spanID, flags := fields[0], fields[1], fields[2], fields[3]
+	if !isLowerHex(version, 2) || version == "ff" || !isLowerHex(traceID, 32) || !isLowerHex(spanID, 16) || !isLowerHex(flags, 2) {
+		return "", "", false
+	}
+	// Version 00 has exactly four fields; later versions may append more
+	if version == "00" && len(fields) != 4 {
+		return "", "", false
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(spanID, "0") == "" {
+		return "", "", false
+	}
+	return traceID, spanID, true
+}
+
+func isLowerHex(value string, length int) bool {
+	if len(value) != length {
+		return false
+	}
+	for _, c := range value {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
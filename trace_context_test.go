@@ -0,0 +1,118 @@
+package loggingproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// proxyTraceHeaders sends a request with the given headers through a proxy with
+// TraceContext enabled and returns the trace headers the backend received and
+// the logged request metadata.
+func proxyTraceHeaders(t *testing.T, traceContext bool, header http.Header) (http.Header, RequestMetadata) {
+	t.Helper()
+	received := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Clone()
+	}))
+	defer backend.Close()
+
+	testLogger := &TestLogger{}
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{TraceContext: traceContext})
+	if err != nil {
+		t.Fatalf("failed to create proxy server: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", testLogger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/trace", nil)
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	if len(testLogger.requests) != 1 {
+		t.Fatalf("expected 1 request log, got %d", len(testLogger.requests))
+	}
+	return <-received, testLogger.requests[0].metadata
+}
+
+func TestTraceContextPropagatesExistingTraceparent(t *testing.T) {
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	received, metadata := proxyTraceHeaders(t, true, http.Header{
+		"Traceparent": {traceparent},
+		"Tracestate":  {"vendor=value"},
+	})
+
+	if received.Get("Traceparent") != traceparent || received.Get("Tracestate") != "vendor=value" {
+		t.Errorf("expected the trace context to be forwarded unchanged, got %v", received)
+	}
+	if metadata.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || metadata.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("expected the incoming IDs in the metadata, got %q and %q", metadata.TraceID, metadata.SpanID)
+	}
+}
+
+func TestTraceContextGeneratesMissingTraceparent(t *testing.T) {
+	received, metadata := proxyTraceHeaders(t, true, nil)
+
+	traceID, spanID, ok := parseTraceparent(received.Get("Traceparent"))
+	if !ok {
+		t.Fatalf("expected the backend to receive a valid traceparent, got %q", received.Get("Traceparent"))
+	}
+	if metadata.TraceID != traceID || metadata.SpanID != spanID {
+		t.Errorf("expected the generated IDs %s/%s in the metadata, got %q and %q", traceID, spanID, metadata.TraceID, metadata.SpanID)
+	}
+}
+
+func TestTraceContextReplacesInvalidTraceparent(t *testing.T) {
+	invalid := "00-00000000000000000000000000000000-00f067aa0ba902b7-01"
+	received, metadata := proxyTraceHeaders(t, true, http.Header{
+		"Traceparent": {invalid},
+		"Tracestate":  {"vendor=value"},
+	})
+
+	traceparent := received.Get("Traceparent")
+	if traceparent == invalid || metadata.TraceID == "" {
+		t.Fatalf("expected a new trace for an invalid traceparent, got %q", traceparent)
+	}
+	if _, _, ok := parseTraceparent(traceparent); !ok {
+		t.Errorf("expected a valid replacement traceparent, got %q", traceparent)
+	}
+	if received.Get("Tracestate") != "" {
+		t.Errorf("expected the tracestate of the invalid trace to be dropped, got %q", received.Get("Tracestate"))
+	}
+}
+
+func TestTraceContextDisabledByDefault(t *testing.T) {
+	received, metadata := proxyTraceHeaders(t, false, nil)
+	if received.Get("Traceparent") != "" || metadata.TraceID != "" {
+		t.Errorf("expected no trace context without TraceContext, got %q", received.Get("Traceparent"))
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	for value, valid := range map[string]bool{
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":       true,
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra": true,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra": false,
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01":       false,
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01":       false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01":       false,
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7":          false,
+		"": false,
+	} {
+		if _, _, ok := parseTraceparent(value); ok != valid {
+			t.Errorf("parseTraceparent(%q) valid = %v, want %v", value, ok, valid)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package loggingproxy
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Transcript is a logged request or response as stored in the .bin files
+// written by FileLogger: a request line or status line, headers, a blank line
+// and the (decompressed) body.
+type Transcript struct {
+	// StartLine is the first line without its line ending.
+	StartLine string
+
+	// IsResponse is true for transcripts that start with a status line.
+	IsResponse bool
+
+	// Method and URL are set for requests. URL is the full upstream URL for
+	// reverse proxy logs and the request target for forward proxy logs.
+	Method string
+	URL    string
+
+	// StatusCode and Status are set for responses, e.g. 200 and "200 OK".
+	StatusCode int
+	Status     string
+
+	Proto  string
+	Header http.Header
+	Body   []byte
+}
+
+// ParseTranscript reads a logged request or response. It is the read-side
+// counterpart of the header reconstruction done when streams are logged.
+func ParseTranscript(r io.Reader) (*Transcript, error) {
+	reader := bufio.NewReader(r)
+	head, header, err := readStreamHead(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse transcript head: %w", err)
+	}
+	startLine, _, _ := strings.Cut(string(head), "\n")
+	transcript := &Transcript{
+		StartLine: strings.TrimRight(startLine, "\r"),
+		Header:    http.Header(header),
+	}
+
+	if strings.HasPrefix(transcript.StartLine, "HTTP/") {
+		proto, status, _ := strings.Cut(transcript.StartLine, " ")
+		code, _, _ := strings.Cut(status, " ")
+		statusCode, err := strconv.Atoi(code)
+		if err != nil {
+			return nil, fmt.Errorf("invalid status line %q", transcript.StartLine)
+		}
+		transcript.IsResponse = true
+		transcript.Proto = proto
+		transcript.StatusCode = statusCode
+		transcript.Status = status
+	} else {
+		fields := strings.Fields(transcript.StartLine)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid request line %q", transcript.StartLine)
+		}
+		transcript.Method = fields[0]
+		transcript.URL = fields[1]
+		transcript.Proto = fields[2]
+	}
+
+	transcript.Body, err = io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transcript body: %w", err)
+	}
+	return transcript, nil
+}
@@ -0,0 +1,105 @@
+package loggingproxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTranscriptReadsFileLoggerOutput(t *testing.T) {
+	logDir := t.TempDir()
+	fileLogger, err := NewFileLoggerWithOptions(FileLoggerOptions{
+		LogDir:           logDir,
+		FilenameTemplate: "{{.StreamType}}",
+	})
+	if err != nil {
+		t.Fatalf("failed to create file logger: %v", err)
+	}
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("X-Backend", "one")
+		w.Header().Add("X-Backend", "two")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"id":1}`)
+	}))
+	defer backend.Close()
+
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", fileLogger); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	request, _ := http.NewRequest(http.MethodPut, testServer.URL+"/api/items?id=1", strings.NewReader("name=first\r\n\r\nsecond line"))
+	request.Header.Set("Content-Type", "text/plain")
+	request.Header.Set("X-Client", "test")
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+
+	requestTranscript := parseTranscriptFile(t, filepath.Join(logDir, "request.bin"))
+	if requestTranscript.IsResponse || requestTranscript.Method != http.MethodPut || requestTranscript.Proto != "HTTP/1.1" {
+		t.Errorf("unexpected request line fields: %+v", requestTranscript)
+	}
+	if requestTranscript.URL != backend.URL+"/items?id=1" {
+		t.Errorf("expected upstream URL, got %q", requestTranscript.URL)
+	}
+	if requestTranscript.Header.Get("X-Client") != "test" || requestTranscript.Header.Get("Content-Type") != "text/plain" {
+		t.Errorf("unexpected request headers: %v", requestTranscript.Header)
+	}
+	// A blank line inside the body must not be mistaken for the header separator
+	if string(requestTranscript.Body) != "name=first\r\n\r\nsecond line" {
+		t.Errorf("unexpected request body %q", requestTranscript.Body)
+	}
+
+	responseTranscript := parseTranscriptFile(t, filepath.Join(logDir, "response.bin"))
+	if !responseTranscript.IsResponse || responseTranscript.StatusCode != http.StatusCreated || responseTranscript.Status != "201 Created" {
+		t.Errorf("unexpected status line fields: %+v", responseTranscript)
+	}
+	if values := responseTranscript.Header.Values("X-Backend"); len(values) != 2 || values[0] != "one" || values[1] != "two" {
+		t.Errorf("expected repeated response headers, got %v", values)
+	}
+	if string(responseTranscript.Body) != `{"id":1}` {
+		t.Errorf("unexpected response body %q", responseTranscript.Body)
+	}
+}
+
+func TestParseTranscriptRejectsMalformedInput(t *testing.T) {
+	for name, input := range map[string]string{
+		"missing separator": "GET http://backend/ HTTP/1.1\r\nAccept: */*\r\n",
+		"bad request line":  "GET\r\n\r\n",
+		"bad status line":   "HTTP/1.1 OK\r\n\r\n",
+	} {
+		if _, err := ParseTranscript(strings.NewReader(input)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func parseTranscriptFile(t *testing.T, path string) *Transcript {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open transcript: %v", err)
+	}
+	defer file.Close()
+	transcript, err := ParseTranscript(file)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", path, err)
+	}
+	return transcript
+}
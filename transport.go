@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"time"
 
 	"golang.org/x/net/http/httpproxy"
 )
@@ -40,6 +41,19 @@ func newHTTPClient(proxyConfig HTTPClientProxyConfig) (*http.Client, error) {
 	return &http.Client{Transport: transport}, nil
 }
 
+// redirectPolicy returns a CheckRedirect function that follows at most
+// maxRedirects hops. Once the limit is reached the last redirect response is
+// returned as-is, so a limit of zero forwards every 3xx to the client. Hops
+// to destinations guard rejects fail the request.
+func redirectPolicy(maxRedirects int, guard *destinationGuard) func(*http.Request, []*http.Request) error {
+	return func(request *http.Request, via []*http.Request) error {
+		if len(via) > maxRedirects {
+			return http.ErrUseLastResponse
+		}
+		return guard.checkRedirect(request)
+	}
+}
+
 func newDirectTransport() *http.Transport {
 	transport := cloneDefaultTransport()
 	transport.Proxy = nil
@@ -50,14 +64,22 @@ func newDirectHTTPClient() *http.Client {
 	return &http.Client{Transport: newDirectTransport()}
 }
 
+// cloneDefaultTransport returns a copy of the default transport for proxied
+// traffic. Compression is disabled so the transport never adds its own
+// "Accept-Encoding: gzip" and transparently decompresses the response: the
+// backend sees the client's Accept-Encoding, and the client gets the response
+// encoded exactly as the backend sent it. Logging decompresses its own copy.
 func cloneDefaultTransport() *http.Transport {
 	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
 		clone := transport.Clone()
 		clone.Proxy = nil
+		clone.DisableCompression = true
 		return clone
 	}
 
-	return &http.Transport{Proxy: nil}
+	// Keep waiting for "100 Continue" like the default transport does, so
+	// upload bodies are not read before the backend accepts them
+	return &http.Transport{Proxy: nil, ExpectContinueTimeout: time.Second, DisableCompression: true}
 }
 
 func (config HTTPClientProxyConfig) proxyFunc() (func(*http.Request) (*url.URL, error), error) {
@@ -4,7 +4,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestHTTPClientProxyConfigDefaultsToEnvironment(t *testing.T) {
@@ -112,3 +114,64 @@ func TestReverseProxyUsesHTTPProxyFromEnvironment(t *testing.T) {
 		t.Fatal("environment proxy did not receive the request")
 	}
 }
+
+func TestMaxConnLifetimeRecyclesUpstreamConnections(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+		if r.Method == http.MethodPost {
+			body, _ := io.ReadAll(r.Body)
+			_, _ = io.WriteString(w, " "+string(body))
+		}
+	}))
+	defer backend.Close()
+
+	disabled := false
+	proxyServer, err := NewProxyServerWithOptions(ProxyServerOptions{
+		ClientProxy:     HTTPClientProxyConfig{ProxyFromEnvironment: &disabled},
+		MaxConnLifetime: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create reverse proxy: %v", err)
+	}
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", &NoOpLogger{}); err != nil {
+		t.Fatalf("failed to add route: %v", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	upstreamAddr := func() string {
+		t.Helper()
+		resp, err := http.Get(testServer.URL + "/api/")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected the request to be proxied, got %d %q", resp.StatusCode, body)
+		}
+		return string(body)
+	}
+
+	first := upstreamAddr()
+	if second := upstreamAddr(); second != first {
+		t.Fatalf("expected a young connection to be reused, got %s and %s", first, second)
+	}
+	time.Sleep(300 * time.Millisecond)
+	third := upstreamAddr()
+	if third == first {
+		t.Fatalf("expected the connection from %s to be replaced after its lifetime", first)
+	}
+
+	// A request with a body is sent again in full on a new connection
+	time.Sleep(300 * time.Millisecond)
+	resp, err := http.Post(testServer.URL+"/api/upload", "text/plain", strings.NewReader("payload"))
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.HasSuffix(string(body), " payload") || strings.HasPrefix(string(body), third+" ") {
+		t.Fatalf("expected the upload to reach the backend on a new connection, got %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,38 @@
+package loggingproxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// stripUnsupportedFeatures removes protocol features from a request that a
+// single forwarded request and response cannot carry, and returns a note for
+// each one for RequestMetadata.UnsupportedFeatures.
+//
+// A connection upgrade, such as to WebSocket or h2c, would turn the exchange
+// into a two-way tunnel, so the request is forwarded without its Upgrade
+// header and the backend answers it as a plain request. HTTP/2 server push
+// needs no handling here: the upstream client tells backends that it does not
+// accept pushes, and the proxy never pushes to its clients.
+func stripUnsupportedFeatures(request *http.Request) []string {
+	var notes []string
+	if upgrade := request.Header.Get("Upgrade"); upgrade != "" {
+		notes = append(notes, "upgrade: "+upgrade)
+		request.Header.Del("Upgrade")
+		request.Header.Del("HTTP2-Settings")
+		var kept []string
+		for _, value := range request.Header.Values("Connection") {
+			for _, token := range strings.Split(value, ",") {
+				token = strings.TrimSpace(token)
+				if token != "" && !strings.EqualFold(token, "Upgrade") && !strings.EqualFold(token, "HTTP2-Settings") {
+					kept = append(kept, token)
+				}
+			}
+		}
+		request.Header.Del("Connection")
+		if len(kept) > 0 {
+			request.Header.Set("Connection", strings.Join(kept, ", "))
+		}
+	}
+	return notes
+}
@@ -0,0 +1,85 @@
+package loggingproxy
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestHTTP2BackendPushAndClientUpgradeAreIgnored(t *testing.T) {
+	backend := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pushErr error = http.ErrNotSupported
+		if pusher, ok := w.(http.Pusher); ok {
+			pushErr = pusher.Push("/app.js", nil)
+		}
+		fmt.Fprintf(w, "%s push=%v upgrade=%q connection=%q", r.Proto, pushErr, r.Header.Get("Upgrade"), r.Header.Get("Connection"))
+	}))
+	backend.EnableHTTP2 = true
+	backend.StartTLS()
+	defer backend.Close()
+
+	caFile := filepath.Join(t.TempDir(), "backend-ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: backend.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o600); err != nil {
+		t.Fatal("Failed to write CA file:", err)
+	}
+
+	testLogger := &TestLogger{}
+	proxyServer := NewProxyServer("")
+	err := proxyServer.AddRouteWithOptions("/api/", backend.URL+"/", testLogger, RouteOptions{
+		ClientTLS: &ClientTLSConfig{CAFile: caFile},
+	})
+	if err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	get := func(upgrade string) string {
+		t.Helper()
+		request, _ := http.NewRequest(http.MethodGet, testServer.URL+"/api/page", nil)
+		if upgrade != "" {
+			request.Header.Set("Connection", "keep-alive, Upgrade")
+			request.Header.Set("Upgrade", upgrade)
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d %q", resp.StatusCode, body)
+		}
+		return string(body)
+	}
+
+	// The backend's push is refused and the response itself is proxied
+	expected := fmt.Sprintf(`HTTP/2.0 push=%v upgrade="" connection=""`, http.ErrNotSupported)
+	if body := get(""); body != expected {
+		t.Errorf("Expected %q, got %q", expected, body)
+	}
+	// An upgrade is dropped and the request forwarded over HTTP/2 as usual
+	if body := get("websocket"); body != expected {
+		t.Errorf("Expected the upgrade to be dropped, got %q", body)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	var notes [][]string
+	for _, entry := range testLogger.requests {
+		if entry.metadata.UnsupportedFeatures != nil {
+			notes = append(notes, entry.metadata.UnsupportedFeatures)
+		}
+	}
+	if len(notes) != 1 || !slices.Equal(notes[0], []string{"upgrade: websocket"}) {
+		t.Errorf("Expected the upgrade to be noted once in the metadata, got %v", notes)
+	}
+}
@@ -0,0 +1,31 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// logUpstreamFailure logs the error response sent to the client when the
+// upstream request failed, so every logged request has a matching response
+// that records why it failed and after how long. The synthetic response
+// carries the error in RequestMetadata.UpstreamError and an X-Proxy-Error
+// header.
+func (s *ProxyServer) logUpstreamFailure(metadata RequestMetadata, logger Logger, requestLogDone <-chan struct{}, statusCode int, body string, upstreamErr error) {
+	responseTime := s.clock.Now()
+	metadata.UpstreamError = upstreamErr.Error()
+	metadata.UpstreamErrorAfterMS = responseTime.Sub(metadata.RequestStartedAt).Milliseconds()
+	metadata.ResponseStatus = fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode))
+	metadata.ResponseStatusCode = statusCode
+
+	s.logWorkers.Go(func() {
+		s.waitForRequestLog(requestLogDone)
+		var transcript bytes.Buffer
+		fmt.Fprintf(&transcript, "HTTP/1.1 %s\r\n", metadata.ResponseStatus)
+		fmt.Fprintf(&transcript, "X-Proxy-Error: %s\r\n", metadata.UpstreamError)
+		transcript.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		transcript.WriteString(body)
+		logger.LogResponse(metadata, responseTime, io.NopCloser(&transcript))
+	})
+}
@@ -0,0 +1,58 @@
+package loggingproxy
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// WriterFactory opens the destination of one logged stream. direction is
+// "request" or "response".
+type WriterFactory func(metadata RequestMetadata, direction string) (io.WriteCloser, error)
+
+// WriterLogger copies every logged stream to a writer of its own, opened by a
+// WriterFactory, so streams can be sent to a named pipe, a socket or any
+// other sink without implementing Logger. The writer is closed when the
+// stream ends. If the factory fails, the stream is read and discarded so the
+// proxy is not held up.
+type WriterLogger struct {
+	factory WriterFactory
+	dropped atomic.Uint64
+}
+
+// NewWriterLogger returns a WriterLogger that opens writers with factory.
+func NewWriterLogger(factory WriterFactory) *WriterLogger {
+	return &WriterLogger{factory: factory}
+}
+
+// LogRequest copies the request stream to a new writer
+func (l *WriterLogger) LogRequest(metadata RequestMetadata, timestamp time.Time, rawRequestStream io.ReadCloser) {
+	l.copyStream(metadata, "request", rawRequestStream)
+}
+
+// LogResponse copies the response stream to a new writer
+func (l *WriterLogger) LogResponse(metadata RequestMetadata, timestamp time.Time, rawResponseStream io.ReadCloser) {
+	l.copyStream(metadata, "response", rawResponseStream)
+}
+
+// Dropped returns the number of streams that could not be written
+// completely, because the factory, the writer or the stream failed.
+func (l *WriterLogger) Dropped() uint64 {
+	return l.dropped.Load()
+}
+
+func (l *WriterLogger) copyStream(metadata RequestMetadata, direction string, stream io.ReadCloser) {
+	defer stream.Close()
+	writer, err := l.factory(metadata, direction)
+	if err != nil {
+		l.dropped.Add(1)
+		io.Copy(io.Discard, stream)
+		return
+	}
+	_, err = io.Copy(writer, stream)
+	if closeErr := writer.Close(); err != nil || closeErr != nil {
+		l.dropped.Add(1)
+		// Keep draining after a failed write
+		io.Copy(io.Discard, stream)
+	}
+}
@@ -0,0 +1,106 @@
+package loggingproxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryWriter is a buffer that records being closed.
+type memoryWriter struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (w *memoryWriter) Close() error {
+	w.closed = true
+	return nil
+}
+
+func TestWriterLoggerWritesEachStreamToItsWriter(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		io.WriteString(w, "echo "+string(body))
+	}))
+	defer backend.Close()
+
+	var mu sync.Mutex
+	writers := map[string]*memoryWriter{}
+	writerLogger := NewWriterLogger(func(metadata RequestMetadata, direction string) (io.WriteCloser, error) {
+		if strings.HasSuffix(metadata.SourceURL, "/fail") {
+			return nil, errors.New("sink unavailable")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		writer := &memoryWriter{}
+		writers[metadata.ID+" "+direction] = writer
+		return writer, nil
+	})
+	proxyServer := NewProxyServer("")
+	if err := proxyServer.AddRoute("/api/", backend.URL+"/", writerLogger); err != nil {
+		t.Fatal("Failed to add route:", err)
+	}
+	testServer := httptest.NewServer(proxyServer)
+	defer testServer.Close()
+
+	for _, body := range []string{"first", "second"} {
+		resp, err := http.Post(testServer.URL+"/api/items", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal("Request failed:", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	// A failing factory does not hold up the proxy
+	resp, err := http.Post(testServer.URL+"/api/fail", "text/plain", strings.NewReader("lost"))
+	if err != nil {
+		t.Fatal("Request failed:", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the request to succeed without its log, got %d", resp.StatusCode)
+	}
+
+	// Give async logging a moment to complete
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if len(writers) != 4 {
+		t.Fatalf("Expected 4 writers, got %d", len(writers))
+	}
+	bodies := map[string]bool{}
+	for key, request := range writers {
+		id, found := strings.CutSuffix(key, " request")
+		if !found {
+			continue
+		}
+		response := writers[id+" response"]
+		if response == nil {
+			t.Fatalf("Expected a response writer for %s", id)
+		}
+		_, body, _ := strings.Cut(request.String(), "\r\n\r\n")
+		bodies[body] = true
+		if !strings.HasPrefix(request.String(), "POST "+backend.URL+"/items ") {
+			t.Errorf("Expected the request in its writer, got %q", request.String())
+		}
+		if !strings.HasSuffix(response.String(), "\r\n\r\necho "+body) {
+			t.Errorf("Expected the response to %q in its writer, got %q", body, response.String())
+		}
+		if !request.closed || !response.closed {
+			t.Errorf("Expected the writers of %s to be closed", id)
+		}
+	}
+	if !bodies["first"] || !bodies["second"] {
+		t.Errorf("Expected a request writer per request, got bodies %v", bodies)
+	}
+	if writerLogger.Dropped() != 2 {
+		t.Errorf("Expected the 2 streams of the failed factory to be dropped, got %d", writerLogger.Dropped())
+	}
+}